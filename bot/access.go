@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/dispatch"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+)
+
+const (
+	// cmdLogin is the name of the login command.
+	cmdLogin = "login"
+	// cmdLogout is the name of the logout command.
+	cmdLogout = "logout"
+
+	// fmtLoggedIn is sent to a user after a successful login.
+	fmtLoggedIn = "Logged in as %v."
+	// fmtLoginFailed is sent to a user after a failed login. It never says
+	// why so it can't be used to find out which accounts exist.
+	fmtLoginFailed = "Login failed."
+	// errFmtLogin is logged with the reason a login failed.
+	errFmtLogin = "bot: %v login to %q by %v failed (%v)\n"
+	// fmtLoggedOut is sent to a user after logging out.
+	fmtLoggedOut = "Logged out."
+	// fmtNotLoggedIn is sent to a user logging out without being logged in.
+	fmtNotLoggedIn = "You are not logged in."
+)
+
+// accessHandler handles the commands users need to log in and out of their
+// accounts. They are only available in private so passwords are not leaked
+// to channels.
+type accessHandler struct {
+	bot *Bot
+}
+
+// commands returns the commands handled by the accessHandler.
+func (a *accessHandler) commands() []*dispatch.Command {
+	return []*dispatch.Command{
+		&dispatch.Command{
+			Name:        cmdLogin,
+			Description: "Logs in to a registered account.",
+			Args:        []string{"username", "password"},
+			Scope:       dispatch.CMDSCOPE_PRIVATE,
+			Handler:     a,
+		},
+		&dispatch.Command{
+			Name:        cmdLogout,
+			Description: "Logs out of your account.",
+			Scope:       dispatch.CMDSCOPE_PRIVATE,
+			Handler:     a,
+		},
+	}
+}
+
+// Command implements dispatch.CommandHandler.
+func (a *accessHandler) Command(command string, cmd *dispatch.CommandData,
+	endpoint irc.Endpoint) error {
+
+	nick := cmd.Nick()
+	key := endpoint.GetKey()
+	mask := irc.Mask(cmd.Sender)
+
+	switch command {
+	case cmdLogin:
+		user, err := a.bot.users.Login(key, mask,
+			cmd.Arg("username"), cmd.Arg("password"))
+		if err != nil {
+			log.Printf(errFmtLogin, key, cmd.Arg("username"), mask, err)
+			endpoint.Notice(nick, fmtLoginFailed)
+		} else {
+			endpoint.Notice(nick,
+				fmt.Sprintf(fmtLoggedIn, user.GetUserAccess().Username))
+		}
+	case cmdLogout:
+		if a.bot.users.Logout(key, mask) {
+			endpoint.Notice(nick, fmtLoggedOut)
+		} else {
+			endpoint.Notice(nick, fmtNotLoggedIn)
+		}
+	}
+	return nil
+}
+
+// OpenUserStore calls a callback with the bot's user store, this is where
+// registered accounts and their access are kept.
+func (b *Bot) OpenUserStore(fn func(*data.UserStore)) {
+	fn(b.users)
+}
+
+// OpenStorage calls a callback with the bot's storage, this is where handlers
+// keep their own data.
+func (b *Bot) OpenStorage(fn func(data.Storage)) {
+	fn(b.storage)
+}
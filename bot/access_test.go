@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestBot_Login(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, true)
+	c.Assert(err, IsNil)
+
+	b.OpenUserStore(func(users *data.UserStore) {
+		ua, err := data.CreateUserAccess("user", "pass", "*!*@host")
+		c.Check(err, IsNil)
+		ua.GrantGlobal(100)
+		c.Check(users.AddUser(ua), IsNil)
+	})
+
+	endpoint := makeTestPoint(b.servers[serverId])
+	privmsg := func(sender, line string) {
+		b.dispatcher.Dispatch(&irc.IrcMessage{
+			Name:   irc.PRIVMSG,
+			Sender: sender,
+			Args:   []string{"bot", line},
+		}, endpoint)
+		b.dispatcher.WaitForCompletion()
+	}
+
+	privmsg("nick!user@host", "login user wrong")
+	c.Check(endpoint.gets(), Equals, "NOTICE nick :Login failed.")
+	endpoint.resetTestWritten()
+	privmsg("nick!user@host", "login nobody pass")
+	c.Check(endpoint.gets(), Equals, "NOTICE nick :Login failed.")
+	endpoint.resetTestWritten()
+
+	privmsg("nick!user@host", "login user pass")
+	c.Check(endpoint.gets(), Equals, "NOTICE nick :Logged in as user.")
+	endpoint.resetTestWritten()
+
+	b.OpenUserStore(func(users *data.UserStore) {
+		user, ok := users.CheckAccess(serverId, "#chan", "nick!user@host", 100)
+		c.Check(ok, Equals, true)
+		c.Check(user.GetUserAccess().Username, Equals, "user")
+	})
+
+	handler := coreHandler{bot: b}
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.NICK,
+		Sender: "nick!user@host", Args: []string{"nick2"}}, endpoint)
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.QUIT,
+		Sender: "nick2!user@host", Args: []string{"bye"}}, endpoint)
+	b.OpenUserStore(func(users *data.UserStore) {
+		c.Check(users.GetAuthedUser(serverId, "nick2!user@host"), IsNil)
+	})
+
+	privmsg("nick!user@host", "login user pass")
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.DISCONNECT}, endpoint)
+	endpoint.resetTestWritten()
+	privmsg("nick!user@host", "logout")
+	c.Check(endpoint.gets(), Equals, "NOTICE nick :You are not logged in.")
+}
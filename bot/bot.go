@@ -8,11 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/dcc"
 	"github.com/aarondl/ultimateq/dispatch"
+	"github.com/aarondl/ultimateq/extension"
 	"github.com/aarondl/ultimateq/irc"
 	"github.com/aarondl/ultimateq/parse"
 	"log"
+	"math/rand"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -22,6 +27,11 @@ const (
 	nAssumedServers = 1
 	// defaultReconnScale is how the config's ReconnTimeout is scaled.
 	defaultReconnScale = time.Second
+	// defaultKeepaliveScale is how the config's keepalive settings are
+	// scaled.
+	defaultKeepaliveScale = time.Second
+	// defaultStateScale is how the config's StateInterval is scaled.
+	defaultStateScale = time.Second
 
 	// errFmtParsingIrcMessage is when the bot fails to parse a message
 	// during it's dispatch loop.
@@ -31,6 +41,9 @@ const (
 	errFmtReaderClosed = "bot: %v reader closed\n"
 	// errFmtClosingServer is when a IrcClient.Close returns an error.
 	errFmtClosingServer = "bot: Error closing server (%v)\n"
+	// errFmtSaveState is when the state database can't be written to the
+	// server's state file.
+	errFmtSaveState = "bot: %v failed to save state (%v)\n"
 	// fmtFailedConnecting shows when the bot is unable to connect to a server.
 	fmtFailedConnecting = "bot: %v failed to connect (%v)"
 	// fmtDisconnected shows when the bot is disconnected
@@ -50,7 +63,8 @@ var (
 type (
 	// CapsProvider returns a usable ProtoCaps to start the bot with
 	CapsProvider func() *irc.ProtoCaps
-	// ConnProvider transforms a "server:port" string into a net.Conn
+	// ConnProvider transforms a "server:port" string into a net.Conn, the
+	// connection is used as is and ssl settings are not applied to it.
 	ConnProvider func(string) (net.Conn, error)
 )
 
@@ -64,6 +78,15 @@ type Bot struct {
 
 	caps       *irc.ProtoCaps
 	dispatcher *dispatch.Dispatcher
+	commander  *dispatch.Commander
+	users      *data.UserStore
+	storage    data.Storage
+	extensions map[*extension.Remote]bool
+	dccs       []*dcc.Manager
+
+	// handler failure policy, applied to every dispatcher.
+	onHandlerError func(*dispatch.HandlerError)
+	failureLimit   int
 
 	// IoC and DI components mostly for testing.
 	attachHandlers bool
@@ -75,6 +98,10 @@ type Bot struct {
 	serversProtect sync.RWMutex
 	// configs (including server configs)
 	configsProtect sync.RWMutex
+	// extensions
+	extensionsProtect sync.RWMutex
+	// dcc managers
+	dccProtect sync.RWMutex
 }
 
 // Configure starts a configuration by calling CreateConfig. Alias for
@@ -204,6 +231,7 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 		b.dispatchMessage(srv, &irc.IrcMessage{Name: irc.CONNECT})
 
 		if reading {
+			srv.createKeepalive()
 			b.msgDispatchers.Add(1)
 			go b.dispatchMessages(srv)
 		}
@@ -304,11 +332,35 @@ func (b *Bot) WaitForHalt() {
 	b.serversProtect.RUnlock()
 }
 
+// Close releases the bot's storage. It should be called once the bot has been
+// stopped and disconnected, handlers can't use the storage after it.
+func (b *Bot) Close() error {
+	return b.storage.Close()
+}
+
 // Register adds an event handler to the bot's global dispatcher.
 func (b *Bot) Register(event string, handler interface{}) int {
 	return b.dispatcher.Register(event, handler)
 }
 
+// RegisterMode adds an event handler to the bot's global dispatcher, the mode
+// is one of the dispatch.DISPATCH_* modes.
+func (b *Bot) RegisterMode(event string, handler interface{}, mode int) int {
+	return b.dispatcher.RegisterMode(event, handler, mode)
+}
+
+// WorkerPool sets the size of the worker pool of the bot's global dispatcher,
+// it runs the handlers registered with dispatch.DISPATCH_POOL.
+func (b *Bot) WorkerPool(workers, size int) {
+	b.dispatcher.WorkerPool(workers, size)
+}
+
+// DispatchStats returns the depth of the queues of the bot's global
+// dispatcher.
+func (b *Bot) DispatchStats() dispatch.Stats {
+	return b.dispatcher.Stats()
+}
+
 // Register adds an event handler to a server specific dispatcher.
 func (b *Bot) RegisterServer(
 	server string, event string, handler interface{}) (int, error) {
@@ -329,6 +381,17 @@ func (b *Bot) Unregister(event string, id int) bool {
 	return b.dispatcher.Unregister(event, id)
 }
 
+// RegisterCommand adds a command to the bot. Commands are available on all
+// servers using each server's configured prefix.
+func (b *Bot) RegisterCommand(cmd *dispatch.Command) error {
+	return b.commander.Register(cmd)
+}
+
+// UnregisterCommand removes a command from the bot.
+func (b *Bot) UnregisterCommand(name string) bool {
+	return b.commander.Unregister(name)
+}
+
 // Unregister removes an event handler from a server specific dispatcher.
 func (b *Bot) UnregisterServer(
 	server string, event string, id int) (bool, error) {
@@ -344,12 +407,51 @@ func (b *Bot) UnregisterServer(
 	return false, errUnknownServerId
 }
 
+// OnHandlerError sets a callback that's given every event handler that panics,
+// on the global dispatcher and on every server's. Nil stops the callbacks.
+func (b *Bot) OnHandlerError(callback func(*dispatch.HandlerError)) {
+	b.serversProtect.Lock()
+	defer b.serversProtect.Unlock()
+
+	b.onHandlerError = callback
+	b.dispatcher.OnError(callback)
+	for _, s := range b.servers {
+		s.dispatcher.OnError(callback)
+	}
+}
+
+// HandlerFailureLimit sets how many times in a row an event handler may panic
+// before it's unregistered, on the global dispatcher and on every server's.
+// 0 never unregisters handlers.
+func (b *Bot) HandlerFailureLimit(limit int) {
+	b.serversProtect.Lock()
+	defer b.serversProtect.Unlock()
+
+	b.failureLimit = limit
+	b.dispatcher.FailureLimit(limit)
+	for _, s := range b.servers {
+		s.dispatcher.FailureLimit(limit)
+	}
+}
+
 // dispatchMessages is a constant read-dispatch from the server to the
 // dispatcher.
 func (b *Bot) dispatchMessages(s *Server) {
 	s.protect.RLock()
 
 	read := s.client.ReadChannel()
+	keepalive := s.keepalive
+	var tick <-chan time.Time
+	if _, _, next := keepalive.tick(time.Now()); next > 0 {
+		tick = time.After(next)
+	}
+	var save <-chan time.Time
+	var saveTicker *time.Ticker
+	if interval := s.conf.GetStateInterval(); interval > 0 {
+		saveTicker = time.NewTicker(time.Duration(interval) * s.stateScale)
+		save = saveTicker.C
+	}
+
 	stop, disconnect := false, false
 	for !stop {
 		select {
@@ -361,15 +463,37 @@ func (b *Bot) dispatchMessages(s *Server) {
 				break
 			}
 			ircMsg, err := parse.Parse(msg)
+			keepalive.read(time.Now(), ircMsg)
 			if err != nil {
 				log.Printf(errFmtParsingIrcMessage, err, msg)
 			} else {
+				var events []data.StateEvent
 				s.protectStore.Lock()
 				if s.store != nil {
-					s.store.Update(ircMsg)
+					events = s.store.Update(ircMsg)
 				}
 				s.protectStore.Unlock()
 				b.dispatchMessage(s, ircMsg)
+				b.dispatchState(s, events)
+			}
+		case <-tick:
+			ping, stale, next := keepalive.tick(time.Now())
+			if stale {
+				log.Printf(fmtStale, s.name, keepalive.timeout)
+				b.dispatchMessage(s, &irc.IrcMessage{Name: irc.DISCONNECT})
+				stop, disconnect = true, true
+				break
+			}
+			if len(ping) > 0 && s.isWriting() {
+				go s.client.Write([]byte(irc.PING + " :" + ping + "\r\n"))
+			}
+			tick = nil
+			if next > 0 {
+				tick = time.After(next)
+			}
+		case <-save:
+			if err := s.saveStore(); err != nil {
+				log.Printf(errFmtSaveState, s.name, err)
 			}
 		case <-s.killdispatch:
 			log.Printf(errFmtReaderClosed, s.name)
@@ -378,16 +502,26 @@ func (b *Bot) dispatchMessages(s *Server) {
 		}
 	}
 	s.protect.RUnlock()
+	if saveTicker != nil {
+		saveTicker.Stop()
+	}
+
+	if err := s.saveStore(); err != nil {
+		log.Printf(errFmtSaveState, s.name, err)
+	}
 
 	var reconn bool
-	var scale time.Duration
-	var timeout uint
+	var timeout, maxTimeout time.Duration
+	var attempts int
 	b.ReadConfig(func(c *config.Config) {
 		cserver := c.GetServer(s.name)
 		if cserver != nil {
 			reconn = disconnect && !cserver.GetNoReconnect()
-			scale = s.reconnScale
-			timeout = cserver.GetReconnectTimeout()
+			timeout = time.Duration(cserver.GetReconnectTimeout()) *
+				s.reconnScale
+			maxTimeout = time.Duration(cserver.GetReconnectMaxTimeout()) *
+				s.reconnScale
+			attempts = int(cserver.GetReconnectAttempts())
 		}
 	})
 
@@ -398,8 +532,17 @@ func (b *Bot) dispatchMessages(s *Server) {
 	log.Printf(fmtDisconnected, s.name)
 
 	if reconn {
-		for {
-			dur := time.Duration(timeout) * scale
+		for attempt := 1; ; attempt++ {
+			if attempts > 0 && attempt > attempts {
+				log.Printf(fmtReconnectAborted, s.name, attempts)
+				b.dispatchMessage(s, &irc.IrcMessage{
+					Name: irc.RECONNECT_ABORTED,
+					Args: []string{strconv.Itoa(attempts)},
+				})
+				break
+			}
+
+			dur := backoff(timeout, maxTimeout, attempt, rand.Int63n)
 			log.Printf(fmtReconnecting, s.name, dur)
 			b.disconnectServer(s)
 			s.protect.Lock()
@@ -416,9 +559,22 @@ func (b *Bot) dispatchMessages(s *Server) {
 				return
 			}
 
+			s.protect.RLock()
+			host := s.currentHost()
+			s.protect.RUnlock()
+			args := []string{host, strconv.Itoa(attempt), dur.String()}
+			b.dispatchMessage(s, &irc.IrcMessage{
+				Name: irc.RECONNECT,
+				Args: args,
+			})
+
 			err := b.connectServer(s)
 			if err != nil {
 				log.Printf(fmtFailedConnecting, s.name, err)
+				b.dispatchMessage(s, &irc.IrcMessage{
+					Name: irc.RECONNECT_FAILED,
+					Args: append(args, err.Error()),
+				})
 				continue
 			} else {
 				b.startServer(s, true, true)
@@ -450,6 +606,18 @@ func (b *Bot) dispatchMessage(s *Server, msg *irc.IrcMessage) {
 	s.dispatcher.Dispatch(msg, endpoint)
 }
 
+// dispatchState sends the changes the store made to the dispatchers.
+func (b *Bot) dispatchState(s *Server, events []data.StateEvent) {
+	if len(events) == 0 {
+		return
+	}
+	endpoint := createServerEndpoint(s)
+	for _, ev := range events {
+		b.dispatcher.DispatchState(ev, endpoint)
+		s.dispatcher.DispatchState(ev, endpoint)
+	}
+}
+
 // createBot creates a bot from the given configuration, using the providers
 // given to create connections and protocol caps.
 func createBot(conf *config.Config, capsProv CapsProvider,
@@ -458,6 +626,7 @@ func createBot(conf *config.Config, capsProv CapsProvider,
 	b := &Bot{
 		conf:           conf,
 		servers:        make(map[string]*Server, nAssumedServers),
+		extensions:     make(map[*extension.Remote]bool),
 		capsProvider:   capsProv,
 		connProvider:   connProv,
 		attachHandlers: attachHandlers,
@@ -474,6 +643,26 @@ func createBot(conf *config.Config, capsProv CapsProvider,
 		return nil, err
 	}
 
+	if b.users, err = data.CreateUserStore(conf.GetUserfile()); err != nil {
+		return nil, err
+	}
+
+	if filename := conf.GetStoragefile(); len(filename) == 0 {
+		b.storage = data.CreateMemStorage()
+	} else if b.storage, err = data.CreateFileStorage(filename); err != nil {
+		return nil, err
+	}
+
+	b.commander = dispatch.CreateCommander(conf.Global.GetPrefix())
+	b.commander.Access(b.users)
+	if attachHandlers {
+		b.dispatcher.Register(irc.PRIVMSG, b.commander)
+		access := &accessHandler{bot: b}
+		for _, cmd := range access.commands() {
+			b.commander.Register(cmd)
+		}
+	}
+
 	for name, srv := range conf.Servers {
 		server, err := b.createServer(srv)
 		if err != nil {
@@ -491,18 +680,25 @@ func createBot(conf *config.Config, capsProv CapsProvider,
 func (b *Bot) createServer(conf *config.Server) (*Server, error) {
 	var copyCaps irc.ProtoCaps = *b.caps
 	s := &Server{
-		bot:          b,
-		name:         conf.GetName(),
-		caps:         &copyCaps,
-		conf:         conf,
-		killdispatch: make(chan int),
-		killreconn:   make(chan int),
-		reconnScale:  defaultReconnScale,
+		bot:            b,
+		name:           conf.GetName(),
+		caps:           &copyCaps,
+		conf:           conf,
+		killdispatch:   make(chan int),
+		killreconn:     make(chan int),
+		reconnScale:    defaultReconnScale,
+		keepaliveScale: defaultKeepaliveScale,
+		stateScale:     defaultStateScale,
+		lookupIP:       net.LookupIP,
+		capneg:         createCapNegotiator(),
+		whois:          createWhoisTracker(),
 	}
 
 	if err := s.createDispatcher(conf.GetChannels()); err != nil {
 		return nil, err
 	}
+	s.dispatcher.OnError(b.onHandlerError)
+	s.dispatcher.FailureLimit(b.failureLimit)
 
 	if !conf.GetNoState() {
 		if err := s.createStore(); err != nil {
@@ -510,10 +706,14 @@ func (b *Bot) createServer(conf *config.Server) (*Server, error) {
 		}
 	}
 
+	b.commander.ServerPrefix(s.name, conf.GetPrefix())
+
 	if b.attachHandlers {
+		// The core handler runs serially, CAP LS replies span lines and the
+		// CAP and AUTHENTICATE exchanges depend on the order of the lines.
 		s.handler = &coreHandler{bot: b}
-		s.handlerId =
-			s.dispatcher.Register(irc.RAW, s.handler)
+		s.handlerId = s.dispatcher.RegisterMode(irc.RAW, s.handler,
+			dispatch.DISPATCH_SERIAL)
 	}
 
 	return s, nil
@@ -2,6 +2,8 @@ package bot
 
 import (
 	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/dispatch"
 	"github.com/aarondl/ultimateq/irc"
 	"github.com/aarondl/ultimateq/mocks"
 	"io"
@@ -9,6 +11,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -265,6 +268,61 @@ func (s *s) TestBot_Dispatching(c *C) {
 	b.Disconnect()
 }
 
+type testJoinHandler struct {
+	callback func(*data.UserJoin, irc.Endpoint)
+}
+
+func (h testJoinHandler) UserJoin(ev *data.UserJoin, ep irc.Endpoint) {
+	h.callback(ev, ep)
+}
+
+func (s *s) TestBot_DispatchState(c *C) {
+	str := []byte(":irc 001 nobody :Welcome nobody!nobody@bitforge.ca\r\n" +
+		":nobody!nobody@bitforge.ca JOIN #chan\r\n" +
+		":nick!user@host JOIN #chan\r\n#\r\n")
+
+	conn := mocks.CreateConn()
+	connProvider := func(srv string) (net.Conn, error) {
+		return conn, nil
+	}
+
+	waiter := sync.WaitGroup{}
+	waiter.Add(2)
+	b, err := createBot(fakeConfig, nil, connProvider, false)
+	c.Check(err, IsNil)
+
+	var protect sync.Mutex
+	var joins []*data.UserJoin
+	b.Register(data.STATE_USERJOIN, testJoinHandler{
+		func(ev *data.UserJoin, ep irc.Endpoint) {
+			protect.Lock()
+			joins = append(joins, ev)
+			protect.Unlock()
+			waiter.Done()
+		},
+	})
+
+	ers := b.Connect()
+	c.Check(len(ers), Equals, 0)
+	b.start(false, true)
+
+	conn.Send(str, len(str), io.EOF)
+
+	waiter.Wait()
+	b.Stop()
+	b.WaitForHalt()
+	b.Disconnect()
+
+	c.Assert(len(joins), Equals, 2)
+	fullhosts := map[string]bool{}
+	for _, join := range joins {
+		c.Check(join.Channel, Equals, "#chan")
+		fullhosts[join.Fullhost] = true
+	}
+	c.Check(fullhosts["nobody!nobody@bitforge.ca"], Equals, true)
+	c.Check(fullhosts["nick!user@host"], Equals, true)
+}
+
 func (s *s) TestBot_Register(c *C) {
 	conn := mocks.CreateConn()
 	connProvider := func(srv string) (net.Conn, error) {
@@ -290,6 +348,76 @@ func (s *s) TestBot_Register(c *C) {
 	c.Check(err, Equals, errUnknownServerId)
 }
 
+func (s *s) TestBot_RegisterMode(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	b.WorkerPool(2, 10)
+	var protect sync.Mutex
+	var order []string
+	b.RegisterMode(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			protect.Lock()
+			order = append(order, m.Args[1])
+			protect.Unlock()
+		},
+	}, dispatch.DISPATCH_SERIAL)
+	b.RegisterMode(irc.PRIVMSG, testHandler{}, dispatch.DISPATCH_POOL)
+
+	srv := b.servers[serverId]
+	for i := 0; i < 5; i++ {
+		b.dispatchMessage(srv, &irc.IrcMessage{Name: irc.PRIVMSG,
+			Args: []string{"#chan", strconv.Itoa(i)}})
+	}
+	b.dispatcher.WaitForCompletion()
+
+	c.Check(order, DeepEquals, []string{"0", "1", "2", "3", "4"})
+	stats := b.DispatchStats()
+	c.Check(stats.PoolWorkers, Equals, 2)
+	c.Check(stats.PoolCapacity, Equals, 10)
+	c.Check(len(stats.SerialQueued), Equals, 1)
+}
+
+func (s *s) TestBot_HandlerErrors(c *C) {
+	oldLog := dispatch.Log
+	dispatch.Log = func(string, ...interface{}) {}
+	defer func() { dispatch.Log = oldLog }()
+
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	var protect sync.Mutex
+	var errs []*dispatch.HandlerError
+	b.OnHandlerError(func(herr *dispatch.HandlerError) {
+		protect.Lock()
+		errs = append(errs, herr)
+		protect.Unlock()
+	})
+	b.HandlerFailureLimit(1)
+
+	broken := testHandler{func(m *irc.IrcMessage, ep irc.Endpoint) {
+		panic("broken")
+	}}
+	gid := b.Register(irc.PRIVMSG, broken)
+	id, err := b.RegisterServer(serverId, irc.PRIVMSG, broken)
+	c.Check(err, IsNil)
+
+	srv := b.servers[serverId]
+	b.dispatchMessage(srv, &irc.IrcMessage{Name: irc.PRIVMSG,
+		Args: []string{"#chan", "hi"}})
+	b.dispatcher.WaitForCompletion()
+	srv.dispatcher.WaitForCompletion()
+
+	c.Assert(len(errs), Equals, 2)
+	for _, herr := range errs {
+		c.Check(herr.Key, Equals, serverId)
+		c.Check(herr.Unregistered, Equals, true)
+	}
+	c.Check(b.Unregister(irc.PRIVMSG, gid), Equals, false)
+	ok, err := b.UnregisterServer(serverId, irc.PRIVMSG, id)
+	c.Check(ok, Equals, false)
+}
+
 func (s *s) TestBot_createBot(c *C) {
 	capsProvider := func() *irc.ProtoCaps {
 		return irc.CreateProtoCaps()
@@ -308,6 +436,16 @@ func (s *s) TestBot_createBot(c *C) {
 	c.Check(b.connProvider, NotNil)
 }
 
+func (s *s) TestBot_Close(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(b.Close(), IsNil)
+	b.OpenStorage(func(st data.Storage) {
+		c.Check(st.View(func(data.Tx) error { return nil }), NotNil)
+	})
+	c.Check(b.Close(), NotNil)
+}
+
 func (s *s) TestBot_createServer(c *C) {
 	b, err := createBot(fakeConfig, nil, nil, true)
 	c.Check(err, IsNil)
@@ -315,6 +453,8 @@ func (s *s) TestBot_createServer(c *C) {
 	c.Check(srv.dispatcher, NotNil)
 	c.Check(srv.store, NotNil)
 	c.Check(srv.handler, NotNil)
+	_, ok := srv.dispatcher.Stats().SerialQueued[irc.RAW][srv.handlerId]
+	c.Check(ok, Equals, true)
 
 	cnf := fakeConfig.Clone()
 	cnf.GlobalContext().NoState(true)
@@ -345,10 +485,20 @@ func (s *s) TestBot_Providers(c *C) {
 }
 
 func (s *s) TestBot_createIrcClient(c *C) {
-	b, err := createBot(fakeConfig, nil, nil, false)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	conf := fakeConfig.Clone()
+	delete(conf.Servers, serverId)
+	p, _ := strconv.Atoi(port)
+	conf.Server("local").Host("127.0.0.1").Port(uint16(p))
+	b, err := createBot(conf, nil, nil, false)
 	c.Check(err, IsNil)
 	ers := b.Connect()
-	c.Check(ers[0], Equals, errSslNotImplemented)
+	c.Check(ers, HasLen, 1)
+	c.Check(b.servers["local"].IsConnected(), Equals, false)
 }
 
 func (s *s) TestBot_createDispatcher(c *C) {
@@ -50,6 +50,10 @@ func (b *Bot) ReplaceConfig(newConfig *config.Config) []NewServer {
 		if serverConf := newConfig.GetServer(k); nil == serverConf {
 			b.stopServer(s)
 			b.disconnectServer(s)
+			b.commander.ServerPrefix(k, "")
+			b.commander.ServerNick(k, "")
+			b.commander.Protocaps(k, nil)
+			b.dccProtocaps(k, nil)
 			delete(b.servers, k)
 		} else {
 			setNick := s.conf.GetNick() != serverConf.GetNick()
@@ -63,6 +67,7 @@ func (b *Bot) ReplaceConfig(newConfig *config.Config) []NewServer {
 			}
 
 			s.conf = serverConf
+			b.commander.ServerPrefix(k, s.conf.GetPrefix())
 
 			if setNick {
 				s.Writeln(irc.NICK + " :" + s.conf.GetNick())
@@ -78,6 +83,7 @@ func (b *Bot) ReplaceConfig(newConfig *config.Config) []NewServer {
 
 		b.dispatcher.Channels(newConfig.Global.GetChannels())
 	}
+	b.commander.Prefix(newConfig.Global.GetPrefix())
 
 	for k, s := range newConfig.Servers {
 		if serverConf := b.conf.GetServer(k); nil == serverConf {
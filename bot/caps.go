@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/irc"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// capLS starts capability negotiation.
+	capLS = irc.CAP + " " + irc.CAP_LS + " " + irc.CAP_VERSION
+	// capEnd ends capability negotiation so registration can finish.
+	capEnd = irc.CAP + " " + irc.CAP_END
+	// capReqHeader begins a request for capabilities.
+	capReqHeader = irc.CAP + " " + irc.CAP_REQ + " :"
+	// capMore is given as the third argument of a LS that continues on
+	// another line.
+	capMore = "*"
+)
+
+// capNegotiator tracks IRCv3 capabilities for a server. It negotiates the
+// requested capabilities before registration, holding registration open with
+// CAP until the server has answered, and keeps track of capabilities that
+// come and go afterwards with cap-notify. When SASL is configured it also
+// authenticates before registration is allowed to finish.
+type capNegotiator struct {
+	requested []string
+	available map[string]string
+	enabled   map[string]bool
+
+	sasl   *saslAuth
+	result SaslResult
+
+	listing    map[string]string
+	pending    int
+	registered bool
+
+	protect sync.RWMutex
+}
+
+// createCapNegotiator creates a negotiator with nothing available or enabled.
+func createCapNegotiator() *capNegotiator {
+	return &capNegotiator{
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+	}
+}
+
+// start resets the negotiator for a new connection and begins negotiation.
+// sasl may be nil if no authentication is required.
+func (n *capNegotiator) start(requested []string, sasl *saslAuth,
+	endpoint irc.Endpoint) {
+
+	n.protect.Lock()
+	n.requested = append([]string(nil), requested...)
+	n.sasl = sasl
+	n.result = SaslResult{}
+	if sasl != nil {
+		n.requested = append(n.requested, irc.CAP_SASL)
+		n.result.Mechanism = sasl.mechanism
+	}
+	n.available = make(map[string]string)
+	n.enabled = make(map[string]bool)
+	n.listing = nil
+	n.pending = 0
+	n.registered = false
+	n.protect.Unlock()
+
+	endpoint.Send(capLS)
+}
+
+// handle deals with the messages that affect capabilities.
+func (n *capNegotiator) handle(msg *irc.IrcMessage, endpoint irc.Endpoint) {
+	n.protect.Lock()
+	defer n.protect.Unlock()
+
+	switch msg.Name {
+	case irc.CAP:
+		if len(msg.Args) < 3 {
+			return
+		}
+		n.cap(msg.Args[1], msg.Args[2:], endpoint)
+	case irc.ERR_UNKNOWNCOMMAND:
+		if len(msg.Args) > 1 && msg.Args[1] == irc.CAP {
+			n.registered = true
+		}
+	case irc.RPL_WELCOME:
+		n.registered = true
+	default:
+		n.saslHandle(msg, endpoint)
+	}
+}
+
+// cap handles a CAP subcommand. Not thread safe.
+func (n *capNegotiator) cap(sub string, args []string, endpoint irc.Endpoint) {
+	list := args[len(args)-1]
+
+	switch strings.ToUpper(sub) {
+	case irc.CAP_LS:
+		if n.listing == nil {
+			n.listing = make(map[string]string)
+		}
+		for name, value := range parseCaps(list) {
+			n.listing[name] = value
+		}
+		if len(args) > 1 && args[0] == capMore {
+			return
+		}
+		n.available = n.listing
+		n.listing = nil
+		n.request(endpoint)
+
+	case irc.CAP_NEW:
+		for name, value := range parseCaps(list) {
+			n.available[name] = value
+		}
+		n.request(endpoint)
+
+	case irc.CAP_DEL:
+		for name := range parseCaps(list) {
+			delete(n.available, name)
+			delete(n.enabled, name)
+		}
+
+	case irc.CAP_ACK:
+		for _, name := range strings.Fields(list) {
+			name = strings.TrimLeft(name, "~=")
+			if strings.HasPrefix(name, "-") {
+				delete(n.enabled, name[1:])
+			} else {
+				n.enabled[name] = true
+			}
+		}
+		n.answered(endpoint)
+
+	case irc.CAP_NAK:
+		n.answered(endpoint)
+	}
+}
+
+// request asks for every requested capability that is available and not yet
+// enabled, cap-notify is asked for whenever it's available. Registration is
+// finished if there is nothing to ask for. Not thread safe.
+func (n *capNegotiator) request(endpoint irc.Endpoint) {
+	var wanted []string
+	for _, name := range append([]string{irc.CAP_NOTIFY}, n.requested...) {
+		if _, ok := n.available[name]; !ok || n.enabled[name] {
+			continue
+		}
+		dup := false
+		for _, w := range wanted {
+			dup = dup || w == name
+		}
+		if !dup {
+			wanted = append(wanted, name)
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, name := range wanted {
+		if buf.Len() > 0 &&
+			len(capReqHeader)+buf.Len()+1+len(name) > irc.IRC_MAX_LENGTH {
+
+			n.sendReq(buf.String(), endpoint)
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(name)
+		if i == len(wanted)-1 {
+			n.sendReq(buf.String(), endpoint)
+		}
+	}
+
+	if n.pending == 0 {
+		n.finish(endpoint)
+	}
+}
+
+// sendReq sends a single CAP REQ. Not thread safe.
+func (n *capNegotiator) sendReq(caps string, endpoint irc.Endpoint) {
+	n.pending++
+	endpoint.Send(capReqHeader + caps)
+}
+
+// answered is called when a request was acknowledged or refused. Not thread
+// safe.
+func (n *capNegotiator) answered(endpoint irc.Endpoint) {
+	if n.pending > 0 {
+		n.pending--
+	}
+	if n.pending == 0 {
+		n.finish(endpoint)
+	}
+}
+
+// finish is called when no requests are pending, it finishes negotiation
+// unless SASL authentication has to happen first. Not thread safe.
+func (n *capNegotiator) finish(endpoint irc.Endpoint) {
+	if !n.authenticate(endpoint) {
+		n.end(endpoint)
+	}
+}
+
+// end finishes negotiation if registration is still being held. Not thread
+// safe.
+func (n *capNegotiator) end(endpoint irc.Endpoint) {
+	if n.registered {
+		return
+	}
+	n.registered = true
+	endpoint.Send(capEnd)
+}
+
+// isEnabled checks if a capability is enabled.
+func (n *capNegotiator) isEnabled(name string) bool {
+	n.protect.RLock()
+	defer n.protect.RUnlock()
+	return n.enabled[name]
+}
+
+// getEnabled returns the enabled capabilities sorted by name.
+func (n *capNegotiator) getEnabled() []string {
+	n.protect.RLock()
+	defer n.protect.RUnlock()
+	caps := make([]string, 0, len(n.enabled))
+	for name := range n.enabled {
+		caps = append(caps, name)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// getValue returns the value the server advertised for a capability, and if
+// the server advertised the capability at all.
+func (n *capNegotiator) getValue(name string) (string, bool) {
+	n.protect.RLock()
+	defer n.protect.RUnlock()
+	value, ok := n.available[name]
+	return value, ok
+}
+
+// parseCaps splits a capability list into names and values.
+func parseCaps(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, name := range strings.Fields(list) {
+		value := ""
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		caps[name] = value
+	}
+	return caps
+}
+
+// IsCapEnabled checks if an IRCv3 capability is currently enabled on this
+// server.
+func (s *Server) IsCapEnabled(name string) bool {
+	return s.capneg.isEnabled(name)
+}
+
+// GetEnabledCaps returns the IRCv3 capabilities currently enabled on this
+// server.
+func (s *Server) GetEnabledCaps() []string {
+	return s.capneg.getEnabled()
+}
+
+// GetCapValue returns the value the server gave for a capability in CAP LS or
+// CAP NEW, and if it's available at all.
+func (s *Server) GetCapValue(name string) (string, bool) {
+	return s.capneg.getValue(name)
+}
+
+// IsCapEnabled checks if an IRCv3 capability is currently enabled on the
+// server this endpoint belongs to.
+func (s *ServerEndpoint) IsCapEnabled(name string) bool {
+	return s.server.IsCapEnabled(name)
+}
+
+// GetEnabledCaps returns the IRCv3 capabilities currently enabled on the server
+// this endpoint belongs to.
+func (s *ServerEndpoint) GetEnabledCaps() []string {
+	return s.server.GetEnabledCaps()
+}
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"strconv"
+	"strings"
+)
+
+func capMsg(args ...string) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: irc.CAP, Sender: "irc.test.net", Args: args}
+}
+
+func (s *s) TestCaps_Negotiate(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+
+	n.start([]string{"multi-prefix", "away-notify", "missing"}, nil,
+		endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP LS 302")
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "LS", "*", "multi-prefix sasl=PLAIN,EXTERNAL"),
+		endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+	n.handle(capMsg("*", "LS", "away-notify cap-notify"), endpoint)
+	c.Check(endpoint.gets(), Equals,
+		"CAP REQ :cap-notify multi-prefix away-notify")
+	endpoint.resetTestWritten()
+
+	value, ok := n.getValue("sasl")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "PLAIN,EXTERNAL")
+	_, ok = n.getValue("missing")
+	c.Check(ok, Equals, false)
+
+	n.handle(capMsg("*", "ACK", "cap-notify multi-prefix away-notify "),
+		endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+	c.Check(n.isEnabled("multi-prefix"), Equals, true)
+	c.Check(n.isEnabled("sasl"), Equals, false)
+	c.Check(n.getEnabled(), DeepEquals,
+		[]string{"away-notify", "cap-notify", "multi-prefix"})
+}
+
+func (s *s) TestCaps_Nak(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start([]string{"multi-prefix"}, nil, endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "LS", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :multi-prefix")
+	endpoint.resetTestWritten()
+	n.handle(capMsg("*", "NAK", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+	c.Check(n.isEnabled("multi-prefix"), Equals, false)
+}
+
+func (s *s) TestCaps_NothingWanted(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start(nil, nil, endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "LS", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+
+	// Unsupported servers simply never answer CAP, or give 421.
+	n.start(nil, nil, endpoint)
+	endpoint.resetTestWritten()
+	n.handle(&irc.IrcMessage{Name: irc.ERR_UNKNOWNCOMMAND,
+		Args: []string{"*", "CAP", "Unknown command"}}, endpoint)
+	n.handle(capMsg("*", "LS", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+}
+
+func (s *s) TestCaps_Notify(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start([]string{"away-notify", "account-notify"}, nil, endpoint)
+	n.handle(capMsg("*", "LS", "cap-notify away-notify"), endpoint)
+	n.handle(capMsg("*", "ACK", "cap-notify away-notify"), endpoint)
+	n.handle(&irc.IrcMessage{Name: irc.RPL_WELCOME,
+		Args: []string{"me", "Welcome"}}, endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("me", "NEW", "account-notify extended-join"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :account-notify")
+	endpoint.resetTestWritten()
+	n.handle(capMsg("me", "ACK", "account-notify"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+	c.Check(n.isEnabled("account-notify"), Equals, true)
+
+	n.handle(capMsg("me", "DEL", "away-notify"), endpoint)
+	c.Check(n.isEnabled("away-notify"), Equals, false)
+	_, ok := n.getValue("away-notify")
+	c.Check(ok, Equals, false)
+
+	n.handle(capMsg("me", "ACK", "-account-notify"), endpoint)
+	c.Check(n.getEnabled(), DeepEquals, []string{"cap-notify"})
+}
+
+func (s *s) TestCaps_LongRequest(c *C) {
+	var wanted []string
+	for i := 0; i < 60; i++ {
+		wanted = append(wanted, "vendor.example.com/cap"+strconv.Itoa(i))
+	}
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start(wanted, nil, endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "LS", strings.Join(wanted, " ")), endpoint)
+	reqs := strings.Split(endpoint.gets(), "CAP REQ :")[1:]
+	c.Check(len(reqs) > 1, Equals, true)
+	var got []string
+	for _, req := range reqs {
+		c.Check(len("CAP REQ :"+req) <= irc.IRC_MAX_LENGTH, Equals, true)
+		got = append(got, strings.Fields(req)...)
+	}
+	c.Check(got, DeepEquals, wanted)
+
+	endpoint.resetTestWritten()
+	for i := range reqs {
+		n.handle(capMsg("*", "ACK", strings.TrimSpace(reqs[i])), endpoint)
+		if i < len(reqs)-1 {
+			c.Check(endpoint.gets(), Equals, "")
+		}
+	}
+	c.Check(endpoint.gets(), Equals, "CAP END")
+}
+
+func (s *s) TestCaps_CoreHandler(c *C) {
+	conf := fakeConfig.Clone()
+	conf.Servers[serverId].Caps = []string{"multi-prefix"}
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	srv := b.servers[serverId]
+	endpoint := makeTestPoint(srv)
+
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.CONNECT}, endpoint)
+	c.Check(strings.HasPrefix(endpoint.gets(), "CAP LS 302NICK"), Equals, true)
+	endpoint.resetTestWritten()
+	handler.HandleRaw(capMsg("*", "LS", "multi-prefix"), endpoint)
+	handler.HandleRaw(capMsg("*", "ACK", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :multi-prefixCAP END")
+
+	c.Check(srv.IsCapEnabled("multi-prefix"), Equals, true)
+	c.Check(createServerEndpoint(srv).IsCapEnabled("multi-prefix"), Equals,
+		true)
+	c.Check(createServerEndpoint(srv).GetEnabledCaps(), DeepEquals,
+		[]string{"multi-prefix"})
+	value, ok := srv.GetCapValue("multi-prefix")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "")
+
+	// A reconnect starts over.
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.CONNECT}, endpoint)
+	c.Check(srv.IsCapEnabled("multi-prefix"), Equals, false)
+}
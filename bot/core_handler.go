@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/aarondl/ultimateq/irc"
 	"sync"
+	"time"
 )
 
 // coreHandler is the bot's main handling struct. As such it has access directly
@@ -16,6 +17,9 @@ type coreHandler struct {
 	// How many nicks have been sent.
 	nickvalue int
 
+	// When the ctcp replies in the current ctcp period were sent.
+	ctcpSent []time.Time
+
 	// Protect access to core Handler
 	protect sync.RWMutex
 }
@@ -28,11 +32,18 @@ func (c *coreHandler) HandleRaw(msg *irc.IrcMessage, endpoint irc.Endpoint) {
 	case irc.PING:
 		endpoint.Send(irc.PONG + " :" + msg.Args[0])
 
+	case irc.PRIVMSG:
+		if len(msg.Args) > 1 && irc.IsCTCP(msg.Args[1]) {
+			c.ctcp(&irc.CTCPMessage{IrcMessage: msg}, endpoint)
+		}
+
 	case irc.CONNECT:
 		server := c.getServer(endpoint)
 		c.protect.Lock()
 		c.nickvalue = 0
 		c.protect.Unlock()
+		server.capneg.start(server.conf.GetCaps(),
+			createSaslAuth(server.conf), endpoint)
 		endpoint.Send("NICK :" + server.conf.GetNick())
 		endpoint.Send(fmt.Sprintf(
 			"USER %v 0 * :%v",
@@ -57,6 +68,44 @@ func (c *coreHandler) HandleRaw(msg *irc.IrcMessage, endpoint irc.Endpoint) {
 		c.protect.Unlock()
 		endpoint.Send("NICK :" + nick)
 
+	case irc.CAP, irc.ERR_UNKNOWNCOMMAND, irc.AUTHENTICATE,
+		irc.RPL_LOGGEDIN, irc.RPL_LOGGEDOUT, irc.ERR_NICKLOCKED,
+		irc.RPL_SASLSUCCESS, irc.ERR_SASLFAIL, irc.ERR_SASLTOOLONG,
+		irc.ERR_SASLABORTED, irc.ERR_SASLALREADY:
+		c.getServer(endpoint).capneg.handle(msg, endpoint)
+
+	case irc.RPL_WELCOME:
+		c.getServer(endpoint).capneg.handle(msg, endpoint)
+		if len(msg.Args) > 0 {
+			c.bot.commander.ServerNick(endpoint.GetKey(), msg.Args[0])
+		}
+
+	case irc.NICK:
+		key := endpoint.GetKey()
+		nick := irc.Mask(msg.Sender).GetNick()
+		casemap := c.getServer(endpoint).casemap()
+		if len(msg.Args) > 0 &&
+			casemap.Equals(nick, c.bot.commander.GetServerNick(key)) {
+			c.bot.commander.ServerNick(key, msg.Args[0])
+		}
+		if len(msg.Args) > 0 {
+			c.bot.users.Rename(key, irc.Mask(msg.Sender), msg.Args[0])
+		}
+
+	case irc.QUIT:
+		c.bot.users.Logout(endpoint.GetKey(), irc.Mask(msg.Sender))
+
+	case irc.DISCONNECT:
+		c.bot.users.LogoutServer(endpoint.GetKey())
+		c.getServer(endpoint).whois.abort()
+
+	case irc.RPL_WHOISUSER, irc.RPL_WHOISSERVER, irc.RPL_WHOISOPERATOR,
+		irc.RPL_WHOISIDLE, irc.RPL_WHOISCHANNELS, irc.RPL_WHOISACCOUNT,
+		irc.RPL_WHOISSECURE, irc.RPL_AWAY, irc.RPL_ENDOFWHOIS,
+		irc.ERR_NOSUCHNICK, irc.RPL_WHOWASUSER, irc.RPL_ENDOFWHOWAS,
+		irc.ERR_WASNOSUCHNICK:
+		c.getServer(endpoint).whois.handle(msg)
+
 	case irc.JOIN:
 		server := c.getServer(endpoint)
 		server.protectStore.RLock()
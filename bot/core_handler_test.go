@@ -20,7 +20,7 @@ type testPoint struct {
 
 func makeTestPoint(srv *Server) *testPoint {
 	buf := &bytes.Buffer{}
-	t := &testPoint{&irc.Helper{buf}, buf, srv}
+	t := &testPoint{&irc.Helper{Writer: buf}, buf, srv}
 	return t
 }
 
@@ -62,7 +62,7 @@ func (s *s) TestCoreHandler_Connect(c *C) {
 	msg := &irc.IrcMessage{Name: irc.CONNECT}
 	endpoint := makeTestPoint(b.servers[serverId])
 	handler.HandleRaw(msg, endpoint)
-	c.Check(endpoint.gets(), Equals, msg1+msg2)
+	c.Check(endpoint.gets(), Equals, "CAP LS 302"+msg1+msg2)
 }
 
 func (s *s) TestCoreHandler_Nick(c *C) {
@@ -135,3 +135,30 @@ func (s *s) TestCoreHandler_Join(c *C) {
 	srv.handler.HandleRaw(msg, endpoint)
 	c.Check(endpoint.gets(), Equals, "WHO :#chanMODE :#chan")
 }
+
+func (s *s) TestCoreHandler_CommandNick(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Check(err, IsNil)
+	handler := coreHandler{bot: b}
+	endpoint := makeTestPoint(b.servers[serverId])
+
+	handler.HandleRaw(&irc.IrcMessage{
+		Name: irc.RPL_WELCOME,
+		Args: []string{"mybot", "Welcome"},
+	}, endpoint)
+	c.Check(b.commander.GetServerNick(serverId), Equals, "mybot")
+
+	handler.HandleRaw(&irc.IrcMessage{
+		Name:   irc.NICK,
+		Sender: "other!user@host",
+		Args:   []string{"newother"},
+	}, endpoint)
+	c.Check(b.commander.GetServerNick(serverId), Equals, "mybot")
+
+	handler.HandleRaw(&irc.IrcMessage{
+		Name:   irc.NICK,
+		Sender: "mybot!user@host",
+		Args:   []string{"mybot2"},
+	}, endpoint)
+	c.Check(b.commander.GetServerNick(serverId), Equals, "mybot2")
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"time"
+)
+
+const (
+	// ctcpVersion asks for the client's name and version.
+	ctcpVersion = "VERSION"
+	// ctcpPing asks for it's data to be sent back to measure lag.
+	ctcpPing = "PING"
+	// ctcpTime asks for the client's local time.
+	ctcpTime = "TIME"
+	// ctcpClientinfo asks for the ctcp tags the client answers.
+	ctcpClientinfo = "CLIENTINFO"
+	// ctcpSource asks for where the client's source can be found.
+	ctcpSource = "SOURCE"
+	// ctcpTags is the reply to CLIENTINFO.
+	ctcpTags = "ACTION CLIENTINFO PING SOURCE TIME VERSION"
+)
+
+// ctcp answers the ctcp requests the bot handles itself. Only the server's
+// ctcp burst of replies are sent in each ctcp period so the bot can't be made
+// to flood itself off the server, requests over the limit are ignored.
+func (c *coreHandler) ctcp(msg *irc.CTCPMessage, endpoint irc.Endpoint) {
+	server := c.getServer(endpoint)
+	if server == nil || server.conf.GetNoCtcp() {
+		return
+	}
+	conf := server.conf
+
+	var reply string
+	switch tag := msg.Tag(); tag {
+	case ctcpVersion:
+		reply = conf.GetCtcpVersion()
+	case ctcpPing:
+		reply = msg.Data()
+	case ctcpTime:
+		reply = time.Now().Format(time.RFC1123Z)
+	case ctcpClientinfo:
+		reply = ctcpTags
+	case ctcpSource:
+		reply = conf.GetCtcpSource()
+	default:
+		return
+	}
+
+	period := time.Duration(conf.GetCtcpPeriod() * float64(time.Second))
+	if !c.ctcpAllow(conf.GetCtcpBurst(), period, time.Now()) {
+		return
+	}
+
+	endpoint.Notice(msg.Nick(), irc.CTCPPack(msg.Tag(), reply))
+}
+
+// ctcpAllow checks if another ctcp reply may be sent at now, and counts it if
+// it may.
+func (c *coreHandler) ctcpAllow(burst uint, period time.Duration,
+	now time.Time) bool {
+
+	c.protect.Lock()
+	defer c.protect.Unlock()
+
+	expired := 0
+	for expired < len(c.ctcpSent) && now.Sub(c.ctcpSent[expired]) >= period {
+		expired++
+	}
+	c.ctcpSent = c.ctcpSent[expired:]
+
+	if uint(len(c.ctcpSent)) >= burst {
+		return false
+	}
+	c.ctcpSent = append(c.ctcpSent, now)
+	return true
+}
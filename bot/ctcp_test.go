@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+func ctcpRequest(text string) *irc.IrcMessage {
+	return &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "nick!user@host",
+		Args:   []string{"nobody", text},
+	}
+}
+
+func (s *s) TestCoreHandler_Ctcp(c *C) {
+	conf := fakeConfig.Clone().CtcpVersion("testbot 1.0").
+		CtcpSource("http://example.com/src").CtcpBurst(10)
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	endpoint := makeTestPoint(b.servers[serverId])
+
+	handler.HandleRaw(ctcpRequest("\x01VERSION\x01"), endpoint)
+	c.Check(endpoint.gets(), Equals,
+		"NOTICE nick :\x01VERSION testbot 1.0\x01")
+
+	endpoint.resetTestWritten()
+	handler.HandleRaw(ctcpRequest("\x01PING 12345\x01"), endpoint)
+	c.Check(endpoint.gets(), Equals, "NOTICE nick :\x01PING 12345\x01")
+
+	endpoint.resetTestWritten()
+	handler.HandleRaw(ctcpRequest("\x01SOURCE\x01"), endpoint)
+	c.Check(endpoint.gets(), Equals,
+		"NOTICE nick :\x01SOURCE http://example.com/src\x01")
+
+	endpoint.resetTestWritten()
+	handler.HandleRaw(ctcpRequest("\x01CLIENTINFO\x01"), endpoint)
+	c.Check(endpoint.gets(), Equals,
+		"NOTICE nick :\x01CLIENTINFO "+ctcpTags+"\x01")
+
+	endpoint.resetTestWritten()
+	handler.HandleRaw(ctcpRequest("\x01TIME\x01"), endpoint)
+	c.Check(strings.HasPrefix(endpoint.gets(), "NOTICE nick :\x01TIME "),
+		Equals, true)
+
+	endpoint.resetTestWritten()
+	handler.HandleRaw(ctcpRequest("\x01ACTION waves\x01"), endpoint)
+	handler.HandleRaw(ctcpRequest("\x01FINGER\x01"), endpoint)
+	handler.HandleRaw(ctcpRequest("VERSION"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+}
+
+func (s *s) TestCoreHandler_CtcpDisabled(c *C) {
+	conf := fakeConfig.Clone().NoCtcp(true)
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	endpoint := makeTestPoint(b.servers[serverId])
+
+	handler.HandleRaw(ctcpRequest("\x01VERSION\x01"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+}
+
+func (s *s) TestCoreHandler_CtcpLimit(c *C) {
+	conf := fakeConfig.Clone().CtcpBurst(2).CtcpPeriod(60)
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	endpoint := makeTestPoint(b.servers[serverId])
+
+	for i := 0; i < 5; i++ {
+		handler.HandleRaw(ctcpRequest("\x01PING 1\x01"), endpoint)
+	}
+	c.Check(strings.Count(endpoint.gets(), "NOTICE"), Equals, 2)
+}
+
+func (s *s) TestCoreHandler_ctcpAllow(c *C) {
+	handler := coreHandler{}
+	now := time.Now()
+	period := 10 * time.Second
+
+	c.Check(handler.ctcpAllow(2, period, now), Equals, true)
+	c.Check(handler.ctcpAllow(2, period, now.Add(time.Second)), Equals, true)
+	c.Check(handler.ctcpAllow(2, period, now.Add(2*time.Second)), Equals,
+		false)
+	c.Check(handler.ctcpAllow(2, period, now.Add(10*time.Second)), Equals,
+		true)
+	c.Check(handler.ctcpAllow(2, period, now.Add(10*time.Second)), Equals,
+		false)
+	c.Check(handler.ctcpAllow(0, period, now.Add(time.Hour)), Equals, false)
+}
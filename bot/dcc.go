@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/dcc"
+	"github.com/aarondl/ultimateq/irc"
+)
+
+// DccManager creates a dcc manager from the dcc settings of the config and
+// registers it for irc.CTCP so it sees offers and the replies to it's own.
+// It's kept up to date with the casemap of each server. The handler is called
+// with offers from other users, it may be nil to ignore them.
+func (b *Bot) DccManager(handler func(*dcc.Offer, irc.Endpoint)) *dcc.Manager {
+	var conf dcc.Config
+	b.ReadConfig(func(c *config.Config) {
+		min, max := c.GetDccPorts()
+		conf = dcc.Config{
+			ListenAddress: c.GetDccListenAddress(),
+			PublicIP:      c.GetDccPublicIP(),
+			PortMin:       int(min),
+			PortMax:       int(max),
+			Passive:       c.GetDccPassive(),
+			AnyPeer:       c.GetDccAnyPeer(),
+		}
+	})
+
+	manager := dcc.CreateManager(conf, handler)
+	b.dccProtect.Lock()
+	b.dccs = append(b.dccs, manager)
+	b.dccProtect.Unlock()
+
+	b.serversProtect.RLock()
+	for name, srv := range b.servers {
+		srv.protectCaps.RLock()
+		manager.Protocaps(name, srv.caps)
+		srv.protectCaps.RUnlock()
+	}
+	b.serversProtect.RUnlock()
+
+	b.dispatcher.Register(irc.CTCP, manager)
+	return manager
+}
+
+// dccProtocaps gives the casemap of the server identified by key to every dcc
+// manager.
+func (b *Bot) dccProtocaps(key string, caps *irc.ProtoCaps) {
+	b.dccProtect.RLock()
+	defer b.dccProtect.RUnlock()
+	for _, manager := range b.dccs {
+		manager.Protocaps(key, caps)
+	}
+}
+
+// ServeChat runs the commands said in a dcc chat as if they were sent to the
+// bot in a private message from the other side of the chat, until the chat is
+// closed. Commands reply into the chat through the endpoint they're given.
+// Other handlers never see the lines of a chat, since they would reply on the
+// server instead.
+func (b *Bot) ServeChat(chat *dcc.Chat) {
+	endpoint := chat.Endpoint()
+	sender := b.chatSender(chat)
+	for {
+		line, ok := chat.ReadLine()
+		if !ok {
+			chat.Close()
+			return
+		}
+
+		msg := &irc.IrcMessage{
+			Name:   irc.PRIVMSG,
+			Sender: sender,
+			Args: []string{
+				b.commander.GetServerNick(chat.Key()),
+				line,
+			},
+		}
+		b.commander.PrivmsgUser(&irc.Message{IrcMessage: msg}, endpoint)
+	}
+}
+
+// chatSender returns the full nick!user@host of the other side of a chat, so
+// it's login can be found. If the chat only knows the nick it's looked up in
+// the server's store.
+func (b *Bot) chatSender(chat *dcc.Chat) string {
+	sender := chat.Sender()
+	if len(irc.Mask(sender).GetHost()) > 0 {
+		return sender
+	}
+
+	b.serversProtect.RLock()
+	srv, ok := b.servers[chat.Key()]
+	b.serversProtect.RUnlock()
+	if !ok {
+		return sender
+	}
+
+	srv.protectStore.RLock()
+	defer srv.protectStore.RUnlock()
+	if srv.store != nil {
+		if user := srv.store.GetUser(sender); user != nil &&
+			len(user.GetHost()) > 0 {
+
+			return user.GetFullhost()
+		}
+	}
+	return sender
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/dcc"
+	"github.com/aarondl/ultimateq/dispatch"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/parse"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+func (s *s) TestBot_DccManager(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	b.WriteConfig(func(conf *config.Config) {
+		conf.DccListenAddress("127.0.0.1").DccPorts(6000, 6010)
+	})
+
+	manager := b.DccManager(nil)
+	c.Check(manager, NotNil)
+	conf := manager.Config()
+	c.Check(conf.ListenAddress, Equals, "127.0.0.1")
+	c.Check(conf.PortMin, Equals, 6000)
+	c.Check(conf.PortMax, Equals, 6010)
+}
+
+func (s *s) TestBot_ServeChat(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	b.commander.ServerNick(serverId, "bot")
+
+	cmd := &chatCommand{}
+	c.Assert(b.RegisterCommand(&dispatch.Command{
+		Name:    "ping",
+		Handler: cmd,
+		Scope:   dispatch.CMDSCOPE_PRIVATE,
+	}), IsNil)
+	var privmsgs int
+	b.Register(irc.PRIVMSG, testHandler{
+		func(msg *irc.IrcMessage, ep irc.Endpoint) {
+			privmsgs++
+		},
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	offer := &dcc.Offer{
+		Type:   dcc.DCC_CHAT,
+		Sender: "nick!user@host",
+		IP:     net.IPv4(127, 0, 0, 1),
+		Port:   l.Addr().(*net.TCPAddr).Port,
+	}
+
+	manager := dcc.CreateManager(dcc.Config{}, nil)
+	chat, err := manager.AcceptChat(makeTestPoint(b.servers[serverId]),
+		offer)
+	c.Assert(err, IsNil)
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+
+	done := make(chan int)
+	go func() {
+		b.ServeChat(chat)
+		close(done)
+	}()
+
+	_, err = conn.Write([]byte("ping\n"))
+	c.Check(err, IsNil)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	c.Check(err, IsNil)
+	c.Check(line, Equals, "pong\r\n")
+
+	conn.Close()
+	<-done
+	b.dispatcher.WaitForCompletion()
+	c.Check(privmsgs, Equals, 0)
+	c.Assert(cmd.msg, NotNil)
+	c.Check(cmd.msg.Sender, Equals, "nick!user@host")
+	c.Check(cmd.msg.Args, DeepEquals, []string{"bot", "ping"})
+}
+
+type chatCommand struct {
+	msg *irc.IrcMessage
+}
+
+func (h *chatCommand) Command(_ string, data *dispatch.CommandData,
+	ep irc.Endpoint) error {
+
+	h.msg = data.IrcMessage
+	c := ep.(*dcc.ChatEndpoint)
+	c.Notice(data.Nick(), "pong")
+	return nil
+}
+
+func (s *s) TestBot_DccManagerCasemap(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	b.WriteConfig(func(conf *config.Config) {
+		conf.DccPassive(true).DccAnyPeer(true)
+	})
+	var offers int
+	manager := b.DccManager(func(*dcc.Offer, irc.Endpoint) {
+		offers++
+	})
+	srv := b.servers[serverId]
+	c.Assert(srv.protocaps(rfc1459Caps()), IsNil)
+
+	filename := filepath.Join(c.MkDir(), "file.txt")
+	c.Assert(ioutil.WriteFile(filename, []byte("file"), 0600), IsNil)
+	ep := makeTestPoint(srv)
+	_, err = manager.SendFile(ep, "[nick]!user@127.0.0.1", filename)
+	c.Assert(err, IsNil)
+	msg, err := parse.Parse(bytes.TrimSpace(ep.buf.Bytes()))
+	c.Assert(err, IsNil)
+	offer, err := dcc.ParseOffer(&irc.CTCPMessage{IrcMessage: msg})
+	c.Assert(err, IsNil)
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	l.SetDeadline(time.Now().Add(5 * time.Second))
+	offer.IP = net.IPv4(127, 0, 0, 1)
+	offer.Port = l.Addr().(*net.TCPAddr).Port
+	manager.CTCP(&irc.CTCPMessage{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "{NICK}!user@127.0.0.1",
+		Args:   []string{"bot", offer.String()},
+	}}, ep)
+
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	conn.Close()
+	c.Check(offers, Equals, 0)
+}
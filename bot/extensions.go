@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/extension"
+	"log"
+	"net"
+)
+
+const (
+	// errFmtExtensionHandshake is when an extension fails the handshake.
+	errFmtExtensionHandshake = "bot: Extension handshake failed (%v) (%v)\n"
+)
+
+// ConnectExtension connects to an extension listening on the given network
+// ("tcp" or "unix") and address, and begins serving it events. The extension
+// is dropped and all its handlers unregistered if it crashes or disconnects.
+func (b *Bot) ConnectExtension(network, address string) error {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	return b.serveExtension(conn)
+}
+
+// ListenExtensions accepts connections from extensions (see
+// extension.Extension.Connect) on the given network ("tcp" or "unix") and
+// address. Closing the returned listener stops accepting new extensions, the
+// ones already connected are unaffected.
+func (b *Bot) ListenExtensions(network, address string) (net.Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := b.serveExtension(conn); err != nil {
+					log.Printf(errFmtExtensionHandshake, conn.RemoteAddr(), err)
+				}
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// DisconnectExtensions closes the connections to all extensions.
+func (b *Bot) DisconnectExtensions() {
+	b.extensionsProtect.RLock()
+	remotes := make([]*extension.Remote, 0, len(b.extensions))
+	for r := range b.extensions {
+		remotes = append(remotes, r)
+	}
+	b.extensionsProtect.RUnlock()
+
+	for _, r := range remotes {
+		r.Close()
+	}
+}
+
+// serveExtension performs the handshake with an extension on the other side of
+// conn and keeps track of it until it goes away.
+func (b *Bot) serveExtension(conn net.Conn) error {
+	remote := extension.CreateRemote(conn, b)
+	if err := remote.Start(); err != nil {
+		return err
+	}
+
+	b.extensionsProtect.Lock()
+	b.extensions[remote] = true
+	b.extensionsProtect.Unlock()
+
+	go func() {
+		remote.Wait()
+		b.extensionsProtect.Lock()
+		delete(b.extensions, remote)
+		b.extensionsProtect.Unlock()
+	}()
+	return nil
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"encoding/gob"
+	"github.com/aarondl/ultimateq/extension"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"net"
+	"time"
+)
+
+// extPacket mirrors the extension package's wire format closely enough to act
+// as an extension process in tests.
+type extPacket struct {
+	Kind    int
+	Id      int
+	Version int
+	Name    string
+	Event   string
+	Key     string
+	Data    string
+	Error   string
+	Msg     *irc.IrcMessage
+}
+
+func (s *s) TestBot_ConnectExtension(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+		var hello extPacket
+		dec.Decode(&hello)
+		enc.Encode(&extPacket{Kind: hello.Kind,
+			Version: extension.ProtocolVersion, Name: "ext"})
+		enc.Encode(&extPacket{Kind: hello.Kind + 1, Id: 1,
+			Event: irc.PRIVMSG})
+		accepted <- conn
+	}()
+
+	err = b.ConnectExtension("tcp", ln.Addr().String())
+	c.Check(err, IsNil)
+	conn := <-accepted
+	c.Assert(conn, NotNil)
+
+	b.extensionsProtect.RLock()
+	c.Check(len(b.extensions), Equals, 1)
+	b.extensionsProtect.RUnlock()
+
+	// Crashing extensions must be forgotten by the bot.
+	conn.Close()
+	for i := 0; i < 200; i++ {
+		b.extensionsProtect.RLock()
+		n := len(b.extensions)
+		b.extensionsProtect.RUnlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.extensionsProtect.RLock()
+	c.Check(len(b.extensions), Equals, 0)
+	b.extensionsProtect.RUnlock()
+
+	err = b.ConnectExtension("tcp", "127.0.0.1:1")
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestBot_ListenExtensions(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	ln, err := b.ListenExtensions("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer ln.Close()
+
+	ext := extension.CreateExtension("ext")
+	ext.Register(irc.PRIVMSG, testHandler{})
+	err = ext.Connect("tcp", ln.Addr().String())
+	c.Check(err, IsNil)
+
+	registered := false
+	for i := 0; i < 200 && !registered; i++ {
+		b.extensionsProtect.RLock()
+		registered = len(b.extensions) == 1
+		b.extensionsProtect.RUnlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Check(registered, Equals, true)
+
+	ext.Close()
+	b.DisconnectExtensions()
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+	"sync"
+	"time"
+)
+
+const (
+	// fmtKeepaliveToken is the token sent in keepalive pings.
+	fmtKeepaliveToken = "uq%d"
+	// fmtStale shows when a connection has gone quiet for too long.
+	fmtStale = "bot: %v has been silent for %v, connection is stale"
+)
+
+// keepalive pings a server periodically to measure the lag, and keeps track
+// of when the server last sent anything to notice a dead connection.
+type keepalive struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	token    string
+	sent     time.Time
+	lastRead time.Time
+	lag      time.Duration
+
+	protect sync.RWMutex
+}
+
+// createKeepalive creates a keepalive that pings every interval, and times
+// out after timeout without any traffic. 0 disables either.
+func createKeepalive(interval, timeout time.Duration,
+	now time.Time) *keepalive {
+
+	return &keepalive{
+		interval: interval,
+		timeout:  timeout,
+		sent:     now,
+		lastRead: now,
+	}
+}
+
+// tick checks the keepalive at time now. It returns the token to ping the
+// server with if a ping is due, true if the connection has timed out, and how
+// long until it has to be checked again, 0 if never.
+func (k *keepalive) tick(now time.Time) (
+	ping string, stale bool, next time.Duration) {
+
+	k.protect.Lock()
+	defer k.protect.Unlock()
+
+	if k.timeout > 0 && now.Sub(k.lastRead) >= k.timeout {
+		return "", true, 0
+	}
+
+	if k.interval > 0 {
+		switch {
+		case len(k.token) > 0:
+			next = k.interval
+		case now.Sub(k.sent) >= k.interval:
+			k.token = fmt.Sprintf(fmtKeepaliveToken, now.UnixNano())
+			k.sent = now
+			ping = k.token
+			next = k.interval
+		default:
+			next = k.sent.Add(k.interval).Sub(now)
+		}
+	}
+
+	if k.timeout > 0 {
+		if wait := k.lastRead.Add(k.timeout).Sub(now); next == 0 ||
+			wait < next {
+			next = wait
+		}
+	}
+	return
+}
+
+// read records traffic from the server at time now, msg is nil if it
+// couldn't be parsed. A pong to the last ping measures the lag.
+func (k *keepalive) read(now time.Time, msg *irc.IrcMessage) {
+	k.protect.Lock()
+	defer k.protect.Unlock()
+
+	k.lastRead = now
+	if msg == nil || msg.Name != irc.PONG || len(msg.Args) == 0 ||
+		len(k.token) == 0 {
+		return
+	}
+
+	if msg.Args[len(msg.Args)-1] == k.token {
+		k.lag = now.Sub(k.sent)
+		k.token = ""
+	}
+}
+
+// currentLag returns the round trip time of the last ping at time now. While
+// a ping is waiting on a reply the lag is at least as long as it's been
+// waiting.
+func (k *keepalive) currentLag(now time.Time) time.Duration {
+	k.protect.RLock()
+	defer k.protect.RUnlock()
+
+	if len(k.token) > 0 {
+		if waiting := now.Sub(k.sent); waiting > k.lag {
+			return waiting
+		}
+	}
+	return k.lag
+}
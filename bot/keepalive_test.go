@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/mocks"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"strings"
+	"time"
+)
+
+func pong(token string) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: irc.PONG, Args: []string{"irc", token}}
+}
+
+func (s *s) TestKeepalive(c *C) {
+	now := time.Now()
+	k := createKeepalive(10*time.Second, 30*time.Second, now)
+
+	ping, stale, next := k.tick(now.Add(4 * time.Second))
+	c.Check(ping, Equals, "")
+	c.Check(stale, Equals, false)
+	c.Check(next, Equals, 6*time.Second)
+
+	now = now.Add(10 * time.Second)
+	ping, stale, next = k.tick(now)
+	c.Check(len(ping) > 0, Equals, true)
+	c.Check(stale, Equals, false)
+	c.Check(next, Equals, 10*time.Second)
+	c.Check(k.currentLag(now.Add(time.Second)), Equals, time.Second)
+
+	k.read(now.Add(time.Second), pong("other"))
+	c.Check(k.currentLag(now.Add(2*time.Second)), Equals, 2*time.Second)
+	k.read(now.Add(2*time.Second), nil)
+	k.read(now.Add(3*time.Second), pong(ping))
+	c.Check(k.currentLag(now.Add(time.Hour)), Equals, 3*time.Second)
+
+	again, _, _ := k.tick(now.Add(5 * time.Second))
+	c.Check(again, Equals, "")
+	again, _, _ = k.tick(now.Add(10 * time.Second))
+	c.Check(again, Not(Equals), "")
+	c.Check(again, Not(Equals), ping)
+
+	_, stale, next = k.tick(now.Add(30 * time.Second))
+	c.Check(stale, Equals, false)
+	c.Check(next, Equals, 3*time.Second)
+	_, stale, _ = k.tick(now.Add(33 * time.Second))
+	c.Check(stale, Equals, true)
+}
+
+func (s *s) TestKeepalive_Disabled(c *C) {
+	now := time.Now()
+	k := createKeepalive(0, 0, now)
+	ping, stale, next := k.tick(now.Add(time.Hour))
+	c.Check(ping, Equals, "")
+	c.Check(stale, Equals, false)
+	c.Check(next, Equals, time.Duration(0))
+	c.Check(k.currentLag(now), Equals, time.Duration(0))
+
+	k = createKeepalive(0, time.Second, now)
+	_, _, next = k.tick(now)
+	c.Check(next, Equals, time.Second)
+	k.read(now.Add(time.Second/2), nil)
+	_, stale, next = k.tick(now.Add(time.Second))
+	c.Check(stale, Equals, false)
+	c.Check(next, Equals, time.Second/2)
+}
+
+func (s *s) TestBot_Keepalive(c *C) {
+	conn := mocks.CreateConn()
+	connProvider := func(srv string) (net.Conn, error) {
+		return conn, nil
+	}
+
+	conf := fakeConfig.Clone().KeepaliveInterval(10).KeepaliveTimeout(100)
+	b, err := createBot(conf, nil, connProvider, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+	srv.keepaliveScale = time.Millisecond
+	c.Check(srv.Lag(), Equals, time.Duration(0))
+
+	disconnected := make(chan int)
+	b.Register(irc.DISCONNECT, testHandler{
+		func(m *irc.IrcMessage, _ irc.Endpoint) {
+			close(disconnected)
+		},
+	})
+
+	writes := make(chan []byte, 100)
+	go func() {
+		for {
+			writes <- conn.Receive(512, nil)
+		}
+	}()
+
+	c.Check(len(b.Connect()), Equals, 0)
+	b.Start()
+
+	var token string
+	for len(token) == 0 {
+		msg := <-writes
+		if bytes.HasPrefix(msg, []byte("PING :")) {
+			token = strings.TrimSpace(string(msg[6:]))
+		}
+	}
+
+	reply := []byte(":irc PONG irc :" + token + "\r\n")
+	conn.Send(reply, len(reply), nil)
+	var lag time.Duration
+	for answered := false; !answered; time.Sleep(time.Millisecond) {
+		srv.keepalive.protect.RLock()
+		answered, lag = srv.keepalive.token != token, srv.keepalive.lag
+		srv.keepalive.protect.RUnlock()
+	}
+	c.Check(lag > 0, Equals, true)
+	c.Check(createServerEndpoint(srv).Lag() > 0, Equals, true)
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		c.Fatal("The stale connection was never disconnected.")
+	}
+
+	conn.Send([]byte{}, 0, io.EOF)
+	b.Stop()
+	b.Disconnect()
+	b.WaitForHalt()
+}
@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"time"
+)
+
+const (
+	// fmtReconnectAborted shows when the bot gives up reconnecting a server.
+	fmtReconnectAborted = "bot: %v gave up reconnecting after %v attempts"
+)
+
+// backoff returns how long to wait before the attempt'th reconnect. The wait
+// starts at base and doubles with each attempt until it reaches max, then up
+// to half of it is taken off at random with jitter so bots that lost the same
+// server don't all come back at once. jitter returns a random number in
+// [0, n) like rand.Int63n.
+func backoff(base, max time.Duration, attempt int,
+	jitter func(int64) int64) time.Duration {
+
+	if max < base {
+		max = base
+	}
+
+	wait := base
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	if half := wait / 2; half > 0 {
+		wait -= time.Duration(jitter(int64(half) + 1))
+	}
+	return wait
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/mocks"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func (s *s) TestBackoff(c *C) {
+	none := func(n int64) int64 { return 0 }
+	most := func(n int64) int64 { return n - 1 }
+
+	c.Check(backoff(time.Second, time.Minute, 1, none), Equals, time.Second)
+	c.Check(backoff(time.Second, time.Minute, 2, none), Equals, 2*time.Second)
+	c.Check(backoff(time.Second, time.Minute, 5, none), Equals, 16*time.Second)
+	c.Check(backoff(time.Second, time.Minute, 7, none), Equals, time.Minute)
+	c.Check(backoff(time.Second, time.Minute, 1000, none), Equals, time.Minute)
+
+	c.Check(backoff(time.Second, time.Minute, 1, most), Equals, time.Second/2)
+	c.Check(backoff(time.Second, time.Minute, 9, most), Equals, time.Minute/2)
+
+	c.Check(backoff(time.Minute, time.Second, 3, none), Equals, time.Minute)
+	c.Check(backoff(0, time.Minute, 3, most), Equals, time.Duration(0))
+
+	var asked int64
+	backoff(10*time.Second, time.Minute, 1, func(n int64) int64 {
+		asked = n
+		return 0
+	})
+	c.Check(asked, Equals, int64(5*time.Second)+1)
+}
+
+func (s *s) TestBot_ReconnectHosts(c *C) {
+	conf := fakeConfig.Clone().NoReconnect(false).ReconnectTimeout(1).
+		ReconnectAttempts(3).ServerContext(serverId).Hosts("backup.net:7000")
+
+	conn := mocks.CreateConn()
+	protect := sync.Mutex{}
+	var dialed []string
+	connProvider := func(srv string) (net.Conn, error) {
+		protect.Lock()
+		defer protect.Unlock()
+		dialed = append(dialed, srv)
+		if len(dialed) == 1 {
+			return conn, nil
+		}
+		return nil, io.EOF
+	}
+
+	b, err := createBot(conf, nil, connProvider, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+	srv.reconnScale = time.Microsecond
+	hosts := srv.conf.GetHosts()
+	c.Assert(len(hosts), Equals, 2)
+
+	events := make(chan *irc.IrcMessage, 10)
+	handler := testHandler{
+		func(m *irc.IrcMessage, _ irc.Endpoint) {
+			events <- m
+		},
+	}
+	b.Register(irc.RECONNECT, handler)
+	b.Register(irc.RECONNECT_FAILED, handler)
+	b.Register(irc.RECONNECT_ABORTED, handler)
+
+	c.Check(len(b.Connect()), Equals, 0)
+	b.start(false, true)
+	conn.Send([]byte{}, 0, io.EOF)
+
+	// Handlers are called concurrently so the events are sorted out by name
+	// and attempt rather than by the order they arrive in.
+	attempts := make(map[string][]string)
+	failures := make(map[string][]string)
+	var aborted []string
+	for i := 0; i < 7; i++ {
+		select {
+		case m := <-events:
+			switch m.Name {
+			case irc.RECONNECT:
+				attempts[m.Args[1]] = m.Args
+			case irc.RECONNECT_FAILED:
+				failures[m.Args[1]] = m.Args
+			case irc.RECONNECT_ABORTED:
+				aborted = m.Args
+			}
+		case <-time.After(5 * time.Second):
+			c.Fatal("The bot never gave up reconnecting.")
+		}
+	}
+	b.WaitForHalt()
+
+	c.Check(len(attempts), Equals, 3)
+	c.Check(len(failures), Equals, 3)
+	for i := 0; i < 3; i++ {
+		n := strconv.Itoa(i + 1)
+		attempt, failed := attempts[n], failures[n]
+		c.Assert(len(attempt), Equals, 3)
+		c.Assert(len(failed), Equals, 4)
+		c.Check(attempt[0], Equals, hosts[i%2])
+		c.Check(failed[:3], DeepEquals, attempt)
+		c.Check(failed[3], Equals, io.EOF.Error())
+	}
+	c.Check(aborted, DeepEquals, []string{"3"})
+
+	protect.Lock()
+	c.Check(dialed, DeepEquals,
+		[]string{hosts[0], hosts[0], hosts[1], hosts[0]})
+	protect.Unlock()
+	c.Check(srv.IsConnected(), Equals, false)
+	c.Check(srv.IsReconnecting(), Equals, false)
+}
+
+func (s *s) TestServer_DialAddresses(c *C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+
+	var looked string
+	srv.lookupIP = func(host string) ([]net.IP, error) {
+		looked = host
+		return []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)}, nil
+	}
+	conn, err := srv.dial(net.JoinHostPort("irc.test.net", port))
+	c.Assert(err, IsNil)
+	c.Check(looked, Equals, "irc.test.net")
+	c.Check(conn.RemoteAddr().String(), Equals, listener.Addr().String())
+	conn.Close()
+
+	srv.lookupIP = func(host string) ([]net.IP, error) {
+		return nil, nil
+	}
+	_, err = srv.dial("irc.test.net:6667")
+	c.Check(err, NotNil)
+
+	lookupErr := errors.New("no such host")
+	srv.lookupIP = func(host string) ([]net.IP, error) {
+		return nil, lookupErr
+	}
+	_, err = srv.dial("irc.test.net:6667")
+	c.Check(err, Equals, lookupErr)
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"encoding/base64"
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+)
+
+// SASL States
+const (
+	SASL_NONE    = 0x0
+	SASL_PENDING = 0x1
+	SASL_SUCCESS = 0x2
+	SASL_FAILED  = 0x3
+)
+
+const (
+	// saslChunkSize is the most base64 that fits in a single AUTHENTICATE.
+	saslChunkSize = 400
+	// saslQuit is the quit message when a required authentication fails.
+	saslQuit = "SASL authentication failed"
+)
+
+// SaslResult is the outcome of SASL authentication on a server.
+type SaslResult struct {
+	// State is one of the SASL_* states.
+	State int
+	// Mechanism is the mechanism that was used, empty if SASL was not
+	// configured.
+	Mechanism string
+	// Account is the account the server has logged the bot in to, this can
+	// change after registration as the server sends RPL_LOGGEDIN and
+	// RPL_LOGGEDOUT.
+	Account string
+	// Numeric is the numeric that ended a failed authentication, empty if it
+	// failed because the server does not offer SASL or the mechanism.
+	Numeric string
+}
+
+// IsAuthed checks if the bot is logged in to an account.
+func (r SaslResult) IsAuthed() bool {
+	return len(r.Account) > 0
+}
+
+// saslAuth holds what's required to authenticate with SASL.
+type saslAuth struct {
+	mechanism string
+	account   string
+	password  string
+	required  bool
+}
+
+// createSaslAuth creates the SASL settings for a server, returns nil if SASL
+// is not configured.
+func createSaslAuth(conf *config.Server) *saslAuth {
+	mechanism := strings.ToUpper(conf.GetSaslMechanism())
+	if len(mechanism) == 0 {
+		return nil
+	}
+	return &saslAuth{
+		mechanism: mechanism,
+		account:   conf.GetSaslAccount(),
+		password:  conf.GetSaslPassword(),
+		required:  conf.GetSaslRequired(),
+	}
+}
+
+// payload returns the base64 encoded response to the server's challenge.
+func (a *saslAuth) payload() string {
+	if a.mechanism != irc.SASL_PLAIN {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(
+		[]byte("\x00" + a.account + "\x00" + a.password))
+}
+
+// authenticate starts SASL once the capability has been negotiated, and
+// reports true if registration must be held until it's done. Not thread safe.
+func (n *capNegotiator) authenticate(endpoint irc.Endpoint) bool {
+	if n.sasl == nil || n.registered {
+		return false
+	}
+
+	switch n.result.State {
+	case SASL_PENDING:
+		return true
+	case SASL_NONE:
+	default:
+		return false
+	}
+
+	mechs, ok := n.available[irc.CAP_SASL]
+	if !ok || !n.enabled[irc.CAP_SASL] {
+		return n.saslFailed("", endpoint)
+	}
+	if len(mechs) > 0 {
+		offered := false
+		for _, mech := range strings.Split(mechs, ",") {
+			offered = offered || strings.EqualFold(mech, n.sasl.mechanism)
+		}
+		if !offered {
+			return n.saslFailed("", endpoint)
+		}
+	}
+
+	n.result.State = SASL_PENDING
+	endpoint.Send(irc.AUTHENTICATE + " " + n.sasl.mechanism)
+	return true
+}
+
+// saslHandle deals with AUTHENTICATE and the SASL numerics. Not thread safe.
+func (n *capNegotiator) saslHandle(msg *irc.IrcMessage,
+	endpoint irc.Endpoint) {
+
+	switch msg.Name {
+	case irc.RPL_LOGGEDIN:
+		if len(msg.Args) > 2 {
+			n.result.Account = msg.Args[2]
+		}
+		return
+	case irc.RPL_LOGGEDOUT:
+		n.result.Account = ""
+		return
+	}
+
+	if n.result.State != SASL_PENDING {
+		return
+	}
+
+	switch msg.Name {
+	case irc.AUTHENTICATE:
+		if len(msg.Args) > 0 && msg.Args[0] == irc.SASL_EMPTY {
+			n.saslRespond(endpoint)
+		}
+	case irc.RPL_SASLSUCCESS, irc.ERR_SASLALREADY:
+		n.result.State = SASL_SUCCESS
+		n.end(endpoint)
+	case irc.ERR_SASLFAIL, irc.ERR_SASLTOOLONG, irc.ERR_SASLABORTED,
+		irc.ERR_NICKLOCKED:
+		n.saslFailed(msg.Name, endpoint)
+	}
+}
+
+// saslRespond answers the server's challenge, splitting the response over as
+// many AUTHENTICATE messages as it takes. Not thread safe.
+func (n *capNegotiator) saslRespond(endpoint irc.Endpoint) {
+	payload := n.sasl.payload()
+	for len(payload) >= saslChunkSize {
+		endpoint.Send(irc.AUTHENTICATE + " " + payload[:saslChunkSize])
+		payload = payload[saslChunkSize:]
+	}
+	if len(payload) == 0 {
+		payload = irc.SASL_EMPTY
+	}
+	endpoint.Send(irc.AUTHENTICATE + " " + payload)
+}
+
+// saslFailed records a failed authentication and either aborts the connection
+// or finishes registration unauthenticated depending on configuration. Always
+// returns true as registration is either finished or abandoned here. Not
+// thread safe.
+func (n *capNegotiator) saslFailed(numeric string,
+	endpoint irc.Endpoint) bool {
+
+	n.result.State = SASL_FAILED
+	n.result.Numeric = numeric
+	if n.sasl.required {
+		n.registered = true
+		endpoint.Quit(saslQuit)
+	} else {
+		n.end(endpoint)
+	}
+	return true
+}
+
+// getSasl returns the outcome of SASL authentication.
+func (n *capNegotiator) getSasl() SaslResult {
+	n.protect.RLock()
+	defer n.protect.RUnlock()
+	return n.result
+}
+
+// GetSasl returns the outcome of SASL authentication on this server.
+func (s *Server) GetSasl() SaslResult {
+	return s.capneg.getSasl()
+}
+
+// GetSasl returns the outcome of SASL authentication on the server this
+// endpoint belongs to.
+func (s *ServerEndpoint) GetSasl() SaslResult {
+	return s.server.GetSasl()
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"encoding/base64"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+func saslMsg(name string, args ...string) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: name, Sender: "irc.test.net", Args: args}
+}
+
+func (s *s) TestSasl_Plain(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	auth := &saslAuth{mechanism: irc.SASL_PLAIN, account: "acct",
+		password: "pass"}
+	n.start([]string{"multi-prefix"}, auth, endpoint)
+	c.Check(n.getSasl().State, Equals, SASL_NONE)
+	c.Check(n.getSasl().Mechanism, Equals, irc.SASL_PLAIN)
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "LS", "multi-prefix sasl=PLAIN,EXTERNAL"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :multi-prefix sasl")
+	endpoint.resetTestWritten()
+
+	n.handle(capMsg("*", "ACK", "multi-prefix sasl"), endpoint)
+	c.Check(endpoint.gets(), Equals, "AUTHENTICATE PLAIN")
+	c.Check(n.getSasl().State, Equals, SASL_PENDING)
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.AUTHENTICATE, "+"), endpoint)
+	c.Check(endpoint.gets(), Equals, "AUTHENTICATE "+
+		base64.StdEncoding.EncodeToString([]byte("\x00acct\x00pass")))
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.RPL_LOGGEDIN, "*", "me!u@h", "acct",
+		"You are now logged in as acct"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+	n.handle(saslMsg(irc.RPL_SASLSUCCESS, "*", "SASL successful"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+
+	result := n.getSasl()
+	c.Check(result.State, Equals, SASL_SUCCESS)
+	c.Check(result.Account, Equals, "acct")
+	c.Check(result.IsAuthed(), Equals, true)
+	c.Check(result.Numeric, Equals, "")
+
+	n.handle(saslMsg(irc.RPL_LOGGEDOUT, "me", "me!u@h", "Logged out"),
+		endpoint)
+	c.Check(n.getSasl().IsAuthed(), Equals, false)
+	c.Check(n.getSasl().State, Equals, SASL_SUCCESS)
+}
+
+func (s *s) TestSasl_External(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start(nil, &saslAuth{mechanism: irc.SASL_EXTERNAL}, endpoint)
+	n.handle(capMsg("*", "LS", "sasl"), endpoint)
+	n.handle(capMsg("*", "ACK", "sasl"), endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.AUTHENTICATE, "+"), endpoint)
+	c.Check(endpoint.gets(), Equals, "AUTHENTICATE +")
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.ERR_SASLALREADY, "me", "Already authed"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+	c.Check(n.getSasl().State, Equals, SASL_SUCCESS)
+}
+
+func (s *s) TestSasl_Chunks(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+
+	// 3 bytes of input become 4 of base64, 300 bytes exactly fill a chunk.
+	password := strings.Repeat("p", 300*2-len("\x00acct\x00"))
+	n.start(nil, &saslAuth{mechanism: irc.SASL_PLAIN, account: "acct",
+		password: password}, endpoint)
+	n.handle(capMsg("*", "LS", "sasl"), endpoint)
+	n.handle(capMsg("*", "ACK", "sasl"), endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.AUTHENTICATE, "+"), endpoint)
+	chunks := strings.Split(endpoint.gets(), "AUTHENTICATE ")[1:]
+	c.Assert(len(chunks), Equals, 3)
+	c.Check(len(chunks[0]), Equals, saslChunkSize)
+	c.Check(len(chunks[1]), Equals, saslChunkSize)
+	c.Check(chunks[2], Equals, "+")
+	decoded, err := base64.StdEncoding.DecodeString(chunks[0] + chunks[1])
+	c.Check(err, IsNil)
+	c.Check(string(decoded), Equals, "\x00acct\x00"+password)
+}
+
+func (s *s) TestSasl_FailContinue(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start(nil, &saslAuth{mechanism: irc.SASL_PLAIN, account: "acct",
+		password: "wrong"}, endpoint)
+	n.handle(capMsg("*", "LS", "sasl"), endpoint)
+	n.handle(capMsg("*", "ACK", "sasl"), endpoint)
+	n.handle(saslMsg(irc.AUTHENTICATE, "+"), endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.ERR_SASLFAIL, "*", "SASL failed"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+	result := n.getSasl()
+	c.Check(result.State, Equals, SASL_FAILED)
+	c.Check(result.Numeric, Equals, irc.ERR_SASLFAIL)
+	c.Check(result.IsAuthed(), Equals, false)
+
+	// Late numerics change nothing.
+	endpoint.resetTestWritten()
+	n.handle(saslMsg(irc.RPL_SASLSUCCESS, "*", "SASL successful"), endpoint)
+	c.Check(endpoint.gets(), Equals, "")
+	c.Check(n.getSasl().State, Equals, SASL_FAILED)
+}
+
+func (s *s) TestSasl_FailAbort(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	n.start(nil, &saslAuth{mechanism: irc.SASL_PLAIN, account: "acct",
+		password: "wrong", required: true}, endpoint)
+	n.handle(capMsg("*", "LS", "sasl"), endpoint)
+	n.handle(capMsg("*", "ACK", "sasl"), endpoint)
+	endpoint.resetTestWritten()
+
+	n.handle(saslMsg(irc.ERR_SASLABORTED, "*", "Aborted"), endpoint)
+	c.Check(endpoint.gets(), Equals, "QUIT :"+saslQuit)
+	c.Check(n.getSasl().State, Equals, SASL_FAILED)
+	c.Check(n.getSasl().Numeric, Equals, irc.ERR_SASLABORTED)
+}
+
+func (s *s) TestSasl_Unavailable(c *C) {
+	n := createCapNegotiator()
+	endpoint := makeTestPoint(nil)
+	auth := &saslAuth{mechanism: irc.SASL_EXTERNAL}
+	n.start(nil, auth, endpoint)
+	endpoint.resetTestWritten()
+	n.handle(capMsg("*", "LS", "multi-prefix"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP END")
+	c.Check(n.getSasl().State, Equals, SASL_FAILED)
+	c.Check(n.getSasl().Numeric, Equals, "")
+
+	// The mechanism isn't offered.
+	auth.required = true
+	n.start(nil, auth, endpoint)
+	endpoint.resetTestWritten()
+	n.handle(capMsg("*", "LS", "sasl=PLAIN"), endpoint)
+	n.handle(capMsg("*", "ACK", "sasl"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :sasl"+"QUIT :"+saslQuit)
+	c.Check(n.getSasl().State, Equals, SASL_FAILED)
+
+	// The capability is refused.
+	auth.required = false
+	n.start(nil, auth, endpoint)
+	endpoint.resetTestWritten()
+	n.handle(capMsg("*", "LS", "sasl"), endpoint)
+	n.handle(capMsg("*", "NAK", "sasl"), endpoint)
+	c.Check(endpoint.gets(), Equals, "CAP REQ :saslCAP END")
+	c.Check(n.getSasl().State, Equals, SASL_FAILED)
+}
+
+func (s *s) TestSasl_CoreHandler(c *C) {
+	conf := fakeConfig.Clone()
+	conf.Servers[serverId].SaslMechanism = "plain"
+	conf.Servers[serverId].SaslAccount = "acct"
+	conf.Servers[serverId].SaslPassword = "pass"
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	srv := b.servers[serverId]
+	endpoint := makeTestPoint(srv)
+
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.CONNECT}, endpoint)
+	handler.HandleRaw(capMsg("*", "LS", "sasl"), endpoint)
+	handler.HandleRaw(capMsg("*", "ACK", "sasl"), endpoint)
+	endpoint.resetTestWritten()
+	handler.HandleRaw(saslMsg(irc.AUTHENTICATE, "+"), endpoint)
+	c.Check(strings.HasPrefix(endpoint.gets(), "AUTHENTICATE "), Equals, true)
+	handler.HandleRaw(saslMsg(irc.RPL_LOGGEDIN, "*", "me!u@h", "acct",
+		"Logged in"), endpoint)
+	handler.HandleRaw(saslMsg(irc.RPL_SASLSUCCESS, "*", "Success"), endpoint)
+
+	result := createServerEndpoint(srv).GetSasl()
+	c.Check(result.State, Equals, SASL_SUCCESS)
+	c.Check(result.Mechanism, Equals, irc.SASL_PLAIN)
+	c.Check(result.Account, Equals, "acct")
+
+	// Without configuration nothing happens.
+	b, err = createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv = b.servers[serverId]
+	handler = coreHandler{bot: b}
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.CONNECT}, endpoint)
+	c.Check(srv.GetSasl(), Equals, SaslResult{})
+}
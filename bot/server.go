@@ -9,7 +9,6 @@ import (
 	"github.com/aarondl/ultimateq/inet"
 	"github.com/aarondl/ultimateq/irc"
 	"net"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -29,13 +28,25 @@ const (
 	// errServerAlreadyConnected occurs if a server has not been shutdown
 	// before another attempt to connect to it is made.
 	errFmtAlreadyConnected = "bot: %v already connected.\n"
+	// errFmtProxy occurs when a connection through the server's proxy can't
+	// be made.
+	errFmtProxy = "bot: %v proxy failed (%v)"
+	// errFmtNoHosts occurs when a server has no hosts to connect to.
+	errFmtNoHosts = "bot: %v has no hosts to connect to"
+	// errFmtNoAddresses occurs when a host doesn't resolve to any addresses.
+	errFmtNoAddresses = "bot: %v has no addresses"
 )
 
 var (
 	// errNotConnected happens when a write occurs to a disconnected server.
 	errNotConnected = errors.New("bot: Server not connected")
-	// temporary error until ssl is fixed.
-	errSslNotImplemented = errors.New("bot: Ssl not implemented")
+
+	// outputOverflows maps the configured overflow policies to the inet ones.
+	outputOverflows = map[string]int{
+		config.OVERFLOW_OLDEST:   inet.OVERFLOW_DROP_OLDEST,
+		config.OVERFLOW_NEWEST:   inet.OVERFLOW_DROP_NEWEST,
+		config.OVERFLOW_TRUNCATE: inet.OVERFLOW_TRUNCATE,
+	}
 )
 
 // Server is all the details around a specific server connection. Also contains
@@ -49,8 +60,18 @@ type Server struct {
 	conf       *config.Server
 	caps       *irc.ProtoCaps
 	store      *data.Store
+	capneg     *capNegotiator
+	whois      *whoisTracker
+	keepalive  *keepalive
+
+	reconnScale    time.Duration
+	keepaliveScale time.Duration
+	stateScale     time.Duration
 
-	reconnScale time.Duration
+	// hostTurn is the index of the host to connect to next, and lookupIP
+	// resolves a host's A and AAAA records.
+	hostTurn int
+	lookupIP func(string) ([]net.IP, error)
 
 	killdispatch chan int
 	killreconn   chan int
@@ -76,7 +97,7 @@ type ServerEndpoint struct {
 
 // createServerEndpoint creates a ServerEndpoint with a helper.
 func createServerEndpoint(srv *Server) *ServerEndpoint {
-	return &ServerEndpoint{&irc.Helper{srv}, srv}
+	return &ServerEndpoint{&irc.Helper{Writer: srv}, srv}
 }
 
 // GetKey returns the server id of the current server.
@@ -84,6 +105,25 @@ func (s *ServerEndpoint) GetKey() string {
 	return s.server.name
 }
 
+// WritePriority writes to the server with the priority class given, one of
+// the inet.PRIORITY_* classes. Long replies can be written with
+// inet.PRIORITY_BULK so they don't hold up anything else.
+func (s *ServerEndpoint) WritePriority(buf []byte, priority int) (int, error) {
+	return s.server.WritePriority(buf, priority)
+}
+
+// CancelBulk drops the bulk messages to target that are waiting to be sent,
+// and returns how many were dropped.
+func (s *ServerEndpoint) CancelBulk(target string) int {
+	return s.server.CancelBulk(target)
+}
+
+// Lag returns the round trip time of the last keepalive ping to the server,
+// 0 if none have been answered.
+func (s *ServerEndpoint) Lag() time.Duration {
+	return s.server.Lag()
+}
+
 // OpenStore calls a callback if this ServerEndpoint can present a data store
 // object. The returned boolean is whether or not the function was called.
 func (s *ServerEndpoint) OpenStore(fn func(*data.Store)) bool {
@@ -97,6 +137,30 @@ func (s *ServerEndpoint) OpenStore(fn func(*data.Store)) bool {
 	}
 }
 
+// OpenUserStore calls a callback with the bot's user store. Logins in the user
+// store are tracked per server, use GetKey to look them up.
+func (s *ServerEndpoint) OpenUserStore(fn func(*data.UserStore)) {
+	s.server.bot.OpenUserStore(fn)
+}
+
+// OpenStorage calls a callback with the bot's storage. It's shared by all
+// servers, ChannelRecords and UserRecords give buckets that belong to this one.
+func (s *ServerEndpoint) OpenStorage(fn func(data.Storage)) {
+	s.server.bot.OpenStorage(fn)
+}
+
+// ChannelRecords returns the bucket in tx for a channel on this server.
+func (s *ServerEndpoint) ChannelRecords(tx data.Tx,
+	channel string) data.Bucket {
+
+	return data.ChannelRecords(tx, s.server.name, s.server.casemap(), channel)
+}
+
+// UserRecords returns the bucket in tx for a nick on this server.
+func (s *ServerEndpoint) UserRecords(tx data.Tx, nick string) data.Bucket {
+	return data.UserRecords(tx, s.server.name, s.server.casemap(), nick)
+}
+
 // Writeln writes to the server's IrcClient.
 func (s *Server) Writeln(args ...interface{}) error {
 	_, err := s.Write([]byte(fmt.Sprint(args...)))
@@ -115,18 +179,92 @@ func (s *Server) Write(buf []byte) (int, error) {
 	return 0, errNotConnected
 }
 
+// WritePriority writes to the server's IrcClient with the priority class
+// given, one of the inet.PRIORITY_* classes.
+func (s *Server) WritePriority(buf []byte, priority int) (int, error) {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	if s.isConnected() {
+		return s.client.WritePriority(buf, priority)
+	}
+
+	return 0, errNotConnected
+}
+
+// CancelBulk drops the bulk messages to target that are waiting to be sent to
+// the server, and returns how many were dropped.
+func (s *Server) CancelBulk(target string) int {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	if s.isConnected() {
+		return s.client.CancelBulk(target)
+	}
+	return 0
+}
+
+// Lag returns the round trip time of the last keepalive ping to the server,
+// 0 if none have been answered. While a ping is waiting on a reply the lag is
+// at least as long as it's been waiting.
+func (s *Server) Lag() time.Duration {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	if s.keepalive == nil {
+		return 0
+	}
+	return s.keepalive.currentLag(time.Now())
+}
+
+// createKeepalive creates the keepalive for a new connection from the
+// server's config.
+func (s *Server) createKeepalive() {
+	s.keepalive = createKeepalive(
+		time.Duration(s.conf.GetKeepaliveInterval())*s.keepaliveScale,
+		time.Duration(s.conf.GetKeepaliveTimeout())*s.keepaliveScale,
+		time.Now())
+}
+
 // createDispatcher uses the server's current ProtoCaps to create a dispatcher.
 func (s *Server) createDispatcher(channels []string) (err error) {
 	s.dispatcher, err = dispatch.CreateRichDispatcher(s.caps, channels)
 	return err
 }
 
-// createStore uses the server's current ProtoCaps to create a store.
+// createStore uses the server's current ProtoCaps to create a store, and
+// loads the state saved in the server's state file if it has one.
 func (s *Server) createStore() (err error) {
-	s.store, err = data.CreateStore(s.caps)
+	if s.store, err = data.CreateStore(s.caps); err != nil {
+		return err
+	}
+	if s.conf != nil && len(s.conf.GetStatefile()) > 0 {
+		err = s.store.Load(s.conf.GetStatefile())
+	}
 	return err
 }
 
+// saveStore drops the seen records the config no longer keeps from the store
+// and writes it to the server's state file if it has one.
+func (s *Server) saveStore() error {
+	s.protectStore.Lock()
+	defer s.protectStore.Unlock()
+	if s.store == nil || s.conf == nil {
+		return nil
+	}
+
+	var before time.Time
+	if days := s.conf.GetSeenExpiry(); days > 0 {
+		before = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	s.store.TrimSeen(before, int(s.conf.GetSeenLimit()))
+
+	if len(s.conf.GetStatefile()) == 0 {
+		return nil
+	}
+	return s.store.Save(s.conf.GetStatefile())
+}
+
 // createIrcClient connects to the configured server, and creates an IrcClient
 // for use with that connection.
 func (s *Server) createIrcClient() error {
@@ -137,32 +275,104 @@ func (s *Server) createIrcClient() error {
 		return errors.New(fmt.Sprintf(errFmtAlreadyConnected, s.name))
 	}
 
-	port := strconv.Itoa(int(s.conf.GetPort()))
-	server := s.conf.GetHost() + ":" + port
+	hosts := s.conf.GetHosts()
+	if len(hosts) == 0 {
+		return errors.New(fmt.Sprintf(errFmtNoHosts, s.name))
+	}
+	server := s.currentHost()
 
 	if s.bot.connProvider == nil {
-		if s.conf.GetSsl() {
-			//TODO: Implement SSL
-			return errSslNotImplemented
-		} else {
-			if conn, err = net.Dial("tcp", server); err != nil {
-				return err
-			}
+		if conn, err = s.dial(server); err == nil && s.conf.GetSsl() {
+			conn, err = s.dialTls(conn, server)
 		}
 	} else {
-		if conn, err = s.bot.connProvider(server); err != nil {
-			return err
-		}
+		conn, err = s.bot.connProvider(server)
 	}
 
-	s.client = inet.CreateIrcClientFloodProtect(conn, s.name,
-		int(s.conf.GetFloodProtectBurst()),
-		int(s.conf.GetFloodProtectTimeout()*1000.0),
-		int(s.conf.GetFloodProtectStep()*1000.0),
-		time.Millisecond)
+	if err != nil {
+		s.hostTurn = (s.hostTurn + 1) % len(hosts)
+		return err
+	}
+
+	if s.conf.GetFloodProtectModel() == config.FLOOD_PENALTY {
+		limit := s.conf.GetFloodProtectLimit() * float64(time.Second)
+		cost := s.conf.GetFloodProtectCost() * float64(time.Second)
+		s.client = inet.CreateIrcClientPenalty(conn, s.name,
+			inet.CreatePenalty(time.Duration(limit), time.Duration(cost),
+				int(s.conf.GetFloodProtectBytes())))
+	} else {
+		s.client = inet.CreateIrcClientFloodProtect(conn, s.name,
+			int(s.conf.GetFloodProtectBurst()),
+			int(s.conf.GetFloodProtectTimeout()*1000.0),
+			int(s.conf.GetFloodProtectStep()*1000.0),
+			time.Millisecond)
+	}
+	s.client.Shape(int(s.conf.GetOutputBacklog()),
+		outputOverflows[s.conf.GetOutputOverflow()],
+		s.conf.GetOutputCoalesce())
+	s.client.Casemapping(s.casemap())
 	return nil
 }
 
+// currentHost returns the host:port the next connection is made to, the hosts
+// are tried in turn as connecting to them fails. Not thread safe.
+func (s *Server) currentHost() string {
+	hosts := s.conf.GetHosts()
+	if len(hosts) == 0 {
+		return ""
+	}
+	return hosts[s.hostTurn%len(hosts)]
+}
+
+// dial connects to the server, through the configured proxy if there is one.
+// Without a proxy every address the host resolves to is tried until one of
+// them connects.
+func (s *Server) dial(server string) (net.Conn, error) {
+	kind := s.conf.GetProxyType()
+	if len(kind) == 0 {
+		return s.dialAddresses(server)
+	}
+
+	username, password := s.conf.GetProxyAuth()
+	proxy := inet.Proxy{
+		Type:     kind,
+		Address:  s.conf.GetProxyAddress(),
+		Username: username,
+		Password: password,
+	}
+	conn, err := proxy.Dial(server)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(errFmtProxy, s.name, err))
+	}
+	return conn, nil
+}
+
+// dialAddresses resolves the host of server and connects to the first of it's
+// addresses that answers.
+func (s *Server) dialAddresses(server string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := s.lookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New(fmt.Sprintf(errFmtNoAddresses, host))
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = net.Dial("tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
 // protocaps sets the protocaps for the given server. If an error is returned
 // no update was done.
 func (s *Server) protocaps(caps *irc.ProtoCaps) error {
@@ -173,12 +383,34 @@ func (s *Server) protocaps(caps *irc.ProtoCaps) error {
 	return s.rehashProtocaps()
 }
 
+// casemap gets the casemap of the server's current protocaps.
+func (s *Server) casemap() irc.Casemap {
+	s.protectCaps.RLock()
+	defer s.protectCaps.RUnlock()
+	return s.caps.Casemap()
+}
+
 // rehashProtocaps rehashes protocaps from the servers current protocaps.
 func (s *Server) rehashProtocaps() error {
 	var err error
 	if err = s.dispatcher.Protocaps(s.caps); err != nil {
 		return err
 	}
+	if s.whois != nil {
+		s.whois.casemapping(s.caps.Casemap())
+	}
+	s.protect.RLock()
+	if s.client != nil {
+		s.client.Casemapping(s.caps.Casemap())
+	}
+	s.protect.RUnlock()
+	if s.bot != nil {
+		if err = s.bot.commander.Protocaps(s.name, s.caps); err != nil {
+			return err
+		}
+		s.bot.users.Protocaps(s.name, s.caps)
+		s.bot.dccProtocaps(s.name, s.caps)
+	}
 	s.protectStore.Lock()
 	if s.store != nil {
 		err = s.store.Protocaps(s.caps)
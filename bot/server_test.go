@@ -1,12 +1,21 @@
 package bot
 
 import (
+	"bufio"
 	"bytes"
 	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/dispatch"
+	"github.com/aarondl/ultimateq/inet"
 	"github.com/aarondl/ultimateq/irc"
 	"github.com/aarondl/ultimateq/mocks"
+	"io"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 func (s *s) TestServerSender(c *C) {
@@ -56,6 +65,9 @@ func (s *s) TestServer_Write(c *C) {
 
 	_, err = srv.Write([]byte{})
 	c.Check(err, Equals, errNotConnected)
+	_, err = srv.WritePriority([]byte(str), inet.PRIORITY_BULK)
+	c.Check(err, Equals, errNotConnected)
+	c.Check(srv.CancelBulk("#chan"), Equals, 0)
 
 	ers := b.Connect()
 	c.Check(len(ers), Equals, 0)
@@ -67,6 +79,11 @@ func (s *s) TestServer_Write(c *C) {
 	_, err = srv.Write([]byte(str))
 	c.Check(bytes.Compare(conn.Receive(len(str), nil), []byte(str)), Equals, 0)
 	c.Check(err, IsNil)
+	endpoint := createServerEndpoint(srv)
+	_, err = endpoint.WritePriority([]byte(str), inet.PRIORITY_BULK)
+	c.Check(bytes.Compare(conn.Receive(len(str), nil), []byte(str)), Equals, 0)
+	c.Check(err, IsNil)
+	c.Check(endpoint.CancelBulk("#chan"), Equals, 0)
 	err = b.Writeln("notrealserver", str)
 	c.Check(err, NotNil)
 	b.WaitForHalt()
@@ -123,6 +140,153 @@ func (s *s) TestServer_Protocaps(c *C) {
 	c.Check(err, NotNil)
 }
 
+func (s *s) TestServer_Statefile(c *C) {
+	filename := filepath.Join(c.MkDir(), "state.db")
+	conf := fakeConfig.Clone()
+	conf.GetServer(serverId).Statefile = filename
+
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+	srv.store.Update(&irc.IrcMessage{Name: irc.PRIVMSG,
+		Sender: "nick!user@host", Args: []string{"#chan", "hi"}})
+	c.Check(srv.saveStore(), IsNil)
+
+	b, err = createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	seen := b.servers[serverId].store.GetSeen("nick")
+	c.Assert(seen, NotNil)
+	c.Check(seen.Message, Equals, "hi")
+
+	// Only as many seen records as the config allows are kept.
+	conf.GetServer(serverId).SeenLimit = "1"
+	b, err = createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv = b.servers[serverId]
+	srv.store.Update(&irc.IrcMessage{Name: irc.PRIVMSG,
+		Sender: "other!user@host", Args: []string{"#chan", "hey"}})
+	c.Check(srv.saveStore(), IsNil)
+	c.Check(srv.store.GetSeen("nick"), IsNil)
+	c.Check(srv.store.GetSeen("other"), NotNil)
+}
+
+func (s *s) TestServer_StateInterval(c *C) {
+	filename := filepath.Join(c.MkDir(), "state.db")
+	conn := mocks.CreateConn()
+	connProvider := func(srv string) (net.Conn, error) {
+		return conn, nil
+	}
+	conf := fakeConfig.Clone().StateInterval(10)
+	conf.GetServer(serverId).Statefile = filename
+
+	b, err := createBot(conf, nil, connProvider, false)
+	c.Assert(err, IsNil)
+	b.servers[serverId].stateScale = time.Millisecond
+	go func() {
+		for {
+			conn.Receive(512, nil)
+		}
+	}()
+	c.Check(len(b.Connect()), Equals, 0)
+	b.Start()
+
+	msg := []byte(":nick!user@host PRIVMSG #chan :hi\r\n")
+	conn.Send(msg, len(msg), nil)
+
+	// The state is saved while the server is still connected.
+	var seen *data.Seen
+	for start := time.Now(); seen == nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			c.Fatal("The state was never saved.")
+		}
+		st, err := data.CreateStore(irc.CreateProtoCaps())
+		c.Assert(err, IsNil)
+		if st.Load(filename) == nil {
+			seen = st.GetSeen("nick")
+		}
+	}
+	c.Check(seen.Message, Equals, "hi")
+
+	conn.Send([]byte{}, 0, io.EOF)
+	b.Stop()
+	b.Disconnect()
+	b.WaitForHalt()
+}
+
+func (s *s) TestServer_ProtocapsCasemap(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+
+	ua, err := data.CreateUserAccess("user", "pass", "nick[1]!*@*")
+	c.Assert(err, IsNil)
+	c.Check(b.users.AddUser(ua), IsNil)
+	_, err = b.users.Login(serverId, "nick{1}!user@host", "user", "pass")
+	c.Check(err, NotNil)
+
+	c.Check(srv.protocaps(rfc1459Caps()), IsNil)
+	_, err = b.users.Login(serverId, "nick{1}!user@host", "user", "pass")
+	c.Check(err, IsNil)
+
+	b.commander.ServerNick(serverId, "Bot[1]")
+	h := &casemapCommand{}
+	b.commander.Register(&dispatch.Command{Name: "hi", Handler: h})
+	b.commander.PrivmsgChannel(&irc.Message{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "nick!user@host",
+		Args:   []string{"#chan", "bot{1}: hi"},
+	}}, createServerEndpoint(srv))
+	c.Check(h.called, Equals, true)
+}
+
+type casemapCommand struct {
+	called bool
+}
+
+func (h *casemapCommand) Command(_ string, _ *dispatch.CommandData,
+	_ irc.Endpoint) error {
+
+	h.called = true
+	return nil
+}
+
+func (s *s) TestServerSender_OpenStorage(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	srv := b.servers[serverId]
+	srv.protocaps(rfc1459Caps())
+	endpoint := createServerEndpoint(srv)
+
+	endpoint.OpenStorage(func(st data.Storage) {
+		err := st.Update(func(tx data.Tx) error {
+			endpoint.ChannelRecords(tx, "#Chan[1]").Put("a", []byte("1"))
+			return endpoint.UserRecords(tx, "Nick[1]").Put("b", []byte("2"))
+		})
+		c.Check(err, IsNil)
+	})
+
+	b.OpenStorage(func(st data.Storage) {
+		st.View(func(tx data.Tx) error {
+			channel := data.ChannelRecords(tx, serverId, irc.CASEMAP_RFC1459,
+				"#chan{1}")
+			c.Check(string(channel.Get("a")), Equals, "1")
+			user := data.UserRecords(tx, serverId, irc.CASEMAP_RFC1459,
+				"nick{1}")
+			c.Check(string(user.Get("b")), Equals, "2")
+			return nil
+		})
+	})
+}
+
+// rfc1459Caps creates protocaps with the rfc1459 casemapping.
+func rfc1459Caps() *irc.ProtoCaps {
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{Args: []string{
+		"NICK", "CASEMAPPING=rfc1459",
+	}})
+	return caps
+}
+
 func (s *s) TestServer_State(c *C) {
 	srv := &Server{}
 
@@ -184,3 +348,72 @@ func (s *s) TestServer_State(c *C) {
 	srv.setReconnecting(false, true)
 	c.Check(srv.IsReconnecting(), Equals, false)
 }
+
+// httpProxy is a stand-in http proxy that connects every CONNECT to target
+// and sends the address it was asked for on the channel.
+func httpProxy(c *C, target string) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	asked := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				asked <- req.Method + " " + req.Host
+				tunnel, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer tunnel.Close()
+				conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+				go io.Copy(tunnel, conn)
+				io.Copy(conn, tunnel)
+			}()
+		}
+	}()
+	return ln, asked
+}
+
+func (s *s) TestServer_Proxy(c *C) {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	ca, caKey := testCert(c, dir, "ca.test.net", true, nil, nil)
+	testCert(c, dir, "irc.test.net", false, ca, caKey)
+
+	target, states := tlsListen(c, dir, "irc.test.net")
+	defer target.Close()
+	proxy, asked := httpProxy(c, target.Addr().String())
+	defer proxy.Close()
+
+	// Tls is negotiated with the server on the other side of the proxy.
+	conf := fakeConfig.Clone()
+	delete(conf.Servers, serverId)
+	conf.Server("irc.test.net").
+		Port(6697).
+		VerifyCert(true).
+		SslCaFile(filepath.Join(dir, "ca.test.net.crt")).
+		Proxy("http", proxy.Addr().String())
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(b.Connect(), IsNil)
+	c.Check(<-asked, Equals, "CONNECT irc.test.net:6697")
+	c.Check((<-states).ServerName, Equals, "irc.test.net")
+	b.Disconnect()
+
+	// Failures name the server.
+	proxy.Close()
+	b, err = createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	ers := b.Connect()
+	c.Assert(ers, HasLen, 1)
+	c.Check(ers[0], ErrorMatches, "bot: irc.test.net proxy failed .*")
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"io/ioutil"
+	"net"
+)
+
+const (
+	// errFmtTls occurs when a tls connection to a server can't be made, or the
+	// ssl configuration for the server can't be loaded.
+	errFmtTls = "bot: %v ssl failed (%v)"
+	// errFmtNoCerts occurs when a certificate authority file has nothing in
+	// it that can be used.
+	errFmtNoCerts = "no certificates found in %v"
+)
+
+// createTlsConfig creates the tls configuration for a server from it's ssl
+// settings. Certificate authorities and client certificates are loaded from
+// disk.
+func createTlsConfig(conf *config.Server) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		ServerName:         conf.GetSslServerName(),
+		InsecureSkipVerify: !conf.GetVerifyCert(),
+		MinVersion:         conf.GetSslMinVersion(),
+	}
+
+	if cafile := conf.GetSslCaFile(); len(cafile) > 0 {
+		pem, err := ioutil.ReadFile(cafile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf(errFmtNoCerts, cafile))
+		}
+	}
+
+	if certfile, keyfile := conf.GetSslClientCert(); len(certfile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
+
+// dialTls wraps an established connection to server with tls and completes
+// the handshake, the connection is closed if anything goes wrong.
+func (s *Server) dialTls(conn net.Conn, server string) (net.Conn, error) {
+	tlsConf, err := createTlsConfig(s.conf)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf(errFmtTls, s.name, err))
+	}
+
+	// Unless a server name was set the certificate has to match the host
+	// that was dialed, which isn't always the server's first host.
+	if host, _, err := net.SplitHostPort(server); err == nil &&
+		tlsConf.ServerName == s.conf.GetHost() {
+		tlsConf.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, tlsConf)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf(errFmtTls, s.name, err))
+	}
+	return tlsConn, nil
+}
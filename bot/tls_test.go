@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/aarondl/ultimateq/config"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// testCert creates a certificate signed by parent, or self signed if parent is
+// nil, and writes it and it's key to dir as PEM files named after name.
+func testCert(c *C, dir, name string, isCA bool, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent,
+		&key.PublicKey, parentKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0600), IsNil)
+	return cert, key
+}
+
+// tlsListen starts a tls server using the named certificate in dir, and sends
+// the state of each connection that completes a handshake on the channel.
+func tlsListen(c *C, dir, name string) (net.Listener,
+	chan tls.ConnectionState) {
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"),
+		filepath.Join(dir, name+".key"))
+	c.Assert(err, IsNil)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	c.Assert(err, IsNil)
+
+	states := make(chan tls.ConnectionState, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(states)
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				states <- tlsConn.ConnectionState()
+			}
+			conn.Close()
+		}
+	}()
+	return ln, states
+}
+
+func tlsPort(ln net.Listener) uint16 {
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return uint16(p)
+}
+
+func (s *s) TestTls_Config(c *C) {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	testCert(c, dir, "ca.test.net", true, nil, nil)
+	testCert(c, dir, "client", false, nil, nil)
+
+	conf := config.CreateConfig().
+		Server("irc.test.net").
+		SslMinVersion("1.2")
+	tlsConf, err := createTlsConfig(conf.GetServer("irc.test.net"))
+	c.Assert(err, IsNil)
+	c.Check(tlsConf.ServerName, Equals, "irc.test.net")
+	c.Check(tlsConf.InsecureSkipVerify, Equals, true)
+	c.Check(tlsConf.MinVersion, Equals, uint16(tls.VersionTLS12))
+	c.Check(tlsConf.RootCAs, IsNil)
+	c.Check(tlsConf.Certificates, HasLen, 0)
+
+	conf.VerifyCert(true).
+		SslServerName("sni.test.net").
+		SslCaFile(filepath.Join(dir, "ca.test.net.crt")).
+		SslClientCert(filepath.Join(dir, "client.crt"),
+			filepath.Join(dir, "client.key"))
+	tlsConf, err = createTlsConfig(conf.GetServer("irc.test.net"))
+	c.Assert(err, IsNil)
+	c.Check(tlsConf.ServerName, Equals, "sni.test.net")
+	c.Check(tlsConf.InsecureSkipVerify, Equals, false)
+	c.Check(tlsConf.RootCAs, NotNil)
+	c.Check(tlsConf.Certificates, HasLen, 1)
+
+	conf.SslClientCert(filepath.Join(dir, "client.crt"),
+		filepath.Join(dir, "ca.test.net.key"))
+	_, err = createTlsConfig(conf.GetServer("irc.test.net"))
+	c.Check(err, NotNil)
+
+	conf.SslClientCert("", "").SslCaFile(filepath.Join(dir, "client.key"))
+	_, err = createTlsConfig(conf.GetServer("irc.test.net"))
+	c.Check(err, ErrorMatches, "no certificates found in .*")
+
+	conf.SslCaFile(filepath.Join(dir, "missing.crt"))
+	_, err = createTlsConfig(conf.GetServer("irc.test.net"))
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestTls_Connect(c *C) {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	ca, caKey := testCert(c, dir, "ca.test.net", true, nil, nil)
+	testCert(c, dir, "irc.test.net", false, ca, caKey)
+	client, _ := testCert(c, dir, "client", false, nil, nil)
+
+	ln, states := tlsListen(c, dir, "irc.test.net")
+	defer ln.Close()
+
+	conf := fakeConfig.Clone()
+	delete(conf.Servers, serverId)
+	conf.Server("local").
+		Host("127.0.0.1").
+		Port(tlsPort(ln)).
+		VerifyCert(true).
+		SslCaFile(filepath.Join(dir, "ca.test.net.crt")).
+		SslClientCert(filepath.Join(dir, "client.crt"),
+			filepath.Join(dir, "client.key")).
+		SslMinVersion("1.2").
+		SslServerName("irc.test.net")
+
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(b.Connect(), IsNil)
+	b.Disconnect()
+
+	state := <-states
+	c.Check(state.ServerName, Equals, "irc.test.net")
+	c.Check(state.Version >= tls.VersionTLS12, Equals, true)
+	c.Assert(state.PeerCertificates, HasLen, 1)
+	c.Check(state.PeerCertificates[0].Equal(client), Equals, true)
+}
+
+func (s *s) TestTls_ConnectFail(c *C) {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	ca, caKey := testCert(c, dir, "ca.test.net", true, nil, nil)
+	testCert(c, dir, "irc.test.net", false, ca, caKey)
+
+	ln, _ := tlsListen(c, dir, "irc.test.net")
+	defer ln.Close()
+
+	// The certificate authority isn't trusted.
+	conf := fakeConfig.Clone()
+	delete(conf.Servers, serverId)
+	conf.Server("local").
+		Host("127.0.0.1").
+		Port(tlsPort(ln)).
+		VerifyCert(true).
+		SslServerName("irc.test.net")
+	b, err := createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	ers := b.Connect()
+	c.Assert(ers, HasLen, 1)
+	c.Check(ers[0], ErrorMatches, "bot: local ssl failed .*")
+	c.Check(b.servers["local"].IsConnected(), Equals, false)
+
+	// The name doesn't match the certificate.
+	conf.GetServer("local").SslCaFile = filepath.Join(dir, "ca.test.net.crt")
+	conf.GetServer("local").SslServerName = ""
+	b, err = createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	ers = b.Connect()
+	c.Assert(ers, HasLen, 1)
+	c.Check(ers[0], ErrorMatches, "bot: local ssl failed .*")
+
+	// Without verification anything goes.
+	conf.GetServer("local").VerifyCert = "false"
+	b, err = createBot(conf, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(b.Connect(), IsNil)
+	b.Disconnect()
+}
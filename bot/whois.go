@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"errors"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWhoisTimeout is how long to wait for a WHOIS or WHOWAS reply.
+	defaultWhoisTimeout = 30 * time.Second
+)
+
+var (
+	// errWhoisTimeout happens when the server doesn't finish a reply in time.
+	errWhoisTimeout = errors.New("bot: Timed out waiting for reply")
+	// errWhoisDisconnect happens when the server disconnects before it
+	// finishes a reply.
+	errWhoisDisconnect = errors.New("bot: Disconnected waiting for reply")
+)
+
+// whoisRequest is a WHOIS or WHOWAS that is waiting for it's reply.
+type whoisRequest struct {
+	whois  *data.Whois
+	whowas *data.WhowasReply
+	err    error
+	done   chan struct{}
+}
+
+// whoisTracker sends WHOIS and WHOWAS on behalf of handlers and aggregates
+// the replies. Requests for a nick that is already being asked about wait
+// for the same reply rather than asking again.
+type whoisTracker struct {
+	whois   map[string]*whoisRequest
+	whowas  map[string]*whoisRequest
+	timeout time.Duration
+	casemap irc.Casemap
+
+	protect sync.Mutex
+}
+
+// createWhoisTracker creates a tracker with no requests.
+func createWhoisTracker() *whoisTracker {
+	return &whoisTracker{
+		whois:   make(map[string]*whoisRequest),
+		whowas:  make(map[string]*whoisRequest),
+		timeout: defaultWhoisTimeout,
+	}
+}
+
+// request sends a WHOIS or WHOWAS for nick unless one is outstanding, and
+// waits for the reply.
+func (w *whoisTracker) request(command, nick string,
+	endpoint irc.Endpoint) (*whoisRequest, error) {
+
+	requests := w.whois
+	if command == irc.WHOWAS {
+		requests = w.whowas
+	}
+
+	w.protect.Lock()
+	key := w.casemap.Fold(nick)
+	req, ok := requests[key]
+	if !ok {
+		req = &whoisRequest{done: make(chan struct{})}
+		if command == irc.WHOWAS {
+			req.whowas = data.CreateWhowasReply(nick)
+		} else {
+			req.whois = data.CreateWhois(nick)
+		}
+		requests[key] = req
+		if err := endpoint.Send(command + " " + nick); err != nil {
+			delete(requests, key)
+			w.protect.Unlock()
+			return nil, err
+		}
+	}
+	timeout := w.timeout
+	w.protect.Unlock()
+
+	select {
+	case <-req.done:
+		return req, req.err
+	case <-time.After(timeout):
+		w.protect.Lock()
+		if requests[key] == req {
+			delete(requests, key)
+		}
+		w.protect.Unlock()
+		return nil, errWhoisTimeout
+	}
+}
+
+// handle gives a message to the requests it's about.
+func (w *whoisTracker) handle(msg *irc.IrcMessage) {
+	if len(msg.Args) < 2 {
+		return
+	}
+	w.protect.Lock()
+	defer w.protect.Unlock()
+	key := w.casemap.Fold(msg.Args[1])
+
+	if req, ok := w.whois[key]; ok && req.whois.Update(msg) {
+		delete(w.whois, key)
+		close(req.done)
+	}
+	if req, ok := w.whowas[key]; ok && req.whowas.Update(msg) {
+		delete(w.whowas, key)
+		close(req.done)
+	}
+}
+
+// casemapping sets the casemap used to match replies to requests.
+func (w *whoisTracker) casemapping(casemap irc.Casemap) {
+	w.protect.Lock()
+	w.casemap = casemap
+	w.protect.Unlock()
+}
+
+// abort fails every outstanding request.
+func (w *whoisTracker) abort() {
+	w.protect.Lock()
+	defer w.protect.Unlock()
+
+	for _, requests := range []map[string]*whoisRequest{w.whois, w.whowas} {
+		for key, req := range requests {
+			req.err = errWhoisDisconnect
+			delete(requests, key)
+			close(req.done)
+		}
+	}
+}
+
+// Whois sends a WHOIS for nick and waits for the server's whole reply. The
+// reply is shared with any other handler that asked about the same nick at
+// the same time and must not be modified.
+func (s *ServerEndpoint) Whois(nick string) (*data.Whois, error) {
+	req, err := s.server.whois.request(irc.WHOIS, nick, s)
+	if err != nil {
+		return nil, err
+	}
+	return req.whois, nil
+}
+
+// Whowas sends a WHOWAS for nick and waits for the server's whole reply. The
+// reply is shared with any other handler that asked about the same nick at
+// the same time and must not be modified.
+func (s *ServerEndpoint) Whowas(nick string) (*data.WhowasReply, error) {
+	req, err := s.server.whois.request(irc.WHOWAS, nick, s)
+	if err != nil {
+		return nil, err
+	}
+	return req.whowas, nil
+}
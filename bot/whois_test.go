@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func whoisReply(name string, args ...string) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: name, Sender: "irc.test.net",
+		Args: append([]string{"me"}, args...)}
+}
+
+// waitSent waits for a request to be written to the endpoint.
+func waitSent(c *C, w *whoisTracker, key string) {
+	for i := 0; i < 100; i++ {
+		w.protect.Lock()
+		_, whois := w.whois[key]
+		_, whowas := w.whowas[key]
+		w.protect.Unlock()
+		if whois || whowas {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Fatal("Request was never sent.")
+}
+
+func (s *s) TestWhois_Request(c *C) {
+	w := createWhoisTracker()
+	endpoint := makeTestPoint(nil)
+
+	replies := make(chan *data.Whois, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, err := w.request(irc.WHOIS, "Nick1", endpoint)
+			c.Check(err, IsNil)
+			replies <- req.whois
+		}()
+	}
+	waitSent(c, w, "nick1")
+	time.Sleep(10 * time.Millisecond)
+
+	w.handle(whoisReply(irc.RPL_WHOISUSER, "nick1", "u", "h", "*", "real"))
+	w.handle(whoisReply(irc.RPL_WHOISACCOUNT, "nick1", "acct", "logged in"))
+	w.handle(whoisReply(irc.RPL_WHOISUSER, "nick2", "u2", "h2", "*", "x"))
+	w.handle(whoisReply(irc.RPL_ENDOFWHOIS, "NICK1", "End of /WHOIS"))
+
+	for i := 0; i < 2; i++ {
+		whois := <-replies
+		c.Check(whois.Found, Equals, true)
+		c.Check(whois.Realname, Equals, "real")
+		c.Check(whois.Account, Equals, "acct")
+	}
+	c.Check(endpoint.gets(), Equals, "WHOIS Nick1")
+	c.Check(w.whois, HasLen, 0)
+}
+
+func (s *s) TestWhois_Whowas(c *C) {
+	w := createWhoisTracker()
+	endpoint := makeTestPoint(nil)
+
+	replies := make(chan *data.WhowasReply)
+	go func() {
+		req, err := w.request(irc.WHOWAS, "nick1", endpoint)
+		c.Check(err, IsNil)
+		replies <- req.whowas
+	}()
+	waitSent(c, w, "nick1")
+
+	w.handle(whoisReply(irc.RPL_WHOWASUSER, "nick1", "u", "h", "*", "real"))
+	w.handle(whoisReply(irc.RPL_WHOISSERVER, "nick1", "srv", "Mon Jun 3"))
+	w.handle(whoisReply(irc.RPL_ENDOFWHOWAS, "nick1", "End of WHOWAS"))
+
+	whowas := <-replies
+	c.Check(endpoint.gets(), Equals, "WHOWAS nick1")
+	c.Assert(whowas.Entries, HasLen, 1)
+	c.Check(whowas.Entries[0].Server, Equals, "srv")
+}
+
+func (s *s) TestWhois_TimeoutAbort(c *C) {
+	w := createWhoisTracker()
+	w.timeout = time.Millisecond
+	endpoint := makeTestPoint(nil)
+
+	_, err := w.request(irc.WHOIS, "nick1", endpoint)
+	c.Check(err, Equals, errWhoisTimeout)
+	c.Check(w.whois, HasLen, 0)
+
+	w.timeout = time.Minute
+	errs := make(chan error)
+	go func() {
+		_, err := w.request(irc.WHOIS, "nick1", endpoint)
+		errs <- err
+	}()
+	waitSent(c, w, "nick1")
+	w.abort()
+	c.Check(<-errs, Equals, errWhoisDisconnect)
+}
+
+func (s *s) TestWhois_CoreHandler(c *C) {
+	b, err := createBot(fakeConfig, nil, nil, false)
+	c.Assert(err, IsNil)
+	handler := coreHandler{bot: b}
+	srv := b.servers[serverId]
+	endpoint := makeTestPoint(srv)
+
+	replies := make(chan *data.Whois)
+	go func() {
+		req, err := srv.whois.request(irc.WHOIS, "nobody", endpoint)
+		c.Check(err, IsNil)
+		replies <- req.whois
+	}()
+	waitSent(c, srv.whois, "nobody")
+
+	handler.HandleRaw(whoisReply(irc.ERR_NOSUCHNICK, "nobody", "No such"),
+		endpoint)
+	handler.HandleRaw(whoisReply(irc.RPL_ENDOFWHOIS, "nobody", "End"),
+		endpoint)
+	c.Check((<-replies).Found, Equals, false)
+
+	errs := make(chan error)
+	go func() {
+		_, err := srv.whois.request(irc.WHOIS, "nobody", endpoint)
+		errs <- err
+	}()
+	waitSent(c, srv.whois, "nobody")
+	handler.HandleRaw(&irc.IrcMessage{Name: irc.DISCONNECT}, endpoint)
+	c.Check(<-errs, Equals, errWhoisDisconnect)
+
+	// The server isn't connected so nothing can be sent.
+	_, err = createServerEndpoint(srv).Whois("nobody")
+	c.Check(err, Equals, errNotConnected)
+	_, err = createServerEndpoint(srv).Whowas("nobody")
+	c.Check(err, Equals, errNotConnected)
+}
+
+func (s *s) TestWhois_Casemap(c *C) {
+	w := createWhoisTracker()
+	w.casemapping(irc.CASEMAP_RFC1459)
+	endpoint := makeTestPoint(nil)
+
+	replies := make(chan *data.Whois)
+	go func() {
+		req, err := w.request(irc.WHOIS, "Foo[away]", endpoint)
+		c.Check(err, IsNil)
+		replies <- req.whois
+	}()
+	waitSent(c, w, "foo{away}")
+
+	w.handle(whoisReply(irc.RPL_WHOISUSER, "foo{AWAY}", "u", "h", "*", "r"))
+	w.handle(whoisReply(irc.RPL_ENDOFWHOIS, "foo{AWAY}", "End of /WHOIS"))
+	c.Check((<-replies).Found, Equals, true)
+}
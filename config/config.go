@@ -5,11 +5,14 @@ input/output, as well as configuration diffs.
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -26,12 +29,81 @@ const (
 	// defaultFloodProtectStep is the number of seconds between messages once
 	// flood protection has been activated.
 	defaultFloodProtectStep = float64(3)
+	// defaultFloodProtectModel is the flood protection model by default.
+	defaultFloodProtectModel = FLOOD_MESSAGES
+	// defaultFloodProtectLimit is how many seconds of penalty can build up
+	// before the penalty model holds messages back.
+	defaultFloodProtectLimit = float64(10)
+	// defaultFloodProtectCost is how many seconds of penalty each message
+	// costs in the penalty model.
+	defaultFloodProtectCost = float64(2)
+	// defaultFloodProtectBytes is how many bytes of a message add another
+	// message's cost to it's penalty in the penalty model.
+	defaultFloodProtectBytes = uint(240)
+	// defaultOutputOverflow is what happens to messages written to a target
+	// whose output backlog is full by default.
+	defaultOutputOverflow = OVERFLOW_TRUNCATE
 	// defaultReconnectTimeout is how many seconds to wait between reconns.
 	defaultReconnectTimeout = uint(20)
+	// defaultReconnectMaxTimeout is the most seconds the wait between reconns
+	// is allowed to grow to.
+	defaultReconnectMaxTimeout = uint(300)
+	// defaultKeepaliveInterval is how many seconds apart keepalive pings are
+	// sent.
+	defaultKeepaliveInterval = uint(60)
+	// defaultKeepaliveTimeout is how many seconds without any traffic before
+	// a connection is considered dead.
+	defaultKeepaliveTimeout = uint(240)
+	// defaultStateInterval is how many seconds apart the state database is
+	// saved to it's file.
+	defaultStateInterval = uint(300)
+	// defaultSeenExpiry is how many days a seen record is kept.
+	defaultSeenExpiry = uint(90)
+	// defaultSeenLimit is the most seen records that are kept.
+	defaultSeenLimit = uint(50000)
 	// botDefaultPrefix is the command prefix by default
 	defaultPrefix = "."
+	// defaultCtcpVersion is the reply to a ctcp VERSION by default.
+	defaultCtcpVersion = "ultimateq"
+	// defaultCtcpSource is the reply to a ctcp SOURCE by default.
+	defaultCtcpSource = "https://github.com/aarondl/ultimateq"
+	// defaultCtcpBurst is how many ctcp requests are answered in each
+	// ctcp period.
+	defaultCtcpBurst = uint(3)
+	// defaultCtcpPeriod is how many seconds ctcp replies are counted over.
+	defaultCtcpPeriod = float64(10)
 	// maxHostSize is the biggest hostname possible
 	maxHostSize = 255
+	// saslPlain is the SASL mechanism that uses an account and password.
+	saslPlain = "PLAIN"
+	// saslExternal is the SASL mechanism that uses the client certificate.
+	saslExternal = "EXTERNAL"
+	// proxySocks5 is the proxy type for socks5 proxies.
+	proxySocks5 = "socks5"
+	// proxyHttp is the proxy type for http proxies that support CONNECT.
+	proxyHttp = "http"
+)
+
+// Flood protection models, these decide how messages are throttled.
+const (
+	// FLOOD_MESSAGES counts messages, after a burst messages are sent a step
+	// apart until the timeout passes without any.
+	FLOOD_MESSAGES = "messages"
+	// FLOOD_PENALTY keeps a penalty like ircu and hybrid servers do, where
+	// long lines and commands like WHO and MODE cost more.
+	FLOOD_PENALTY = "penalty"
+)
+
+// Output overflow policies, these decide what happens to messages written to
+// a target whose output backlog is full.
+const (
+	// OVERFLOW_OLDEST drops the oldest message waiting for the target.
+	OVERFLOW_OLDEST = "oldest"
+	// OVERFLOW_NEWEST drops the new message.
+	OVERFLOW_NEWEST = "newest"
+	// OVERFLOW_TRUNCATE drops the new messages and follows the backlog with a
+	// line saying how many were left out.
+	OVERFLOW_TRUNCATE = "truncate"
 )
 
 // The following format strings are for formatting various config errors.
@@ -46,25 +118,68 @@ const (
 // The following is for mapping config setting names to strings
 const (
 	errHost                = "host"
+	errHosts               = "hosts"
 	errPort                = "port"
 	errSsl                 = "ssl"
 	errVerifyCert          = "verifycert"
+	errSslClientCert       = "ssl client certificate"
+	errSslClientKey        = "ssl client key"
+	errSslMinVersion       = "ssl minimum version"
+	errSslServerName       = "ssl server name"
+	errProxyType           = "proxy type"
+	errProxyAddress        = "proxy address"
 	errNoState             = "nostate"
 	errFloodProtectBurst   = "floodprotectburst"
 	errFloodProtectTimeout = "floodprotecttimeout"
 	errFloodProtectStep    = "floodprotectstep"
+	errFloodProtectModel   = "floodprotectmodel"
+	errFloodProtectLimit   = "floodprotectlimit"
+	errFloodProtectCost    = "floodprotectcost"
+	errFloodProtectBytes   = "floodprotectbytes"
+	errOutputBacklog       = "outputbacklog"
+	errOutputOverflow      = "outputoverflow"
+	errOutputCoalesce      = "outputcoalesce"
 	errNoReconnect         = "noreconnect"
 	errReconnectTimeout    = "reconnecttimeout"
+	errReconnectMaxTimeout = "reconnectmaxtimeout"
+	errReconnectAttempts   = "reconnectattempts"
+	errKeepaliveInterval   = "keepaliveinterval"
+	errKeepaliveTimeout    = "keepalivetimeout"
+	errStateInterval       = "stateinterval"
+	errSeenExpiry          = "seenexpiry"
+	errSeenLimit           = "seenlimit"
 	errNick                = "nickname"
 	errAltnick             = "alternate nickname"
 	errRealname            = "realname"
 	errUsername            = "username"
 	errUserhost            = "userhost"
 	errPrefix              = "prefix"
+	errNoCtcp              = "noctcp"
+	errCtcpBurst           = "ctcpburst"
+	errCtcpPeriod          = "ctcpperiod"
 	errChannel             = "channel"
+	errCap                 = "capability"
+	errSaslMechanism       = "sasl mechanism"
+	errSaslAccount         = "sasl account"
+	errSaslPassword        = "sasl password"
+	errSaslRequired        = "saslrequired"
+	errDccListenAddress    = "dcc listen address"
+	errDccPublicIP         = "dcc public ip"
+	errDccPorts            = "dcc ports"
+	errDccPassive          = "dccpassive"
+	errDccAnyPeer          = "dccanypeer"
 )
 
 var (
+	// sslVersions maps the minimum ssl versions that may be configured to
+	// their tls package constants.
+	sslVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
 	// From the RFC:
 	// nickname   =  ( letter / special ) *8( letter / digit / special / "-" )
 	// letter     =  %x41-5A / %x61-7A  ; A-Z / a-z
@@ -99,6 +214,9 @@ var (
 		`(?i)^[0-9a-z](?:(?:[0-9a-z]|-){0,61}[0-9a-z])?` +
 			`(?:\.[0-9a-z](?:(?:[0-9a-z]|-){0,61}[0-9a-z])?)*\.?$`)
 
+	// rgxCap matches IRCv3 capability names, which may have a vendor prefix.
+	rgxCap = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9\-./]*$`)
+
 	// rgxUsername matches usernames, insensitive all chars without spaces.
 	rgxUsername = regexp.MustCompile(`^[A-Za-z0-9]+$`)
 	// rgxRealname matches real names, insensitive all chars with spaces.
@@ -160,6 +278,7 @@ func (c *Config) IsValid() bool {
 	}
 
 	c.validateServer(c.Global, false)
+	c.validateDcc(c.Global)
 	for _, s := range c.Servers {
 		c.validateServer(s, true)
 	}
@@ -167,6 +286,37 @@ func (c *Config) IsValid() bool {
 	return len(c.Errors) == 0
 }
 
+// validateDcc checks the dcc settings, which are only read from the global
+// settings, and adds to the error collection if any are wrong.
+func (c *Config) validateDcc(s *Server) {
+	name := s.GetName()
+	if len(s.DccListenAddress) != 0 && net.ParseIP(s.DccListenAddress) == nil {
+		c.addError(fmtErrInvalid, name, errDccListenAddress,
+			s.DccListenAddress)
+	}
+
+	if len(s.DccPublicIP) != 0 && net.ParseIP(s.DccPublicIP) == nil {
+		c.addError(fmtErrInvalid, name, errDccPublicIP, s.DccPublicIP)
+	}
+
+	if s.DccPortMax != 0 && s.DccPortMax < s.DccPortMin {
+		c.addError(fmtErrInvalid, name, errDccPorts,
+			fmt.Sprintf("%v-%v", s.DccPortMin, s.DccPortMax))
+	}
+
+	if len(s.DccPassive) != 0 {
+		if _, err := strconv.ParseBool(s.DccPassive); err != nil {
+			c.addError(fmtErrInvalid, name, errDccPassive, s.DccPassive)
+		}
+	}
+
+	if len(s.DccAnyPeer) != 0 {
+		if _, err := strconv.ParseBool(s.DccAnyPeer); err != nil {
+			c.addError(fmtErrInvalid, name, errDccAnyPeer, s.DccAnyPeer)
+		}
+	}
+}
+
 // validateServer checks a server for errors and adds to the error collection
 // if any are found.
 func (c *Config) validateServer(s *Server, missingIsError bool) {
@@ -183,6 +333,42 @@ func (c *Config) validateServer(s *Server, missingIsError bool) {
 		}
 	}
 
+	if len(s.SslMinVersion) != 0 {
+		if _, ok := sslVersions[s.SslMinVersion]; !ok {
+			c.addError(fmtErrInvalid, name, errSslMinVersion,
+				s.SslMinVersion)
+		}
+	}
+
+	if len(s.SslServerName) != 0 && (!rgxHost.MatchString(s.SslServerName) ||
+		len(s.SslServerName) > maxHostSize) {
+
+		c.addError(fmtErrInvalid, name, errSslServerName, s.SslServerName)
+	}
+
+	switch kind := s.GetProxyType(); kind {
+	case "":
+	case proxySocks5, proxyHttp:
+		if address := s.GetProxyAddress(); len(address) == 0 {
+			c.addError(fmtErrMissing, name, errProxyAddress)
+		} else if host, port, err := net.SplitHostPort(address); err != nil ||
+			!rgxHost.MatchString(host) || len(host) > maxHostSize {
+
+			c.addError(fmtErrInvalid, name, errProxyAddress, address)
+		} else if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+			c.addError(fmtErrInvalid, name, errProxyAddress, address)
+		}
+	default:
+		c.addError(fmtErrInvalid, name, errProxyType, kind)
+	}
+
+	cert, key := s.GetSslClientCert()
+	if len(cert) != 0 && len(key) == 0 {
+		c.addError(fmtErrMissing, name, errSslClientKey)
+	} else if len(cert) == 0 && len(key) != 0 {
+		c.addError(fmtErrMissing, name, errSslClientCert)
+	}
+
 	if len(s.NoState) != 0 {
 		if _, err := strconv.ParseBool(s.NoState); err != nil {
 			c.addError(fmtErrInvalid, name, errNoState, s.NoState)
@@ -211,6 +397,54 @@ func (c *Config) validateServer(s *Server, missingIsError bool) {
 		}
 	}
 
+	switch model := s.GetFloodProtectModel(); model {
+	case FLOOD_MESSAGES, FLOOD_PENALTY:
+	default:
+		c.addError(fmtErrInvalid, name, errFloodProtectModel, model)
+	}
+
+	if len(s.FloodProtectLimit) != 0 {
+		if _, err := strconv.ParseFloat(s.FloodProtectLimit, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errFloodProtectLimit,
+				s.FloodProtectLimit)
+		}
+	}
+
+	if len(s.FloodProtectCost) != 0 {
+		if _, err := strconv.ParseFloat(s.FloodProtectCost, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errFloodProtectCost,
+				s.FloodProtectCost)
+		}
+	}
+
+	if len(s.FloodProtectBytes) != 0 {
+		if _, err :=
+			strconv.ParseUint(s.FloodProtectBytes, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errFloodProtectBytes,
+				s.FloodProtectBytes)
+		}
+	}
+
+	if len(s.OutputBacklog) != 0 {
+		if _, err := strconv.ParseUint(s.OutputBacklog, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errOutputBacklog,
+				s.OutputBacklog)
+		}
+	}
+
+	switch overflow := s.GetOutputOverflow(); overflow {
+	case OVERFLOW_OLDEST, OVERFLOW_NEWEST, OVERFLOW_TRUNCATE:
+	default:
+		c.addError(fmtErrInvalid, name, errOutputOverflow, overflow)
+	}
+
+	if len(s.OutputCoalesce) != 0 {
+		if _, err := strconv.ParseBool(s.OutputCoalesce); err != nil {
+			c.addError(fmtErrInvalid, name, errOutputCoalesce,
+				s.OutputCoalesce)
+		}
+	}
+
 	if len(s.NoReconnect) != 0 {
 		if _, err := strconv.ParseBool(s.NoReconnect); err != nil {
 			c.addError(fmtErrInvalid, name, errNoReconnect,
@@ -225,6 +459,74 @@ func (c *Config) validateServer(s *Server, missingIsError bool) {
 		}
 	}
 
+	if len(s.ReconnectMaxTimeout) != 0 {
+		if _, err :=
+			strconv.ParseUint(s.ReconnectMaxTimeout, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errReconnectMaxTimeout,
+				s.ReconnectMaxTimeout)
+		}
+	}
+
+	if len(s.ReconnectAttempts) != 0 {
+		if _, err :=
+			strconv.ParseUint(s.ReconnectAttempts, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errReconnectAttempts,
+				s.ReconnectAttempts)
+		}
+	}
+
+	if len(s.KeepaliveInterval) != 0 {
+		if _, err :=
+			strconv.ParseUint(s.KeepaliveInterval, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errKeepaliveInterval,
+				s.KeepaliveInterval)
+		}
+	}
+
+	if len(s.KeepaliveTimeout) != 0 {
+		if _, err := strconv.ParseUint(s.KeepaliveTimeout, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errKeepaliveTimeout,
+				s.KeepaliveTimeout)
+		}
+	}
+
+	if len(s.StateInterval) != 0 {
+		if _, err := strconv.ParseUint(s.StateInterval, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errStateInterval,
+				s.StateInterval)
+		}
+	}
+
+	if len(s.SeenExpiry) != 0 {
+		if _, err := strconv.ParseUint(s.SeenExpiry, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errSeenExpiry, s.SeenExpiry)
+		}
+	}
+
+	if len(s.SeenLimit) != 0 {
+		if _, err := strconv.ParseUint(s.SeenLimit, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errSeenLimit, s.SeenLimit)
+		}
+	}
+
+	if len(s.NoCtcp) != 0 {
+		if _, err := strconv.ParseBool(s.NoCtcp); err != nil {
+			c.addError(fmtErrInvalid, name, errNoCtcp, s.NoCtcp)
+		}
+	}
+
+	if len(s.CtcpBurst) != 0 {
+		if _, err := strconv.ParseUint(s.CtcpBurst, 10, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errCtcpBurst, s.CtcpBurst)
+		}
+	}
+
+	if len(s.CtcpPeriod) != 0 {
+		if _, err := strconv.ParseFloat(s.CtcpPeriod, 32); err != nil {
+			c.addError(fmtErrInvalid, name, errCtcpPeriod, s.CtcpPeriod)
+		}
+	}
+
 	if host := s.GetHost(); len(host) == 0 {
 		if missingIsError {
 			c.addError(fmtErrMissing, name, errHost)
@@ -233,6 +535,20 @@ func (c *Config) validateServer(s *Server, missingIsError bool) {
 		c.addError(fmtErrInvalid, name, errHost, host)
 	}
 
+	for _, address := range s.Hosts {
+		host := address
+		if h, port, err := net.SplitHostPort(address); err == nil {
+			host = h
+			if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+				c.addError(fmtErrInvalid, name, errHosts, address)
+				continue
+			}
+		}
+		if !rgxHost.MatchString(host) || len(host) > maxHostSize {
+			c.addError(fmtErrInvalid, name, errHosts, address)
+		}
+	}
+
 	if nick := s.GetNick(); len(nick) == 0 {
 		if missingIsError {
 			c.addError(fmtErrMissing, name, errNick)
@@ -270,6 +586,35 @@ func (c *Config) validateServer(s *Server, missingIsError bool) {
 			c.addError(fmtErrInvalid, name, errChannel, channel)
 		}
 	}
+
+	for _, capability := range s.GetCaps() {
+		if !rgxCap.MatchString(capability) {
+			c.addError(fmtErrInvalid, name, errCap, capability)
+		}
+	}
+
+	if len(s.SaslRequired) != 0 {
+		if _, err := strconv.ParseBool(s.SaslRequired); err != nil {
+			c.addError(fmtErrInvalid, name, errSaslRequired,
+				s.SaslRequired)
+		}
+	}
+
+	switch mechanism := s.GetSaslMechanism(); mechanism {
+	case "", saslExternal:
+	case saslPlain:
+		if !missingIsError {
+			break
+		}
+		if len(s.GetSaslAccount()) == 0 {
+			c.addError(fmtErrMissing, name, errSaslAccount)
+		}
+		if len(s.GetSaslPassword()) == 0 {
+			c.addError(fmtErrMissing, name, errSaslPassword)
+		}
+	default:
+		c.addError(fmtErrInvalid, name, errSaslMechanism, mechanism)
+	}
 }
 
 // DisplayErrors is a helper function to log the output of all config to the
@@ -347,6 +692,18 @@ func (c *Config) Host(host string) *Config {
 	return c
 }
 
+// Hosts fluently sets more hosts for the current config context to try in
+// turn when the host can't be reached. A host may be given a port of it's own
+// as host:port, otherwise the port of the server is used.
+func (c *Config) Hosts(hosts ...string) *Config {
+	if len(hosts) > 0 {
+		context := c.GetContext()
+		context.Hosts = make([]string, len(hosts))
+		copy(context.Hosts, hosts)
+	}
+	return c
+}
+
 // Port fluently sets the port for the current config context
 func (c *Config) Port(port uint16) *Config {
 	c.GetContext().Port = port
@@ -365,6 +722,56 @@ func (c *Config) VerifyCert(verifyCert bool) *Config {
 	return c
 }
 
+// SslCaFile fluently sets a file of PEM encoded certificate authorities to
+// verify the server's certificate against for the current config context,
+// instead of the system's.
+func (c *Config) SslCaFile(filename string) *Config {
+	c.GetContext().SslCaFile = filename
+	return c
+}
+
+// SslClientCert fluently sets the PEM encoded client certificate and key files
+// to present to the server for the current config context. These are used
+// for CertFP and SASL EXTERNAL.
+func (c *Config) SslClientCert(certfile, keyfile string) *Config {
+	context := c.GetContext()
+	context.SslClientCert = certfile
+	context.SslClientKey = keyfile
+	return c
+}
+
+// SslMinVersion fluently sets the minimum tls version for the current config
+// context. One of: 1.0, 1.1, 1.2, 1.3
+func (c *Config) SslMinVersion(version string) *Config {
+	c.GetContext().SslMinVersion = version
+	return c
+}
+
+// SslServerName fluently sets the name sent with SNI and expected in the
+// server's certificate for the current config context, instead of the host.
+func (c *Config) SslServerName(name string) *Config {
+	c.GetContext().SslServerName = name
+	return c
+}
+
+// Proxy fluently sets the proxy to connect through for the current config
+// context. The type is one of: socks5, http. The address is a host:port.
+func (c *Config) Proxy(kind, address string) *Config {
+	context := c.GetContext()
+	context.ProxyType = strings.ToLower(kind)
+	context.ProxyAddress = address
+	return c
+}
+
+// ProxyAuth fluently sets the credentials for the proxy for the current config
+// context.
+func (c *Config) ProxyAuth(username, password string) *Config {
+	context := c.GetContext()
+	context.ProxyUsername = username
+	context.ProxyPassword = password
+	return c
+}
+
 // NoState fluently sets reconnection for the current config context,
 // this turns off the irc state database (data package).
 func (c *Config) NoState(nostate bool) *Config {
@@ -372,6 +779,36 @@ func (c *Config) NoState(nostate bool) *Config {
 	return c
 }
 
+// Statefile fluently sets the file the irc state database is kept in between
+// runs for the current config context. Each server needs it's own file so
+// this is not inherited from the global settings.
+func (c *Config) Statefile(filename string) *Config {
+	c.GetContext().Statefile = filename
+	return c
+}
+
+// StateInterval fluently sets how many seconds apart the state database is
+// saved to it's file for the current config context, 0 only saves it when
+// the server's connection ends.
+func (c *Config) StateInterval(seconds uint) *Config {
+	c.GetContext().StateInterval = strconv.FormatUint(uint64(seconds), 10)
+	return c
+}
+
+// SeenExpiry fluently sets how many days a seen record is kept after the nick
+// was last seen for the current config context, 0 keeps them forever.
+func (c *Config) SeenExpiry(days uint) *Config {
+	c.GetContext().SeenExpiry = strconv.FormatUint(uint64(days), 10)
+	return c
+}
+
+// SeenLimit fluently sets the most seen records that are kept for the current
+// config context, the oldest are dropped first. 0 keeps any number of them.
+func (c *Config) SeenLimit(limit uint) *Config {
+	c.GetContext().SeenLimit = strconv.FormatUint(uint64(limit), 10)
+	return c
+}
+
 // FloodProtectBurst fluently sets flood burst for the current config context,
 // this is how many messages will be bursted through without enabling flood
 // protection.
@@ -399,6 +836,63 @@ func (c *Config) FloodProtectStep(floodstep float64) *Config {
 	return c
 }
 
+// FloodProtectModel fluently sets the flood protection model for the current
+// config context, one of the FLOOD_* models.
+func (c *Config) FloodProtectModel(model string) *Config {
+	c.GetContext().FloodProtectModel = strings.ToLower(model)
+	return c
+}
+
+// FloodProtectLimit fluently sets flood protect limit for the current config
+// context, this is how many seconds of penalty can build up before the
+// penalty model holds messages back.
+func (c *Config) FloodProtectLimit(floodlimit float64) *Config {
+	c.GetContext().FloodProtectLimit =
+		strconv.FormatFloat(floodlimit, 'e', -1, 64)
+	return c
+}
+
+// FloodProtectCost fluently sets flood protect cost for the current config
+// context, this is how many seconds of penalty each message costs in the
+// penalty model.
+func (c *Config) FloodProtectCost(floodcost float64) *Config {
+	c.GetContext().FloodProtectCost =
+		strconv.FormatFloat(floodcost, 'e', -1, 64)
+	return c
+}
+
+// FloodProtectBytes fluently sets flood protect bytes for the current config
+// context, this is how many bytes of a message add another FloodProtectCost
+// to it's penalty in the penalty model.
+func (c *Config) FloodProtectBytes(floodbytes uint) *Config {
+	c.GetContext().FloodProtectBytes =
+		strconv.FormatUint(uint64(floodbytes), 10)
+	return c
+}
+
+// OutputBacklog fluently sets how many messages may wait to be sent to each
+// target (channel or nick) for the current config context, 0 is unlimited.
+func (c *Config) OutputBacklog(lines uint) *Config {
+	c.GetContext().OutputBacklog = strconv.FormatUint(uint64(lines), 10)
+	return c
+}
+
+// OutputOverflow fluently sets what happens to messages written to a target
+// whose backlog is full for the current config context, one of the OVERFLOW_*
+// policies.
+func (c *Config) OutputOverflow(policy string) *Config {
+	c.GetContext().OutputOverflow = strings.ToLower(policy)
+	return c
+}
+
+// OutputCoalesce fluently sets if a message is dropped when it's the same as
+// the last one waiting to be sent to it's target for the current config
+// context.
+func (c *Config) OutputCoalesce(coalesce bool) *Config {
+	c.GetContext().OutputCoalesce = strconv.FormatBool(coalesce)
+	return c
+}
+
 // NoReconnect fluently sets reconnection for the current config context
 func (c *Config) NoReconnect(noreconnect bool) *Config {
 	c.GetContext().NoReconnect = strconv.FormatBool(noreconnect)
@@ -411,6 +905,36 @@ func (c *Config) ReconnectTimeout(seconds uint) *Config {
 	return c
 }
 
+// ReconnectMaxTimeout fluently sets the most seconds the wait between reconns
+// can grow to as attempts keep failing for the current config context.
+func (c *Config) ReconnectMaxTimeout(seconds uint) *Config {
+	c.GetContext().ReconnectMaxTimeout =
+		strconv.FormatUint(uint64(seconds), 10)
+	return c
+}
+
+// ReconnectAttempts fluently sets how many times in a row reconnecting may
+// fail before giving up for the current config context, 0 never gives up.
+func (c *Config) ReconnectAttempts(attempts uint) *Config {
+	c.GetContext().ReconnectAttempts = strconv.FormatUint(uint64(attempts), 10)
+	return c
+}
+
+// KeepaliveInterval fluently sets how many seconds apart keepalive pings are
+// sent to measure the lag for the current config context, 0 sends none.
+func (c *Config) KeepaliveInterval(seconds uint) *Config {
+	c.GetContext().KeepaliveInterval = strconv.FormatUint(uint64(seconds), 10)
+	return c
+}
+
+// KeepaliveTimeout fluently sets how many seconds can go by without any
+// traffic before the connection is considered dead for the current config
+// context, 0 waits forever.
+func (c *Config) KeepaliveTimeout(seconds uint) *Config {
+	c.GetContext().KeepaliveTimeout = strconv.FormatUint(uint64(seconds), 10)
+	return c
+}
+
 // Nick fluently sets the nick for the current config context
 func (c *Config) Nick(nick string) *Config {
 	c.GetContext().Nick = nick
@@ -447,6 +971,124 @@ func (c *Config) Prefix(prefix string) *Config {
 	return c
 }
 
+// NoCtcp fluently sets if the bot should stop answering ctcp requests itself
+// for the current config context.
+func (c *Config) NoCtcp(noctcp bool) *Config {
+	c.GetContext().NoCtcp = strconv.FormatBool(noctcp)
+	return c
+}
+
+// CtcpVersion fluently sets the reply to ctcp VERSION requests for the
+// current config context.
+func (c *Config) CtcpVersion(version string) *Config {
+	c.GetContext().CtcpVersion = version
+	return c
+}
+
+// CtcpSource fluently sets the reply to ctcp SOURCE requests for the current
+// config context.
+func (c *Config) CtcpSource(source string) *Config {
+	c.GetContext().CtcpSource = source
+	return c
+}
+
+// CtcpBurst fluently sets how many ctcp requests are answered in each ctcp
+// period for the current config context, the rest are ignored.
+func (c *Config) CtcpBurst(burst uint) *Config {
+	c.GetContext().CtcpBurst = strconv.FormatUint(uint64(burst), 10)
+	return c
+}
+
+// CtcpPeriod fluently sets how many seconds ctcp replies are counted over for
+// the current config context.
+func (c *Config) CtcpPeriod(period float64) *Config {
+	c.GetContext().CtcpPeriod = strconv.FormatFloat(period, 'e', -1, 64)
+	return c
+}
+
+// Caps fluently sets the IRCv3 capabilities to request for the current config
+// context.
+func (c *Config) Caps(caps ...string) *Config {
+	if len(caps) > 0 {
+		context := c.GetContext()
+		context.Caps = make([]string, len(caps))
+		copy(context.Caps, caps)
+	}
+	return c
+}
+
+// Sasl fluently sets the SASL mechanism and credentials for the current config
+// context. The account and password are only used by the PLAIN mechanism,
+// EXTERNAL authenticates with the client certificate.
+func (c *Config) Sasl(mechanism, account, password string) *Config {
+	context := c.GetContext()
+	context.SaslMechanism = strings.ToUpper(mechanism)
+	context.SaslAccount = account
+	context.SaslPassword = password
+	return c
+}
+
+// SaslRequired fluently sets if the connection should be aborted when SASL
+// authentication fails for the current config context.
+func (c *Config) SaslRequired(required bool) *Config {
+	c.GetContext().SaslRequired = strconv.FormatBool(required)
+	return c
+}
+
+// Userfile fluently sets the file the bot keeps it's registered users in. This
+// is a global setting regardless of the current config context.
+func (c *Config) Userfile(filename string) *Config {
+	c.Global.Userfile = filename
+	return c
+}
+
+// Storagefile fluently sets the file the bot keeps handler storage in. This
+// is a global setting regardless of the current config context.
+func (c *Config) Storagefile(filename string) *Config {
+	c.Global.Storagefile = filename
+	return c
+}
+
+// DccListenAddress fluently sets the local address dcc connections are
+// listened for on, every address if it's empty. This is a global setting
+// regardless of the current config context.
+func (c *Config) DccListenAddress(address string) *Config {
+	c.Global.DccListenAddress = address
+	return c
+}
+
+// DccPublicIP fluently sets the address users are told to connect to for dcc.
+// This is a global setting regardless of the current config context.
+func (c *Config) DccPublicIP(ip string) *Config {
+	c.Global.DccPublicIP = ip
+	return c
+}
+
+// DccPorts fluently sets the ports dcc connections may be listened for on,
+// any free port is used if min is 0. This is a global setting regardless of
+// the current config context.
+func (c *Config) DccPorts(min, max uint16) *Config {
+	c.Global.DccPortMin = min
+	c.Global.DccPortMax = max
+	return c
+}
+
+// DccPassive fluently sets if dcc offers are passive, so the user listens and
+// the bot connects to them. This is a global setting regardless of the
+// current config context.
+func (c *Config) DccPassive(passive bool) *Config {
+	c.Global.DccPassive = strconv.FormatBool(passive)
+	return c
+}
+
+// DccAnyPeer fluently sets if dcc connections are accepted from any address
+// when the user's host can't be resolved to check it against. This is a
+// global setting regardless of the current config context.
+func (c *Config) DccAnyPeer(anyPeer bool) *Config {
+	c.Global.DccAnyPeer = strconv.FormatBool(anyPeer)
+	return c
+}
+
 // Channels fluently sets the channels for the current config context
 func (c *Config) Channels(channels ...string) *Config {
 	if len(channels) > 0 {
@@ -469,21 +1111,54 @@ type Server struct {
 
 	// Irc Server connection info
 	Host       string
+	Hosts      []string
 	Port       uint16
 	Ssl        string
 	VerifyCert string
 
+	// Ssl options
+	SslCaFile     string
+	SslClientCert string
+	SslClientKey  string
+	SslMinVersion string
+	SslServerName string
+
+	// Proxy to connect through
+	ProxyType     string
+	ProxyAddress  string
+	ProxyUsername string
+	ProxyPassword string
+
 	// State tracking
-	NoState string
+	NoState       string
+	Statefile     string
+	StateInterval string
+	SeenExpiry    string
+	SeenLimit     string
 
 	// Flood Protection
 	FloodProtectBurst   string
 	FloodProtectTimeout string
 	FloodProtectStep    string
+	FloodProtectModel   string
+	FloodProtectLimit   string
+	FloodProtectCost    string
+	FloodProtectBytes   string
+
+	// Outbound queue shaping
+	OutputBacklog  string
+	OutputOverflow string
+	OutputCoalesce string
 
 	// Auto reconnection
-	NoReconnect      string
-	ReconnectTimeout string
+	NoReconnect         string
+	ReconnectTimeout    string
+	ReconnectMaxTimeout string
+	ReconnectAttempts   string
+
+	// Keepalive
+	KeepaliveInterval string
+	KeepaliveTimeout  string
 
 	// Irc User data
 	Nick     string
@@ -495,6 +1170,82 @@ type Server struct {
 	// Dispatching options
 	Prefix   string
 	Channels []string
+
+	// Ctcp replies
+	NoCtcp      string
+	CtcpVersion string
+	CtcpSource  string
+	CtcpBurst   string
+	CtcpPeriod  string
+
+	// IRCv3 capabilities to request
+	Caps []string
+
+	// SASL authentication
+	SaslMechanism string
+	SaslAccount   string
+	SaslPassword  string
+	SaslRequired  string
+
+	// Access control, this is only read from the global settings.
+	Userfile string
+
+	// Storage for handlers, this is only read from the global settings.
+	Storagefile string
+
+	// Dcc connections, these are only read from the global settings.
+	DccListenAddress string
+	DccPublicIP      string
+	DccPortMin       uint16
+	DccPortMax       uint16
+	DccPassive       string
+	DccAnyPeer       string
+}
+
+// GetDccListenAddress returns the local address dcc connections are listened
+// for on, empty string for every address.
+func (c *Config) GetDccListenAddress() string {
+	if c.Global == nil {
+		return ""
+	}
+	return c.Global.DccListenAddress
+}
+
+// GetDccPublicIP returns the address users are told to connect to for dcc,
+// or nil if there isn't one.
+func (c *Config) GetDccPublicIP() net.IP {
+	if c.Global == nil {
+		return nil
+	}
+	return net.ParseIP(c.Global.DccPublicIP)
+}
+
+// GetDccPorts returns the ports dcc connections may be listened for on, 0 for
+// min means any free port.
+func (c *Config) GetDccPorts() (min, max uint16) {
+	if c.Global == nil {
+		return 0, 0
+	}
+	return c.Global.DccPortMin, c.Global.DccPortMax
+}
+
+// GetDccPassive returns if dcc offers are passive, or false.
+func (c *Config) GetDccPassive() (passive bool) {
+	if c.Global == nil || len(c.Global.DccPassive) == 0 {
+		return false
+	}
+	passive, _ = strconv.ParseBool(c.Global.DccPassive)
+	return
+}
+
+// GetDccAnyPeer returns if dcc connections are accepted from any address
+// when the user's host can't be checked, or false.
+func (c *Config) GetDccAnyPeer() (anyPeer bool) {
+	if c.Global == nil || len(c.Global.DccAnyPeer) == 0 {
+		return false
+	}
+	anyPeer, _ = strconv.ParseBool(c.Global.DccAnyPeer)
+	return
 }
 
 // GetFilename returns fileName of the configuration, or the default.
@@ -506,11 +1257,46 @@ func (c *Config) GetFilename() (filename string) {
 	return
 }
 
+// GetUserfile returns the file the bot keeps it's registered users in, or
+// empty string if they are only kept in memory.
+func (c *Config) GetUserfile() string {
+	if c.Global == nil {
+		return ""
+	}
+	return c.Global.Userfile
+}
+
+// GetStoragefile returns the file the bot keeps handler storage in, or empty
+// string if it's only kept in memory.
+func (c *Config) GetStoragefile() string {
+	if c.Global == nil {
+		return ""
+	}
+	return c.Global.Storagefile
+}
+
 // GetHost gets s.host
 func (s *Server) GetHost() string {
 	return s.Host
 }
 
+// GetHosts gets the addresses to connect to as host:port, s.host first and
+// then s.hosts. Hosts without a port of their own use the server's port.
+func (s *Server) GetHosts() (hosts []string) {
+	port := strconv.Itoa(int(s.GetPort()))
+	if len(s.Host) > 0 {
+		hosts = append(hosts, net.JoinHostPort(s.Host, port))
+	}
+	for _, address := range s.Hosts {
+		if _, _, err := net.SplitHostPort(address); err == nil {
+			hosts = append(hosts, address)
+		} else {
+			hosts = append(hosts, net.JoinHostPort(address, port))
+		}
+	}
+	return
+}
+
 // GetName gets s.name
 func (s *Server) GetName() string {
 	return s.Name
@@ -559,6 +1345,89 @@ func (s *Server) GetVerifyCert() (verifyCert bool) {
 	return
 }
 
+// GetSslCaFile gets SslCaFile of the server, or the global sslCaFile, or empty
+// string to use the system's certificate authorities.
+func (s *Server) GetSslCaFile() (filename string) {
+	if len(s.SslCaFile) > 0 {
+		filename = s.SslCaFile
+	} else if s.parent != nil && len(s.parent.Global.SslCaFile) > 0 {
+		filename = s.parent.Global.SslCaFile
+	}
+	return
+}
+
+// GetSslClientCert gets SslClientCert and SslClientKey of the server, or the
+// global sslClientCert and sslClientKey, or empty strings. The certificate and
+// key are always taken together.
+func (s *Server) GetSslClientCert() (certfile, keyfile string) {
+	if len(s.SslClientCert) > 0 || len(s.SslClientKey) > 0 {
+		certfile, keyfile = s.SslClientCert, s.SslClientKey
+	} else if s.parent != nil {
+		certfile = s.parent.Global.SslClientCert
+		keyfile = s.parent.Global.SslClientKey
+	}
+	return
+}
+
+// GetSslMinVersion gets SslMinVersion of the server, or the global
+// sslMinVersion, as a tls package version constant, or 0 for the tls package
+// default.
+func (s *Server) GetSslMinVersion() (version uint16) {
+	if len(s.SslMinVersion) > 0 {
+		version = sslVersions[s.SslMinVersion]
+	} else if s.parent != nil && len(s.parent.Global.SslMinVersion) > 0 {
+		version = sslVersions[s.parent.Global.SslMinVersion]
+	}
+	return
+}
+
+// GetSslServerName gets SslServerName of the server, or the global
+// sslServerName, or the host.
+func (s *Server) GetSslServerName() (name string) {
+	name = s.GetHost()
+	if len(s.SslServerName) > 0 {
+		name = s.SslServerName
+	} else if s.parent != nil && len(s.parent.Global.SslServerName) > 0 {
+		name = s.parent.Global.SslServerName
+	}
+	return
+}
+
+// GetProxyType gets ProxyType of the server, or the global proxyType, or empty
+// string for a direct connection.
+func (s *Server) GetProxyType() (kind string) {
+	if len(s.ProxyType) > 0 {
+		kind = s.ProxyType
+	} else if s.parent != nil && len(s.parent.Global.ProxyType) > 0 {
+		kind = s.parent.Global.ProxyType
+	}
+	return
+}
+
+// GetProxyAddress gets ProxyAddress of the server, or the global
+// proxyAddress, or empty string.
+func (s *Server) GetProxyAddress() (address string) {
+	if len(s.ProxyAddress) > 0 {
+		address = s.ProxyAddress
+	} else if s.parent != nil && len(s.parent.Global.ProxyAddress) > 0 {
+		address = s.parent.Global.ProxyAddress
+	}
+	return
+}
+
+// GetProxyAuth gets ProxyUsername and ProxyPassword of the server, or the
+// global proxyUsername and proxyPassword, or empty strings. The username and
+// password are always taken together.
+func (s *Server) GetProxyAuth() (username, password string) {
+	if len(s.ProxyUsername) > 0 || len(s.ProxyPassword) > 0 {
+		username, password = s.ProxyUsername, s.ProxyPassword
+	} else if s.parent != nil {
+		username = s.parent.Global.ProxyUsername
+		password = s.parent.Global.ProxyPassword
+	}
+	return
+}
+
 // GetNoState gets NoState of the server, or the global nostate, or
 // false
 func (s *Server) GetNoState() (nostate bool) {
@@ -575,6 +1444,81 @@ func (s *Server) GetNoState() (nostate bool) {
 	return
 }
 
+// GetStatefile gets the file the server's state database is kept in, or
+// empty string if it's only kept in memory.
+func (s *Server) GetStatefile() string {
+	return s.Statefile
+}
+
+// GetStateInterval gets StateInterval of the server, or the global
+// stateInterval, or defaultStateInterval.
+func (s *Server) GetStateInterval() (interval uint) {
+	var notset bool
+	var err error
+	var u uint64
+	interval = defaultStateInterval
+	if len(s.StateInterval) != 0 {
+		u, err = strconv.ParseUint(s.StateInterval, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.StateInterval) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.StateInterval, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		interval = defaultStateInterval
+	} else if !notset {
+		interval = uint(u)
+	}
+	return
+}
+
+// GetSeenExpiry gets SeenExpiry of the server, or the global
+// seenExpiry, or defaultSeenExpiry.
+func (s *Server) GetSeenExpiry() (days uint) {
+	var notset bool
+	var err error
+	var u uint64
+	days = defaultSeenExpiry
+	if len(s.SeenExpiry) != 0 {
+		u, err = strconv.ParseUint(s.SeenExpiry, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.SeenExpiry) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.SeenExpiry, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		days = defaultSeenExpiry
+	} else if !notset {
+		days = uint(u)
+	}
+	return
+}
+
+// GetSeenLimit gets SeenLimit of the server, or the global
+// seenLimit, or defaultSeenLimit.
+func (s *Server) GetSeenLimit() (limit uint) {
+	var notset bool
+	var err error
+	var u uint64
+	limit = defaultSeenLimit
+	if len(s.SeenLimit) != 0 {
+		u, err = strconv.ParseUint(s.SeenLimit, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.SeenLimit) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.SeenLimit, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		limit = defaultSeenLimit
+	} else if !notset {
+		limit = uint(u)
+	}
+	return
+}
+
 // GetNoReconnect gets NoReconnect of the server, or the global noReconnect, or
 // false
 func (s *Server) GetNoReconnect() (noReconnect bool) {
@@ -652,6 +1596,122 @@ func (s *Server) GetFloodProtectStep() (floodStep float64) {
 	return
 }
 
+// GetFloodProtectModel gets FloodProtectModel of the server, or the global
+// floodProtectModel, or defaultFloodProtectModel.
+func (s *Server) GetFloodProtectModel() (model string) {
+	model = defaultFloodProtectModel
+	if len(s.FloodProtectModel) > 0 {
+		model = s.FloodProtectModel
+	} else if s.parent != nil && len(s.parent.Global.FloodProtectModel) > 0 {
+		model = s.parent.Global.FloodProtectModel
+	}
+	return
+}
+
+// GetFloodProtectLimit gets FloodProtectLimit of the server, or the global
+// floodProtectLimit, or defaultFloodProtectLimit.
+func (s *Server) GetFloodProtectLimit() (floodLimit float64) {
+	var err error
+	floodLimit = defaultFloodProtectLimit
+	if len(s.FloodProtectLimit) != 0 {
+		floodLimit, err = strconv.ParseFloat(s.FloodProtectLimit, 32)
+	} else if s.parent != nil && len(s.parent.Global.FloodProtectLimit) != 0 {
+		floodLimit, err =
+			strconv.ParseFloat(s.parent.Global.FloodProtectLimit, 32)
+	}
+
+	if err != nil {
+		floodLimit = defaultFloodProtectLimit
+	}
+	return
+}
+
+// GetFloodProtectCost gets FloodProtectCost of the server, or the global
+// floodProtectCost, or defaultFloodProtectCost.
+func (s *Server) GetFloodProtectCost() (floodCost float64) {
+	var err error
+	floodCost = defaultFloodProtectCost
+	if len(s.FloodProtectCost) != 0 {
+		floodCost, err = strconv.ParseFloat(s.FloodProtectCost, 32)
+	} else if s.parent != nil && len(s.parent.Global.FloodProtectCost) != 0 {
+		floodCost, err =
+			strconv.ParseFloat(s.parent.Global.FloodProtectCost, 32)
+	}
+
+	if err != nil {
+		floodCost = defaultFloodProtectCost
+	}
+	return
+}
+
+// GetFloodProtectBytes gets FloodProtectBytes of the server, or the global
+// floodProtectBytes, or defaultFloodProtectBytes.
+func (s *Server) GetFloodProtectBytes() (floodBytes uint) {
+	var notset bool
+	var u uint64
+	var err error
+	floodBytes = defaultFloodProtectBytes
+	if len(s.FloodProtectBytes) != 0 {
+		u, err = strconv.ParseUint(s.FloodProtectBytes, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.FloodProtectBytes) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.FloodProtectBytes, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		floodBytes = defaultFloodProtectBytes
+	} else if !notset {
+		floodBytes = uint(u)
+	}
+	return
+}
+
+// GetOutputBacklog gets OutputBacklog of the server, or the global
+// outputBacklog, or 0 for unlimited.
+func (s *Server) GetOutputBacklog() (backlog uint) {
+	var u uint64
+	var err error
+	if len(s.OutputBacklog) != 0 {
+		u, err = strconv.ParseUint(s.OutputBacklog, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.OutputBacklog) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.OutputBacklog, 10, 32)
+	}
+
+	if err == nil {
+		backlog = uint(u)
+	}
+	return
+}
+
+// GetOutputOverflow gets OutputOverflow of the server, or the global
+// outputOverflow, or defaultOutputOverflow.
+func (s *Server) GetOutputOverflow() (overflow string) {
+	overflow = defaultOutputOverflow
+	if len(s.OutputOverflow) > 0 {
+		overflow = s.OutputOverflow
+	} else if s.parent != nil && len(s.parent.Global.OutputOverflow) > 0 {
+		overflow = s.parent.Global.OutputOverflow
+	}
+	return
+}
+
+// GetOutputCoalesce gets OutputCoalesce of the server, or the global
+// outputCoalesce, or false.
+func (s *Server) GetOutputCoalesce() (coalesce bool) {
+	var err error
+	if len(s.OutputCoalesce) != 0 {
+		coalesce, err = strconv.ParseBool(s.OutputCoalesce)
+	} else if s.parent != nil && len(s.parent.Global.OutputCoalesce) != 0 {
+		coalesce, err = strconv.ParseBool(s.parent.Global.OutputCoalesce)
+	}
+
+	if err != nil {
+		coalesce = false
+	}
+	return
+}
+
 // GetReconnectTimeout gets ReconnectTimeout of the server, or the global
 // reconnectTimeout, or defaultReconnectTimeout
 func (s *Server) GetReconnectTimeout() (reconnTimeout uint) {
@@ -675,6 +1735,92 @@ func (s *Server) GetReconnectTimeout() (reconnTimeout uint) {
 	return
 }
 
+// GetReconnectMaxTimeout gets ReconnectMaxTimeout of the server, or the global
+// reconnectMaxTimeout, or defaultReconnectMaxTimeout
+func (s *Server) GetReconnectMaxTimeout() (maxTimeout uint) {
+	var notset bool
+	var err error
+	var u uint64
+	maxTimeout = defaultReconnectMaxTimeout
+	if len(s.ReconnectMaxTimeout) != 0 {
+		u, err = strconv.ParseUint(s.ReconnectMaxTimeout, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.ReconnectMaxTimeout) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.ReconnectMaxTimeout, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		maxTimeout = defaultReconnectMaxTimeout
+	} else if !notset {
+		maxTimeout = uint(u)
+	}
+	return
+}
+
+// GetReconnectAttempts gets ReconnectAttempts of the server, or the global
+// reconnectAttempts, or 0 to never give up.
+func (s *Server) GetReconnectAttempts() (attempts uint) {
+	if len(s.ReconnectAttempts) != 0 {
+		u, err := strconv.ParseUint(s.ReconnectAttempts, 10, 32)
+		if err == nil {
+			attempts = uint(u)
+		}
+	} else if s.parent != nil && len(s.parent.Global.ReconnectAttempts) != 0 {
+		u, err := strconv.ParseUint(s.parent.Global.ReconnectAttempts, 10, 32)
+		if err == nil {
+			attempts = uint(u)
+		}
+	}
+	return
+}
+
+// GetKeepaliveInterval gets KeepaliveInterval of the server, or the global
+// keepaliveInterval, or defaultKeepaliveInterval.
+func (s *Server) GetKeepaliveInterval() (interval uint) {
+	var notset bool
+	var err error
+	var u uint64
+	interval = defaultKeepaliveInterval
+	if len(s.KeepaliveInterval) != 0 {
+		u, err = strconv.ParseUint(s.KeepaliveInterval, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.KeepaliveInterval) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.KeepaliveInterval, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		interval = defaultKeepaliveInterval
+	} else if !notset {
+		interval = uint(u)
+	}
+	return
+}
+
+// GetKeepaliveTimeout gets KeepaliveTimeout of the server, or the global
+// keepaliveTimeout, or defaultKeepaliveTimeout.
+func (s *Server) GetKeepaliveTimeout() (timeout uint) {
+	var notset bool
+	var err error
+	var u uint64
+	timeout = defaultKeepaliveTimeout
+	if len(s.KeepaliveTimeout) != 0 {
+		u, err = strconv.ParseUint(s.KeepaliveTimeout, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.KeepaliveTimeout) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.KeepaliveTimeout, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		timeout = defaultKeepaliveTimeout
+	} else if !notset {
+		timeout = uint(u)
+	}
+	return
+}
+
 // GetNick gets Nick of the server, or the global nick, or empty string.
 func (s *Server) GetNick() (nick string) {
 	if len(s.Nick) > 0 {
@@ -740,6 +1886,146 @@ func (s *Server) GetPrefix() (prefix string) {
 	return
 }
 
+// GetNoCtcp gets NoCtcp of the server, or the global noCtcp, or false.
+func (s *Server) GetNoCtcp() (noctcp bool) {
+	var err error
+	if len(s.NoCtcp) != 0 {
+		noctcp, err = strconv.ParseBool(s.NoCtcp)
+	} else if s.parent != nil && len(s.parent.Global.NoCtcp) != 0 {
+		noctcp, err = strconv.ParseBool(s.parent.Global.NoCtcp)
+	}
+
+	if err != nil {
+		noctcp = false
+	}
+	return
+}
+
+// GetCtcpVersion gets CtcpVersion of the server, or the global ctcpVersion,
+// or defaultCtcpVersion.
+func (s *Server) GetCtcpVersion() (version string) {
+	version = defaultCtcpVersion
+	if len(s.CtcpVersion) > 0 {
+		version = s.CtcpVersion
+	} else if s.parent != nil && len(s.parent.Global.CtcpVersion) > 0 {
+		version = s.parent.Global.CtcpVersion
+	}
+	return
+}
+
+// GetCtcpSource gets CtcpSource of the server, or the global ctcpSource, or
+// defaultCtcpSource.
+func (s *Server) GetCtcpSource() (source string) {
+	source = defaultCtcpSource
+	if len(s.CtcpSource) > 0 {
+		source = s.CtcpSource
+	} else if s.parent != nil && len(s.parent.Global.CtcpSource) > 0 {
+		source = s.parent.Global.CtcpSource
+	}
+	return
+}
+
+// GetCtcpBurst gets CtcpBurst of the server, or the global ctcpBurst, or
+// defaultCtcpBurst.
+func (s *Server) GetCtcpBurst() (burst uint) {
+	var notset bool
+	var u uint64
+	var err error
+	burst = defaultCtcpBurst
+	if len(s.CtcpBurst) != 0 {
+		u, err = strconv.ParseUint(s.CtcpBurst, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.CtcpBurst) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.CtcpBurst, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		burst = defaultCtcpBurst
+	} else if !notset {
+		burst = uint(u)
+	}
+	return
+}
+
+// GetCtcpPeriod gets CtcpPeriod of the server, or the global ctcpPeriod, or
+// defaultCtcpPeriod.
+func (s *Server) GetCtcpPeriod() (period float64) {
+	var err error
+	period = defaultCtcpPeriod
+	if len(s.CtcpPeriod) != 0 {
+		period, err = strconv.ParseFloat(s.CtcpPeriod, 32)
+	} else if s.parent != nil && len(s.parent.Global.CtcpPeriod) != 0 {
+		period, err = strconv.ParseFloat(s.parent.Global.CtcpPeriod, 32)
+	}
+
+	if err != nil {
+		period = defaultCtcpPeriod
+	}
+	return
+}
+
+// GetCaps gets Caps of the server, or the global caps, or nil slice of string
+// (check the length!).
+func (s *Server) GetCaps() (caps []string) {
+	if len(s.Caps) > 0 {
+		caps = s.Caps
+	} else if s.parent != nil && len(s.parent.Global.Caps) > 0 {
+		caps = s.parent.Global.Caps
+	}
+	return
+}
+
+// GetSaslMechanism gets SaslMechanism of the server, or the global
+// saslMechanism, or empty string if SASL is not to be used.
+func (s *Server) GetSaslMechanism() (mechanism string) {
+	if len(s.SaslMechanism) > 0 {
+		mechanism = s.SaslMechanism
+	} else if s.parent != nil && len(s.parent.Global.SaslMechanism) > 0 {
+		mechanism = s.parent.Global.SaslMechanism
+	}
+	return
+}
+
+// GetSaslAccount gets SaslAccount of the server, or the global saslAccount, or
+// empty string.
+func (s *Server) GetSaslAccount() (account string) {
+	if len(s.SaslAccount) > 0 {
+		account = s.SaslAccount
+	} else if s.parent != nil && len(s.parent.Global.SaslAccount) > 0 {
+		account = s.parent.Global.SaslAccount
+	}
+	return
+}
+
+// GetSaslPassword gets SaslPassword of the server, or the global
+// saslPassword, or empty string.
+func (s *Server) GetSaslPassword() (password string) {
+	if len(s.SaslPassword) > 0 {
+		password = s.SaslPassword
+	} else if s.parent != nil && len(s.parent.Global.SaslPassword) > 0 {
+		password = s.parent.Global.SaslPassword
+	}
+	return
+}
+
+// GetSaslRequired gets SaslRequired of the server, or the global
+// saslRequired, or false. When true a failed SASL authentication aborts the
+// connection instead of continuing unauthenticated.
+func (s *Server) GetSaslRequired() (required bool) {
+	var err error
+	if len(s.SaslRequired) != 0 {
+		required, err = strconv.ParseBool(s.SaslRequired)
+	} else if s.parent != nil && len(s.parent.Global.SaslRequired) != 0 {
+		required, err = strconv.ParseBool(s.parent.Global.SaslRequired)
+	}
+
+	if err != nil {
+		required = false
+	}
+	return
+}
+
 // GetChannels gets Channels of the server, or the global channels, or nil
 // slice of string (check the length!).
 func (s *Server) GetChannels() (channels []string) {
@@ -2,8 +2,10 @@ package config
 
 import (
 	"bytes"
+	"crypto/tls"
 	. "launchpad.net/gocheck"
 	"log"
+	"net"
 	"os"
 	"testing"
 )
@@ -491,6 +493,436 @@ func (s *s) TestConfig_Filename(c *C) {
 	c.Check(conf.GetFilename(), Equals, filename)
 }
 
+func (s *s) TestConfig_Caps(c *C) {
+	conf := CreateConfig().
+		Caps("multi-prefix", "draft/chathistory").
+		Server("irc.test.net").
+		Server("irc.other.net").
+		Caps("sasl")
+	c.Check(conf.GetServer("irc.test.net").GetCaps(), DeepEquals,
+		[]string{"multi-prefix", "draft/chathistory"})
+	c.Check(conf.GetServer("irc.other.net").GetCaps(), DeepEquals,
+		[]string{"sasl"})
+
+	conf = CreateConfig().
+		Server("irc.test.net").
+		Nick("nick").
+		Username("user").
+		Userhost("host").
+		Realname("real").
+		Caps("multi prefix", ":sasl")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+	c.Check(conf.Errors[0], ErrorMatches, invErr(errCap))
+}
+
+func (s *s) TestConfig_SslOptions(c *C) {
+	conf := CreateConfig().
+		SslCaFile("ca.pem").
+		SslClientCert("cert.pem", "key.pem").
+		SslMinVersion("1.2").
+		Server("irc.test.net").
+		Server("irc.other.net").
+		SslCaFile("other.pem").
+		SslClientCert("othercert.pem", "otherkey.pem").
+		SslMinVersion("1.0").
+		SslServerName("sni.other.net")
+
+	srv := conf.GetServer("irc.test.net")
+	c.Check(srv.GetSslCaFile(), Equals, "ca.pem")
+	cert, key := srv.GetSslClientCert()
+	c.Check(cert, Equals, "cert.pem")
+	c.Check(key, Equals, "key.pem")
+	c.Check(srv.GetSslMinVersion(), Equals, uint16(tls.VersionTLS12))
+	c.Check(srv.GetSslServerName(), Equals, "irc.test.net")
+
+	srv = conf.GetServer("irc.other.net")
+	c.Check(srv.GetSslCaFile(), Equals, "other.pem")
+	cert, key = srv.GetSslClientCert()
+	c.Check(cert, Equals, "othercert.pem")
+	c.Check(key, Equals, "otherkey.pem")
+	c.Check(srv.GetSslMinVersion(), Equals, uint16(tls.VersionTLS10))
+	c.Check(srv.GetSslServerName(), Equals, "sni.other.net")
+
+	srv = CreateConfig().Server("irc.test.net").GetServer("irc.test.net")
+	c.Check(srv.GetSslCaFile(), Equals, "")
+	cert, key = srv.GetSslClientCert()
+	c.Check(cert, Equals, "")
+	c.Check(key, Equals, "")
+	c.Check(srv.GetSslMinVersion(), Equals, uint16(0))
+
+	conf = CreateConfig().
+		Server("irc.test.net").
+		Nick("nick").
+		Username("user").
+		Userhost("host").
+		Realname("real").
+		SslMinVersion("2.0").
+		SslServerName("bad name").
+		SslClientCert("cert.pem", "")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 3)
+	c.Check(conf.Errors[0], ErrorMatches, invErr(errSslMinVersion))
+	c.Check(conf.Errors[1], ErrorMatches, invErr(errSslServerName))
+	c.Check(conf.Errors[2], ErrorMatches, reqErr(errSslClientKey))
+}
+
+func (s *s) TestConfig_Proxy(c *C) {
+	conf := CreateConfig().
+		Proxy("SOCKS5", "proxy.test.net:1080").
+		ProxyAuth("user", "pass").
+		Server("irc.test.net").
+		Server("irc.other.net").
+		Proxy("http", "10.0.0.1:3128").
+		ProxyAuth("other", "")
+
+	srv := conf.GetServer("irc.test.net")
+	c.Check(srv.GetProxyType(), Equals, proxySocks5)
+	c.Check(srv.GetProxyAddress(), Equals, "proxy.test.net:1080")
+	username, password := srv.GetProxyAuth()
+	c.Check(username, Equals, "user")
+	c.Check(password, Equals, "pass")
+
+	srv = conf.GetServer("irc.other.net")
+	c.Check(srv.GetProxyType(), Equals, proxyHttp)
+	c.Check(srv.GetProxyAddress(), Equals, "10.0.0.1:3128")
+	username, password = srv.GetProxyAuth()
+	c.Check(username, Equals, "other")
+	c.Check(password, Equals, "")
+
+	srv = CreateConfig().Server("irc.test.net").GetServer("irc.test.net")
+	c.Check(srv.GetProxyType(), Equals, "")
+	c.Check(srv.GetProxyAddress(), Equals, "")
+
+	for _, bad := range []string{"", "proxy.test.net", "bad host:1080",
+		"proxy.test.net:port", "proxy.test.net:70000"} {
+
+		conf = CreateConfig().
+			Server("irc.test.net").
+			Nick("nick").
+			Username("user").
+			Userhost("host").
+			Realname("real").
+			Proxy("http", bad)
+		c.Check(conf.IsValid(), Equals, false)
+		c.Check(len(conf.Errors), Equals, 1)
+		if len(bad) == 0 {
+			c.Check(conf.Errors[0], ErrorMatches, reqErr(errProxyAddress))
+		} else {
+			c.Check(conf.Errors[0], ErrorMatches, invErr(errProxyAddress))
+		}
+	}
+
+	conf = CreateConfig().
+		Server("irc.test.net").
+		Nick("nick").
+		Username("user").
+		Userhost("host").
+		Realname("real").
+		Proxy("socks4", "proxy.test.net:1080")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0], ErrorMatches, invErr(errProxyType))
+}
+
+func (s *s) TestConfig_Sasl(c *C) {
+	conf := CreateConfig().
+		Sasl("plain", "acct", "pass").
+		SaslRequired(true).
+		Server("irc.test.net").
+		Server("irc.other.net").
+		Sasl("EXTERNAL", "", "").
+		SaslRequired(false)
+
+	srv := conf.GetServer("irc.test.net")
+	c.Check(srv.GetSaslMechanism(), Equals, saslPlain)
+	c.Check(srv.GetSaslAccount(), Equals, "acct")
+	c.Check(srv.GetSaslPassword(), Equals, "pass")
+	c.Check(srv.GetSaslRequired(), Equals, true)
+
+	srv = conf.GetServer("irc.other.net")
+	c.Check(srv.GetSaslMechanism(), Equals, saslExternal)
+	c.Check(srv.GetSaslRequired(), Equals, false)
+
+	conf = CreateConfig().Server("irc.test.net")
+	srv = conf.GetServer("irc.test.net")
+	c.Check(srv.GetSaslMechanism(), Equals, "")
+	c.Check(srv.GetSaslRequired(), Equals, false)
+	srv.SaslRequired = "notabool"
+	c.Check(srv.GetSaslRequired(), Equals, false)
+
+	conf = CreateConfig().
+		Server("irc.test.net").
+		Nick("nick").
+		Username("user").
+		Userhost("host").
+		Realname("real").
+		Sasl("PLAIN", "", "")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+	c.Check(conf.Errors[0], ErrorMatches, reqErr(errSaslAccount))
+	c.Check(conf.Errors[1], ErrorMatches, reqErr(errSaslPassword))
+
+	conf = CreateConfig().
+		Server("irc.test.net").
+		Nick("nick").
+		Username("user").
+		Userhost("host").
+		Realname("real").
+		Sasl("SCRAM-SHA-1", "acct", "pass")
+	conf.GetServer("irc.test.net").SaslRequired = "notabool"
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+	c.Check(conf.Errors[0], ErrorMatches, invErr(errSaslRequired))
+	c.Check(conf.Errors[1], ErrorMatches, invErr(errSaslMechanism))
+}
+
+func (s *s) TestConfig_Userfile(c *C) {
+	conf := CreateConfig()
+	c.Check(conf.GetUserfile(), Equals, "")
+	conf.Server("irc.test.net").Userfile("users.db")
+	c.Check(conf.GetUserfile(), Equals, "users.db")
+	c.Check(conf.Global.Userfile, Equals, "users.db")
+	c.Check(conf.GetServer("irc.test.net").Userfile, Equals, "")
+}
+
+func (s *s) TestConfig_Dcc(c *C) {
+	conf := CreateConfig()
+	c.Check(conf.GetDccListenAddress(), Equals, "")
+	c.Check(conf.GetDccPublicIP(), IsNil)
+	min, max := conf.GetDccPorts()
+	c.Check(min, Equals, uint16(0))
+	c.Check(max, Equals, uint16(0))
+	c.Check(conf.GetDccPassive(), Equals, false)
+	c.Check(conf.GetDccAnyPeer(), Equals, false)
+
+	conf.Server("irc.test.net").DccListenAddress("127.0.0.1").
+		DccPublicIP("192.0.2.1").DccPorts(5000, 5010).DccPassive(true).
+		DccAnyPeer(true)
+	c.Check(conf.GetDccListenAddress(), Equals, "127.0.0.1")
+	c.Check(conf.GetDccPublicIP().Equal(net.IPv4(192, 0, 2, 1)), Equals, true)
+	min, max = conf.GetDccPorts()
+	c.Check(min, Equals, uint16(5000))
+	c.Check(max, Equals, uint16(5010))
+	c.Check(conf.GetDccPassive(), Equals, true)
+	c.Check(conf.GetDccAnyPeer(), Equals, true)
+	c.Check(conf.GetServer("irc.test.net").DccPublicIP, Equals, "")
+
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody")
+	c.Check(conf.IsValid(), Equals, true)
+
+	conf.DccListenAddress("everywhere").DccPublicIP("home").
+		DccPorts(5010, 5000)
+	conf.Global.DccPassive = "sometimes"
+	conf.Global.DccAnyPeer = "anyone"
+	c.Check(conf.GetDccPassive(), Equals, false)
+	c.Check(conf.GetDccAnyPeer(), Equals, false)
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 5)
+}
+
+func (s *s) TestConfig_Storagefile(c *C) {
+	conf := CreateConfig()
+	c.Check(conf.GetStoragefile(), Equals, "")
+	conf.Server("irc.test.net").Storagefile("storage.db")
+	c.Check(conf.GetStoragefile(), Equals, "storage.db")
+	c.Check(conf.Global.Storagefile, Equals, "storage.db")
+	c.Check(conf.GetServer("irc.test.net").Storagefile, Equals, "")
+}
+
+func (s *s) TestConfig_Statefile(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	c.Check(conf.GetServer("irc.test.net").GetStatefile(), Equals, "")
+	conf.Statefile("state.db")
+	c.Check(conf.GetServer("irc.test.net").GetStatefile(), Equals, "state.db")
+
+	conf = CreateConfig().Statefile("global.db").Server("irc.test.net")
+	c.Check(conf.Global.Statefile, Equals, "global.db")
+	c.Check(conf.GetServer("irc.test.net").GetStatefile(), Equals, "")
+}
+
+func (s *s) TestConfig_Ctcp(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetNoCtcp(), Equals, false)
+	c.Check(server.GetCtcpVersion(), Equals, defaultCtcpVersion)
+	c.Check(server.GetCtcpSource(), Equals, defaultCtcpSource)
+	c.Check(server.GetCtcpBurst(), Equals, defaultCtcpBurst)
+	c.Check(server.GetCtcpPeriod(), Equals, defaultCtcpPeriod)
+
+	conf = CreateConfig().CtcpVersion("bot 1.0").CtcpBurst(5).
+		Server("irc.test.net").NoCtcp(true).CtcpSource("src").CtcpPeriod(2.5)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetNoCtcp(), Equals, true)
+	c.Check(server.GetCtcpVersion(), Equals, "bot 1.0")
+	c.Check(server.GetCtcpSource(), Equals, "src")
+	c.Check(server.GetCtcpBurst(), Equals, uint(5))
+	c.Check(server.GetCtcpPeriod(), Equals, 2.5)
+
+	server.NoCtcp = "maybe"
+	server.CtcpBurst = "lots"
+	server.CtcpPeriod = "soon"
+	c.Check(server.GetCtcpBurst(), Equals, defaultCtcpBurst)
+	c.Check(server.GetCtcpPeriod(), Equals, defaultCtcpPeriod)
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 3)
+}
+
+func (s *s) TestConfig_Keepalive(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetKeepaliveInterval(), Equals, defaultKeepaliveInterval)
+	c.Check(server.GetKeepaliveTimeout(), Equals, defaultKeepaliveTimeout)
+
+	conf = CreateConfig().KeepaliveInterval(30).Server("irc.test.net").
+		KeepaliveTimeout(0)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetKeepaliveInterval(), Equals, uint(30))
+	c.Check(server.GetKeepaliveTimeout(), Equals, uint(0))
+
+	server.KeepaliveInterval = "often"
+	server.KeepaliveTimeout = "never"
+	c.Check(server.GetKeepaliveInterval(), Equals, defaultKeepaliveInterval)
+	c.Check(server.GetKeepaliveTimeout(), Equals, defaultKeepaliveTimeout)
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+}
+
+func (s *s) TestConfig_StateSaving(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetStateInterval(), Equals, defaultStateInterval)
+	c.Check(server.GetSeenExpiry(), Equals, defaultSeenExpiry)
+	c.Check(server.GetSeenLimit(), Equals, defaultSeenLimit)
+
+	conf = CreateConfig().StateInterval(60).SeenExpiry(7).
+		Server("irc.test.net").SeenLimit(0)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetStateInterval(), Equals, uint(60))
+	c.Check(server.GetSeenExpiry(), Equals, uint(7))
+	c.Check(server.GetSeenLimit(), Equals, uint(0))
+
+	server.StateInterval = "often"
+	server.SeenExpiry = "never"
+	server.SeenLimit = "-1"
+	c.Check(server.GetStateInterval(), Equals, defaultStateInterval)
+	c.Check(server.GetSeenExpiry(), Equals, defaultSeenExpiry)
+	c.Check(server.GetSeenLimit(), Equals, defaultSeenLimit)
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 3)
+}
+
+func (s *s) TestConfig_Hosts(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetHosts(), DeepEquals, []string{"irc.test.net:6667"})
+
+	conf = CreateConfig().Port(7000).Server("irc.test.net").
+		Hosts("irc2.test.net", "irc3.test.net:6697", "[::1]:6668")
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetHosts(), DeepEquals, []string{
+		"irc.test.net:7000",
+		"irc2.test.net:7000",
+		"irc3.test.net:6697",
+		"[::1]:6668",
+	})
+
+	server.Hosts = []string{"irc2.test.net:port", "in valid", "ok.net"}
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+}
+
+func (s *s) TestConfig_ReconnectBackoff(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetReconnectMaxTimeout(), Equals,
+		defaultReconnectMaxTimeout)
+	c.Check(server.GetReconnectAttempts(), Equals, uint(0))
+
+	conf = CreateConfig().ReconnectMaxTimeout(60).Server("irc.test.net").
+		ReconnectAttempts(5)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetReconnectMaxTimeout(), Equals, uint(60))
+	c.Check(server.GetReconnectAttempts(), Equals, uint(5))
+
+	server.ReconnectMaxTimeout = "forever"
+	server.ReconnectAttempts = "lots"
+	c.Check(server.GetReconnectMaxTimeout(), Equals,
+		defaultReconnectMaxTimeout)
+	c.Check(server.GetReconnectAttempts(), Equals, uint(0))
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 2)
+}
+
+func (s *s) TestConfig_FloodProtectModel(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetFloodProtectModel(), Equals, FLOOD_MESSAGES)
+	c.Check(server.GetFloodProtectLimit(), Equals, defaultFloodProtectLimit)
+	c.Check(server.GetFloodProtectCost(), Equals, defaultFloodProtectCost)
+	c.Check(server.GetFloodProtectBytes(), Equals, defaultFloodProtectBytes)
+
+	conf = CreateConfig().FloodProtectModel("Penalty").FloodProtectLimit(8).
+		Server("irc.test.net").FloodProtectCost(1.5).FloodProtectBytes(100)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetFloodProtectModel(), Equals, FLOOD_PENALTY)
+	c.Check(server.GetFloodProtectLimit(), Equals, float64(8))
+	c.Check(server.GetFloodProtectCost(), Equals, 1.5)
+	c.Check(server.GetFloodProtectBytes(), Equals, uint(100))
+
+	server.FloodProtectModel = "guesswork"
+	server.FloodProtectLimit = "high"
+	server.FloodProtectCost = "cheap"
+	server.FloodProtectBytes = "many"
+	c.Check(server.GetFloodProtectLimit(), Equals, defaultFloodProtectLimit)
+	c.Check(server.GetFloodProtectCost(), Equals, defaultFloodProtectCost)
+	c.Check(server.GetFloodProtectBytes(), Equals, defaultFloodProtectBytes)
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 4)
+}
+
+func (s *s) TestConfig_Output(c *C) {
+	conf := CreateConfig().Server("irc.test.net")
+	server := conf.GetServer("irc.test.net")
+	c.Check(server.GetOutputBacklog(), Equals, uint(0))
+	c.Check(server.GetOutputOverflow(), Equals, defaultOutputOverflow)
+	c.Check(server.GetOutputCoalesce(), Equals, false)
+
+	conf = CreateConfig().OutputBacklog(10).OutputOverflow("Oldest").
+		Server("irc.test.net").OutputCoalesce(true)
+	server = conf.GetServer("irc.test.net")
+	c.Check(server.GetOutputBacklog(), Equals, uint(10))
+	c.Check(server.GetOutputOverflow(), Equals, OVERFLOW_OLDEST)
+	c.Check(server.GetOutputCoalesce(), Equals, true)
+
+	conf.OutputBacklog(5).OutputOverflow(OVERFLOW_NEWEST)
+	c.Check(server.GetOutputBacklog(), Equals, uint(5))
+	c.Check(server.GetOutputOverflow(), Equals, OVERFLOW_NEWEST)
+
+	server.OutputBacklog = "lots"
+	server.OutputOverflow = "sideways"
+	server.OutputCoalesce = "maybe"
+	c.Check(server.GetOutputBacklog(), Equals, uint(0))
+	c.Check(server.GetOutputCoalesce(), Equals, false)
+	conf.Nick("nobody").Username("nobody").Userhost("host.com").
+		Realname("nobody").Host("irc.test.net")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 3)
+}
+
 func (s *s) TestValidChannels(c *C) {
 	// Check that the first letter must be {#+!&}
 	goodChannels := []string{"#ValidChannel", "+ValidChannel", "&ValidChannel",
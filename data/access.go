@@ -0,0 +1,102 @@
+package data
+
+import (
+	"bytes"
+	"strconv"
+)
+
+const (
+	// nFlagsPerCase is how many flag bits are reserved for a single case.
+	nFlagsPerCase = 26
+)
+
+// Access defines an access level and a set of flags. Flags are the letters
+// a-z and A-Z, anything else is ignored.
+type Access struct {
+	Level uint8
+	Flags uint64
+}
+
+// CreateAccess creates an access object with the given level and flags.
+func CreateAccess(level uint8, flags ...string) *Access {
+	a := &Access{Level: level}
+	a.SetFlags(flags...)
+	return a
+}
+
+// HasLevel checks that the level of this access is at least the given level.
+func (a *Access) HasLevel(level uint8) bool {
+	return a.Level >= level
+}
+
+// HasFlag checks if this access has the given flag.
+func (a *Access) HasFlag(flag rune) bool {
+	bit := getFlagBit(flag)
+	return bit != 0 && bit == a.Flags&bit
+}
+
+// HasFlags checks that this access has every flag given.
+func (a *Access) HasFlags(flags ...string) bool {
+	for _, str := range flags {
+		for _, f := range str {
+			if !a.HasFlag(f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetFlags sets all the flags given.
+func (a *Access) SetFlags(flags ...string) {
+	for _, str := range flags {
+		for _, f := range str {
+			a.Flags |= getFlagBit(f)
+		}
+	}
+}
+
+// ClearFlags clears all the flags given.
+func (a *Access) ClearFlags(flags ...string) {
+	for _, str := range flags {
+		for _, f := range str {
+			a.Flags &= ^getFlagBit(f)
+		}
+	}
+}
+
+// IsZero checks if this access grants nothing at all.
+func (a *Access) IsZero() bool {
+	return a.Level == 0 && a.Flags == 0
+}
+
+// GetFlags returns the flags of this access as a string.
+func (a *Access) GetFlags() string {
+	var buf bytes.Buffer
+	for _, c := range "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		if a.HasFlag(c) {
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// String returns a one-line representation of this access.
+func (a *Access) String() string {
+	str := strconv.Itoa(int(a.Level))
+	if flags := a.GetFlags(); len(flags) > 0 {
+		str += " " + flags
+	}
+	return str
+}
+
+// getFlagBit returns the bit for the given flag, 0 if it's not a valid flag.
+func getFlagBit(flag rune) uint64 {
+	switch {
+	case flag >= 'a' && flag <= 'z':
+		return 1 << uint(flag-'a')
+	case flag >= 'A' && flag <= 'Z':
+		return 1 << uint(flag-'A'+nFlagsPerCase)
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package data
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestAccess(c *C) {
+	a := CreateAccess(100, "ab", "Z")
+	c.Check(a.Level, Equals, uint8(100))
+	c.Check(a.HasLevel(50), Equals, true)
+	c.Check(a.HasLevel(100), Equals, true)
+	c.Check(a.HasLevel(101), Equals, false)
+	c.Check(a.HasFlag('a'), Equals, true)
+	c.Check(a.HasFlag('Z'), Equals, true)
+	c.Check(a.HasFlag('z'), Equals, false)
+	c.Check(a.HasFlag('!'), Equals, false)
+	c.Check(a.HasFlags("abZ"), Equals, true)
+	c.Check(a.HasFlags("ab", "c"), Equals, false)
+	c.Check(a.HasFlags(), Equals, true)
+	c.Check(a.IsZero(), Equals, false)
+}
+
+func (s *s) TestAccess_SetClearFlags(c *C) {
+	a := CreateAccess(0)
+	c.Check(a.IsZero(), Equals, true)
+	a.SetFlags("xyz!", "A")
+	c.Check(a.GetFlags(), Equals, "xyzA")
+	a.ClearFlags("yA")
+	c.Check(a.GetFlags(), Equals, "xz")
+	a.ClearFlags("xz")
+	c.Check(a.IsZero(), Equals, true)
+}
+
+func (s *s) TestAccess_String(c *C) {
+	c.Check(CreateAccess(0).String(), Equals, "0")
+	c.Check(CreateAccess(5, "ba").String(), Equals, "5 ab")
+}
@@ -12,8 +12,9 @@ const (
 
 // Channel encapsulates all the data associated with a channel.
 type Channel struct {
-	name  string
-	topic string
+	name    string
+	topic   string
+	casemap irc.Casemap
 	*ChannelModes
 }
 
@@ -51,7 +52,7 @@ func (c *Channel) IsBanned(mask irc.Mask) bool {
 	}
 	bans := c.GetAddresses(banMode)
 	for i := 0; i < len(bans); i++ {
-		if irc.WildMask(bans[i]).Match(mask) {
+		if irc.WildMask(bans[i]).MatchFold(mask, c.casemap) {
 			return true
 		}
 	}
@@ -111,7 +112,7 @@ func (c *Channel) DeleteBans(mask irc.Mask) {
 
 	toRemove := make([]string, 0, 1) // Assume only one ban will match.
 	for i := 0; i < len(bans); i++ {
-		if irc.WildMask(bans[i]).Match(mask) {
+		if irc.WildMask(bans[i]).MatchFold(mask, c.casemap) {
 			toRemove = append(toRemove, bans[i])
 		}
 	}
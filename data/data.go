@@ -29,6 +29,11 @@ type Store struct {
 	kinds     *ChannelModeKinds
 	umodes    *UserModeKinds
 	cfinder   *irc.ChannelFinder
+	casemap   irc.Casemap
+
+	seen     map[string]*Seen
+	restored restored
+	events   []StateEvent
 }
 
 // CreateStore creates a store from an irc protocaps instance.
@@ -46,6 +51,7 @@ func CreateStore(caps *irc.ProtoCaps) (*Store, error) {
 	store.users = make(map[string]*User)
 	store.channelUsers = make(map[string]map[string]*ChannelUser)
 	store.userChannels = make(map[string]map[string]*UserChannel)
+	store.seen = make(map[string]*Seen)
 
 	return store, nil
 }
@@ -70,24 +76,80 @@ func (s *Store) Protocaps(caps *irc.ProtoCaps) error {
 	s.kinds = kinds
 	s.umodes = modes
 	s.cfinder = cfinder
+	if casemap := caps.Casemap(); casemap != s.casemap {
+		s.casemap = casemap
+		s.refold()
+	}
 	return nil
 }
 
+// refold re-keys the database after the casemap has changed. Names that are
+// the same under the new casemap are merged.
+func (s *Store) refold() {
+	if s.users == nil {
+		return
+	}
+
+	users := make(map[string]*User, len(s.users))
+	for _, u := range s.users {
+		users[s.casemap.Fold(u.GetNick())] = u
+	}
+	channels := make(map[string]*Channel, len(s.channels))
+	for _, ch := range s.channels {
+		ch.casemap = s.casemap
+		channels[s.casemap.Fold(ch.GetName())] = ch
+	}
+	channelUsers := make(map[string]map[string]*ChannelUser,
+		len(s.channelUsers))
+	for key, cus := range s.channelUsers {
+		if ch, ok := s.channels[key]; ok {
+			folded := make(map[string]*ChannelUser, len(cus))
+			for _, cu := range cus {
+				folded[s.casemap.Fold(cu.User.GetNick())] = cu
+			}
+			channelUsers[s.casemap.Fold(ch.GetName())] = folded
+		}
+	}
+	userChannels := make(map[string]map[string]*UserChannel,
+		len(s.userChannels))
+	for key, ucs := range s.userChannels {
+		if u, ok := s.users[key]; ok {
+			folded := make(map[string]*UserChannel, len(ucs))
+			for _, uc := range ucs {
+				folded[s.casemap.Fold(uc.Channel.GetName())] = uc
+			}
+			userChannels[s.casemap.Fold(u.GetNick())] = folded
+		}
+	}
+
+	seen := make(map[string]*Seen, len(s.seen))
+	for _, last := range s.seen {
+		seen[s.casemap.Fold(last.Nick)] = last
+	}
+
+	s.users = users
+	s.channels = channels
+	s.seen = seen
+	s.restored = s.restored.refold(s.casemap)
+	s.channelUsers = channelUsers
+	s.userChannels = userChannels
+}
+
 // GetUser returns the user if he exists.
 func (s *Store) GetUser(nickorhost string) *User {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	return s.users[nick]
 }
 
 // GetChannel returns the channel if it exists.
 func (s *Store) GetChannel(channel string) *Channel {
-	return s.channels[strings.ToLower(channel)]
+	return s.channels[s.casemap.Fold(channel)]
 }
 
 // GetUserByChannel fetches a user based
 func (s *Store) GetUsersChannelModes(nickorhost, channel string) *UserModes {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
-	channel = strings.ToLower(channel)
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
+	channel = s.casemap.Fold(channel)
 
 	if nicks, ok := s.channelUsers[channel]; ok {
 		if cu, ok := nicks[nick]; ok {
@@ -110,7 +172,7 @@ func (s *Store) GetNChannels() int {
 
 // GetNUserChans returns the number of channels for a user in the database.
 func (s *Store) GetNUserChans(nickorhost string) (n int) {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	if ucs, ok := s.userChannels[nick]; ok {
 		n = len(ucs)
 	}
@@ -119,7 +181,7 @@ func (s *Store) GetNUserChans(nickorhost string) (n int) {
 
 // GetNChanUsers returns the number of users for a channel in the database.
 func (s *Store) GetNChanUsers(channel string) (n int) {
-	channel = strings.ToLower(channel)
+	channel = s.casemap.Fold(channel)
 	if cus, ok := s.channelUsers[channel]; ok {
 		n = len(cus)
 	}
@@ -142,7 +204,7 @@ func (s *Store) EachChannel(fn func(*Channel)) {
 
 // EachUserChan iterates through the channels a user is on.
 func (s *Store) EachUserChan(nickorhost string, fn func(*UserChannel)) {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	if ucs, ok := s.userChannels[nick]; ok {
 		for _, uc := range ucs {
 			fn(uc)
@@ -153,7 +215,7 @@ func (s *Store) EachUserChan(nickorhost string, fn func(*UserChannel)) {
 
 // EachChanUser iterates through the users on a channel.
 func (s *Store) EachChanUser(channel string, fn func(*ChannelUser)) {
-	channel = strings.ToLower(channel)
+	channel = s.casemap.Fold(channel)
 	if cus, ok := s.channelUsers[channel]; ok {
 		for _, cu := range cus {
 			fn(cu)
@@ -182,7 +244,7 @@ func (s *Store) GetChannels() []string {
 
 // GetUserChans returns a string array of the channels a user is on.
 func (s *Store) GetUserChans(nickorhost string) []string {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	if ucs, ok := s.userChannels[nick]; ok {
 		ret := make([]string, 0, len(ucs))
 		for _, uc := range ucs {
@@ -195,7 +257,7 @@ func (s *Store) GetUserChans(nickorhost string) []string {
 
 // GetChanUsers returns a string array of the users on a channel.
 func (s *Store) GetChanUsers(channel string) []string {
-	channel = strings.ToLower(channel)
+	channel = s.casemap.Fold(channel)
 	if cus, ok := s.channelUsers[channel]; ok {
 		ret := make([]string, 0, len(cus))
 		for _, cu := range cus {
@@ -208,8 +270,8 @@ func (s *Store) GetChanUsers(channel string) []string {
 
 // IsOn checks if a user is on a specific channel.
 func (s *Store) IsOn(nickorhost, channel string) bool {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
-	channel = strings.ToLower(channel)
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
+	channel = s.casemap.Fold(channel)
 
 	if chans, ok := s.userChannels[nick]; ok {
 		if _, ok = chans[channel]; ok {
@@ -237,7 +299,7 @@ func (s *Store) addUser(nickorhost string) *User {
 		return nil
 	}
 
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	var user *User
 	var ok bool
 	if user, ok = s.users[nick]; ok {
@@ -247,13 +309,14 @@ func (s *Store) addUser(nickorhost string) *User {
 	} else {
 		user = CreateUser(nickorhost)
 		s.users[nick] = user
+		delete(s.restored.users, nick)
 	}
 	return user
 }
 
 // removeUser deletes a user from the database.
 func (s *Store) removeUser(nickorhost string) {
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
 	for _, cus := range s.channelUsers {
 		delete(cus, nick)
 	}
@@ -262,20 +325,25 @@ func (s *Store) removeUser(nickorhost string) {
 	delete(s.users, nick)
 }
 
-// addChannel adds a channel to the database.
+// addChannel adds a channel to the database. A new channel always starts
+// empty, what was restored for it from a snapshot is dropped.
 func (s *Store) addChannel(channel string) *Channel {
-	chankey := strings.ToLower(channel)
-	var ch *Channel
-	if ch, ok := s.channels[chankey]; !ok {
-		ch = CreateChannel(channel, s.kinds)
+	chankey := s.casemap.Fold(channel)
+	ch, ok := s.channels[chankey]
+	if !ok {
+		if ch = CreateChannel(channel, s.kinds); ch == nil {
+			return nil
+		}
+		ch.casemap = s.casemap
 		s.channels[chankey] = ch
+		s.restored.dropChannel(chankey)
 	}
 	return ch
 }
 
 // removeChannel deletes a channel from the database.
 func (s *Store) removeChannel(channel string) {
-	channel = strings.ToLower(channel)
+	channel = s.casemap.Fold(channel)
 	for _, cus := range s.userChannels {
 		delete(cus, channel)
 	}
@@ -284,23 +352,24 @@ func (s *Store) removeChannel(channel string) {
 	delete(s.channels, channel)
 }
 
-// addToChannel adds a user by nick or fullhost to the channel
-func (s *Store) addToChannel(nickorhost, channel string) {
+// addToChannel adds a user by nick or fullhost to the channel, returns true if
+// the user was added.
+func (s *Store) addToChannel(nickorhost, channel string) bool {
 	var user *User
 	var ch *Channel
 	var cu map[string]*ChannelUser
 	var uc map[string]*UserChannel
 	var ok, cuhas, uchas bool
 
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
-	channel = strings.ToLower(channel)
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
+	channel = s.casemap.Fold(channel)
 
 	if user, ok = s.users[nick]; !ok {
-		return
+		return false
 	}
 
 	if ch, ok = s.channels[channel]; !ok {
-		return
+		return false
 	}
 
 	if cu, ok = s.channelUsers[channel]; !ok {
@@ -316,7 +385,7 @@ func (s *Store) addToChannel(nickorhost, channel string) {
 	}
 
 	if cuhas || uchas {
-		return
+		return false
 	}
 
 	modes := CreateUserModes(s.umodes)
@@ -324,29 +393,36 @@ func (s *Store) addToChannel(nickorhost, channel string) {
 	uc[channel] = CreateUserChannel(ch, modes)
 	s.channelUsers[channel] = cu
 	s.userChannels[nick] = uc
+	return true
 }
 
-// removeFromChannel removes a user by nick or fullhost from the channel
-func (s *Store) removeFromChannel(nickorhost, channel string) {
+// removeFromChannel removes a user by nick or fullhost from the channel,
+// returns true if the user was on the channel.
+func (s *Store) removeFromChannel(nickorhost, channel string) bool {
 	var cu map[string]*ChannelUser
 	var uc map[string]*UserChannel
-	var ok bool
+	var ok, removed bool
 
-	nick := strings.ToLower(irc.Mask(nickorhost).GetNick())
-	channel = strings.ToLower(channel)
+	nick := s.casemap.Fold(irc.Mask(nickorhost).GetNick())
+	channel = s.casemap.Fold(channel)
 
 	if cu, ok = s.channelUsers[channel]; ok {
+		_, removed = cu[nick]
 		delete(cu, nick)
 	}
 
 	if uc, ok = s.userChannels[nick]; ok {
 		delete(uc, channel)
 	}
+	return removed
 }
 
-// Update uses the irc.IrcMessage to modify the database accordingly.
-func (s *Store) Update(m *irc.IrcMessage) {
+// Update uses the irc.IrcMessage to modify the database accordingly. The
+// changes it made are returned as events.
+func (s *Store) Update(m *irc.IrcMessage) []StateEvent {
+	s.events = nil
 	s.addUser(m.Sender)
+	s.seenUpdate(m)
 	switch m.Name {
 	case irc.NICK:
 		s.nick(m)
@@ -376,97 +452,249 @@ func (s *Store) Update(m *irc.IrcMessage) {
 		s.rpl_channelmodeis(m)
 	case irc.RPL_BANLIST:
 		s.rpl_banlist(m)
-
-		// TODO: Handle Whois
-	}
+	case irc.RPL_WHOISUSER:
+		s.rpl_whoisuser(m)
+	case irc.RPL_WHOISSERVER:
+		s.rpl_whoisserver(m)
+	case irc.RPL_WHOISIDLE:
+		s.rpl_whoisidle(m)
+	case irc.RPL_WHOISCHANNELS:
+		s.rpl_whoischannels(m)
+	case irc.RPL_WHOISACCOUNT:
+		s.rpl_whoisaccount(m)
+	case irc.RPL_AWAY:
+		s.rpl_away(m)
+	case irc.RPL_UNAWAY, irc.RPL_NOWAWAY:
+		s.rpl_selfaway(m)
+	case irc.ACCOUNT:
+		s.account(m)
+	case irc.AWAY:
+		s.away(m)
+	case irc.CHGHOST:
+		s.chghost(m)
+	}
+
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// event records a change made by the current Update.
+func (s *Store) event(ev StateEvent) {
+	s.events = append(s.events, ev)
 }
 
 // nick alters the state of the database when a NICK message is received.
 func (s *Store) nick(m *irc.IrcMessage) {
-	nick, username, host := irc.Mask(m.Sender).SplitFullhost()
-	newnick := m.Args[0]
-	newuser := irc.Mask(newnick + "!" + username + "@" + host)
+	oldnick, username, host := irc.Mask(m.Sender).SplitFullhost()
+	newuser := irc.Mask(m.Args[0] + "!" + username + "@" + host)
 
-	nick = strings.ToLower(nick)
-	newnick = strings.ToLower(newnick)
+	nick := s.casemap.Fold(oldnick)
+	newnick := s.casemap.Fold(m.Args[0])
 
-	var ok bool
-	if _, ok = s.users[nick]; !ok {
+	user, ok := s.users[nick]
+	if !ok {
 		s.addUser(string(newuser))
+		return
+	}
+
+	if len(username) > 0 && len(host) > 0 {
+		user.mask = newuser
 	} else {
-		newnicklow := strings.ToLower(newnick)
-		s.userChannels[newnicklow] = s.userChannels[nick]
+		user.mask = irc.Mask(m.Args[0])
+	}
+	delete(s.users, nick)
+	s.users[newnick] = user
+
+	if ucs, ok := s.userChannels[nick]; ok {
 		delete(s.userChannels, nick)
-		s.users[newnicklow] = s.users[nick]
-		delete(s.users, nick)
+		s.userChannels[newnick] = ucs
+		for channel := range ucs {
+			if cu, ok := s.channelUsers[channel][nick]; ok {
+				delete(s.channelUsers[channel], nick)
+				s.channelUsers[channel][newnick] = cu
+			}
+		}
 	}
+
+	s.event(&UserRename{
+		Old: oldnick, New: m.Args[0], Fullhost: user.GetFullhost(),
+	})
 }
 
 // join alters the state of the database when a JOIN message is received.
+// With extended-join the account and realname of the user follow the channel.
 func (s *Store) join(m *irc.IrcMessage) {
 	if m.Sender == s.Self.GetFullhost() {
 		s.addChannel(m.Args[0])
 	}
-	s.addToChannel(m.Sender, m.Args[0])
+	if s.addToChannel(m.Sender, m.Args[0]) {
+		s.event(&UserJoin{
+			Channel:  m.Args[0],
+			Nick:     irc.Mask(m.Sender).GetNick(),
+			Fullhost: m.Sender,
+		})
+	}
+
+	if len(m.Args) > 2 {
+		if user := s.GetUser(m.Sender); user != nil {
+			user.setAccount(m.Args[1])
+			user.Realname(m.Args[2])
+		}
+	}
 }
 
 // part alters the state of the database when a PART message is received.
 func (s *Store) part(m *irc.IrcMessage) {
+	left := false
 	if m.Sender == s.Self.GetFullhost() {
+		left = s.IsOn(m.Sender, m.Args[0])
 		s.removeChannel(m.Args[0])
 	} else {
-		s.removeFromChannel(m.Sender, m.Args[0])
+		left = s.removeFromChannel(m.Sender, m.Args[0])
+	}
+
+	if left {
+		ev := &UserLeave{
+			Channel:  m.Args[0],
+			Nick:     irc.Mask(m.Sender).GetNick(),
+			Fullhost: m.Sender,
+			Event:    irc.PART,
+		}
+		if len(m.Args) > 1 {
+			ev.Reason = m.Args[1]
+		}
+		s.event(ev)
 	}
 }
 
 // quit alters the state of the database when a QUIT message is received.
 func (s *Store) quit(m *irc.IrcMessage) {
-	if m.Sender != s.Self.GetFullhost() {
-		s.removeUser(m.Sender)
+	if m.Sender == s.Self.GetFullhost() {
+		return
+	}
+
+	reason := ""
+	if len(m.Args) > 0 {
+		reason = m.Args[0]
 	}
+	nick := irc.Mask(m.Sender).GetNick()
+	s.EachUserChan(m.Sender, func(uc *UserChannel) {
+		s.event(&UserLeave{
+			Channel:  uc.Channel.GetName(),
+			Nick:     nick,
+			Fullhost: m.Sender,
+			Event:    irc.QUIT,
+			Reason:   reason,
+		})
+	})
+	s.removeUser(m.Sender)
 }
 
 // kick alters the state of the database when a KICK message is received.
 func (s *Store) kick(m *irc.IrcMessage) {
-	if m.Args[1] == s.Self.GetNick() {
+	fullhost := m.Args[1]
+	if user := s.GetUser(m.Args[1]); user != nil {
+		fullhost = user.GetFullhost()
+	}
+
+	left := false
+	if s.casemap.Equals(m.Args[1], s.Self.GetNick()) {
+		left = s.IsOn(m.Args[1], m.Args[0])
 		s.removeChannel(m.Args[0])
 	} else {
-		s.removeFromChannel(m.Args[1], m.Args[0])
+		left = s.removeFromChannel(m.Args[1], m.Args[0])
+	}
+
+	if left {
+		ev := &UserLeave{
+			Channel:  m.Args[0],
+			Nick:     irc.Mask(fullhost).GetNick(),
+			Fullhost: fullhost,
+			Event:    irc.KICK,
+			By:       m.Sender,
+		}
+		if len(m.Args) > 2 {
+			ev.Reason = m.Args[2]
+		}
+		s.event(ev)
 	}
 }
 
 // mode alters the state of the database when a MODE message is received.
 func (s *Store) mode(m *irc.IrcMessage) {
-	target := strings.ToLower(m.Args[0])
+	target := s.casemap.Fold(m.Args[0])
 	if s.cfinder.IsChannel(target) {
 		if ch, ok := s.channels[target]; ok {
-			pos, neg := ch.Apply(strings.Join(m.Args[1:], " "))
-			for i := 0; i < len(pos); i++ {
-				nick := strings.ToLower(pos[i].Arg)
-				s.channelUsers[target][nick].SetMode(pos[i].Mode)
+			s.channelMode(ch, target, m)
+		}
+	} else if target == s.casemap.Fold(s.Self.GetNick()) {
+		s.Self.Apply(m.Args[1])
+	}
+}
+
+// channelMode applies a MODE message to a channel and the users on it.
+func (s *Store) channelMode(ch *Channel, key string, m *irc.IrcMessage) {
+	rec := &modeRecorder{ChannelModes: ch.ChannelModes}
+	pos, neg := apply(rec, strings.Join(m.Args[1:], " "))
+
+	for _, change := range rec.changes {
+		if change.Mode == banMode {
+			s.event(&Ban{
+				Channel: ch.GetName(),
+				Mask:    change.Arg,
+				Set:     change.Set,
+				By:      m.Sender,
+			})
+		} else {
+			ev := change
+			ev.Channel, ev.By = ch.GetName(), m.Sender
+			s.event(&ev)
+		}
+	}
+
+	for i, modes := range [][]UnknownMode{pos, neg} {
+		set := i == 0
+		for _, mode := range modes {
+			cu, ok := s.channelUsers[key][s.casemap.Fold(mode.Arg)]
+			if !ok {
+				continue
 			}
-			for i := 0; i < len(neg); i++ {
-				nick := strings.ToLower(neg[i].Arg)
-				s.channelUsers[target][nick].UnsetMode(neg[i].Mode)
+			if set {
+				cu.SetMode(mode.Mode)
+			} else {
+				cu.UnsetMode(mode.Mode)
 			}
+			s.event(&UserMode{
+				Channel: ch.GetName(),
+				Nick:    mode.Arg,
+				Mode:    mode.Mode,
+				Set:     set,
+				By:      m.Sender,
+			})
 		}
-	} else if target == s.Self.GetNick() {
-		s.Self.Apply(m.Args[1])
 	}
 }
 
 // topic alters the state of the database when a TOPIC message is received.
 func (s *Store) topic(m *irc.IrcMessage) {
-	chname := strings.ToLower(m.Args[0])
+	chname := s.casemap.Fold(m.Args[0])
 	if ch, ok := s.channels[chname]; ok {
+		old := ch.GetTopic()
 		ch.Topic(m.Args[1])
+		s.event(&Topic{
+			Channel: ch.GetName(),
+			Topic:   m.Args[1],
+			Old:     old,
+			By:      m.Sender,
+		})
 	}
 }
 
 // rpl_topic alters the state of the database when a RPL_TOPIC message is
 // received.
 func (s *Store) rpl_topic(m *irc.IrcMessage) {
-	chname := strings.ToLower(m.Args[1])
+	chname := s.casemap.Fold(m.Args[1])
 	if ch, ok := s.channels[chname]; ok {
 		ch.Topic(m.Args[2])
 	}
@@ -491,7 +719,7 @@ func (s *Store) rpl_welcome(m *irc.IrcMessage) {
 	}
 	user := CreateUser(host)
 	s.Self.User = user
-	s.users[user.GetNick()] = user
+	s.users[s.casemap.Fold(user.GetNick())] = user
 }
 
 // rpl_namereply alters the state of the database when a RPL_NAMEREPLY
@@ -530,7 +758,14 @@ func (s *Store) rpl_whoreply(m *irc.IrcMessage) {
 
 	s.addUser(fullhost)
 	s.addToChannel(fullhost, channel)
-	s.GetUser(fullhost).Realname(realname)
+	user := s.GetUser(fullhost)
+	user.Realname(realname)
+	user.server = m.Args[4]
+	if len(modes) > 0 && modes[0] == 'G' {
+		user.isAway = true
+	} else if len(modes) > 0 && modes[0] == 'H' {
+		user.setAway("")
+	}
 	for _, modechar := range modes {
 		if mode := s.umodes.GetMode(modechar); mode != 0 {
 			s.GetUsersChannelModes(fullhost, channel).SetMode(mode)
@@ -552,3 +787,136 @@ func (s *Store) rpl_banlist(m *irc.IrcMessage) {
 	channel := m.Args[1]
 	s.GetChannel(channel).AddBan(m.Args[2])
 }
+
+// rpl_whoisuser alters the state of the database when a RPL_WHOISUSER message
+// is received.
+func (s *Store) rpl_whoisuser(m *irc.IrcMessage) {
+	if len(m.Args) < 6 {
+		return
+	}
+	fullhost := m.Args[1] + "!" + m.Args[2] + "@" + m.Args[3]
+	if user := s.addUser(fullhost); user != nil {
+		user.Realname(m.Args[5])
+	}
+}
+
+// rpl_whoisserver alters the state of the database when a RPL_WHOISSERVER
+// message is received.
+func (s *Store) rpl_whoisserver(m *irc.IrcMessage) {
+	if len(m.Args) < 3 {
+		return
+	}
+	if user := s.GetUser(m.Args[1]); user != nil {
+		user.server = m.Args[2]
+	}
+}
+
+// rpl_whoisidle alters the state of the database when a RPL_WHOISIDLE message
+// is received.
+func (s *Store) rpl_whoisidle(m *irc.IrcMessage) {
+	if len(m.Args) < 3 {
+		return
+	}
+	if user := s.GetUser(m.Args[1]); user != nil {
+		idle, signon := parseIdle(m.Args)
+		user.idle = idle
+		if !signon.IsZero() {
+			user.signon = signon
+		}
+	}
+}
+
+// rpl_whoischannels alters the state of the database when a
+// RPL_WHOISCHANNELS message is received. Only channels the bot is on are
+// affected.
+func (s *Store) rpl_whoischannels(m *irc.IrcMessage) {
+	if len(m.Args) < 3 {
+		return
+	}
+	nick := m.Args[1]
+	if s.GetUser(nick) == nil {
+		return
+	}
+
+	for _, channel := range strings.Fields(m.Args[2]) {
+		var modes []rune
+		for len(channel) > 1 && s.cfinder.IsChannel(channel[1:]) {
+			mode := s.umodes.GetMode(rune(channel[0]))
+			if mode == 0 {
+				break
+			}
+			modes = append(modes, mode)
+			channel = channel[1:]
+		}
+
+		if s.GetChannel(channel) == nil {
+			continue
+		}
+		s.addToChannel(nick, channel)
+		for _, mode := range modes {
+			s.GetUsersChannelModes(nick, channel).SetMode(mode)
+		}
+	}
+}
+
+// rpl_whoisaccount alters the state of the database when a RPL_WHOISACCOUNT
+// message is received.
+func (s *Store) rpl_whoisaccount(m *irc.IrcMessage) {
+	if len(m.Args) < 3 {
+		return
+	}
+	if user := s.GetUser(m.Args[1]); user != nil {
+		user.setAccount(m.Args[2])
+	}
+}
+
+// rpl_away alters the state of the database when a RPL_AWAY message is
+// received.
+func (s *Store) rpl_away(m *irc.IrcMessage) {
+	if len(m.Args) < 3 {
+		return
+	}
+	if user := s.GetUser(m.Args[1]); user != nil {
+		user.setAway(m.Args[2])
+	}
+}
+
+// rpl_selfaway alters the state of the database when a RPL_UNAWAY or
+// RPL_NOWAWAY message is received.
+func (s *Store) rpl_selfaway(m *irc.IrcMessage) {
+	if s.Self.User == nil {
+		return
+	}
+	if m.Name == irc.RPL_NOWAWAY {
+		s.Self.isAway = true
+	} else {
+		s.Self.setAway("")
+	}
+}
+
+// account alters the state of the database when an ACCOUNT message is
+// received.
+func (s *Store) account(m *irc.IrcMessage) {
+	if user := s.GetUser(m.Sender); user != nil && len(m.Args) > 0 {
+		user.setAccount(m.Args[0])
+	}
+}
+
+// away alters the state of the database when an AWAY message is received.
+func (s *Store) away(m *irc.IrcMessage) {
+	if user := s.GetUser(m.Sender); user != nil {
+		message := ""
+		if len(m.Args) > 0 {
+			message = m.Args[0]
+		}
+		user.setAway(message)
+	}
+}
+
+// chghost alters the state of the database when a CHGHOST message is
+// received.
+func (s *Store) chghost(m *irc.IrcMessage) {
+	if user := s.GetUser(m.Sender); user != nil && len(m.Args) > 1 {
+		user.mask = irc.Mask(user.GetNick() + "!" + m.Args[0] + "@" + m.Args[1])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/aarondl/ultimateq/irc"
 	. "launchpad.net/gocheck"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) } //Hook into testing package
@@ -77,6 +78,34 @@ func (s *s) TestStore_UpdateProtoCaps(c *C) {
 	c.Assert(st.cfinder.IsChannel("!"), Equals, true)
 }
 
+func (s *s) TestStore_Casemap(c *C) {
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{Args: []string{
+		"NICK", "CASEMAPPING=rfc1459",
+	}})
+	st, err := CreateStore(caps)
+	c.Assert(err, IsNil)
+
+	st.addUser("Foo[away]!user@host")
+	st.addChannel("#Chan[1]")
+	st.addToChannel("Foo[away]", "#Chan[1]")
+	st.GetChannel("#Chan[1]").AddBan("*[away]!*@*")
+
+	c.Check(st.GetUser("foo{AWAY}"), NotNil)
+	c.Check(st.GetChannel("#chan{1}"), NotNil)
+	c.Check(st.IsOn("FOO{away}", "#CHAN{1}"), Equals, true)
+	c.Check(st.GetChannel("#chan{1}").IsBanned("x{AWAY}!u@h"), Equals, true)
+
+	caps = irc.CreateProtoCaps()
+	c.Check(st.Protocaps(caps), IsNil)
+	c.Check(st.GetUser("foo{AWAY}"), IsNil)
+	c.Check(st.GetUser("FOO[away]"), NotNil)
+	c.Check(st.GetChannel("#chan{1}"), IsNil)
+	c.Check(st.IsOn("FOO[away]", "#CHAN[1]"), Equals, true)
+	c.Check(st.GetChannel("#chan[1]").IsBanned("x{AWAY}!u@h"), Equals, false)
+	c.Check(st.GetChannel("#chan[1]").IsBanned("x[AWAY]!u@h"), Equals, true)
+}
+
 func (s *s) TestStore_GetUser(c *C) {
 	st, err := CreateStore(irc.CreateProtoCaps())
 	c.Check(err, IsNil)
@@ -792,3 +821,131 @@ func (s *s) TestStore_UpdateRplBanlist(c *C) {
 	st.Update(m)
 	c.Check(st.GetChannel(channels[0]).HasBan(nicks[0]+"!*@*"), Equals, true)
 }
+
+func (s *s) TestStore_UpdateWhois(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+
+	whois := []*irc.IrcMessage{
+		{Name: irc.RPL_WHOISUSER, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "user1", "host1", "*", "real name"}},
+		{Name: irc.RPL_WHOISSERVER, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "hub.server.net", "The hub"}},
+		{Name: irc.RPL_WHOISIDLE, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "90", "1370000000",
+			"seconds idle, signon time"}},
+		{Name: irc.RPL_WHOISCHANNELS, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "@+" + channels[0] + " " + channels[1]}},
+		{Name: irc.RPL_WHOISACCOUNT, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "acct", "is logged in as"}},
+		{Name: irc.RPL_AWAY, Sender: server, Args: []string{
+			self.GetNick(), nicks[0], "gone fishing"}},
+	}
+	for _, m := range whois {
+		st.Update(m)
+	}
+
+	user := st.GetUser(nicks[0])
+	c.Assert(user, NotNil)
+	c.Check(user.GetFullhost(), Equals, users[0])
+	c.Check(user.GetRealname(), Equals, "real name")
+	c.Check(user.GetServer(), Equals, "hub.server.net")
+	c.Check(user.GetIdle(), Equals, 90*time.Second)
+	c.Check(user.GetSignon().Unix(), Equals, int64(1370000000))
+	c.Check(user.GetAccount(), Equals, "acct")
+	c.Check(user.IsAway(), Equals, true)
+	c.Check(user.GetAwayMessage(), Equals, "gone fishing")
+
+	c.Check(st.IsOn(nicks[0], channels[0]), Equals, true)
+	c.Check(st.IsOn(nicks[0], channels[1]), Equals, false)
+	modes := st.GetUsersChannelModes(nicks[0], channels[0])
+	c.Check(modes.HasMode('o'), Equals, true)
+	c.Check(modes.HasMode('v'), Equals, true)
+
+	// Replies about unknown users are ignored apart from RPL_WHOISUSER.
+	st.Update(&irc.IrcMessage{Name: irc.RPL_AWAY, Sender: server,
+		Args: []string{self.GetNick(), "unknown", "away"}})
+	c.Check(st.GetUser("unknown"), IsNil)
+
+	// Replies that are too short are ignored.
+	for _, name := range []string{irc.RPL_WHOISUSER, irc.RPL_WHOISSERVER,
+		irc.RPL_WHOISIDLE, irc.RPL_WHOISCHANNELS, irc.RPL_WHOISACCOUNT,
+		irc.RPL_AWAY} {
+
+		st.Update(&irc.IrcMessage{Name: name, Sender: server,
+			Args: []string{self.GetNick(), nicks[0]}})
+		st.Update(&irc.IrcMessage{Name: name, Sender: server})
+	}
+	c.Check(st.GetUser(nicks[0]).GetAccount(), Equals, "acct")
+}
+
+func (s *s) TestStore_UpdateAway(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = Self{User: CreateUser("me!my@host.com")}
+
+	st.Update(&irc.IrcMessage{Name: irc.AWAY, Sender: users[0],
+		Args: []string{"brb"}})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, true)
+	c.Check(st.GetUser(users[0]).GetAwayMessage(), Equals, "brb")
+	st.Update(&irc.IrcMessage{Name: irc.AWAY, Sender: users[0]})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, false)
+	c.Check(st.GetUser(users[0]).GetAwayMessage(), Equals, "")
+
+	st.Update(&irc.IrcMessage{Name: irc.RPL_NOWAWAY, Sender: server,
+		Args: []string{"me", "You have been marked as being away"}})
+	c.Check(st.Self.IsAway(), Equals, true)
+	st.Update(&irc.IrcMessage{Name: irc.RPL_UNAWAY, Sender: server,
+		Args: []string{"me", "You are no longer marked as being away"}})
+	c.Check(st.Self.IsAway(), Equals, false)
+
+	// Who replies carry here and gone flags.
+	st.addChannel(channels[0])
+	st.Update(&irc.IrcMessage{Name: irc.RPL_WHOREPLY, Sender: server,
+		Args: []string{"me", channels[0], "user1", "host1", "*.server.net",
+			nicks[0], "G", "0 real name"}})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, true)
+	c.Check(st.GetUser(users[0]).GetServer(), Equals, "*.server.net")
+	st.Update(&irc.IrcMessage{Name: irc.RPL_WHOREPLY, Sender: server,
+		Args: []string{"me", channels[0], "user1", "host1", "*.server.net",
+			nicks[0], "H", "0 real name"}})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, false)
+}
+
+func (s *s) TestStore_UpdateAccount(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+
+	st.Update(&irc.IrcMessage{Name: irc.ACCOUNT, Sender: users[0],
+		Args: []string{"acct"}})
+	c.Check(st.GetUser(users[0]).GetAccount(), Equals, "acct")
+	st.Update(&irc.IrcMessage{Name: irc.ACCOUNT, Sender: users[0],
+		Args: []string{"*"}})
+	c.Check(st.GetUser(users[0]).GetAccount(), Equals, "")
+
+	// Extended join.
+	st.addChannel(channels[0])
+	st.Update(&irc.IrcMessage{Name: irc.JOIN, Sender: users[1],
+		Args: []string{channels[0], "other", "Real Name"}})
+	c.Check(st.IsOn(users[1], channels[0]), Equals, true)
+	c.Check(st.GetUser(users[1]).GetAccount(), Equals, "other")
+	c.Check(st.GetUser(users[1]).GetRealname(), Equals, "Real Name")
+}
+
+func (s *s) TestStore_UpdateChghost(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+	st.Update(&irc.IrcMessage{Name: irc.JOIN, Sender: users[0],
+		Args: []string{channels[0]}})
+
+	st.Update(&irc.IrcMessage{Name: irc.CHGHOST, Sender: users[0],
+		Args: []string{"newuser", "new.host"}})
+	c.Check(st.GetUser(nicks[0]).GetFullhost(), Equals,
+		nicks[0]+"!newuser@new.host")
+	c.Check(st.IsOn(nicks[0], channels[0]), Equals, true)
+}
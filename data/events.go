@@ -0,0 +1,151 @@
+package data
+
+const (
+	// STATE is the event name to register for every state change.
+	STATE = "STATE"
+	// STATE_USERJOIN is the event name of UserJoin.
+	STATE_USERJOIN = "STATE_USERJOIN"
+	// STATE_USERLEAVE is the event name of UserLeave.
+	STATE_USERLEAVE = "STATE_USERLEAVE"
+	// STATE_USERRENAME is the event name of UserRename.
+	STATE_USERRENAME = "STATE_USERRENAME"
+	// STATE_USERMODE is the event name of UserMode.
+	STATE_USERMODE = "STATE_USERMODE"
+	// STATE_CHANNELMODE is the event name of ChannelMode.
+	STATE_CHANNELMODE = "STATE_CHANNELMODE"
+	// STATE_BAN is the event name of Ban.
+	STATE_BAN = "STATE_BAN"
+	// STATE_TOPIC is the event name of Topic.
+	STATE_TOPIC = "STATE_TOPIC"
+)
+
+// StateEvent is a change the Store made because of a message. The events are
+// copies of what changed and are safe to keep after the store changes again.
+type StateEvent interface {
+	// StateName returns the event name the change is dispatched as.
+	StateName() string
+}
+
+// UserJoin is a user joining a channel.
+type UserJoin struct {
+	Channel  string
+	Nick     string
+	Fullhost string
+}
+
+// UserLeave is a user leaving a channel. Event is the irc event that made
+// them leave, PART, KICK or QUIT, a QUIT makes a UserLeave for each of the
+// channels the user was on. By is who kicked them.
+type UserLeave struct {
+	Channel  string
+	Nick     string
+	Fullhost string
+	Event    string
+	Reason   string
+	By       string
+}
+
+// UserRename is a user changing their nick.
+type UserRename struct {
+	Old      string
+	New      string
+	Fullhost string
+}
+
+// UserMode is a user being given or losing a mode on a channel, like +o.
+type UserMode struct {
+	Channel string
+	Nick    string
+	Mode    rune
+	Set     bool
+	By      string
+}
+
+// ChannelMode is a channel mode being set or unset, Arg is empty if the mode
+// doesn't take one. Bans are given as Ban instead.
+type ChannelMode struct {
+	Channel string
+	Mode    rune
+	Arg     string
+	Set     bool
+	By      string
+}
+
+// Ban is a ban being added to or removed from a channel.
+type Ban struct {
+	Channel string
+	Mask    string
+	Set     bool
+	By      string
+}
+
+// Topic is a channel's topic being changed.
+type Topic struct {
+	Channel string
+	Topic   string
+	Old     string
+	By      string
+}
+
+// StateName returns STATE_USERJOIN.
+func (e *UserJoin) StateName() string { return STATE_USERJOIN }
+
+// StateName returns STATE_USERLEAVE.
+func (e *UserLeave) StateName() string { return STATE_USERLEAVE }
+
+// StateName returns STATE_USERRENAME.
+func (e *UserRename) StateName() string { return STATE_USERRENAME }
+
+// StateName returns STATE_USERMODE.
+func (e *UserMode) StateName() string { return STATE_USERMODE }
+
+// StateName returns STATE_CHANNELMODE.
+func (e *ChannelMode) StateName() string { return STATE_CHANNELMODE }
+
+// StateName returns STATE_BAN.
+func (e *Ban) StateName() string { return STATE_BAN }
+
+// StateName returns STATE_TOPIC.
+func (e *Topic) StateName() string { return STATE_TOPIC }
+
+// modeRecorder applies modes to a channel and records each change so it can
+// be turned into events.
+type modeRecorder struct {
+	*ChannelModes
+	changes []ChannelMode
+}
+
+// record adds a change.
+func (r *modeRecorder) record(mode rune, arg string, set bool) {
+	r.changes = append(r.changes, ChannelMode{Mode: mode, Arg: arg, Set: set})
+}
+
+func (r *modeRecorder) setMode(mode rune) {
+	r.ChannelModes.setMode(mode)
+	r.record(mode, "", true)
+}
+
+func (r *modeRecorder) unsetMode(mode rune) {
+	r.ChannelModes.unsetMode(mode)
+	r.record(mode, "", false)
+}
+
+func (r *modeRecorder) setArg(mode rune, arg string) {
+	r.ChannelModes.setArg(mode, arg)
+	r.record(mode, arg, true)
+}
+
+func (r *modeRecorder) unsetArg(mode rune, arg string) {
+	r.ChannelModes.unsetArg(mode, arg)
+	r.record(mode, arg, false)
+}
+
+func (r *modeRecorder) setAddress(mode rune, address string) {
+	r.ChannelModes.setAddress(mode, address)
+	r.record(mode, address, true)
+}
+
+func (r *modeRecorder) unsetAddress(mode rune, address string) {
+	r.ChannelModes.unsetAddress(mode, address)
+	r.record(mode, address, false)
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestStore_EventsJoinPart(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	events := st.Update(&irc.IrcMessage{Name: irc.JOIN,
+		Sender: self.GetFullhost(), Args: []string{channels[0]}})
+	c.Assert(len(events), Equals, 1)
+	join := events[0].(*UserJoin)
+	c.Check(join.StateName(), Equals, STATE_USERJOIN)
+	c.Check(*join, Equals, UserJoin{Channel: channels[0],
+		Nick: self.GetNick(), Fullhost: self.GetFullhost()})
+
+	events = st.Update(&irc.IrcMessage{Name: irc.JOIN, Sender: users[0],
+		Args: []string{channels[0]}})
+	c.Assert(len(events), Equals, 1)
+	c.Check(events[0].(*UserJoin).Nick, Equals, nicks[0])
+
+	// Joining twice changes nothing.
+	events = st.Update(&irc.IrcMessage{Name: irc.JOIN, Sender: users[0],
+		Args: []string{channels[0]}})
+	c.Check(len(events), Equals, 0)
+
+	events = st.Update(&irc.IrcMessage{Name: irc.PART, Sender: users[0],
+		Args: []string{channels[0], "bye"}})
+	c.Assert(len(events), Equals, 1)
+	leave := events[0].(*UserLeave)
+	c.Check(leave.StateName(), Equals, STATE_USERLEAVE)
+	c.Check(*leave, Equals, UserLeave{Channel: channels[0], Nick: nicks[0],
+		Fullhost: users[0], Event: irc.PART, Reason: "bye"})
+
+	events = st.Update(&irc.IrcMessage{Name: irc.PART, Sender: users[0],
+		Args: []string{channels[0]}})
+	c.Check(len(events), Equals, 0)
+
+	events = st.Update(&irc.IrcMessage{Name: irc.PART,
+		Sender: self.GetFullhost(), Args: []string{channels[0]}})
+	c.Assert(len(events), Equals, 1)
+	c.Check(events[0].(*UserLeave).Nick, Equals, self.GetNick())
+	c.Check(st.GetChannel(channels[0]), IsNil)
+}
+
+func (s *s) TestStore_EventsQuitKick(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	st.addChannel(channels[0])
+	st.addChannel(channels[1])
+	st.addUser(users[0])
+	st.addUser(users[1])
+	st.addToChannel(users[0], channels[0])
+	st.addToChannel(users[0], channels[1])
+	st.addToChannel(users[1], channels[0])
+
+	events := st.Update(&irc.IrcMessage{Name: irc.KICK, Sender: users[1],
+		Args: []string{channels[0], "NICK1", "out"}})
+	c.Assert(len(events), Equals, 1)
+	c.Check(*events[0].(*UserLeave), Equals, UserLeave{Channel: channels[0],
+		Nick: nicks[0], Fullhost: users[0], Event: irc.KICK, Reason: "out",
+		By: users[1]})
+
+	events = st.Update(&irc.IrcMessage{Name: irc.QUIT, Sender: users[0],
+		Args: []string{"gone"}})
+	c.Assert(len(events), Equals, 1)
+	c.Check(*events[0].(*UserLeave), Equals, UserLeave{Channel: channels[1],
+		Nick: nicks[0], Fullhost: users[0], Event: irc.QUIT, Reason: "gone"})
+	c.Check(st.GetUser(users[0]), IsNil)
+}
+
+func (s *s) TestStore_EventsNick(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	st.addChannel(channels[0])
+	st.addUser(users[0])
+	st.addToChannel(users[0], channels[0])
+	st.GetUsersChannelModes(users[0], channels[0]).SetMode('o')
+
+	events := st.Update(&irc.IrcMessage{Name: irc.NICK, Sender: users[0],
+		Args: []string{"newnick"}})
+	c.Assert(len(events), Equals, 1)
+	rename := events[0].(*UserRename)
+	c.Check(rename.StateName(), Equals, STATE_USERRENAME)
+	c.Check(*rename, Equals, UserRename{Old: nicks[0], New: "newnick",
+		Fullhost: "newnick!user1@host1"})
+
+	c.Check(st.GetUser("newnick").GetFullhost(), Equals,
+		"newnick!user1@host1")
+	modes := st.GetUsersChannelModes("newnick", channels[0])
+	c.Assert(modes, NotNil)
+	c.Check(modes.HasMode('o'), Equals, true)
+	c.Check(st.IsOn(nicks[0], channels[0]), Equals, false)
+}
+
+func (s *s) TestStore_EventsMode(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	st.addChannel(channels[0])
+	st.addUser(users[0])
+	st.addToChannel(users[0], channels[0])
+	st.GetChannel(channels[0]).Set("n")
+
+	events := st.Update(&irc.IrcMessage{Name: irc.MODE, Sender: users[1],
+		Args: []string{channels[0], "+obk-n", nicks[0], "*!*@host", "key"}})
+	c.Assert(len(events), Equals, 4)
+
+	c.Check(*events[0].(*Ban), Equals, Ban{Channel: channels[0],
+		Mask: "*!*@host", Set: true, By: users[1]})
+	c.Check(*events[1].(*ChannelMode), Equals, ChannelMode{
+		Channel: channels[0], Mode: 'k', Arg: "key", Set: true, By: users[1]})
+	c.Check(*events[2].(*ChannelMode), Equals, ChannelMode{
+		Channel: channels[0], Mode: 'n', Set: false, By: users[1]})
+	c.Check(*events[3].(*UserMode), Equals, UserMode{Channel: channels[0],
+		Nick: nicks[0], Mode: 'o', Set: true, By: users[1]})
+
+	// Modes for users that aren't on the channel change nothing.
+	events = st.Update(&irc.IrcMessage{Name: irc.MODE, Sender: users[1],
+		Args: []string{channels[0], "-v", "nobody"}})
+	c.Check(len(events), Equals, 0)
+}
+
+func (s *s) TestStore_EventsTopic(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	st.addChannel(channels[0])
+	st.GetChannel(channels[0]).Topic("old topic")
+
+	events := st.Update(&irc.IrcMessage{Name: irc.TOPIC, Sender: users[0],
+		Args: []string{channels[0], "new topic"}})
+	c.Assert(len(events), Equals, 1)
+	topic := events[0].(*Topic)
+	c.Check(topic.StateName(), Equals, STATE_TOPIC)
+	c.Check(*topic, Equals, Topic{Channel: channels[0], Topic: "new topic",
+		Old: "old topic", By: users[0]})
+
+	events = st.Update(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: users[0],
+		Args: []string{channels[0], "hi"}})
+	c.Check(len(events), Equals, 0)
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"encoding/gob"
+	"github.com/aarondl/ultimateq/irc"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Seen is the last thing a nick was seen doing.
+type Seen struct {
+	// Nick is the nick as it was last seen.
+	Nick string
+	// Fullhost is the fullhost the nick was last seen with.
+	Fullhost string
+	// Time is when the nick was seen.
+	Time time.Time
+	// Event is the irc event the nick was seen in, e.g. PRIVMSG or QUIT.
+	Event string
+	// Target is the channel the event happened in, if there was one.
+	Target string
+	// Message is the text that went with the event, the message for PRIVMSG
+	// and NOTICE, the reason for PART, QUIT and KICK and the new nick for
+	// NICK.
+	Message string
+}
+
+// snapshot is the form a Store is written to a file in.
+type snapshot struct {
+	Users    []userSnapshot
+	Channels []channelSnapshot
+	Seen     []*Seen
+}
+
+// userSnapshot is the saved form of a User.
+type userSnapshot struct {
+	Mask     string
+	Realname string
+	Server   string
+	Away     string
+	IsAway   bool
+	Account  string
+}
+
+// channelSnapshot is the saved form of a Channel, the users on it are kept
+// by nick along with their modes.
+type channelSnapshot struct {
+	Name         string
+	Topic        string
+	Modes        []rune
+	ArgModes     map[rune]string
+	AddressModes map[rune][]string
+	Users        map[string]string
+}
+
+// restored is the state loaded from a snapshot. It may be out of date so it's
+// kept apart from the live state, a channel is dropped from it when the bot
+// joins the channel again and a user when they're seen again.
+type restored struct {
+	channels     map[string]*Channel
+	channelUsers map[string]map[string]string
+	users        map[string]*User
+}
+
+// dropChannel removes a channel and it's users by folded name.
+func (r restored) dropChannel(key string) {
+	delete(r.channels, key)
+	delete(r.channelUsers, key)
+}
+
+// refold re-keys the restored state after the casemap has changed.
+func (r restored) refold(casemap irc.Casemap) restored {
+	folded := restored{
+		channels:     make(map[string]*Channel, len(r.channels)),
+		channelUsers: make(map[string]map[string]string, len(r.channelUsers)),
+		users:        make(map[string]*User, len(r.users)),
+	}
+	for key, ch := range r.channels {
+		ch.casemap = casemap
+		chankey := casemap.Fold(ch.GetName())
+		folded.channels[chankey] = ch
+		if users, ok := r.channelUsers[key]; ok {
+			folded.channelUsers[chankey] = users
+		}
+	}
+	for _, u := range r.users {
+		folded.users[casemap.Fold(u.GetNick())] = u
+	}
+	return folded
+}
+
+// GetRestoredChannel returns a channel as it was when the store was saved,
+// with it's topic, modes and address lists like bans. Nil if the channel
+// wasn't saved or the bot has joined it since the store was loaded, then
+// GetChannel has it's current state instead.
+func (s *Store) GetRestoredChannel(channel string) *Channel {
+	return s.restored.channels[s.casemap.Fold(channel)]
+}
+
+// GetRestoredChanUsers returns the nicks that were on a channel when the store
+// was saved, each with the modes they had on it. Nil under the same
+// conditions as GetRestoredChannel.
+func (s *Store) GetRestoredChanUsers(channel string) map[string]string {
+	users, ok := s.restored.channelUsers[s.casemap.Fold(channel)]
+	if !ok {
+		return nil
+	}
+	cp := make(map[string]string, len(users))
+	for nick, modes := range users {
+		cp[nick] = modes
+	}
+	return cp
+}
+
+// GetRestoredUser returns a user as they were when the store was saved. Nil if
+// the user wasn't saved or has been seen since the store was loaded, then
+// GetUser has their current state instead.
+func (s *Store) GetRestoredUser(nickorhost string) *User {
+	return s.restored.users[s.casemap.Fold(irc.Mask(nickorhost).GetNick())]
+}
+
+// GetSeen returns the last thing the nick was seen doing, nil if it has never
+// been seen.
+func (s *Store) GetSeen(nick string) *Seen {
+	if seen, ok := s.seen[s.casemap.Fold(nick)]; ok {
+		cp := *seen
+		return &cp
+	}
+	return nil
+}
+
+// TrimSeen drops the seen records of nicks that haven't been seen since
+// before, a zero time keeps them all. Then if more than limit records are left
+// the oldest are dropped, a limit of 0 allows any number.
+func (s *Store) TrimSeen(before time.Time, limit int) {
+	if !before.IsZero() {
+		for key, seen := range s.seen {
+			if seen.Time.Before(before) {
+				delete(s.seen, key)
+			}
+		}
+	}
+
+	if limit <= 0 || len(s.seen) <= limit {
+		return
+	}
+	keys := seenByAge{keys: make([]string, 0, len(s.seen)), seen: s.seen}
+	for key := range s.seen {
+		keys.keys = append(keys.keys, key)
+	}
+	sort.Sort(keys)
+	for _, key := range keys.keys[limit:] {
+		delete(s.seen, key)
+	}
+}
+
+// seenByAge sorts the keys of seen records from the newest to the oldest.
+type seenByAge struct {
+	keys []string
+	seen map[string]*Seen
+}
+
+func (a seenByAge) Len() int {
+	return len(a.keys)
+}
+
+func (a seenByAge) Swap(i, j int) {
+	a.keys[i], a.keys[j] = a.keys[j], a.keys[i]
+}
+
+func (a seenByAge) Less(i, j int) bool {
+	return a.seen[a.keys[i]].Time.After(a.seen[a.keys[j]].Time)
+}
+
+// seenUpdate records what the sender of a message was doing.
+func (s *Store) seenUpdate(m *irc.IrcMessage) {
+	if !strings.ContainsRune(m.Sender, '!') {
+		return
+	}
+
+	nick := irc.Mask(m.Sender).GetNick()
+	seen := &Seen{
+		Nick:     nick,
+		Fullhost: m.Sender,
+		Time:     time.Now(),
+		Event:    m.Name,
+	}
+
+	switch m.Name {
+	case irc.QUIT, irc.NICK:
+		if len(m.Args) > 0 {
+			seen.Message = m.Args[0]
+		}
+	default:
+		if len(m.Args) > 0 && s.cfinder.IsChannel(m.Args[0]) {
+			seen.Target = m.Args[0]
+		}
+		if len(m.Args) > 1 {
+			seen.Message = m.Args[len(m.Args)-1]
+		}
+	}
+
+	s.seen[s.casemap.Fold(nick)] = seen
+	if m.Name == irc.NICK && len(m.Args) > 0 {
+		_, username, host := irc.Mask(m.Sender).SplitFullhost()
+		renamed := *seen
+		renamed.Nick = m.Args[0]
+		renamed.Fullhost = m.Args[0] + "!" + username + "@" + host
+		s.seen[s.casemap.Fold(m.Args[0])] = &renamed
+	}
+}
+
+// Save writes the channels, users and seen records of the store to a file.
+// What was restored and hasn't been seen again is written too, so it isn't
+// lost when the bot restarts before rejoining. It's written to a temporary
+// file first so a failed write never leaves a broken file behind.
+func (s *Store) Save(filename string) error {
+	snap := snapshot{
+		Users: make([]userSnapshot, 0,
+			len(s.users)+len(s.restored.users)),
+		Channels: make([]channelSnapshot, 0,
+			len(s.channels)+len(s.restored.channels)),
+		Seen: make([]*Seen, 0, len(s.seen)),
+	}
+
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, saveUser(u))
+	}
+	for key, u := range s.restored.users {
+		if _, ok := s.users[key]; !ok {
+			snap.Users = append(snap.Users, saveUser(u))
+		}
+	}
+
+	for key, ch := range s.channels {
+		users := make(map[string]string, len(s.channelUsers[key]))
+		for _, cu := range s.channelUsers[key] {
+			users[cu.User.GetNick()] = cu.UserModes.String()
+		}
+		snap.Channels = append(snap.Channels, saveChannel(ch, users))
+	}
+	for key, ch := range s.restored.channels {
+		if _, ok := s.channels[key]; !ok {
+			snap.Channels = append(snap.Channels,
+				saveChannel(ch, s.restored.channelUsers[key]))
+		}
+	}
+
+	for _, seen := range s.seen {
+		snap.Seen = append(snap.Seen, seen)
+	}
+
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(snap); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// saveUser creates the saved form of a user.
+func saveUser(u *User) userSnapshot {
+	return userSnapshot{
+		Mask:     u.GetFullhost(),
+		Realname: u.name,
+		Server:   u.server,
+		Away:     u.away,
+		IsAway:   u.isAway,
+		Account:  u.account,
+	}
+}
+
+// saveChannel creates the saved form of a channel and the modes of the users
+// on it by nick.
+func saveChannel(ch *Channel, users map[string]string) channelSnapshot {
+	chsnap := channelSnapshot{
+		Name:         ch.GetName(),
+		Topic:        ch.GetTopic(),
+		Modes:        make([]rune, 0, len(ch.modes)),
+		ArgModes:     ch.argModes,
+		AddressModes: ch.addressModes,
+		Users:        users,
+	}
+	for mode := range ch.modes {
+		chsnap.Modes = append(chsnap.Modes, mode)
+	}
+	return chsnap
+}
+
+// Load reads a file written by Save into the store. What's read is kept apart
+// from the live state since it may be out of date, it can be looked at with
+// GetRestoredChannel, GetRestoredChanUsers and GetRestoredUser until the bot
+// sees the channel or user again. Seen records are merged with the store's.
+// A file that doesn't exist is not an error.
+func (s *Store) Load(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snap snapshot
+	if err = gob.NewDecoder(file).Decode(&snap); err != nil {
+		return err
+	}
+
+	r := restored{
+		channels:     make(map[string]*Channel, len(snap.Channels)),
+		channelUsers: make(map[string]map[string]string, len(snap.Channels)),
+		users:        make(map[string]*User, len(snap.Users)),
+	}
+
+	for _, usnap := range snap.Users {
+		nick := s.casemap.Fold(irc.Mask(usnap.Mask).GetNick())
+		if len(nick) == 0 {
+			continue
+		}
+		if _, ok := s.users[nick]; ok {
+			continue
+		}
+		user := CreateUser(usnap.Mask)
+		user.name = usnap.Realname
+		user.server = usnap.Server
+		user.away, user.isAway = usnap.Away, usnap.IsAway
+		user.account = usnap.Account
+		r.users[nick] = user
+	}
+
+	for _, chsnap := range snap.Channels {
+		key := s.casemap.Fold(chsnap.Name)
+		if _, ok := s.channels[key]; ok {
+			continue
+		}
+		ch := CreateChannel(chsnap.Name, s.kinds)
+		if ch == nil {
+			continue
+		}
+		ch.casemap = s.casemap
+		ch.Topic(chsnap.Topic)
+		for _, mode := range chsnap.Modes {
+			ch.setMode(mode)
+		}
+		for mode, arg := range chsnap.ArgModes {
+			ch.setArg(mode, arg)
+		}
+		for mode, addresses := range chsnap.AddressModes {
+			for _, address := range addresses {
+				ch.setAddress(mode, address)
+			}
+		}
+		r.channels[key] = ch
+		users := make(map[string]string, len(chsnap.Users))
+		for nick, modes := range chsnap.Users {
+			users[nick] = modes
+		}
+		r.channelUsers[key] = users
+	}
+
+	for _, seen := range snap.Seen {
+		s.seen[s.casemap.Fold(seen.Nick)] = seen
+	}
+
+	s.restored = r
+	return nil
+}
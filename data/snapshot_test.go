@@ -0,0 +1,184 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (s *s) TestStore_Seen(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+
+	c.Check(st.GetSeen("nick1"), IsNil)
+
+	before := time.Now()
+	st.Update(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: users[0],
+		Args: []string{channels[0], "hello there"}})
+	seen := st.GetSeen("NICK1")
+	c.Assert(seen, NotNil)
+	c.Check(seen.Nick, Equals, "nick1")
+	c.Check(seen.Fullhost, Equals, users[0])
+	c.Check(seen.Event, Equals, irc.PRIVMSG)
+	c.Check(seen.Target, Equals, channels[0])
+	c.Check(seen.Message, Equals, "hello there")
+	c.Check(seen.Time.Before(before), Equals, false)
+
+	st.Update(&irc.IrcMessage{Name: irc.NICK, Sender: users[0],
+		Args: []string{"newnick"}})
+	c.Check(st.GetSeen("nick1").Message, Equals, "newnick")
+	seen = st.GetSeen("newnick")
+	c.Assert(seen, NotNil)
+	c.Check(seen.Fullhost, Equals, "newnick!user1@host1")
+	c.Check(seen.Event, Equals, irc.NICK)
+
+	st.Update(&irc.IrcMessage{Name: irc.QUIT, Sender: users[1],
+		Args: []string{"bye"}})
+	seen = st.GetSeen("nick2")
+	c.Check(seen.Event, Equals, irc.QUIT)
+	c.Check(seen.Target, Equals, "")
+	c.Check(seen.Message, Equals, "bye")
+
+	// Servers are never seen.
+	st.Update(&irc.IrcMessage{Name: irc.NOTICE, Sender: server,
+		Args: []string{"*", "hi"}})
+	c.Check(st.GetSeen(server), IsNil)
+}
+
+func (s *s) TestStore_TrimSeen(c *C) {
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+
+	now := time.Now()
+	for i, nick := range []string{"old", "older", "new", "newer"} {
+		st.seen[nick] = &Seen{Nick: nick, Time: now.Add(
+			time.Duration([]int{-2, -3, 0, 1}[i]) * time.Hour)}
+	}
+
+	st.TrimSeen(time.Time{}, 0)
+	c.Check(st.seen, HasLen, 4)
+
+	st.TrimSeen(now.Add(-150*time.Minute), 0)
+	c.Check(st.seen, HasLen, 3)
+	c.Check(st.GetSeen("older"), IsNil)
+
+	st.TrimSeen(time.Time{}, 2)
+	c.Check(st.seen, HasLen, 2)
+	c.Check(st.GetSeen("old"), IsNil)
+	c.Check(st.GetSeen("new"), NotNil)
+	c.Check(st.GetSeen("newer"), NotNil)
+}
+
+func (s *s) TestStore_SaveLoad(c *C) {
+	filename := filepath.Join(c.MkDir(), "state.db")
+
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	c.Check(st.Load(filename), IsNil)
+
+	st.addUser(users[0])
+	st.addUser(users[1])
+	st.GetUser(users[0]).Realname("Real Name")
+	st.GetUser(users[0]).setAccount("acct")
+	st.GetUser(users[1]).setAway("gone")
+	st.addChannel(channels[0])
+	st.addToChannel(users[0], channels[0])
+	st.addToChannel(users[1], channels[0])
+	st.GetUsersChannelModes(users[0], channels[0]).SetMode('o')
+	ch := st.GetChannel(channels[0])
+	ch.Topic("the topic")
+	ch.Set("n", "k key", "b *!*@host1", "b *!*@host2")
+	st.Update(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: users[1],
+		Args: []string{channels[0], "last words"}})
+
+	c.Assert(st.Save(filename), IsNil)
+	_, err = os.Stat(filename + ".tmp")
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	st, err = CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	c.Assert(st.Load(filename), IsNil)
+
+	// Nothing that was loaded is live until it's seen again.
+	c.Check(st.GetNUsers(), Equals, 0)
+	c.Check(st.GetNChannels(), Equals, 0)
+	c.Check(st.IsOn("nick1", channels[0]), Equals, false)
+
+	user := st.GetRestoredUser("NICK1")
+	c.Assert(user, NotNil)
+	c.Check(user.GetFullhost(), Equals, users[0])
+	c.Check(user.GetRealname(), Equals, "Real Name")
+	c.Check(user.GetAccount(), Equals, "acct")
+	c.Check(st.GetRestoredUser("nick2").GetAwayMessage(), Equals, "gone")
+
+	ch = st.GetRestoredChannel(channels[0])
+	c.Assert(ch, NotNil)
+	c.Check(ch.GetName(), Equals, channels[0])
+	c.Check(ch.GetTopic(), Equals, "the topic")
+	c.Check(ch.IsSet("n", "k key"), Equals, true)
+	c.Check(ch.GetBans(), HasLen, 2)
+	c.Check(ch.IsBanned("x!y@host2"), Equals, true)
+	c.Check(st.GetRestoredChanUsers(channels[0]), DeepEquals,
+		map[string]string{"nick1": "o", "nick2": ""})
+
+	seen := st.GetSeen("nick2")
+	c.Assert(seen, NotNil)
+	c.Check(seen.Message, Equals, "last words")
+	c.Check(seen.Target, Equals, channels[0])
+
+	c.Check(ioutil.WriteFile(filename, []byte("garbage"), 0600), IsNil)
+	c.Check(st.Load(filename), NotNil)
+}
+
+func (s *s) TestStore_Restored(c *C) {
+	filename := filepath.Join(c.MkDir(), "state.db")
+
+	st, err := CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.addUser(users[0])
+	st.addUser(users[1])
+	for _, channel := range channels {
+		st.addChannel(channel)
+		st.addToChannel(users[0], channel)
+		st.GetChannel(channel).Set("b *!*@host1")
+	}
+	c.Assert(st.Save(filename), IsNil)
+
+	st, err = CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self = self
+	c.Assert(st.Load(filename), IsNil)
+
+	// Joining a channel again starts it fresh, the saved state is dropped.
+	st.Update(&irc.IrcMessage{Name: irc.JOIN, Sender: self.GetFullhost(),
+		Args: []string{channels[0]}})
+	c.Check(st.GetRestoredChannel(channels[0]), IsNil)
+	c.Check(st.GetRestoredChanUsers(channels[0]), IsNil)
+	ch := st.GetChannel(channels[0])
+	c.Assert(ch, NotNil)
+	c.Check(ch.GetBans(), HasLen, 0)
+	c.Check(st.IsOn("nick1", channels[0]), Equals, false)
+	c.Check(st.GetRestoredChannel(channels[1]), NotNil)
+
+	// A user is dropped once they're seen again.
+	c.Check(st.GetRestoredUser("nick1"), NotNil)
+	st.Update(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: users[0],
+		Args: []string{channels[0], "back"}})
+	c.Check(st.GetRestoredUser("nick1"), IsNil)
+	c.Check(st.GetUser("nick1"), NotNil)
+
+	// What hasn't been seen again is saved along with the live state.
+	c.Assert(st.Save(filename), IsNil)
+	st, err = CreateStore(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	c.Assert(st.Load(filename), IsNil)
+	c.Check(st.GetRestoredChannel(channels[0]), NotNil)
+	c.Check(st.GetRestoredChannel(channels[1]).GetBans(), HasLen, 1)
+	c.Check(st.GetRestoredChanUsers(channels[1]), DeepEquals,
+		map[string]string{"nick1": ""})
+	c.Check(st.GetRestoredUser("nick2"), NotNil)
+}
@@ -0,0 +1,339 @@
+package data
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// channelBucketPrefix starts the name of every channel's bucket.
+	channelBucketPrefix = "channel:"
+	// userBucketPrefix starts the name of every user's bucket.
+	userBucketPrefix = "user:"
+)
+
+var (
+	// errStorageClosed is given when a transaction is started on a storage
+	// that has been closed.
+	errStorageClosed = errors.New("data: Storage is closed.")
+	// errTxReadOnly is given when writing inside a View.
+	errTxReadOnly = errors.New("data: Transaction is read only.")
+	// errTxDone is given when a transaction is used after it's function has
+	// returned.
+	errTxDone = errors.New("data: Transaction has finished.")
+)
+
+// Storage is a database handlers can keep their own data in. Data is kept in
+// named buckets of key value pairs and all access goes through transactions.
+// Implementations must be safe to use from many handlers at once.
+type Storage interface {
+	// View calls fn with a read only transaction. Any number of views may
+	// run at the same time.
+	View(fn func(Tx) error) error
+	// Update calls fn with a read write transaction, the changes it makes
+	// are kept only if it returns nil. Updates run one at a time and block
+	// views, so Update must not be called from inside View or Update.
+	Update(fn func(Tx) error) error
+	// Close releases the storage, transactions fail after it's called.
+	Close() error
+}
+
+// Tx is a transaction on a Storage, it must not be used after the function it
+// was given to returns.
+type Tx interface {
+	// Bucket returns the bucket with the given name. In a read only
+	// transaction a bucket that doesn't exist is empty.
+	Bucket(name string) Bucket
+	// DeleteBucket removes a bucket and everything in it.
+	DeleteBucket(name string) error
+	// Buckets returns the names of all the buckets.
+	Buckets() []string
+}
+
+// Bucket is a set of key value pairs in a Storage. Values returned by Get are
+// copies and values given to Put are copied.
+type Bucket interface {
+	// Get returns the value for key, nil if it's not set.
+	Get(key string) []byte
+	// Put sets the value for key.
+	Put(key string, value []byte) error
+	// Delete removes key.
+	Delete(key string) error
+	// Keys returns all the keys in sorted order.
+	Keys() []string
+}
+
+// ChannelRecords returns the bucket for a channel on the server identified by
+// key. The channel is folded with the casemap so every spelling of it shares
+// the same bucket.
+func ChannelRecords(tx Tx, key string, casemap irc.Casemap,
+	channel string) Bucket {
+
+	return tx.Bucket(channelBucketPrefix + key + ":" + casemap.Fold(channel))
+}
+
+// UserRecords returns the bucket for a nick on the server identified by key.
+// The nick is folded with the casemap so every spelling of it shares the same
+// bucket.
+func UserRecords(tx Tx, key string, casemap irc.Casemap, nick string) Bucket {
+	return tx.Bucket(userBucketPrefix + key + ":" + casemap.Fold(nick))
+}
+
+// storage is the built in Storage. It keeps everything in memory and, if it
+// has a file, writes all of it out each time an Update commits.
+type storage struct {
+	filename string
+	buckets  map[string]map[string][]byte
+	closed   bool
+
+	protect sync.RWMutex
+}
+
+// CreateMemStorage creates a Storage that is only kept in memory.
+func CreateMemStorage() Storage {
+	return &storage{buckets: make(map[string]map[string][]byte)}
+}
+
+// CreateFileStorage creates a Storage that is kept in the given file, loading
+// anything that is already there.
+func CreateFileStorage(filename string) (Storage, error) {
+	s := &storage{
+		filename: filename,
+		buckets:  make(map[string]map[string][]byte),
+	}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err = gob.NewDecoder(file).Decode(&s.buckets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// View calls fn with a read only transaction.
+func (s *storage) View(fn func(Tx) error) error {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+	if s.closed {
+		return errStorageClosed
+	}
+
+	tx := &storageTx{buckets: s.buckets}
+	defer func() { tx.done = true }()
+	return fn(tx)
+}
+
+// Update calls fn with a read write transaction and commits it's changes if
+// it returns nil.
+func (s *storage) Update(fn func(Tx) error) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	if s.closed {
+		return errStorageClosed
+	}
+
+	tx := &storageTx{
+		buckets:  s.buckets,
+		changed:  make(map[string]map[string][]byte),
+		writable: true,
+	}
+	err := fn(tx)
+	tx.done = true
+	if err != nil {
+		return err
+	}
+
+	buckets := make(map[string]map[string][]byte, len(s.buckets))
+	for name, bucket := range s.buckets {
+		buckets[name] = bucket
+	}
+	for name, bucket := range tx.changed {
+		if bucket == nil {
+			delete(buckets, name)
+		} else {
+			buckets[name] = bucket
+		}
+	}
+
+	if err = s.save(buckets); err != nil {
+		return err
+	}
+	s.buckets = buckets
+	return nil
+}
+
+// Close closes the storage.
+func (s *storage) Close() error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	if s.closed {
+		return errStorageClosed
+	}
+	s.closed = true
+	return nil
+}
+
+// save writes the buckets to a temporary file, syncs it to disk and then moves
+// it over the old one so a failed write or a crash never leaves a broken file
+// behind.
+func (s *storage) save(buckets map[string]map[string][]byte) error {
+	if len(s.filename) == 0 {
+		return nil
+	}
+
+	tmp := s.filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(buckets); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+// storageTx is a transaction on the built in storage. Buckets that are written
+// to are copied into changed, a nil bucket in changed has been deleted.
+type storageTx struct {
+	buckets  map[string]map[string][]byte
+	changed  map[string]map[string][]byte
+	writable bool
+	done     bool
+}
+
+// Bucket returns the bucket with the given name.
+func (t *storageTx) Bucket(name string) Bucket {
+	return &storageBucket{tx: t, name: name}
+}
+
+// DeleteBucket removes a bucket.
+func (t *storageTx) DeleteBucket(name string) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	t.changed[name] = nil
+	return nil
+}
+
+// Buckets returns the names of all the buckets in sorted order.
+func (t *storageTx) Buckets() []string {
+	names := make([]string, 0, len(t.buckets)+len(t.changed))
+	for name := range t.buckets {
+		if _, ok := t.changed[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name, bucket := range t.changed {
+		if bucket != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// check makes sure the transaction may be written to.
+func (t *storageTx) check() error {
+	if t.done {
+		return errTxDone
+	} else if !t.writable {
+		return errTxReadOnly
+	}
+	return nil
+}
+
+// read returns a bucket to read from.
+func (t *storageTx) read(name string) map[string][]byte {
+	if bucket, ok := t.changed[name]; ok {
+		return bucket
+	}
+	return t.buckets[name]
+}
+
+// write returns a copy of a bucket that belongs to this transaction.
+func (t *storageTx) write(name string) map[string][]byte {
+	if bucket, ok := t.changed[name]; ok && bucket != nil {
+		return bucket
+	}
+
+	old := t.read(name)
+	bucket := make(map[string][]byte, len(old))
+	for key, value := range old {
+		bucket[key] = value
+	}
+	t.changed[name] = bucket
+	return bucket
+}
+
+// storageBucket is a bucket in a storageTx.
+type storageBucket struct {
+	tx   *storageTx
+	name string
+}
+
+// Get returns a copy of the value for key.
+func (b *storageBucket) Get(key string) []byte {
+	if b.tx.done {
+		return nil
+	}
+	value, ok := b.tx.read(b.name)[key]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+// Put sets a copy of value for key.
+func (b *storageBucket) Put(key string, value []byte) error {
+	if err := b.tx.check(); err != nil {
+		return err
+	}
+	b.tx.write(b.name)[key] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes key.
+func (b *storageBucket) Delete(key string) error {
+	if err := b.tx.check(); err != nil {
+		return err
+	}
+	if _, ok := b.tx.read(b.name)[key]; ok {
+		delete(b.tx.write(b.name), key)
+	}
+	return nil
+}
+
+// Keys returns the keys in sorted order.
+func (b *storageBucket) Keys() []string {
+	if b.tx.done {
+		return nil
+	}
+	bucket := b.tx.read(b.name)
+	keys := make([]string, 0, len(bucket))
+	for key := range bucket {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"path/filepath"
+	"sync"
+)
+
+func (s *s) TestStorage_Mem(c *C) {
+	testStorage(c, CreateMemStorage())
+}
+
+func (s *s) TestStorage_File(c *C) {
+	filename := filepath.Join(c.MkDir(), "storage.db")
+	st, err := CreateFileStorage(filename)
+	c.Assert(err, IsNil)
+	testStorage(c, st)
+	c.Check(st.Close(), IsNil)
+
+	st, err = CreateFileStorage(filename)
+	c.Assert(err, IsNil)
+	err = st.View(func(tx Tx) error {
+		c.Check(tx.Buckets(), DeepEquals, []string{"bucket"})
+		c.Check(string(tx.Bucket("bucket").Get("key")), Equals, "value")
+		return nil
+	})
+	c.Check(err, IsNil)
+
+	c.Check(ioutil.WriteFile(filename, []byte("garbage"), 0600), IsNil)
+	_, err = CreateFileStorage(filename)
+	c.Check(err, NotNil)
+}
+
+func testStorage(c *C, st Storage) {
+	err := st.Update(func(tx Tx) error {
+		b := tx.Bucket("bucket")
+		c.Check(b.Get("key"), IsNil)
+		c.Check(b.Put("key", []byte("value")), IsNil)
+		c.Check(b.Put("other", []byte("value")), IsNil)
+		c.Check(string(b.Get("key")), Equals, "value")
+		c.Check(b.Keys(), DeepEquals, []string{"key", "other"})
+		return nil
+	})
+	c.Check(err, IsNil)
+
+	// Failed updates are rolled back.
+	failed := errors.New("failed")
+	err = st.Update(func(tx Tx) error {
+		c.Check(tx.Bucket("bucket").Delete("other"), IsNil)
+		c.Check(tx.Bucket("gone").Put("key", []byte("value")), IsNil)
+		c.Check(tx.Buckets(), DeepEquals, []string{"bucket", "gone"})
+		return failed
+	})
+	c.Check(err, Equals, failed)
+
+	var saved Tx
+	err = st.View(func(tx Tx) error {
+		saved = tx
+		b := tx.Bucket("bucket")
+		c.Check(b.Keys(), DeepEquals, []string{"key", "other"})
+		c.Check(b.Put("key", nil), Equals, errTxReadOnly)
+		c.Check(tx.DeleteBucket("bucket"), Equals, errTxReadOnly)
+		c.Check(tx.Bucket("gone").Keys(), HasLen, 0)
+		c.Check(tx.Buckets(), DeepEquals, []string{"bucket"})
+
+		value := b.Get("key")
+		value[0] = 'X'
+		c.Check(string(b.Get("key")), Equals, "value")
+		return nil
+	})
+	c.Check(err, IsNil)
+	c.Check(saved.Bucket("bucket").Get("key"), IsNil)
+
+	err = st.Update(func(tx Tx) error {
+		saved = tx
+		c.Check(tx.Bucket("bucket").Delete("other"), IsNil)
+		c.Check(tx.Bucket("deleted").Put("key", nil), IsNil)
+		c.Check(tx.DeleteBucket("deleted"), IsNil)
+		c.Check(tx.Bucket("deleted").Get("key"), IsNil)
+		return nil
+	})
+	c.Check(err, IsNil)
+	c.Check(saved.Bucket("bucket").Put("key", nil), Equals, errTxDone)
+
+	err = st.View(func(tx Tx) error {
+		c.Check(tx.Buckets(), DeepEquals, []string{"bucket"})
+		c.Check(tx.Bucket("bucket").Keys(), DeepEquals, []string{"key"})
+		return nil
+	})
+	c.Check(err, IsNil)
+}
+
+func (s *s) TestStorage_Concurrent(c *C) {
+	st := CreateMemStorage()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			st.Update(func(tx Tx) error {
+				b := tx.Bucket("counter")
+				n := len(b.Get("n"))
+				return b.Put("n", make([]byte, n+1))
+			})
+		}()
+		go func() {
+			defer wait.Done()
+			st.View(func(tx Tx) error {
+				tx.Bucket("counter").Get("n")
+				return nil
+			})
+		}()
+	}
+	wait.Wait()
+
+	st.View(func(tx Tx) error {
+		c.Check(tx.Bucket("counter").Get("n"), HasLen, 20)
+		return nil
+	})
+}
+
+func (s *s) TestStorage_Records(c *C) {
+	st := CreateMemStorage()
+	err := st.Update(func(tx Tx) error {
+		ChannelRecords(tx, "srv", irc.CASEMAP_RFC1459, "#Chan[1]").
+			Put("greeting", []byte("hi"))
+		UserRecords(tx, "srv", irc.CASEMAP_RFC1459, "Foo[away]").
+			Put("karma", []byte("5"))
+		return nil
+	})
+	c.Check(err, IsNil)
+
+	st.View(func(tx Tx) error {
+		b := ChannelRecords(tx, "srv", irc.CASEMAP_RFC1459, "#chan{1}")
+		c.Check(string(b.Get("greeting")), Equals, "hi")
+		b = ChannelRecords(tx, "other", irc.CASEMAP_RFC1459, "#chan{1}")
+		c.Check(b.Get("greeting"), IsNil)
+		b = UserRecords(tx, "srv", irc.CASEMAP_RFC1459, "FOO{AWAY}")
+		c.Check(string(b.Get("karma")), Equals, "5")
+		c.Check(tx.Buckets(), HasLen, 2)
+		return nil
+	})
+
+	c.Check(st.Close(), IsNil)
+	c.Check(st.Close(), Equals, errStorageClosed)
+	c.Check(st.View(func(Tx) error { return nil }), Equals, errStorageClosed)
+	c.Check(st.Update(func(Tx) error { return nil }), Equals,
+		errStorageClosed)
+}
@@ -2,12 +2,21 @@ package data
 
 import (
 	"github.com/aarondl/ultimateq/irc"
+	"time"
 )
 
 // User encapsulates all the data associated with a user.
 type User struct {
-	mask irc.Mask
-	name string
+	mask   irc.Mask
+	name   string
+	access *UserAccess
+
+	server  string
+	idle    time.Duration
+	signon  time.Time
+	away    string
+	isAway  bool
+	account string
 }
 
 // CreateUser creates a user object from a nickname or fullhost.
@@ -53,6 +62,70 @@ func (u *User) GetRealname() string {
 	return u.name
 }
 
+// GetServer returns the server this user is connected to, this is only known
+// after a WHOIS.
+func (u *User) GetServer() string {
+	return u.server
+}
+
+// GetIdle returns how long this user had been idle when they were last
+// WHOIS'd.
+func (u *User) GetIdle() time.Duration {
+	return u.idle
+}
+
+// GetSignon returns when this user connected, this is only known after a
+// WHOIS and only on servers that send it.
+func (u *User) GetSignon() time.Time {
+	return u.signon
+}
+
+// IsAway checks if this user is away.
+func (u *User) IsAway() bool {
+	return u.isAway
+}
+
+// GetAwayMessage returns this user's away message, this may be empty even if
+// the user is away if the message has not been seen.
+func (u *User) GetAwayMessage() string {
+	return u.away
+}
+
+// setAway marks the user as away with a message, or back if the message is
+// empty.
+func (u *User) setAway(message string) {
+	u.away = message
+	u.isAway = len(message) > 0
+}
+
+// GetAccount returns the services account this user is logged in to, or
+// empty string if they're not logged in or it's not known. This is the
+// server's account, not the bot's, see IsLoggedIn.
+func (u *User) GetAccount() string {
+	return u.account
+}
+
+// setAccount sets the services account for the user, * means logged out.
+func (u *User) setAccount(account string) {
+	if account == "*" {
+		account = ""
+	}
+	u.account = account
+}
+
+// IsLoggedIn checks if this user is logged in to one of the bot's accounts,
+// see UserStore. It has nothing to do with the services account from
+// GetAccount.
+func (u *User) IsLoggedIn() bool {
+	return u.access != nil
+}
+
+// GetUserAccess returns the bot's account this user is logged in to, nil if
+// the user is not logged in to the bot.
+func (u *User) GetUserAccess() *UserAccess {
+	return u.access
+}
+
 // String returns a one-line representation of this user.
 func (u *User) String() string {
 	str := u.mask.GetNick()
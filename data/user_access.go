@@ -0,0 +1,344 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+)
+
+const (
+	// nSaltBytes is the length of the salt used for passwords.
+	nSaltBytes = 16
+	// nHashRounds is how many times a password is hashed.
+	nHashRounds = 4096
+)
+
+var (
+	// errMissingUsername is given when an account is created without a name.
+	errMissingUsername = errors.New("data: Username is required.")
+	// errMissingPassword is given when an account is created without a
+	// password.
+	errMissingPassword = errors.New("data: Password is required.")
+	// defaultCasemap is used to fold masks, which aren't tied to a server,
+	// and channels on servers whose casemap isn't known.
+	defaultCasemap = irc.CreateCasemap(irc.CAPS_DEFAULT_CASEMAPPING)
+)
+
+// UserAccess is a registered account. It holds the password and the hostmasks
+// that may log in to it, as well as the access it has globally, per server
+// and per channel. Although all of these are exported so they can be stored,
+// the helper methods should be used to keep server and channel keys sane.
+type UserAccess struct {
+	Username string
+	Password []byte
+	Salt     []byte
+	Masks    []irc.WildMask
+
+	Global  *Access
+	Server  map[string]*Access
+	Channel map[string]map[string]*Access
+
+	// casemaps are the casemaps of the servers channel keys are folded with,
+	// servers that are missing use the default casemap. The map is replaced
+	// and never modified so copies of the account can share it.
+	casemaps map[string]irc.Casemap
+}
+
+// CreateUserAccess creates an account with a password and the hostmasks that
+// are allowed to log in to it. When no masks are given any host may log in.
+func CreateUserAccess(username, password string,
+	masks ...irc.WildMask) (*UserAccess, error) {
+
+	if len(username) == 0 {
+		return nil, errMissingUsername
+	}
+
+	u := &UserAccess{
+		Username: username,
+		Server:   make(map[string]*Access),
+		Channel:  make(map[string]map[string]*Access),
+	}
+	if err := u.SetPassword(password); err != nil {
+		return nil, err
+	}
+	u.AddMasks(masks...)
+
+	return u, nil
+}
+
+// SetPassword sets the password of the account.
+func (u *UserAccess) SetPassword(password string) error {
+	if len(password) == 0 {
+		return errMissingPassword
+	}
+
+	salt := make([]byte, nSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	u.Salt = salt
+	u.Password = hashPassword(salt, password)
+	return nil
+}
+
+// VerifyPassword checks the password against the one stored in the account.
+func (u *UserAccess) VerifyPassword(password string) bool {
+	hash := hashPassword(u.Salt, password)
+	return subtle.ConstantTimeCompare(hash, u.Password) == 1
+}
+
+// AddMasks adds hostmasks that are allowed to log in to this account.
+func (u *UserAccess) AddMasks(masks ...irc.WildMask) {
+	for _, mask := range masks {
+		found := false
+		for _, has := range u.Masks {
+			if defaultCasemap.Equals(string(has), string(mask)) {
+				found = true
+				break
+			}
+		}
+		if !found && len(mask) > 0 {
+			u.Masks = append(u.Masks, mask)
+		}
+	}
+}
+
+// DelMasks removes hostmasks from the account.
+func (u *UserAccess) DelMasks(masks ...irc.WildMask) {
+	for _, mask := range masks {
+		for i := 0; i < len(u.Masks); i++ {
+			if defaultCasemap.Equals(string(u.Masks[i]), string(mask)) {
+				u.Masks = append(u.Masks[:i], u.Masks[i+1:]...)
+				i--
+			}
+		}
+	}
+}
+
+// ValidateMask checks if the given mask may log in to this account, using the
+// default casemap.
+func (u *UserAccess) ValidateMask(mask irc.Mask) bool {
+	return u.ValidateMaskFold(mask, defaultCasemap)
+}
+
+// ValidateMaskFold checks if the given mask may log in to this account, the
+// masks are compared using the casemap of the server the mask is from.
+func (u *UserAccess) ValidateMaskFold(mask irc.Mask,
+	casemap irc.Casemap) bool {
+
+	if len(u.Masks) == 0 {
+		return true
+	}
+	for _, wild := range u.Masks {
+		if wild.MatchFold(mask, casemap) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantGlobal sets the global level and adds the given flags.
+func (u *UserAccess) GrantGlobal(level uint8, flags ...string) {
+	if u.Global == nil {
+		u.Global = &Access{}
+	}
+	u.Global.Level = level
+	u.Global.SetFlags(flags...)
+}
+
+// GrantServer sets the level on a server and adds the given flags.
+func (u *UserAccess) GrantServer(server string, level uint8, flags ...string) {
+	if u.Server == nil {
+		u.Server = make(map[string]*Access)
+	}
+	a, ok := u.Server[server]
+	if !ok {
+		a = &Access{}
+		u.Server[server] = a
+	}
+	a.Level = level
+	a.SetFlags(flags...)
+}
+
+// GrantChannel sets the level on a channel of a server and adds the given
+// flags.
+func (u *UserAccess) GrantChannel(server, channel string, level uint8,
+	flags ...string) {
+
+	if u.Channel == nil {
+		u.Channel = make(map[string]map[string]*Access)
+	}
+	channel = u.casemap(server).Fold(channel)
+	chans, ok := u.Channel[server]
+	if !ok {
+		chans = make(map[string]*Access)
+		u.Channel[server] = chans
+	}
+	a, ok := chans[channel]
+	if !ok {
+		a = &Access{}
+		chans[channel] = a
+	}
+	a.Level = level
+	a.SetFlags(flags...)
+}
+
+// RevokeGlobal removes all global access.
+func (u *UserAccess) RevokeGlobal() {
+	u.Global = nil
+}
+
+// RevokeServer removes all access on a server, channel access on that server
+// is left untouched.
+func (u *UserAccess) RevokeServer(server string) {
+	delete(u.Server, server)
+}
+
+// RevokeChannel removes all access on a channel of a server.
+func (u *UserAccess) RevokeChannel(server, channel string) {
+	if chans, ok := u.Channel[server]; ok {
+		delete(chans, u.casemap(server).Fold(channel))
+		if len(chans) == 0 {
+			delete(u.Channel, server)
+		}
+	}
+}
+
+// GetGlobal returns the global access, nil if there is none.
+func (u *UserAccess) GetGlobal() *Access {
+	return u.Global
+}
+
+// GetServer returns the access on a server, nil if there is none.
+func (u *UserAccess) GetServer(server string) *Access {
+	return u.Server[server]
+}
+
+// GetChannel returns the access on a channel of a server, nil if there is
+// none.
+func (u *UserAccess) GetChannel(server, channel string) *Access {
+	if chans, ok := u.Channel[server]; ok {
+		return chans[u.casemap(server).Fold(channel)]
+	}
+	return nil
+}
+
+// Has checks if the account has at least the given level and all of the given
+// flags on a channel of a server. Global, server and channel access are
+// combined: the highest level wins and flags from each are added together.
+// The channel may be empty to check only global and server access.
+func (u *UserAccess) Has(server, channel string, level uint8,
+	flags ...string) bool {
+
+	combined := u.access(server, channel)
+	return combined.HasLevel(level) && combined.HasFlags(flags...)
+}
+
+// access combines the global, server and channel access into one.
+func (u *UserAccess) access(server, channel string) Access {
+	var combined Access
+	all := []*Access{u.Global, u.GetServer(server)}
+	if len(channel) > 0 {
+		all = append(all, u.GetChannel(server, channel))
+	}
+	for _, a := range all {
+		if a == nil {
+			continue
+		}
+		if a.Level > combined.Level {
+			combined.Level = a.Level
+		}
+		combined.Flags |= a.Flags
+	}
+	return combined
+}
+
+// casemap returns the casemap channels on a server are folded with.
+func (u *UserAccess) casemap(server string) irc.Casemap {
+	if casemap, ok := u.casemaps[server]; ok {
+		return casemap
+	}
+	return defaultCasemap
+}
+
+// refold sets the casemaps of the servers and folds the channel keys of the
+// given server again. Access on channels that are now the same is combined.
+func (u *UserAccess) refold(casemaps map[string]irc.Casemap, server string) {
+	u.casemaps = casemaps
+	chans, ok := u.Channel[server]
+	if !ok {
+		return
+	}
+
+	casemap := u.casemap(server)
+	folded := make(map[string]*Access, len(chans))
+	for channel, a := range chans {
+		channel = casemap.Fold(channel)
+		if has, ok := folded[channel]; ok {
+			if a.Level > has.Level {
+				has.Level = a.Level
+			}
+			has.Flags |= a.Flags
+		} else {
+			folded[channel] = a
+		}
+	}
+	u.Channel[server] = folded
+}
+
+// clone deep copies the account.
+func (u *UserAccess) clone() *UserAccess {
+	c := &UserAccess{
+		Username: u.Username,
+		Password: append([]byte(nil), u.Password...),
+		Salt:     append([]byte(nil), u.Salt...),
+		Masks:    append([]irc.WildMask(nil), u.Masks...),
+		Server:   make(map[string]*Access, len(u.Server)),
+		Channel:  make(map[string]map[string]*Access, len(u.Channel)),
+		casemaps: u.casemaps,
+	}
+	if u.Global != nil {
+		global := *u.Global
+		c.Global = &global
+	}
+	for srv, a := range u.Server {
+		access := *a
+		c.Server[srv] = &access
+	}
+	for srv, chans := range u.Channel {
+		c.Channel[srv] = make(map[string]*Access, len(chans))
+		for ch, a := range chans {
+			access := *a
+			c.Channel[srv][ch] = &access
+		}
+	}
+	return c
+}
+
+// String returns a one-line representation of this account.
+func (u *UserAccess) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(u.Username)
+	if u.Global != nil && !u.Global.IsZero() {
+		buf.WriteString(" (")
+		buf.WriteString(u.Global.String())
+		buf.WriteString(")")
+	}
+	for _, mask := range u.Masks {
+		buf.WriteRune(' ')
+		buf.WriteString(string(mask))
+	}
+	return buf.String()
+}
+
+// hashPassword salts and hashes a password.
+func hashPassword(salt []byte, password string) []byte {
+	sum := sha256.Sum256(append(append([]byte(nil), salt...), password...))
+	for i := 1; i < nHashRounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum[:]
+}
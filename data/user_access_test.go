@@ -0,0 +1,115 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestUserAccess_Create(c *C) {
+	ua, err := CreateUserAccess("", "pass")
+	c.Check(ua, IsNil)
+	c.Check(err, Equals, errMissingUsername)
+	ua, err = CreateUserAccess("user", "")
+	c.Check(ua, IsNil)
+	c.Check(err, Equals, errMissingPassword)
+
+	ua, err = CreateUserAccess("user", "pass", "*!*@host", "")
+	c.Check(err, IsNil)
+	c.Check(ua.Username, Equals, "user")
+	c.Check(ua.Masks, DeepEquals, []irc.WildMask{"*!*@host"})
+	c.Check(ua.Password, Not(DeepEquals), []byte("pass"))
+}
+
+func (s *s) TestUserAccess_Password(c *C) {
+	ua, err := CreateUserAccess("user", "pass")
+	c.Check(err, IsNil)
+	c.Check(ua.VerifyPassword("pass"), Equals, true)
+	c.Check(ua.VerifyPassword("Pass"), Equals, false)
+	c.Check(ua.VerifyPassword(""), Equals, false)
+
+	other, _ := CreateUserAccess("other", "pass")
+	c.Check(other.Password, Not(DeepEquals), ua.Password)
+
+	c.Check(ua.SetPassword(""), Equals, errMissingPassword)
+	c.Check(ua.SetPassword("new"), IsNil)
+	c.Check(ua.VerifyPassword("pass"), Equals, false)
+	c.Check(ua.VerifyPassword("new"), Equals, true)
+}
+
+func (s *s) TestUserAccess_Masks(c *C) {
+	ua, _ := CreateUserAccess("user", "pass")
+	c.Check(ua.ValidateMask("nick!user@host"), Equals, true)
+
+	ua.AddMasks("*!*@host", "*!*@HOST", "nick!*@*")
+	c.Check(len(ua.Masks), Equals, 2)
+	c.Check(ua.ValidateMask("other!user@Host"), Equals, true)
+	c.Check(ua.ValidateMask("NICK!user@elsewhere"), Equals, true)
+	c.Check(ua.ValidateMask("other!user@elsewhere"), Equals, false)
+
+	ua.DelMasks("*!*@Host")
+	c.Check(ua.Masks, DeepEquals, []irc.WildMask{"nick!*@*"})
+	c.Check(ua.ValidateMask("other!user@host"), Equals, false)
+
+	ua.AddMasks("nick[a]!*@*")
+	c.Check(ua.ValidateMask("nick{a}!user@host"), Equals, false)
+	c.Check(ua.ValidateMaskFold("nick{a}!user@host", irc.CASEMAP_RFC1459),
+		Equals, true)
+}
+
+func (s *s) TestUserAccess_Grant(c *C) {
+	ua, _ := CreateUserAccess("user", "pass")
+	c.Check(ua.Has("srv", "#chan", 0), Equals, true)
+	c.Check(ua.Has("srv", "#chan", 1), Equals, false)
+
+	ua.GrantGlobal(10, "a")
+	ua.GrantServer("srv", 50, "b")
+	ua.GrantChannel("srv", "#Chan", 100, "c")
+	c.Check(ua.GetGlobal(), DeepEquals, CreateAccess(10, "a"))
+	c.Check(ua.GetServer("srv"), DeepEquals, CreateAccess(50, "b"))
+	c.Check(ua.GetChannel("srv", "#CHAN"), DeepEquals, CreateAccess(100, "c"))
+	c.Check(ua.GetServer("other"), IsNil)
+	c.Check(ua.GetChannel("other", "#chan"), IsNil)
+
+	c.Check(ua.Has("srv", "#chan", 100, "abc"), Equals, true)
+	c.Check(ua.Has("srv", "#other", 100), Equals, false)
+	c.Check(ua.Has("srv", "#other", 50, "ab"), Equals, true)
+	c.Check(ua.Has("srv", "#other", 50, "c"), Equals, false)
+	c.Check(ua.Has("srv", "", 50), Equals, true)
+	c.Check(ua.Has("other", "#chan", 10, "a"), Equals, true)
+	c.Check(ua.Has("other", "#chan", 11), Equals, false)
+
+	ua.GrantChannel("srv", "#chan", 5, "d")
+	c.Check(ua.GetChannel("srv", "#chan"), DeepEquals, CreateAccess(5, "cd"))
+
+	ua.RevokeChannel("srv", "#chan")
+	c.Check(ua.GetChannel("srv", "#chan"), IsNil)
+	c.Check(len(ua.Channel), Equals, 0)
+	ua.RevokeServer("srv")
+	c.Check(ua.GetServer("srv"), IsNil)
+	ua.RevokeGlobal()
+	c.Check(ua.GetGlobal(), IsNil)
+	c.Check(ua.Has("srv", "#chan", 1), Equals, false)
+}
+
+func (s *s) TestUserAccess_Clone(c *C) {
+	ua, _ := CreateUserAccess("user", "pass", "*!*@host")
+	ua.GrantGlobal(1, "a")
+	ua.GrantServer("srv", 2)
+	ua.GrantChannel("srv", "#chan", 3)
+
+	cl := ua.clone()
+	c.Check(cl, DeepEquals, ua)
+	cl.GrantGlobal(5)
+	cl.GrantChannel("srv", "#chan", 5)
+	cl.AddMasks("*!*@other")
+	c.Check(ua.GetGlobal().Level, Equals, uint8(1))
+	c.Check(ua.GetChannel("srv", "#chan").Level, Equals, uint8(3))
+	c.Check(len(ua.Masks), Equals, 1)
+}
+
+func (s *s) TestUserAccess_String(c *C) {
+	ua, _ := CreateUserAccess("user", "pass", "*!*@host")
+	c.Check(ua.String(), Equals, "user *!*@host")
+	ua.GrantGlobal(100, "ab")
+	c.Check(ua.String(), Equals, "user (100 ab) *!*@host")
+}
@@ -0,0 +1,428 @@
+package data
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// loginAttempts is how many logins from a host may fail before it has to
+	// wait between attempts.
+	loginAttempts = 3
+	// loginDelay is how long a host waits after it's first failed login past
+	// loginAttempts, the wait doubles with each failure after that.
+	loginDelay = 5 * time.Second
+	// loginMaxDelay is the longest a host has to wait between logins.
+	loginMaxDelay = 10 * time.Minute
+	// loginForget is how long after it's last failed login a host's failures
+	// are forgotten.
+	loginForget = time.Hour
+)
+
+var (
+	// errUserExists is given when adding an account that already exists.
+	errUserExists = errors.New("data: User already exists.")
+	// errUserNotFound is given when an account does not exist.
+	errUserNotFound = errors.New("data: User not found.")
+	// errBadPassword is given when a login uses the wrong password.
+	errBadPassword = errors.New("data: Invalid password.")
+	// errBadMask is given when a login comes from a host the account does
+	// not allow.
+	errBadMask = errors.New("data: Host is not allowed to log in.")
+	// errLoginThrottled is given when a host logs in again too soon after
+	// failing to log in too many times.
+	errLoginThrottled = errors.New("data: Too many failed logins, wait " +
+		"before trying again.")
+)
+
+// UserStore keeps registered accounts and tracks which hosts are logged in to
+// them on each server. Accounts are written to a file each time they change,
+// logins are not and only last as long as the UserStore. It is safe to use
+// from multiple goroutines.
+type UserStore struct {
+	filename string
+	accounts map[string]*UserAccess
+	authed   map[string]map[string]string
+	casemaps map[string]irc.Casemap
+	failures map[string]*loginFailures
+
+	protect sync.RWMutex
+}
+
+// CreateUserStore creates a user store that keeps it's accounts in the given
+// file, loading any that are already there. If the filename is empty the
+// accounts are only kept in memory.
+func CreateUserStore(filename string) (*UserStore, error) {
+	s := &UserStore{
+		filename: filename,
+		accounts: make(map[string]*UserAccess),
+		authed:   make(map[string]map[string]string),
+		failures: make(map[string]*loginFailures),
+	}
+
+	if len(filename) == 0 {
+		return s, nil
+	}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var accounts []*UserAccess
+	if err = gob.NewDecoder(file).Decode(&accounts); err != nil {
+		return nil, err
+	}
+	for _, ua := range accounts {
+		s.accounts[strings.ToLower(ua.Username)] = ua
+	}
+
+	return s, nil
+}
+
+// Save writes the accounts to the user store's file. This is done each time
+// the accounts are changed through the user store.
+func (s *UserStore) Save() error {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+	return s.save()
+}
+
+// save writes the accounts to a temporary file and then moves it over the
+// old one so a failed write never leaves a broken file behind.
+func (s *UserStore) save() error {
+	if len(s.filename) == 0 {
+		return nil
+	}
+
+	accounts := make([]*UserAccess, 0, len(s.accounts))
+	for _, ua := range s.accounts {
+		accounts = append(accounts, ua)
+	}
+
+	tmp := s.filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(accounts); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+// AddUser adds an account to the user store.
+func (s *UserStore) AddUser(ua *UserAccess) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	name := strings.ToLower(ua.Username)
+	if _, ok := s.accounts[name]; ok {
+		return errUserExists
+	}
+	ua = ua.clone()
+	for server := range s.casemaps {
+		ua.refold(s.casemaps, server)
+	}
+	s.accounts[name] = ua
+	return s.save()
+}
+
+// RemoveUser removes an account from the user store, logging it out
+// everywhere. The returned boolean is whether or not the account was found.
+func (s *UserStore) RemoveUser(username string) (bool, error) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	name := strings.ToLower(username)
+	if _, ok := s.accounts[name]; !ok {
+		return false, nil
+	}
+	delete(s.accounts, name)
+	for _, hosts := range s.authed {
+		for host, authed := range hosts {
+			if authed == name {
+				delete(hosts, host)
+			}
+		}
+	}
+	return true, s.save()
+}
+
+// UpdateUser calls a callback to modify an account and saves the result.
+func (s *UserStore) UpdateUser(username string, fn func(*UserAccess)) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	ua, ok := s.accounts[strings.ToLower(username)]
+	if !ok {
+		return errUserNotFound
+	}
+	fn(ua)
+	return s.save()
+}
+
+// GetUser returns a copy of an account, nil if it does not exist.
+func (s *UserStore) GetUser(username string) *UserAccess {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	if ua, ok := s.accounts[strings.ToLower(username)]; ok {
+		return ua.clone()
+	}
+	return nil
+}
+
+// GetNUsers returns the number of accounts in the user store.
+func (s *UserStore) GetNUsers() int {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+	return len(s.accounts)
+}
+
+// EachUser iterates through copies of the accounts.
+func (s *UserStore) EachUser(fn func(*UserAccess)) {
+	s.protect.RLock()
+	accounts := make([]*UserAccess, 0, len(s.accounts))
+	for _, ua := range s.accounts {
+		accounts = append(accounts, ua.clone())
+	}
+	s.protect.RUnlock()
+
+	for _, ua := range accounts {
+		fn(ua)
+	}
+}
+
+// loginFailures counts the failed logins from a host.
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// Login logs the given mask in to an account on a server. The returned user
+// is authenticated. After loginAttempts failed logins from the same user@host
+// it has to wait longer and longer before it can try again, even with the
+// right password. The errors say why a login failed so they should be logged,
+// not shown to the user.
+func (s *UserStore) Login(server string, mask irc.Mask,
+	username, password string) (*User, error) {
+
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	now := time.Now()
+	key := s.failureKey(server, mask)
+	s.forgetFailures(now)
+	if f, ok := s.failures[key]; ok && now.Before(f.until) {
+		return nil, errLoginThrottled
+	}
+
+	name := strings.ToLower(username)
+	ua, ok := s.accounts[name]
+	if !ok {
+		s.fail(key, now)
+		return nil, errUserNotFound
+	}
+	if !ua.ValidateMaskFold(mask, s.casemaps[server]) {
+		s.fail(key, now)
+		return nil, errBadMask
+	}
+	if !ua.VerifyPassword(password) {
+		s.fail(key, now)
+		return nil, errBadPassword
+	}
+	delete(s.failures, key)
+
+	hosts, ok := s.authed[server]
+	if !ok {
+		hosts = make(map[string]string)
+		s.authed[server] = hosts
+	}
+	hosts[s.fold(server, string(mask))] = name
+
+	return createAuthedUser(mask, ua), nil
+}
+
+// Logout logs the given mask out on a server. The returned boolean is whether
+// or not the mask was logged in.
+func (s *UserStore) Logout(server string, mask irc.Mask) bool {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	host := s.fold(server, string(mask))
+	if hosts, ok := s.authed[server]; ok {
+		if _, ok = hosts[host]; ok {
+			delete(hosts, host)
+			return true
+		}
+	}
+	return false
+}
+
+// Protocaps sets the casemap masks and channels on a server are compared with,
+// from the server's protocaps.
+func (s *UserStore) Protocaps(server string, caps *irc.ProtoCaps) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	casemap := caps.Casemap()
+	casemaps := make(map[string]irc.Casemap, len(s.casemaps)+1)
+	for srv, c := range s.casemaps {
+		casemaps[srv] = c
+	}
+	casemaps[server] = casemap
+	s.casemaps = casemaps
+
+	for _, ua := range s.accounts {
+		ua.refold(casemaps, server)
+	}
+	if hosts, ok := s.authed[server]; ok {
+		folded := make(map[string]string, len(hosts))
+		for host, name := range hosts {
+			folded[casemap.Fold(host)] = name
+		}
+		s.authed[server] = folded
+	}
+}
+
+// fold folds a mask with the casemap of a server. Not thread safe.
+func (s *UserStore) fold(server, mask string) string {
+	return s.casemaps[server].Fold(mask)
+}
+
+// failureKey returns the key the failed logins of a mask are counted under,
+// it's user@host so changing nicks doesn't start the count over.
+// Not thread safe.
+func (s *UserStore) failureKey(server string, mask irc.Mask) string {
+	_, username, host := mask.SplitFullhost()
+	return server + " " + s.fold(server, username+"@"+host)
+}
+
+// fail counts a failed login, once there have been loginAttempts the next
+// login has to wait. Not thread safe.
+func (s *UserStore) fail(key string, now time.Time) {
+	f, ok := s.failures[key]
+	if !ok {
+		f = &loginFailures{}
+		s.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < loginAttempts {
+		return
+	}
+
+	delay := loginDelay
+	for i := loginAttempts; i < f.count && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	f.until = now.Add(delay)
+}
+
+// forgetFailures drops the failed logins of hosts that haven't failed to log
+// in for loginForget. Not thread safe.
+func (s *UserStore) forgetFailures(now time.Time) {
+	for key, f := range s.failures {
+		if now.Sub(f.last) > loginForget {
+			delete(s.failures, key)
+		}
+	}
+}
+
+// LogoutServer logs out everyone on a server.
+func (s *UserStore) LogoutServer(server string) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	delete(s.authed, server)
+}
+
+// Rename keeps a login when a logged in mask changes it's nickname.
+func (s *UserStore) Rename(server string, mask irc.Mask, nick string) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	hosts, ok := s.authed[server]
+	if !ok {
+		return
+	}
+	host := s.fold(server, string(mask))
+	name, ok := hosts[host]
+	if !ok {
+		return
+	}
+	delete(hosts, host)
+
+	_, user, hostname := mask.SplitFullhost()
+	renamed := nick
+	if len(user) > 0 || len(hostname) > 0 {
+		renamed += "!" + user + "@" + hostname
+	}
+	hosts[s.fold(server, renamed)] = name
+}
+
+// GetAuthedUser returns the authenticated user for the given mask on a server,
+// nil if the mask is not logged in.
+func (s *UserStore) GetAuthedUser(server string, mask irc.Mask) *User {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	if ua := s.getAuthed(server, mask); ua != nil {
+		return createAuthedUser(mask, ua)
+	}
+	return nil
+}
+
+// CheckAccess checks if the given mask is logged in on a server and has at
+// least the given level and all of the given flags on the channel. The
+// channel may be empty to check only global and server access. The
+// authenticated user is returned whenever the mask is logged in.
+func (s *UserStore) CheckAccess(server, channel string, mask irc.Mask,
+	level uint8, flags ...string) (*User, bool) {
+
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+
+	ua := s.getAuthed(server, mask)
+	if ua == nil {
+		return nil, false
+	}
+	return createAuthedUser(mask, ua), ua.Has(server, channel, level, flags...)
+}
+
+// getAuthed looks up the account the mask is logged in to.
+func (s *UserStore) getAuthed(server string, mask irc.Mask) *UserAccess {
+	if hosts, ok := s.authed[server]; ok {
+		if name, ok := hosts[s.fold(server, string(mask))]; ok {
+			return s.accounts[name]
+		}
+	}
+	return nil
+}
+
+// createAuthedUser creates a user that carries a copy of it's account.
+func createAuthedUser(mask irc.Mask, ua *UserAccess) *User {
+	u := CreateUser(string(mask))
+	if u != nil {
+		u.access = ua.clone()
+	}
+	return u
+}
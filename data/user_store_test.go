@@ -0,0 +1,235 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func createTestUserStore(c *C, filename string) *UserStore {
+	store, err := CreateUserStore(filename)
+	c.Assert(err, IsNil)
+	ua, err := CreateUserAccess("User", "pass", "*!*@host")
+	c.Assert(err, IsNil)
+	ua.GrantServer("srv", 50)
+	ua.GrantChannel("srv", "#chan", 100, "o")
+	c.Assert(store.AddUser(ua), IsNil)
+	return store
+}
+
+func (s *s) TestUserStore_Users(c *C) {
+	store := createTestUserStore(c, "")
+	c.Check(store.GetNUsers(), Equals, 1)
+
+	ua, _ := CreateUserAccess("user", "other")
+	c.Check(store.AddUser(ua), Equals, errUserExists)
+
+	got := store.GetUser("USER")
+	c.Check(got, NotNil)
+	c.Check(got.Username, Equals, "User")
+	got.GrantGlobal(255)
+	c.Check(store.GetUser("user").GetGlobal(), IsNil)
+
+	c.Check(store.UpdateUser("nobody", func(*UserAccess) {}), Equals,
+		errUserNotFound)
+	err := store.UpdateUser("user", func(u *UserAccess) { u.GrantGlobal(1) })
+	c.Check(err, IsNil)
+	c.Check(store.GetUser("user").GetGlobal().Level, Equals, uint8(1))
+
+	n := 0
+	store.EachUser(func(u *UserAccess) {
+		c.Check(u.Username, Equals, "User")
+		n++
+	})
+	c.Check(n, Equals, 1)
+
+	found, err := store.RemoveUser("nobody")
+	c.Check(found, Equals, false)
+	found, err = store.RemoveUser("user")
+	c.Check(found, Equals, true)
+	c.Check(err, IsNil)
+	c.Check(store.GetUser("user"), IsNil)
+}
+
+func (s *s) TestUserStore_Persist(c *C) {
+	filename := filepath.Join(c.MkDir(), "users.db")
+	store := createTestUserStore(c, filename)
+	_, err := store.Login("srv", "nick!user@host", "user", "pass")
+	c.Check(err, IsNil)
+
+	loaded, err := CreateUserStore(filename)
+	c.Assert(err, IsNil)
+	c.Check(loaded.GetNUsers(), Equals, 1)
+	ua := loaded.GetUser("user")
+	c.Check(ua, DeepEquals, store.GetUser("user"))
+	c.Check(ua.VerifyPassword("pass"), Equals, true)
+	c.Check(loaded.GetAuthedUser("srv", "nick!user@host"), IsNil)
+
+	found, err := loaded.RemoveUser("user")
+	c.Check(found, Equals, true)
+	c.Check(err, IsNil)
+	loaded, err = CreateUserStore(filename)
+	c.Assert(err, IsNil)
+	c.Check(loaded.GetNUsers(), Equals, 0)
+
+	_, err = os.Stat(filename + ".tmp")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *s) TestUserStore_BadFile(c *C) {
+	filename := filepath.Join(c.MkDir(), "users.db")
+	file, err := os.Create(filename)
+	c.Assert(err, IsNil)
+	file.WriteString("garbage")
+	file.Close()
+
+	store, err := CreateUserStore(filename)
+	c.Check(store, IsNil)
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestUserStore_Login(c *C) {
+	store := createTestUserStore(c, "")
+	mask := irc.Mask("nick!user@host")
+
+	_, err := store.Login("srv", mask, "nobody", "pass")
+	c.Check(err, Equals, errUserNotFound)
+	_, err = store.Login("srv", mask, "user", "wrong")
+	c.Check(err, Equals, errBadPassword)
+	_, err = store.Login("srv", "nick!user@elsewhere", "user", "pass")
+	c.Check(err, Equals, errBadMask)
+	c.Check(store.GetAuthedUser("srv", mask), IsNil)
+
+	user, err := store.Login("srv", mask, "user", "pass")
+	c.Check(err, IsNil)
+	c.Check(user.GetNick(), Equals, "nick")
+	c.Check(user.IsLoggedIn(), Equals, true)
+	c.Check(user.GetUserAccess().Username, Equals, "User")
+
+	user = store.GetAuthedUser("srv", "NICK!user@host")
+	c.Check(user, NotNil)
+	c.Check(store.GetAuthedUser("other", mask), IsNil)
+
+	c.Check(store.Logout("srv", mask), Equals, true)
+	c.Check(store.Logout("srv", mask), Equals, false)
+	c.Check(store.GetAuthedUser("srv", mask), IsNil)
+}
+
+func (s *s) TestUserStore_LoginThrottle(c *C) {
+	store := createTestUserStore(c, "")
+	mask := irc.Mask("nick!user@host")
+
+	for i := 0; i < loginAttempts; i++ {
+		_, err := store.Login("srv", mask, "user", "wrong")
+		c.Check(err, Equals, errBadPassword)
+	}
+
+	// Changing nicks doesn't help, and neither does the right password.
+	_, err := store.Login("srv", "other!user@host", "user", "pass")
+	c.Check(err, Equals, errLoginThrottled)
+	_, err = store.Login("srv", "nick!user@elsewhere", "nobody", "pass")
+	c.Check(err, Equals, errUserNotFound)
+
+	key := store.failureKey("srv", mask)
+	c.Check(store.failures[key].until.Sub(store.failures[key].last),
+		Equals, loginDelay)
+	store.failures[key].until = time.Now()
+	_, err = store.Login("srv", mask, "user", "wrong")
+	c.Check(err, Equals, errBadPassword)
+	c.Check(store.failures[key].until.Sub(store.failures[key].last),
+		Equals, 2*loginDelay)
+
+	store.failures[key].until = time.Now()
+	_, err = store.Login("srv", mask, "user", "pass")
+	c.Check(err, IsNil)
+	_, ok := store.failures[key]
+	c.Check(ok, Equals, false)
+
+	// Old failures are forgotten.
+	store.Login("srv", mask, "user", "wrong")
+	store.failures[key].last = time.Now().Add(-2 * loginForget)
+	store.forgetFailures(time.Now())
+	c.Check(store.failures, HasLen, 1)
+}
+
+func (s *s) TestUserStore_Sessions(c *C) {
+	store := createTestUserStore(c, "")
+	mask := irc.Mask("nick!user@host")
+	_, err := store.Login("srv", mask, "user", "pass")
+	c.Check(err, IsNil)
+
+	store.Rename("srv", mask, "newnick")
+	c.Check(store.GetAuthedUser("srv", mask), IsNil)
+	c.Check(store.GetAuthedUser("srv", "newnick!user@host"), NotNil)
+	store.Rename("srv", "stranger!a@b", "x")
+	store.Rename("other", mask, "x")
+
+	store.LogoutServer("srv")
+	c.Check(store.GetAuthedUser("srv", "newnick!user@host"), IsNil)
+
+	_, err = store.Login("srv", mask, "user", "pass")
+	c.Check(err, IsNil)
+	store.RemoveUser("user")
+	c.Check(store.GetAuthedUser("srv", mask), IsNil)
+}
+
+func (s *s) TestUserStore_CheckAccess(c *C) {
+	store := createTestUserStore(c, "")
+	mask := irc.Mask("nick!user@host")
+
+	user, ok := store.CheckAccess("srv", "#chan", mask, 0)
+	c.Check(user, IsNil)
+	c.Check(ok, Equals, false)
+
+	store.Login("srv", mask, "user", "pass")
+	user, ok = store.CheckAccess("srv", "#CHAN", mask, 100, "o")
+	c.Check(user, NotNil)
+	c.Check(ok, Equals, true)
+	user, ok = store.CheckAccess("srv", "#other", mask, 100)
+	c.Check(user, NotNil)
+	c.Check(ok, Equals, false)
+	_, ok = store.CheckAccess("srv", "", mask, 50)
+	c.Check(ok, Equals, true)
+
+	// Changes to an account take effect for users already logged in.
+	store.UpdateUser("user", func(u *UserAccess) { u.RevokeServer("srv") })
+	_, ok = store.CheckAccess("srv", "", mask, 50)
+	c.Check(ok, Equals, false)
+}
+
+func (s *s) TestUserStore_Casemap(c *C) {
+	store := createTestUserStore(c, "")
+	store.UpdateUser("user", func(u *UserAccess) {
+		u.AddMasks("nick[1]!*@*")
+		u.GrantChannel("srv", "#chan[1]", 75)
+	})
+
+	mask := irc.Mask("nick{1}!user@elsewhere")
+	_, err := store.Login("srv", mask, "user", "pass")
+	c.Check(err, Equals, errBadMask)
+
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{
+		Args: []string{"nick", "CASEMAPPING=rfc1459"},
+	})
+	store.Protocaps("srv", caps)
+
+	_, err = store.Login("srv", mask, "user", "pass")
+	c.Check(err, IsNil)
+	c.Check(store.GetAuthedUser("srv", "Nick[1]!user@elsewhere"), NotNil)
+	_, ok := store.CheckAccess("srv", "#CHAN{1}", mask, 75)
+	c.Check(ok, Equals, true)
+	_, err = store.Login("other", mask, "user", "pass")
+	c.Check(err, Equals, errBadMask)
+
+	store.Rename("srv", "NICK[1]!user@elsewhere", "nick^")
+	c.Check(store.GetAuthedUser("srv", "nick~!user@elsewhere"), NotNil)
+
+	ua, err := CreateUserAccess("added", "pass")
+	c.Assert(err, IsNil)
+	ua.GrantChannel("srv", "#new[]", 10)
+	c.Check(store.AddUser(ua), IsNil)
+	c.Check(store.GetUser("added").GetChannel("srv", "#NEW{}"), NotNil)
+}
@@ -28,6 +28,17 @@ func (s *s) TestUser_Realname(c *C) {
 	c.Check(u.GetRealname(), Equals, "realname realname")
 }
 
+func (s *s) TestUser_Accounts(c *C) {
+	u := CreateUser("nick!user@host")
+	u.setAccount("services")
+	c.Check(u.GetAccount(), Equals, "services")
+	c.Check(u.IsLoggedIn(), Equals, false)
+	c.Check(u.GetUserAccess(), IsNil)
+
+	u.setAccount("*")
+	c.Check(u.GetAccount(), Equals, "")
+}
+
 func (s *s) TestUser_String(c *C) {
 	u := CreateUser("nick")
 	str := fmt.Sprint(u)
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Whois is the aggregated reply to a WHOIS.
+type Whois struct {
+	// Found is false if the server replied ERR_NOSUCHNICK.
+	Found bool
+
+	Nick       string
+	Username   string
+	Host       string
+	Realname   string
+	Server     string
+	ServerInfo string
+	Account    string
+	AwayMsg    string
+	IsAway     bool
+	IsOperator bool
+	IsSecure   bool
+	Idle       time.Duration
+	Signon     time.Time
+	// Channels are given as they are sent by the server, with prefixes.
+	Channels []string
+}
+
+// CreateWhois creates an empty WHOIS reply for a nick.
+func CreateWhois(nick string) *Whois {
+	return &Whois{Nick: nick}
+}
+
+// Update adds a message to the WHOIS reply. The message must be about the nick
+// the reply is for. Returns true when the reply is complete.
+func (w *Whois) Update(m *irc.IrcMessage) (done bool) {
+	switch m.Name {
+	case irc.RPL_WHOISUSER:
+		if len(m.Args) > 5 {
+			w.Found = true
+			w.Nick = m.Args[1]
+			w.Username, w.Host, w.Realname = m.Args[2], m.Args[3], m.Args[5]
+		}
+	case irc.RPL_WHOISSERVER:
+		if len(m.Args) > 3 {
+			w.Server, w.ServerInfo = m.Args[2], m.Args[3]
+		}
+	case irc.RPL_WHOISOPERATOR:
+		w.IsOperator = true
+	case irc.RPL_WHOISSECURE:
+		w.IsSecure = true
+	case irc.RPL_WHOISIDLE:
+		w.Idle, w.Signon = parseIdle(m.Args)
+	case irc.RPL_WHOISCHANNELS:
+		if len(m.Args) > 2 {
+			w.Channels = append(w.Channels, strings.Fields(m.Args[2])...)
+		}
+	case irc.RPL_WHOISACCOUNT:
+		if len(m.Args) > 2 {
+			w.Account = m.Args[2]
+		}
+	case irc.RPL_AWAY:
+		if len(m.Args) > 2 {
+			w.IsAway, w.AwayMsg = true, m.Args[2]
+		}
+	case irc.ERR_NOSUCHNICK:
+		w.Found = false
+	case irc.RPL_ENDOFWHOIS:
+		return true
+	}
+	return false
+}
+
+// Whowas is a single entry in the reply to a WHOWAS.
+type Whowas struct {
+	Nick       string
+	Username   string
+	Host       string
+	Realname   string
+	Server     string
+	ServerInfo string
+}
+
+// WhowasReply is the aggregated reply to a WHOWAS, it has an entry for each
+// time the nick was used, newest first. It is empty if the server replied
+// ERR_WASNOSUCHNICK.
+type WhowasReply struct {
+	Nick    string
+	Entries []*Whowas
+}
+
+// CreateWhowasReply creates an empty WHOWAS reply for a nick.
+func CreateWhowasReply(nick string) *WhowasReply {
+	return &WhowasReply{Nick: nick}
+}
+
+// Update adds a message to the WHOWAS reply. The message must be about the
+// nick the reply is for. Returns true when the reply is complete.
+func (w *WhowasReply) Update(m *irc.IrcMessage) (done bool) {
+	switch m.Name {
+	case irc.RPL_WHOWASUSER:
+		if len(m.Args) > 5 {
+			w.Entries = append(w.Entries, &Whowas{
+				Nick:     m.Args[1],
+				Username: m.Args[2],
+				Host:     m.Args[3],
+				Realname: m.Args[5],
+			})
+		}
+	case irc.RPL_WHOISSERVER:
+		if len(m.Args) > 3 && len(w.Entries) > 0 {
+			entry := w.Entries[len(w.Entries)-1]
+			entry.Server, entry.ServerInfo = m.Args[2], m.Args[3]
+		}
+	case irc.RPL_ENDOFWHOWAS:
+		return true
+	}
+	return false
+}
+
+// parseIdle reads the idle seconds and optional signon time from a
+// RPL_WHOISIDLE.
+func parseIdle(args []string) (idle time.Duration, signon time.Time) {
+	if len(args) < 3 {
+		return
+	}
+	if secs, err := strconv.ParseUint(args[2], 10, 32); err == nil {
+		idle = time.Duration(secs) * time.Second
+	}
+	if len(args) > 4 {
+		if unix, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+			signon = time.Unix(unix, 0)
+		}
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func whoisMsg(name string, args ...string) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: name, Sender: server,
+		Args: append([]string{"me"}, args...)}
+}
+
+func (s *s) TestWhois(c *C) {
+	w := CreateWhois("NICK1")
+	msgs := []*irc.IrcMessage{
+		whoisMsg(irc.RPL_WHOISUSER, "nick1", "user1", "host1", "*", "real"),
+		whoisMsg(irc.RPL_WHOISSERVER, "nick1", "hub.server.net", "The hub"),
+		whoisMsg(irc.RPL_WHOISOPERATOR, "nick1", "is an IRC operator"),
+		whoisMsg(irc.RPL_WHOISSECURE, "nick1", "is using a secure connection"),
+		whoisMsg(irc.RPL_WHOISIDLE, "nick1", "5", "1370000000", "idle"),
+		whoisMsg(irc.RPL_WHOISCHANNELS, "nick1", "@#chan1 #chan2"),
+		whoisMsg(irc.RPL_WHOISCHANNELS, "nick1", "+#chan3"),
+		whoisMsg(irc.RPL_WHOISACCOUNT, "nick1", "acct", "is logged in as"),
+		whoisMsg(irc.RPL_AWAY, "nick1", "gone"),
+	}
+	for _, m := range msgs {
+		c.Check(w.Update(m), Equals, false)
+	}
+	c.Check(w.Update(whoisMsg(irc.RPL_ENDOFWHOIS, "NICK1", "End")), Equals,
+		true)
+
+	c.Check(w.Found, Equals, true)
+	c.Check(w.Nick, Equals, "nick1")
+	c.Check(w.Username, Equals, "user1")
+	c.Check(w.Host, Equals, "host1")
+	c.Check(w.Realname, Equals, "real")
+	c.Check(w.Server, Equals, "hub.server.net")
+	c.Check(w.ServerInfo, Equals, "The hub")
+	c.Check(w.IsOperator, Equals, true)
+	c.Check(w.IsSecure, Equals, true)
+	c.Check(w.Idle, Equals, 5*time.Second)
+	c.Check(w.Signon.Unix(), Equals, int64(1370000000))
+	c.Check(w.Channels, DeepEquals, []string{"@#chan1", "#chan2", "+#chan3"})
+	c.Check(w.Account, Equals, "acct")
+	c.Check(w.IsAway, Equals, true)
+	c.Check(w.AwayMsg, Equals, "gone")
+
+	w = CreateWhois("nobody")
+	c.Check(w.Update(whoisMsg(irc.ERR_NOSUCHNICK, "nobody", "No such nick")),
+		Equals, false)
+	c.Check(w.Update(whoisMsg(irc.RPL_ENDOFWHOIS, "nobody", "End")), Equals,
+		true)
+	c.Check(w.Found, Equals, false)
+	c.Check(w.Nick, Equals, "nobody")
+}
+
+func (s *s) TestWhowas(c *C) {
+	w := CreateWhowasReply("nick1")
+	msgs := []*irc.IrcMessage{
+		whoisMsg(irc.RPL_WHOWASUSER, "nick1", "user1", "host1", "*", "real1"),
+		whoisMsg(irc.RPL_WHOISSERVER, "nick1", "a.server.net", "Mon Jun 3"),
+		whoisMsg(irc.RPL_WHOWASUSER, "nick1", "user2", "host2", "*", "real2"),
+		whoisMsg(irc.RPL_WHOISSERVER, "nick1", "b.server.net", "Sun Jun 2"),
+	}
+	for _, m := range msgs {
+		c.Check(w.Update(m), Equals, false)
+	}
+	c.Check(w.Update(whoisMsg(irc.RPL_ENDOFWHOWAS, "nick1", "End")), Equals,
+		true)
+
+	c.Assert(w.Entries, HasLen, 2)
+	c.Check(*w.Entries[0], Equals, Whowas{"nick1", "user1", "host1", "real1",
+		"a.server.net", "Mon Jun 3"})
+	c.Check(*w.Entries[1], Equals, Whowas{"nick1", "user2", "host2", "real2",
+		"b.server.net", "Sun Jun 2"})
+
+	w = CreateWhowasReply("nobody")
+	w.Update(whoisMsg(irc.ERR_WASNOSUCHNICK, "nobody", "There was no such"))
+	c.Check(w.Update(whoisMsg(irc.RPL_ENDOFWHOWAS, "nobody", "End")), Equals,
+		true)
+	c.Check(w.Entries, HasLen, 0)
+}
@@ -0,0 +1,241 @@
+package dcc
+
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/inet"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/parse"
+	"net"
+	"time"
+)
+
+// Chat is a DCC CHAT session. Lines are read with ReadLine and written with
+// Write or through the Endpoint.
+type Chat struct {
+	client *inet.IrcClient
+	key    string
+	sender string
+}
+
+// OfferChat offers a chat to target and waits for them to accept it. The
+// target should be the full nick!user@host of the user, it becomes the sender
+// of the chat and only connections from it's host are accepted.
+func (m *Manager) OfferChat(ep irc.Endpoint, target string) (*Chat, error) {
+	nick := irc.Mask(target).GetNick()
+	offer := &Offer{Type: DCC_CHAT}
+
+	allowed, err := m.peerIPs(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.conf.Passive {
+		offer.IP = m.conf.PublicIP
+		if offer.Token, err = m.token(); err != nil {
+			return nil, err
+		}
+		key := m.tokenKey(ep.GetKey(), nick, offer.Token)
+		replies := m.wait(key)
+		defer m.done(key)
+
+		if err = ep.Privmsg(nick, offer.String()); err != nil {
+			return nil, err
+		}
+
+		select {
+		case reply := <-replies:
+			conn, err := m.dialReply(reply, allowed)
+			if err != nil {
+				return nil, err
+			}
+			return createChat(conn, ep.GetKey(), reply.Sender), nil
+		case <-time.After(m.conf.Timeout):
+			return nil, errTimeout
+		}
+	}
+
+	if offer.IP, err = m.publicIP(); err != nil {
+		return nil, err
+	}
+	l, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+	offer.Port = listenPort(l)
+
+	if err = ep.Privmsg(nick, offer.String()); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	conn, err := m.accept(l, allowed)
+	if err != nil {
+		return nil, err
+	}
+	return createChat(conn, ep.GetKey(), target), nil
+}
+
+// AcceptChat accepts a chat offered by another user.
+func (m *Manager) AcceptChat(ep irc.Endpoint, offer *Offer) (*Chat, error) {
+	if !offer.IsPassive() {
+		conn, err := m.dial(offer)
+		if err != nil {
+			return nil, err
+		}
+		return createChat(conn, ep.GetKey(), offer.Sender), nil
+	}
+
+	ip, err := m.publicIP()
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := m.peerIPs(offer.Sender)
+	if err != nil {
+		return nil, err
+	}
+	l, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &Offer{
+		Type:  DCC_CHAT,
+		IP:    ip,
+		Port:  listenPort(l),
+		Token: offer.Token,
+	}
+	if err = ep.Privmsg(offer.Nick(), reply.String()); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	conn, err := m.accept(l, allowed)
+	if err != nil {
+		return nil, err
+	}
+	return createChat(conn, ep.GetKey(), offer.Sender), nil
+}
+
+// createChat starts a chat session on a connection. The key is the key of
+// the server the chat was set up on and sender is the other side of it.
+func createChat(conn net.Conn, key, sender string) *Chat {
+	c := &Chat{
+		client: inet.CreateIrcClient(&lineConn{Conn: conn},
+			"dcc:"+irc.Mask(sender).GetNick()),
+		key:    key,
+		sender: sender,
+	}
+	c.client.SpawnWorkers(true, true)
+	return c
+}
+
+// Key returns the key of the server the chat was set up on.
+func (c *Chat) Key() string {
+	return c.key
+}
+
+// Sender returns the nick or fullhost of the other side of the chat.
+func (c *Chat) Sender() string {
+	return c.sender
+}
+
+// Nick returns the nick of the other side of the chat.
+func (c *Chat) Nick() string {
+	return irc.Mask(c.sender).GetNick()
+}
+
+// ReadLine reads the next line of the chat, false once the chat is closed.
+func (c *Chat) ReadLine() (string, bool) {
+	line, ok := c.client.ReadMessage()
+	return string(line), ok
+}
+
+// Write writes lines to the chat.
+func (c *Chat) Write(buf []byte) (int, error) {
+	return c.client.Write(buf)
+}
+
+// Close ends the chat.
+func (c *Chat) Close() error {
+	return c.client.Close()
+}
+
+// Endpoint returns an irc.Endpoint for replying into the chat. The text of
+// any privmsg or notice written to it is said in the chat and other irc
+// messages are dropped, so handlers can reply as they would on a server.
+func (c *Chat) Endpoint() *ChatEndpoint {
+	return &ChatEndpoint{&irc.Helper{Writer: chatWriter{c}}, c}
+}
+
+// ChatEndpoint implements the irc.Endpoint interface for a chat.
+type ChatEndpoint struct {
+	*irc.Helper
+	chat *Chat
+}
+
+// GetKey returns the key of the server the chat was set up on, so user access
+// is looked up the same as it would be for the server.
+func (c *ChatEndpoint) GetKey() string {
+	return c.chat.key
+}
+
+// Chat returns the chat the endpoint writes to.
+func (c *ChatEndpoint) Chat() *Chat {
+	return c.chat
+}
+
+// chatWriter turns the irc messages written to an endpoint into chat lines.
+type chatWriter struct {
+	chat *Chat
+}
+
+// Write writes the text of each privmsg or notice in buf to the chat.
+func (w chatWriter) Write(buf []byte) (int, error) {
+	for _, line := range bytes.Split(buf, []byte{'\n'}) {
+		msg, err := parse.Parse(line)
+		if err != nil || len(msg.Args) < 2 ||
+			(msg.Name != irc.PRIVMSG && msg.Name != irc.NOTICE) {
+
+			continue
+		}
+		if _, err = w.chat.Write([]byte(msg.Args[1])); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+// lineConn makes lines ending in a bare \n end in \r\n, since many clients
+// don't send the \r and inet splits lines on \r\n.
+type lineConn struct {
+	net.Conn
+	buf []byte
+	out []byte
+	cr  bool
+}
+
+// Read reads from the connection, adding a \r in front of any \n without one.
+func (l *lineConn) Read(p []byte) (int, error) {
+	if len(l.out) == 0 {
+		if len(l.buf) < len(p) {
+			l.buf = make([]byte, len(p))
+		}
+
+		n, err := l.Conn.Read(l.buf[:len(p)])
+		l.out = l.out[:0]
+		for _, b := range l.buf[:n] {
+			if b == '\n' && !l.cr {
+				l.out = append(l.out, '\r')
+			}
+			l.out = append(l.out, b)
+			l.cr = b == '\r'
+		}
+		if len(l.out) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
@@ -0,0 +1,195 @@
+package dcc
+
+import (
+	"bufio"
+	"github.com/aarondl/ultimateq/irc"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"time"
+)
+
+// checkChat checks lines go both ways between a chat and the other side.
+func checkChat(c *C, chat *Chat, conn net.Conn) {
+	defer chat.Close()
+	defer conn.Close()
+	c.Check(chat.Key(), Equals, "server")
+	c.Check(chat.Nick(), Equals, "nick")
+
+	_, err := conn.Write([]byte("hello\nthere\r\n"))
+	c.Assert(err, IsNil)
+	line, ok := chat.ReadLine()
+	c.Check(ok, Equals, true)
+	c.Check(line, Equals, "hello")
+	line, ok = chat.ReadLine()
+	c.Check(ok, Equals, true)
+	c.Check(line, Equals, "there")
+
+	ep := chat.Endpoint()
+	c.Check(ep.GetKey(), Equals, "server")
+	c.Check(ep.Chat(), Equals, chat)
+	c.Check(ep.Notice("nick", "a reply"), IsNil)
+	c.Check(ep.Join("#chan"), IsNil)
+	c.Check(ep.Privmsg("nick", "another"), IsNil)
+
+	read := bufio.NewReader(conn)
+	str, err := read.ReadString('\n')
+	c.Check(err, IsNil)
+	c.Check(str, Equals, "a reply\r\n")
+	str, err = read.ReadString('\n')
+	c.Check(err, IsNil)
+	c.Check(str, Equals, "another\r\n")
+
+	conn.Close()
+	_, ok = chat.ReadLine()
+	c.Check(ok, Equals, false)
+}
+
+func (s *s) TestManager_OfferChat(c *C) {
+	m := testManager(false)
+	ep := makeTestPoint()
+
+	chats := make(chan *Chat)
+	go func() {
+		chat, err := m.OfferChat(ep, testSender)
+		c.Check(err, IsNil)
+		chats <- chat
+	}()
+
+	offer := ep.offer(c)
+	c.Check(offer.Type, Equals, DCC_CHAT)
+	c.Check(offer.IsPassive(), Equals, false)
+	conn, err := net.Dial("tcp", offer.Addr())
+	c.Assert(err, IsNil)
+
+	chat := <-chats
+	c.Assert(chat, NotNil)
+	c.Check(chat.Sender(), Equals, testSender)
+	checkChat(c, chat, conn)
+}
+
+func (s *s) TestManager_OfferChatPassive(c *C) {
+	m := testManager(true)
+	m.conf.AnyPeer = true
+	ep := makeTestPoint()
+
+	chats := make(chan *Chat)
+	go func() {
+		chat, err := m.OfferChat(ep, testSender)
+		c.Check(err, IsNil)
+		chats <- chat
+	}()
+
+	offer := ep.offer(c)
+	c.Check(offer.IsPassive(), Equals, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	reply(m, &Offer{
+		Type:  DCC_CHAT,
+		IP:    testIP,
+		Port:  l.Addr().(*net.TCPAddr).Port,
+		Token: offer.Token,
+	})
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+
+	chat := <-chats
+	c.Assert(chat, NotNil)
+	c.Check(chat.Sender(), Equals, testSender)
+	checkChat(c, chat, conn)
+}
+
+func (s *s) TestManager_OfferChatTimeout(c *C) {
+	m := testManager(true)
+	m.conf.Timeout = 10 * time.Millisecond
+	_, err := m.OfferChat(makeTestPoint(), testSender)
+	c.Check(err, Equals, errTimeout)
+	c.Check(pending(m), Equals, 0)
+
+	m = testManager(false)
+	m.conf.PublicIP = nil
+	_, err = m.OfferChat(makeTestPoint(), testSender)
+	c.Check(err, Equals, errNoPublicIP)
+}
+
+func (s *s) TestManager_AcceptChat(c *C) {
+	m := testManager(false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	offer := &Offer{
+		Type:   DCC_CHAT,
+		Sender: testSender,
+		IP:     testIP,
+		Port:   l.Addr().(*net.TCPAddr).Port,
+	}
+	chat, err := m.AcceptChat(makeTestPoint(), offer)
+	c.Assert(err, IsNil)
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	checkChat(c, chat, conn)
+}
+
+func (s *s) TestManager_AcceptChatPassive(c *C) {
+	m := testManager(false)
+	ep := makeTestPoint()
+	offer := &Offer{Type: DCC_CHAT, Sender: testSender, Token: "9"}
+
+	chats := make(chan *Chat)
+	go func() {
+		chat, err := m.AcceptChat(ep, offer)
+		c.Check(err, IsNil)
+		chats <- chat
+	}()
+
+	answer := ep.offer(c)
+	c.Check(answer.Token, Equals, "9")
+	c.Check(answer.Port, Not(Equals), 0)
+	conn, err := net.Dial("tcp", answer.Addr())
+	c.Assert(err, IsNil)
+
+	chat := <-chats
+	c.Assert(chat, NotNil)
+	checkChat(c, chat, conn)
+}
+
+func (s *s) TestChatEndpoint_ImplementsEndpoint(c *C) {
+	var ep irc.Endpoint = (&Chat{}).Endpoint()
+	c.Check(ep, NotNil)
+}
+
+type readerConn struct {
+	net.Conn
+	reads []string
+}
+
+func (r *readerConn) Read(buf []byte) (int, error) {
+	if len(r.reads) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(buf, r.reads[0])
+	if r.reads[0] = r.reads[0][n:]; len(r.reads[0]) == 0 {
+		r.reads = r.reads[1:]
+	}
+	return n, nil
+}
+
+func (s *s) TestLineConn(c *C) {
+	conn := &lineConn{Conn: &readerConn{
+		reads: []string{"a\nb\r", "\nc\n"},
+	}}
+
+	var got []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	c.Check(string(got), Equals, "a\r\nb\r\nc\r\n")
+}
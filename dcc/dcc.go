@@ -0,0 +1,336 @@
+/*
+dcc package makes direct client connections to irc users. It parses the DCC
+offers sent in ctcp privmsgs, opens DCC CHAT sessions that can be used as an
+irc.Endpoint, and sends files with DCC SEND. Both normal and passive (reverse)
+offers are supported, as is resuming a send.
+
+A Manager has to be registered for irc.CTCP events so it can see the replies to
+it's offers.
+*/
+package dcc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTimeout is how long to wait for the other side of an offer.
+	defaultTimeout = 2 * time.Minute
+)
+
+var (
+	// errNoPublicIP is given when an offer needs an address for the other
+	// side to connect to and none is configured.
+	errNoPublicIP = errors.New("dcc: No public ip to offer.")
+	// errTimeout is given when the other side of an offer never replies or
+	// connects.
+	errTimeout = errors.New("dcc: Timed out waiting for the other side.")
+)
+
+// Format strings for errors.
+const (
+	errFmtNoPorts     = "dcc: No free port in %v-%v (%v)"
+	errFmtUnknownPeer = "dcc: Can't find the address of %v to check " +
+		"connections against, give it as nick!user@host"
+	errFmtLocalReply = "dcc: Not connecting to %v from %v, it's a local " +
+		"address"
+	errFmtWrongReply = "dcc: Not connecting to %v from %v, it's not " +
+		"where they are"
+)
+
+// Config is where dcc connections are listened for.
+type Config struct {
+	// ListenAddress is the local address to listen on, every address if it's
+	// empty.
+	ListenAddress string
+	// PublicIP is the address the other side of an offer is told to connect
+	// to. It's needed unless every offer is passive.
+	PublicIP net.IP
+	// PortMin and PortMax are the ports that may be listened on, any free
+	// port is used if PortMin is 0.
+	PortMin int
+	PortMax int
+	// Passive makes the bot's offers passive, the other side listens and the
+	// bot connects to it. For when the bot can't accept connections.
+	Passive bool
+	// Timeout is how long to wait for the other side to reply or connect,
+	// defaultTimeout if it's 0.
+	Timeout time.Duration
+	// AnyPeer accepts a connection to an offer from any address when the
+	// host of the other side can't be resolved, like a cloaked host.
+	// Otherwise only connections from the addresses it's host resolves to
+	// are accepted. It also lets replies to passive offers point at
+	// loopback and private addresses.
+	AnyPeer bool
+}
+
+// Manager makes dcc offers and accepts them. It keeps track of the offers it
+// made so the replies to them can be found.
+type Manager struct {
+	conf     Config
+	handler  func(*Offer, irc.Endpoint)
+	pending  map[string]chan *Offer
+	casemaps map[string]irc.Casemap
+
+	lookupIP func(string) ([]net.IP, error)
+
+	protect sync.RWMutex
+}
+
+// CreateManager creates a manager. The handler is called with offers from
+// other users, it may be nil to ignore them.
+func CreateManager(conf Config, handler func(*Offer, irc.Endpoint)) *Manager {
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultTimeout
+	}
+	return &Manager{
+		conf:     conf,
+		handler:  handler,
+		pending:  make(map[string]chan *Offer),
+		casemaps: make(map[string]irc.Casemap),
+		lookupIP: net.LookupIP,
+	}
+}
+
+// Protocaps sets the casemap used to match the nicks of replies to offers on
+// the server identified by key. Nil caps revert the server to ascii.
+func (m *Manager) Protocaps(key string, caps *irc.ProtoCaps) {
+	m.protect.Lock()
+	defer m.protect.Unlock()
+
+	if caps == nil {
+		delete(m.casemaps, key)
+		return
+	}
+	m.casemaps[key] = caps.Casemap()
+}
+
+// Config returns the settings the manager was created with.
+func (m *Manager) Config() Config {
+	return m.conf
+}
+
+// CTCP implements dispatch.CTCPHandler. Replies to the manager's own offers
+// are sent to the offer waiting on them, anything else is a new offer.
+func (m *Manager) CTCP(msg *irc.CTCPMessage, ep irc.Endpoint) {
+	offer, err := ParseOffer(msg)
+	if err != nil {
+		return
+	}
+
+	if m.deliver(ep.GetKey(), offer) {
+		return
+	}
+
+	switch offer.Type {
+	case DCC_CHAT, DCC_SEND:
+		if m.handler != nil {
+			m.handler(offer, ep)
+		}
+	}
+}
+
+// deliver sends a reply from the server identified by server to the offer
+// waiting on it, it's found by token if it has one and otherwise by port.
+func (m *Manager) deliver(server string, offer *Offer) bool {
+	var key string
+	switch {
+	case len(offer.Token) > 0 && offer.Port != 0,
+		len(offer.Token) > 0 && offer.Type == DCC_RESUME:
+		key = m.tokenKey(server, offer.Nick(), offer.Token)
+	case offer.Type == DCC_RESUME:
+		key = m.portKey(server, offer.Nick(), offer.Port)
+	default:
+		return false
+	}
+
+	m.protect.RLock()
+	replies, ok := m.pending[key]
+	m.protect.RUnlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case replies <- offer:
+	default:
+	}
+	return true
+}
+
+// wait starts waiting for replies to an offer.
+func (m *Manager) wait(key string) chan *Offer {
+	replies := make(chan *Offer, 1)
+	m.protect.Lock()
+	m.pending[key] = replies
+	m.protect.Unlock()
+	return replies
+}
+
+// done stops waiting for replies to an offer.
+func (m *Manager) done(key string) {
+	m.protect.Lock()
+	delete(m.pending, key)
+	m.protect.Unlock()
+}
+
+// token creates a random token for a passive offer, so the reply to an offer
+// can't be guessed by anyone it wasn't sent to.
+func (m *Manager) token() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(binary.BigEndian.Uint64(buf[:])>>1, 10), nil
+}
+
+// publicIP returns the address to put in an offer.
+func (m *Manager) publicIP() (net.IP, error) {
+	if m.conf.PublicIP == nil {
+		return nil, errNoPublicIP
+	}
+	return m.conf.PublicIP, nil
+}
+
+// listen listens on the first free port in the configured range.
+func (m *Manager) listen() (*net.TCPListener, error) {
+	min, max := m.conf.PortMin, m.conf.PortMax
+	if max < min {
+		max = min
+	}
+
+	var err error
+	for port := min; port <= max; port++ {
+		var addr *net.TCPAddr
+		address := net.JoinHostPort(m.conf.ListenAddress, strconv.Itoa(port))
+		if addr, err = net.ResolveTCPAddr("tcp", address); err != nil {
+			return nil, err
+		}
+
+		var l *net.TCPListener
+		if l, err = net.ListenTCP("tcp", addr); err == nil {
+			return l, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf(errFmtNoPorts, min, max, err))
+}
+
+// peerIPs returns the addresses the other side of an offer may connect from,
+// found from the host of peer. Nil means any address is allowed.
+func (m *Manager) peerIPs(peer string) ([]net.IP, error) {
+	var ips []net.IP
+	if host := irc.Mask(peer).GetHost(); len(host) > 0 {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
+		} else {
+			ips, _ = m.lookupIP(host)
+		}
+	}
+
+	if len(ips) == 0 {
+		if m.conf.AnyPeer {
+			return nil, nil
+		}
+		return nil, errors.New(fmt.Sprintf(errFmtUnknownPeer, peer))
+	}
+	return ips, nil
+}
+
+// accept waits for one connection from the allowed addresses on the listener
+// and closes it. Connections from anywhere else are closed. A nil allowed
+// accepts any address.
+func (m *Manager) accept(l *net.TCPListener,
+	allowed []net.IP) (net.Conn, error) {
+
+	defer l.Close()
+	l.SetDeadline(time.Now().Add(m.conf.Timeout))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, errTimeout
+			}
+			return nil, err
+		}
+
+		if allowed == nil {
+			return conn, nil
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			if containsIP(allowed, addr.IP) {
+				return conn, nil
+			}
+		}
+		conn.Close()
+	}
+}
+
+// dial connects to the address in an offer.
+func (m *Manager) dial(offer *Offer) (net.Conn, error) {
+	return net.DialTimeout("tcp", offer.Addr(), m.conf.Timeout)
+}
+
+// dialReply connects to the address in a reply to a passive offer. It has to
+// be one of the allowed addresses, any if allowed is nil, and can't be a
+// local address unless AnyPeer is set. Otherwise anyone who sees the token
+// could point the bot at something on it's own network.
+func (m *Manager) dialReply(reply *Offer, allowed []net.IP) (net.Conn, error) {
+	if !m.conf.AnyPeer && isLocalIP(reply.IP) {
+		return nil, errors.New(fmt.Sprintf(errFmtLocalReply, reply.Addr(),
+			reply.Sender))
+	}
+	if allowed != nil && !containsIP(allowed, reply.IP) {
+		return nil, errors.New(fmt.Sprintf(errFmtWrongReply, reply.Addr(),
+			reply.Sender))
+	}
+	return m.dial(reply)
+}
+
+// containsIP checks if ip is one of ips.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, allowed := range ips {
+		if allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalIP checks if ip is a loopback, private or otherwise local address.
+func isLocalIP(ip net.IP) bool {
+	return ip == nil || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast()
+}
+
+// listenPort returns the port a listener is on.
+func listenPort(l *net.TCPListener) int {
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// tokenKey is the key of an offer to nick on server waiting on a reply with
+// a token.
+func (m *Manager) tokenKey(server, nick, token string) string {
+	return "token:" + server + ":" + m.fold(server, nick) + ":" + token
+}
+
+// portKey is the key of an offer to nick on server waiting on a reply to a
+// port.
+func (m *Manager) portKey(server, nick string, port int) string {
+	return "port:" + server + ":" + m.fold(server, nick) + ":" +
+		strconv.Itoa(port)
+}
+
+// fold folds a nick with the casemap of server.
+func (m *Manager) fold(server, nick string) string {
+	m.protect.RLock()
+	defer m.protect.RUnlock()
+	return m.casemaps[server].Fold(nick)
+}
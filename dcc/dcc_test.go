@@ -0,0 +1,337 @@
+package dcc
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aarondl/ultimateq/inet"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/parse"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) } //Hook into testing package
+type s struct{}
+
+var _ = Suite(&s{})
+
+func init() {
+	inet.Log = func(string, ...interface{}) {}
+}
+
+var (
+	testSender = "nick!user@host"
+	testIP     = net.IPv4(127, 0, 0, 1)
+)
+
+// testPoint is an endpoint that hands the messages written to it to the test.
+type testPoint struct {
+	*irc.Helper
+	sent chan *irc.IrcMessage
+}
+
+type testWriter struct {
+	sent chan *irc.IrcMessage
+}
+
+func (w testWriter) Write(buf []byte) (int, error) {
+	for _, line := range bytes.Split(buf, []byte{'\n'}) {
+		if msg, err := parse.Parse(line); err == nil {
+			w.sent <- msg
+		}
+	}
+	return len(buf), nil
+}
+
+func makeTestPoint() *testPoint {
+	sent := make(chan *irc.IrcMessage, 10)
+	return &testPoint{&irc.Helper{Writer: testWriter{sent}}, sent}
+}
+
+func (t *testPoint) GetKey() string {
+	return "server"
+}
+
+// offer waits for the next offer written to the endpoint.
+func (t *testPoint) offer(c *C) *Offer {
+	select {
+	case msg := <-t.sent:
+		offer, err := ParseOffer(&irc.CTCPMessage{IrcMessage: msg})
+		c.Assert(err, IsNil)
+		return offer
+	case <-time.After(5 * time.Second):
+		c.Fatal("Timed out waiting for an offer.")
+	}
+	return nil
+}
+
+// reply sends an offer to the manager as if it came from testSender.
+func reply(m *Manager, offer *Offer) {
+	m.CTCP(&irc.CTCPMessage{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: testSender,
+		Args:   []string{"bot", offer.String()},
+	}}, makeTestPoint())
+}
+
+// pending returns the number of offers waiting on a reply.
+func pending(m *Manager) int {
+	m.protect.RLock()
+	defer m.protect.RUnlock()
+	return len(m.pending)
+}
+
+func testManager(passive bool) *Manager {
+	m := CreateManager(Config{
+		ListenAddress: "127.0.0.1",
+		PublicIP:      testIP,
+		Passive:       passive,
+		Timeout:       5 * time.Second,
+	}, nil)
+	m.lookupIP = func(host string) ([]net.IP, error) {
+		if host == "host" {
+			return []net.IP{testIP}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return m
+}
+
+func (s *s) TestCreateManager(c *C) {
+	m := CreateManager(Config{}, nil)
+	c.Check(m.conf.Timeout, Equals, defaultTimeout)
+	c.Check(m.pending, NotNil)
+
+	m = CreateManager(Config{Timeout: time.Second}, nil)
+	c.Check(m.conf.Timeout, Equals, time.Second)
+}
+
+func (s *s) TestManager_CTCP(c *C) {
+	var offers []*Offer
+	m := CreateManager(Config{}, func(o *Offer, ep irc.Endpoint) {
+		offers = append(offers, o)
+	})
+
+	reply(m, &Offer{Type: DCC_CHAT, IP: testIP, Port: 1024})
+	reply(m, &Offer{Type: DCC_SEND, Filename: "a", IP: testIP, Port: 1024})
+	reply(m, &Offer{Type: DCC_CHAT, Port: 0, Token: "5"})
+	reply(m, &Offer{Type: DCC_RESUME, Filename: "a", Port: 1024})
+	m.CTCP(&irc.CTCPMessage{IrcMessage: &irc.IrcMessage{Name: irc.PRIVMSG,
+		Sender: testSender, Args: []string{"bot", "\x01DCC junk\x01"}}}, nil)
+
+	c.Assert(len(offers), Equals, 3)
+	c.Check(offers[0].Type, Equals, DCC_CHAT)
+	c.Check(offers[0].Sender, Equals, testSender)
+	c.Check(offers[1].Type, Equals, DCC_SEND)
+	c.Check(offers[2].IsPassive(), Equals, true)
+}
+
+func (s *s) TestManager_Deliver(c *C) {
+	m := CreateManager(Config{}, func(o *Offer, ep irc.Endpoint) {
+		c.Error("Handler should not be called for replies.")
+	})
+
+	byToken := m.wait(m.tokenKey("server", "NICK", "5"))
+	byPort := m.wait(m.portKey("server", "nick", 1024))
+
+	reply(m, &Offer{Type: DCC_CHAT, IP: testIP, Port: 1025, Token: "5"})
+	reply(m, &Offer{Type: DCC_RESUME, Filename: "a", Port: 1024,
+		Position: 10})
+
+	o := <-byToken
+	c.Check(o.Port, Equals, 1025)
+	o = <-byPort
+	c.Check(o.Position, Equals, int64(10))
+
+	m.done(m.tokenKey("server", "nick", "5"))
+	m.done(m.portKey("server", "nick", 1024))
+	c.Check(pending(m), Equals, 0)
+}
+
+func (s *s) TestManager_Protocaps(c *C) {
+	m := CreateManager(Config{}, nil)
+	c.Check(m.tokenKey("server", "[nick]", "5"), Not(Equals),
+		m.tokenKey("server", "{nick}", "5"))
+
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{Args: []string{
+		"bot", "CASEMAPPING=rfc1459", "are supported by this server",
+	}})
+	m.Protocaps("server", caps)
+	c.Check(m.tokenKey("server", "[NICK]", "5"), Equals,
+		m.tokenKey("server", "{nick}", "5"))
+	c.Check(m.portKey("server", "[NICK]", 1024), Equals,
+		m.portKey("server", "{nick}", 1024))
+	c.Check(m.tokenKey("other", "[nick]", "5"), Not(Equals),
+		m.tokenKey("other", "{nick}", "5"))
+
+	byToken := m.wait(m.tokenKey("server", "{nick}", "5"))
+	m.CTCP(&irc.CTCPMessage{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "[NICK]!user@host",
+		Args: []string{"bot", (&Offer{Type: DCC_CHAT, IP: testIP,
+			Port: 1025, Token: "5"}).String()},
+	}}, makeTestPoint())
+	c.Check((<-byToken).Port, Equals, 1025)
+
+	m.Protocaps("server", nil)
+	c.Check(m.tokenKey("server", "[nick]", "5"), Not(Equals),
+		m.tokenKey("server", "{nick}", "5"))
+}
+
+func (s *s) TestManager_Token(c *C) {
+	m := CreateManager(Config{}, nil)
+	a, err := m.token()
+	c.Check(err, IsNil)
+	b, err := m.token()
+	c.Check(err, IsNil)
+	c.Check(a, Not(Equals), b)
+	_, err = strconv.ParseUint(a, 10, 64)
+	c.Check(err, IsNil)
+}
+
+func (s *s) TestManager_PublicIP(c *C) {
+	m := CreateManager(Config{}, nil)
+	_, err := m.publicIP()
+	c.Check(err, Equals, errNoPublicIP)
+
+	m = testManager(false)
+	ip, err := m.publicIP()
+	c.Check(err, IsNil)
+	c.Check(ip.Equal(testIP), Equals, true)
+}
+
+func (s *s) TestManager_Listen(c *C) {
+	m := testManager(false)
+	taken, err := m.listen()
+	c.Assert(err, IsNil)
+	defer taken.Close()
+	port := listenPort(taken)
+
+	m.conf.PortMin, m.conf.PortMax = port, port+1
+	l, err := m.listen()
+	if err == nil {
+		c.Check(listenPort(l), Equals, port+1)
+		l.Close()
+	}
+
+	m.conf.PortMin, m.conf.PortMax = port, port
+	_, err = m.listen()
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestManager_Accept(c *C) {
+	m := testManager(false)
+	m.conf.Timeout = 10 * time.Millisecond
+	l, err := m.listen()
+	c.Assert(err, IsNil)
+	_, err = m.accept(l, nil)
+	c.Check(err, Equals, errTimeout)
+
+	m.conf.Timeout = 5 * time.Second
+	l, err = m.listen()
+	c.Assert(err, IsNil)
+	addr := l.Addr().String()
+	conns := make(chan net.Conn)
+	go func() {
+		conn, err := m.accept(l, []net.IP{net.IPv4(10, 0, 0, 1), testIP})
+		c.Check(err, IsNil)
+		conns <- conn
+	}()
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	accepted := <-conns
+	c.Assert(accepted, NotNil)
+	accepted.Close()
+
+	m.conf.Timeout = 100 * time.Millisecond
+	l, err = m.listen()
+	c.Assert(err, IsNil)
+	addr = l.Addr().String()
+	go func() {
+		conn, err := m.accept(l, []net.IP{net.IPv4(10, 0, 0, 1)})
+		c.Check(conn, IsNil)
+		c.Check(err, Equals, errTimeout)
+		conns <- conn
+	}()
+	stranger, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer stranger.Close()
+	stranger.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = stranger.Read(make([]byte, 1))
+	c.Check(err, Equals, io.EOF)
+	<-conns
+}
+
+func (s *s) TestManager_PeerIPs(c *C) {
+	m := testManager(false)
+	ips, err := m.peerIPs(testSender)
+	c.Check(err, IsNil)
+	c.Check(ips, DeepEquals, []net.IP{testIP})
+
+	ips, err = m.peerIPs("nick!user@10.0.0.1")
+	c.Check(err, IsNil)
+	c.Check(ips[0].Equal(net.IPv4(10, 0, 0, 1)), Equals, true)
+
+	_, err = m.peerIPs("nick")
+	c.Check(err, NotNil)
+	_, err = m.peerIPs("nick!user@cloaked.user")
+	c.Check(err, NotNil)
+
+	m.conf.AnyPeer = true
+	ips, err = m.peerIPs("nick!user@cloaked.user")
+	c.Check(err, IsNil)
+	c.Check(ips, IsNil)
+}
+
+func (s *s) TestManager_DialReply(c *C) {
+	m := testManager(true)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	local := &Offer{Sender: testSender, IP: testIP, Port: port}
+
+	_, err = m.dialReply(local, []net.IP{testIP})
+	c.Check(err, ErrorMatches, ".*local address")
+	_, err = m.dialReply(&Offer{Sender: testSender,
+		IP: net.IPv4(192, 168, 0, 1), Port: port}, nil)
+	c.Check(err, ErrorMatches, ".*local address")
+	_, err = m.dialReply(&Offer{Sender: testSender,
+		IP: net.IPv4(8, 8, 8, 8), Port: port}, []net.IP{testIP})
+	c.Check(err, ErrorMatches, ".*not where they are")
+
+	m.conf.AnyPeer = true
+	_, err = m.dialReply(&Offer{Sender: testSender,
+		IP: net.IPv4(10, 0, 0, 1), Port: port}, []net.IP{testIP})
+	c.Check(err, ErrorMatches, ".*not where they are")
+	conn, err := m.dialReply(local, []net.IP{testIP})
+	c.Assert(err, IsNil)
+	conn.Close()
+}
+
+func (s *s) TestManager_OfferChatWrongReply(c *C) {
+	m := testManager(true)
+	ep := makeTestPoint()
+
+	errs := make(chan error)
+	go func() {
+		_, err := m.OfferChat(ep, testSender)
+		errs <- err
+	}()
+
+	offer := ep.offer(c)
+	reply(m, &Offer{
+		Type:  DCC_CHAT,
+		IP:    testIP,
+		Port:  1024,
+		Token: offer.Token,
+	})
+	c.Check(<-errs, ErrorMatches, ".*local address")
+	c.Check(pending(m), Equals, 0)
+}
@@ -0,0 +1,213 @@
+package dcc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DCC offer types, the first word after DCC in the ctcp message.
+const (
+	DCC_CHAT   = "CHAT"
+	DCC_SEND   = "SEND"
+	DCC_RESUME = "RESUME"
+	DCC_ACCEPT = "ACCEPT"
+)
+
+const (
+	// ctcpDCC is the ctcp tag of every dcc message.
+	ctcpDCC = "DCC"
+	// chatProtocol is the argument of every DCC CHAT, it's where a
+	// filename would be for a send.
+	chatProtocol = "chat"
+)
+
+var (
+	// errNotDCC is given when a message doesn't hold a dcc offer.
+	errNotDCC = errors.New("dcc: Message is not a dcc offer.")
+)
+
+// Format strings for offer errors.
+const (
+	errFmtBadOffer = "dcc: Malformed %v offer (%v)"
+	errFmtUnknown  = "dcc: Unknown offer type (%v)"
+)
+
+// Offer is a dcc message. CHAT and SEND ask for a connection, RESUME and
+// ACCEPT agree on where to restart a SEND. An offer with a Port of 0 and a
+// Token is passive, the other side is asked to listen and reply with an offer
+// carrying the same Token.
+type Offer struct {
+	// Type is one of the DCC_* types.
+	Type string
+	// Sender is who sent the offer, empty for offers being sent.
+	Sender string
+	// Filename is the file being sent, empty for a CHAT.
+	Filename string
+	// IP and Port are where to connect to, RESUME and ACCEPT have no IP.
+	IP   net.IP
+	Port int
+	// Size is the size of the file for a SEND, 0 if it's unknown.
+	Size int64
+	// Position is where a RESUME or ACCEPT restarts the file at.
+	Position int64
+	// Token matches a passive offer to it's reply.
+	Token string
+}
+
+// ParseOffer reads a dcc offer out of a privmsg holding a DCC ctcp.
+func ParseOffer(msg *irc.CTCPMessage) (*Offer, error) {
+	if msg.Tag() != ctcpDCC {
+		return nil, errNotDCC
+	}
+
+	fields := splitOffer(msg.Data())
+	if len(fields) < 2 {
+		return nil, errNotDCC
+	}
+
+	o := &Offer{Type: strings.ToUpper(fields[0]), Sender: msg.Sender}
+	args := fields[2:]
+	var err error
+	switch o.Type {
+	case DCC_CHAT, DCC_SEND:
+		if o.Type == DCC_SEND {
+			o.Filename = fields[1]
+		}
+		if len(args) < 2 {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		if o.IP = parseIP(args[0]); o.IP == nil {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		if o.Port, err = strconv.Atoi(args[1]); err != nil {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		args = args[2:]
+		if o.Type == DCC_SEND && len(args) > 0 {
+			if o.Size, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+				return nil, badOffer(o.Type, msg.Data())
+			}
+			args = args[1:]
+		}
+	case DCC_RESUME, DCC_ACCEPT:
+		o.Filename = fields[1]
+		if len(args) < 2 {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		if o.Port, err = strconv.Atoi(args[0]); err != nil {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		if o.Position, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return nil, badOffer(o.Type, msg.Data())
+		}
+		args = args[2:]
+	default:
+		return nil, errors.New(fmt.Sprintf(errFmtUnknown, o.Type))
+	}
+
+	if len(args) > 0 {
+		o.Token = args[0]
+	}
+	if o.Port < 0 || o.Port > 65535 || o.Size < 0 || o.Position < 0 {
+		return nil, badOffer(o.Type, msg.Data())
+	}
+	return o, nil
+}
+
+// IsPassive checks if the offer asks the other side to listen.
+func (o *Offer) IsPassive() bool {
+	return o.Port == 0 && len(o.Token) > 0
+}
+
+// Addr returns the host:port to connect to for the offer.
+func (o *Offer) Addr() string {
+	return net.JoinHostPort(o.IP.String(), strconv.Itoa(o.Port))
+}
+
+// Nick returns the nick of the sender of the offer.
+func (o *Offer) Nick() string {
+	return irc.Mask(o.Sender).GetNick()
+}
+
+// String returns the offer as a ctcp message that can be sent in a privmsg.
+func (o *Offer) String() string {
+	args := []string{o.Type}
+	switch o.Type {
+	case DCC_CHAT:
+		args = append(args, chatProtocol, formatIP(o.IP),
+			strconv.Itoa(o.Port))
+	case DCC_SEND:
+		args = append(args, quoteFilename(o.Filename), formatIP(o.IP),
+			strconv.Itoa(o.Port), strconv.FormatInt(o.Size, 10))
+	case DCC_RESUME, DCC_ACCEPT:
+		args = append(args, quoteFilename(o.Filename), strconv.Itoa(o.Port),
+			strconv.FormatInt(o.Position, 10))
+	}
+	if len(o.Token) > 0 {
+		args = append(args, o.Token)
+	}
+	return irc.CTCPPack(ctcpDCC, strings.Join(args, " "))
+}
+
+// badOffer creates an error for a malformed offer.
+func badOffer(kind, data string) error {
+	return errors.New(fmt.Sprintf(errFmtBadOffer, kind, data))
+}
+
+// splitOffer splits the arguments of an offer on spaces, a filename may be
+// quoted to keep the spaces in it.
+func splitOffer(data string) []string {
+	var fields []string
+	for data = strings.TrimLeft(data, " "); len(data) > 0; {
+		var field string
+		if data[0] == '"' {
+			if end := strings.IndexByte(data[1:], '"'); end >= 0 {
+				field, data = data[1:end+1], data[end+2:]
+			} else {
+				field, data = data[1:], ""
+			}
+		} else if end := strings.IndexByte(data, ' '); end >= 0 {
+			field, data = data[:end], data[end:]
+		} else {
+			field, data = data, ""
+		}
+		fields = append(fields, field)
+		data = strings.TrimLeft(data, " ")
+	}
+	return fields
+}
+
+// quoteFilename quotes a filename that has spaces in it.
+func quoteFilename(filename string) string {
+	if strings.IndexByte(filename, ' ') >= 0 {
+		return `"` + filename + `"`
+	}
+	return filename
+}
+
+// parseIP reads an address given as a 32 bit integer for ipv4 or as text for
+// ipv6.
+func parseIP(str string) net.IP {
+	if n, err := strconv.ParseUint(str, 10, 32); err == nil {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+	return net.ParseIP(str)
+}
+
+// formatIP writes an ipv4 address as a 32 bit integer and ipv6 as text.
+func formatIP(ip net.IP) string {
+	if ip == nil {
+		return "0"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	return ip.String()
+}
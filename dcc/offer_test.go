@@ -0,0 +1,122 @@
+package dcc
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"net"
+)
+
+func parseText(text string) (*Offer, error) {
+	return ParseOffer(&irc.CTCPMessage{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: testSender,
+		Args:   []string{"bot", text},
+	}})
+}
+
+func (s *s) TestParseOffer(c *C) {
+	o, err := parseText("\x01DCC CHAT chat 2130706433 1024\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.Type, Equals, DCC_CHAT)
+	c.Check(o.Sender, Equals, testSender)
+	c.Check(o.Nick(), Equals, "nick")
+	c.Check(o.IP.Equal(testIP), Equals, true)
+	c.Check(o.Port, Equals, 1024)
+	c.Check(o.Addr(), Equals, "127.0.0.1:1024")
+	c.Check(o.IsPassive(), Equals, false)
+
+	o, err = parseText("\x01DCC SEND \"a file.txt\" 2130706433 1024 500\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.Type, Equals, DCC_SEND)
+	c.Check(o.Filename, Equals, "a file.txt")
+	c.Check(o.Size, Equals, int64(500))
+
+	o, err = parseText("\x01DCC SEND file ::1 0 500 7\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.IP.Equal(net.IPv6loopback), Equals, true)
+	c.Check(o.Token, Equals, "7")
+	c.Check(o.IsPassive(), Equals, true)
+
+	o, err = parseText("\x01DCC SEND file 2130706433 1024\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.Size, Equals, int64(0))
+
+	o, err = parseText("\x01DCC RESUME file 1024 200\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.Type, Equals, DCC_RESUME)
+	c.Check(o.Filename, Equals, "file")
+	c.Check(o.Port, Equals, 1024)
+	c.Check(o.Position, Equals, int64(200))
+
+	o, err = parseText("\x01DCC accept file 0 200 7\x01")
+	c.Assert(err, IsNil)
+	c.Check(o.Type, Equals, DCC_ACCEPT)
+	c.Check(o.Token, Equals, "7")
+}
+
+func (s *s) TestParseOffer_Errors(c *C) {
+	_, err := parseText("\x01VERSION\x01")
+	c.Check(err, Equals, errNotDCC)
+	_, err = parseText("\x01DCC CHAT\x01")
+	c.Check(err, Equals, errNotDCC)
+
+	bad := []string{
+		"\x01DCC CHAT chat 2130706433\x01",
+		"\x01DCC CHAT chat nowhere 1024\x01",
+		"\x01DCC CHAT chat 2130706433 port\x01",
+		"\x01DCC CHAT chat 2130706433 70000\x01",
+		"\x01DCC SEND file 2130706433 1024 big\x01",
+		"\x01DCC SEND file 2130706433 1024 -5\x01",
+		"\x01DCC RESUME file 1024\x01",
+		"\x01DCC RESUME file 1024 here\x01",
+		"\x01DCC UNKNOWN file 1024\x01",
+	}
+	for _, text := range bad {
+		_, err = parseText(text)
+		c.Check(err, NotNil, Commentf(text))
+	}
+}
+
+func (s *s) TestOffer_String(c *C) {
+	offers := []*Offer{
+		{Type: DCC_CHAT, IP: testIP, Port: 1024},
+		{Type: DCC_CHAT, Port: 0, Token: "1"},
+		{Type: DCC_SEND, Filename: "a file", IP: testIP, Port: 1024,
+			Size: 50},
+		{Type: DCC_SEND, Filename: "f", IP: net.IPv6loopback, Port: 1024},
+		{Type: DCC_RESUME, Filename: "f", Port: 1024, Position: 20},
+		{Type: DCC_ACCEPT, Filename: "f", Port: 0, Position: 20, Token: "2"},
+	}
+
+	c.Check(offers[0].String(), Equals,
+		"\x01DCC CHAT chat 2130706433 1024\x01")
+	c.Check(offers[1].String(), Equals, "\x01DCC CHAT chat 0 0 1\x01")
+	c.Check(offers[2].String(), Equals,
+		"\x01DCC SEND \"a file\" 2130706433 1024 50\x01")
+	c.Check(offers[4].String(), Equals, "\x01DCC RESUME f 1024 20\x01")
+
+	for _, offer := range offers {
+		parsed, err := parseText(offer.String())
+		c.Assert(err, IsNil, Commentf(offer.String()))
+		parsed.Sender = ""
+		if offer.IP == nil && parsed.IP != nil {
+			c.Check(parsed.IP.Equal(net.IPv4zero), Equals, true)
+			parsed.IP = nil
+		}
+		if offer.IP != nil {
+			c.Check(parsed.IP.Equal(offer.IP), Equals, true)
+			parsed.IP = offer.IP
+		}
+		c.Check(parsed, DeepEquals, offer)
+	}
+}
+
+func (s *s) TestSplitOffer(c *C) {
+	c.Check(splitOffer("SEND file 1 2"), DeepEquals,
+		[]string{"SEND", "file", "1", "2"})
+	c.Check(splitOffer(`SEND "a  b" 1`), DeepEquals,
+		[]string{"SEND", "a  b", "1"})
+	c.Check(splitOffer(`SEND "open`), DeepEquals,
+		[]string{"SEND", "open"})
+	c.Check(splitOffer("  "), IsNil)
+}
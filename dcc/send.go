@@ -0,0 +1,279 @@
+package dcc
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sendBufferSize is how much of a file is written to the connection at
+	// once.
+	sendBufferSize = 8192
+)
+
+var (
+	// errIncomplete is given when the other side of a send closes the
+	// connection before acknowledging the whole file.
+	errIncomplete = errors.New("dcc: Send was not fully acknowledged.")
+)
+
+// Transfer is a file being sent with DCC SEND.
+type Transfer struct {
+	// Nick is who the file is being sent to.
+	Nick string
+	// Filename is the name the file was offered with.
+	Filename string
+	// Size is the size of the file.
+	Size int64
+
+	sent int64
+	done chan struct{}
+	err  error
+}
+
+// Sent returns the number of bytes of the file sent so far, including any
+// skipped by a resume.
+func (t *Transfer) Sent() int64 {
+	return atomic.LoadInt64(&t.sent)
+}
+
+// Wait blocks until the send is finished and returns why it failed, nil if
+// the whole file was sent.
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// SendFile offers a file to target. The offer and the send happen in the
+// background, the returned Transfer can be waited on to find out how it went.
+// The target should be the full nick!user@host of the user, only connections
+// from it's host are accepted.
+func (m *Manager) SendFile(ep irc.Endpoint, target, filename string) (
+	*Transfer, error) {
+
+	nick := irc.Mask(target).GetNick()
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	t := &Transfer{
+		Nick:     nick,
+		Filename: filepath.Base(filename),
+		Size:     info.Size(),
+		done:     make(chan struct{}),
+	}
+	offer := &Offer{Type: DCC_SEND, Filename: t.Filename, Size: t.Size}
+
+	allowed, err := m.peerIPs(target)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if m.conf.Passive {
+		offer.IP = m.conf.PublicIP
+		if offer.Token, err = m.token(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		key := m.tokenKey(ep.GetKey(), nick, offer.Token)
+		replies := m.wait(key)
+		if err = ep.Privmsg(nick, offer.String()); err != nil {
+			m.done(key)
+			file.Close()
+			return nil, err
+		}
+
+		go func() {
+			err := m.sendPassive(ep, t, file, offer, allowed, replies)
+			m.done(key)
+			t.finish(file, err)
+		}()
+		return t, nil
+	}
+
+	if offer.IP, err = m.publicIP(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l, err := m.listen()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	offer.Port = listenPort(l)
+
+	key := m.portKey(ep.GetKey(), nick, offer.Port)
+	replies := m.wait(key)
+	if err = ep.Privmsg(nick, offer.String()); err != nil {
+		m.done(key)
+		l.Close()
+		file.Close()
+		return nil, err
+	}
+
+	go func() {
+		err := m.sendActive(ep, t, file, offer, l, allowed, replies)
+		m.done(key)
+		t.finish(file, err)
+	}()
+	return t, nil
+}
+
+// sendActive waits for the other side to connect to the listener, agreeing
+// to any resume it asks for first, and sends the file.
+func (m *Manager) sendActive(ep irc.Endpoint, t *Transfer, file *os.File,
+	offer *Offer, l *net.TCPListener, allowed []net.IP,
+	replies chan *Offer) error {
+
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	conns := make(chan accepted, 1)
+	go func() {
+		conn, err := m.accept(l, allowed)
+		conns <- accepted{conn, err}
+	}()
+
+	var position int64
+	for {
+		select {
+		case reply := <-replies:
+			if reply.Type != DCC_RESUME {
+				continue
+			}
+			position = acceptResume(ep, t, offer, reply)
+		case a := <-conns:
+			if a.err != nil {
+				return a.err
+			}
+			return m.send(a.conn, t, file, position)
+		}
+	}
+}
+
+// sendPassive waits for the other side to reply with where it's listening,
+// agreeing to any resume it asks for first, and sends the file. The reply has
+// to point at one of the allowed addresses.
+func (m *Manager) sendPassive(ep irc.Endpoint, t *Transfer, file *os.File,
+	offer *Offer, allowed []net.IP, replies chan *Offer) error {
+
+	var position int64
+	expire := time.After(m.conf.Timeout)
+	for {
+		select {
+		case reply := <-replies:
+			switch reply.Type {
+			case DCC_RESUME:
+				position = acceptResume(ep, t, offer, reply)
+			case DCC_SEND:
+				conn, err := m.dialReply(reply, allowed)
+				if err != nil {
+					return err
+				}
+				return m.send(conn, t, file, position)
+			}
+		case <-expire:
+			return errTimeout
+		}
+	}
+}
+
+// acceptResume replies to a resume with an accept and returns the position to
+// start sending from.
+func acceptResume(ep irc.Endpoint, t *Transfer, offer, resume *Offer) int64 {
+	position := resume.Position
+	if position > t.Size {
+		position = t.Size
+	}
+
+	accept := &Offer{
+		Type:     DCC_ACCEPT,
+		Filename: offer.Filename,
+		Port:     offer.Port,
+		Position: position,
+		Token:    offer.Token,
+	}
+	ep.Privmsg(t.Nick, accept.String())
+	return position
+}
+
+// send writes the file from position to the connection and waits for the
+// other side to acknowledge all of it.
+func (m *Manager) send(conn net.Conn, t *Transfer, file *os.File,
+	position int64) error {
+
+	defer conn.Close()
+
+	if _, err := file.Seek(position, os.SEEK_SET); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&t.sent, position)
+
+	acked := make(chan error, 1)
+	go func() {
+		acked <- readAcks(conn, t.Size)
+	}()
+
+	buf := make([]byte, sendBufferSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return err
+			}
+			atomic.AddInt64(&t.sent, int64(n))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if position == t.Size {
+		return nil
+	}
+	select {
+	case err := <-acked:
+		return err
+	case <-time.After(m.conf.Timeout):
+		return errTimeout
+	}
+}
+
+// readAcks reads the acknowledgements of the other side until the whole file
+// is acknowledged. They are the number of bytes received as a 32 bit big
+// endian integer, files over 4GB wrap around.
+func readAcks(conn net.Conn, size int64) error {
+	var ack [4]byte
+	for {
+		if _, err := io.ReadFull(conn, ack[:]); err != nil {
+			return errIncomplete
+		}
+		if binary.BigEndian.Uint32(ack[:]) == uint32(size) {
+			return nil
+		}
+	}
+}
+
+// finish closes the file and marks the transfer as done.
+func (t *Transfer) finish(file *os.File, err error) {
+	file.Close()
+	t.err = err
+	close(t.done)
+}
@@ -0,0 +1,151 @@
+package dcc
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var sendContents = []byte("the contents of the file being sent")
+
+func sendFile(c *C) string {
+	dir := c.MkDir()
+	filename := filepath.Join(dir, "file.txt")
+	err := ioutil.WriteFile(filename, sendContents, 0600)
+	c.Assert(err, IsNil)
+	return filename
+}
+
+// receive reads a file from a send, acknowledging what it gets like a client.
+func receive(c *C, conn net.Conn, position int64) []byte {
+	defer conn.Close()
+	buf := make([]byte, int64(len(sendContents))-position)
+	_, err := io.ReadFull(conn, buf)
+	c.Check(err, IsNil)
+
+	var ack [4]byte
+	binary.BigEndian.PutUint32(ack[:], uint32(len(sendContents)))
+	_, err = conn.Write(ack[:])
+	c.Check(err, IsNil)
+	return buf
+}
+
+func (s *s) TestManager_SendFile(c *C) {
+	m := testManager(false)
+	ep := makeTestPoint()
+
+	t, err := m.SendFile(ep, testSender, sendFile(c))
+	c.Assert(err, IsNil)
+	c.Check(t.Nick, Equals, "nick")
+	c.Check(t.Filename, Equals, "file.txt")
+	c.Check(t.Size, Equals, int64(len(sendContents)))
+
+	offer := ep.offer(c)
+	c.Check(offer.Type, Equals, DCC_SEND)
+	c.Check(offer.Filename, Equals, "file.txt")
+	c.Check(offer.Size, Equals, t.Size)
+
+	conn, err := net.Dial("tcp", offer.Addr())
+	c.Assert(err, IsNil)
+	c.Check(receive(c, conn, 0), DeepEquals, sendContents)
+	c.Check(t.Wait(), IsNil)
+	c.Check(t.Sent(), Equals, t.Size)
+}
+
+func (s *s) TestManager_SendFileResume(c *C) {
+	m := testManager(false)
+	ep := makeTestPoint()
+
+	t, err := m.SendFile(ep, testSender, sendFile(c))
+	c.Assert(err, IsNil)
+	offer := ep.offer(c)
+
+	reply(m, &Offer{
+		Type:     DCC_RESUME,
+		Filename: offer.Filename,
+		Port:     offer.Port,
+		Position: 10,
+	})
+	accept := ep.offer(c)
+	c.Check(accept.Type, Equals, DCC_ACCEPT)
+	c.Check(accept.Port, Equals, offer.Port)
+	c.Check(accept.Position, Equals, int64(10))
+
+	conn, err := net.Dial("tcp", offer.Addr())
+	c.Assert(err, IsNil)
+	c.Check(receive(c, conn, 10), DeepEquals, sendContents[10:])
+	c.Check(t.Wait(), IsNil)
+	c.Check(t.Sent(), Equals, t.Size)
+}
+
+func (s *s) TestManager_SendFilePassive(c *C) {
+	m := testManager(true)
+	m.conf.AnyPeer = true
+	ep := makeTestPoint()
+
+	t, err := m.SendFile(ep, testSender, sendFile(c))
+	c.Assert(err, IsNil)
+	offer := ep.offer(c)
+	c.Check(offer.IsPassive(), Equals, true)
+	c.Check(offer.Size, Equals, t.Size)
+
+	reply(m, &Offer{
+		Type:     DCC_RESUME,
+		Filename: offer.Filename,
+		Position: 5,
+		Token:    offer.Token,
+	})
+	accept := ep.offer(c)
+	c.Check(accept.Type, Equals, DCC_ACCEPT)
+	c.Check(accept.Token, Equals, offer.Token)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	reply(m, &Offer{
+		Type:     DCC_SEND,
+		Filename: offer.Filename,
+		IP:       testIP,
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Size:     offer.Size,
+		Token:    offer.Token,
+	})
+
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	c.Check(receive(c, conn, 5), DeepEquals, sendContents[5:])
+	c.Check(t.Wait(), IsNil)
+	c.Check(pending(m), Equals, 0)
+}
+
+func (s *s) TestManager_SendFileIncomplete(c *C) {
+	m := testManager(false)
+	ep := makeTestPoint()
+
+	t, err := m.SendFile(ep, testSender, sendFile(c))
+	c.Assert(err, IsNil)
+	offer := ep.offer(c)
+
+	conn, err := net.Dial("tcp", offer.Addr())
+	c.Assert(err, IsNil)
+	buf := make([]byte, len(sendContents))
+	_, err = io.ReadFull(conn, buf)
+	c.Check(err, IsNil)
+	conn.Close()
+	c.Check(t.Wait(), Equals, errIncomplete)
+}
+
+func (s *s) TestManager_SendFileErrors(c *C) {
+	m := testManager(false)
+	_, err := m.SendFile(makeTestPoint(), testSender,
+		filepath.Join(c.MkDir(), "missing"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	m.conf.PublicIP = nil
+	_, err = m.SendFile(makeTestPoint(), testSender, sendFile(c))
+	c.Check(err, Equals, errNoPublicIP)
+}
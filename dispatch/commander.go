@@ -0,0 +1,531 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+	"sync"
+)
+
+// Command scopes, these control where a command may be used from.
+const (
+	CMDSCOPE_PRIVATE = 0x1
+	CMDSCOPE_CHANNEL = 0x2
+	CMDSCOPE_ALL     = CMDSCOPE_PRIVATE | CMDSCOPE_CHANNEL
+)
+
+// The various kinds of command arguments, derived from the argument spec.
+const (
+	CMDARG_REQUIRED = 0x1
+	CMDARG_OPTIONAL = 0x2
+	CMDARG_VARIADIC = 0x4
+	CMDARG_CHANNEL  = 0x8
+	CMDARG_NICK     = 0x10
+)
+
+const (
+	// fmtErrCmdArgSpec is returned by Register when an argument spec is bad.
+	fmtErrCmdArgSpec = "dispatch: Invalid argument spec for %v (%v)"
+	// fmtErrCmdExists is returned by Register when a command is taken.
+	fmtErrCmdExists = "dispatch: Command %v already registered"
+	// fmtErrCmdTooFew is replied when required arguments are missing.
+	fmtErrCmdTooFew = "%v: Not enough arguments."
+	// fmtErrCmdTooMany is replied when too many arguments were given.
+	fmtErrCmdTooMany = "%v: Too many arguments."
+	// fmtErrCmdNoChannel is replied when a channel argument was missing and
+	// could not be taken from where the command was used.
+	fmtErrCmdNoChannel = "%v: A channel is required."
+	// fmtErrCmdBadNick is replied when a nick argument is not a nick.
+	fmtErrCmdBadNick = "%v: Invalid nick (%v)."
+	// fmtErrCmdHandler is replied when a command handler returns an error.
+	fmtErrCmdHandler = "%v: %v"
+	// fmtErrCmdNotAuthed is replied when a command requires access and the
+	// user is not logged in.
+	fmtErrCmdNotAuthed = "%v: You are not logged in."
+	// fmtErrCmdAccess is replied when a user does not have enough access.
+	fmtErrCmdAccess = "%v: Access denied."
+	// fmtCmdUsage is replied after an error to explain the command.
+	fmtCmdUsage = "Usage: %v%v"
+)
+
+var (
+	// errCmdMissing is returned when a nil or nameless command is registered.
+	errCmdMissing = errors.New("dispatch: Command requires a name and handler")
+)
+
+// AccessChecker looks up the account a user is logged in to. It is used by the
+// Commander to enforce access on commands, see data.UserStore.
+type AccessChecker interface {
+	GetAuthedUser(server string, mask irc.Mask) *data.User
+}
+
+// CommandHandler is the interface for handling commands registered with
+// a Commander. Returning an error replies with the error and the command's
+// usage to the user who ran it.
+type CommandHandler interface {
+	Command(command string, data *CommandData, endpoint irc.Endpoint) error
+}
+
+// Command describes a command. Args is a list of argument specs:
+//
+//	name      a required argument
+//	[name]    an optional argument, may only be followed by optional ones
+//	name...   a variadic argument, collects the rest of the line, must be last
+//	[name...] an optional variadic argument
+//	#name     a channel, must be first. When the command is used in a channel
+//	          and no channel was given, that channel is used.
+//	~name     a nick, validated to not be a channel.
+//
+// Channel and nick specs may also be wrapped in [] to make them optional.
+type Command struct {
+	// Name of the command, what the user types after the prefix.
+	Name string
+	// Description is given to users along with usage.
+	Description string
+	// Args is the argument spec, see Command.
+	Args []string
+	// Scope is where the command can be used, defaults to CMDSCOPE_ALL.
+	Scope int
+	// Handler is called when the command is used.
+	Handler CommandHandler
+	// Level is the access level required to use the command, on the channel
+	// the command is about if there is one.
+	Level uint8
+	// Flags are the access flags required to use the command.
+	Flags string
+
+	args []commandArg
+}
+
+// commandArg is a parsed argument spec.
+type commandArg struct {
+	name string
+	kind int
+}
+
+// CommandData is given to a CommandHandler, it holds the message the command
+// came from and its parsed arguments.
+type CommandData struct {
+	*irc.Message
+
+	args     map[string]string
+	variadic []string
+	channel  string
+	private  bool
+	user     *data.User
+}
+
+// Arg returns the argument given by name, or empty string if it was not
+// supplied. Variadic arguments are returned joined by spaces.
+func (d *CommandData) Arg(name string) string {
+	return d.args[name]
+}
+
+// Variadic returns the words that made up the variadic argument if one exists.
+func (d *CommandData) Variadic() []string {
+	return d.variadic
+}
+
+// Channel returns the channel the command is about. This is the channel
+// argument if one was declared, or the channel the command was used in, or
+// empty string when used in private without one.
+func (d *CommandData) Channel() string {
+	return d.channel
+}
+
+// IsPrivate returns true if the command was used in a private message.
+func (d *CommandData) IsPrivate() bool {
+	return d.private
+}
+
+// GetUser returns the authenticated user who used the command, nil if they are
+// not logged in or the Commander has no AccessChecker.
+func (d *CommandData) GetUser() *data.User {
+	return d.user
+}
+
+// Nick returns the nick of the user who used the command.
+func (d *CommandData) Nick() string {
+	return irc.Mask(d.Sender).GetNick()
+}
+
+// Commander dispatches commands found in privmsgs. It is meant to be
+// registered with a Dispatcher for PRIVMSG. A command is recognized when a
+// message begins with the prefix of the server it came from, when it is
+// addressed to the bot by nick ("nick: command" or "nick, command"), or when
+// it is sent in private, where the prefix is optional.
+type Commander struct {
+	commands map[string]*Command
+	prefix   string
+	prefixes map[string]string
+	nicks    map[string]string
+	finder   *irc.ChannelFinder
+	finders  map[string]*irc.ChannelFinder
+	casemaps map[string]irc.Casemap
+	access   AccessChecker
+
+	// Protects all state variables.
+	protect sync.RWMutex
+}
+
+// CreateCommander creates a commander that uses prefix for all servers that
+// have not been given their own with ServerPrefix.
+func CreateCommander(prefix string) *Commander {
+	finder, _ := irc.CreateChannelFinder(irc.CAPS_DEFAULT_CHANTYPES)
+	return &Commander{
+		commands: make(map[string]*Command),
+		prefix:   prefix,
+		prefixes: make(map[string]string),
+		nicks:    make(map[string]string),
+		finder:   finder,
+		finders:  make(map[string]*irc.ChannelFinder),
+		casemaps: make(map[string]irc.Casemap),
+	}
+}
+
+// Prefix sets the default prefix.
+func (c *Commander) Prefix(prefix string) {
+	c.protect.Lock()
+	c.prefix = prefix
+	c.protect.Unlock()
+}
+
+// ServerPrefix sets the prefix for the server identified by key, an empty
+// prefix reverts the server to the default.
+func (c *Commander) ServerPrefix(key, prefix string) {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	if len(prefix) == 0 {
+		delete(c.prefixes, key)
+	} else {
+		c.prefixes[key] = prefix
+	}
+}
+
+// GetPrefix gets the prefix used for the server identified by key.
+func (c *Commander) GetPrefix(key string) string {
+	c.protect.RLock()
+	defer c.protect.RUnlock()
+	return c.getPrefix(key)
+}
+
+// getPrefix gets the prefix for a server. Not thread safe.
+func (c *Commander) getPrefix(key string) string {
+	if prefix, ok := c.prefixes[key]; ok {
+		return prefix
+	}
+	return c.prefix
+}
+
+// ServerNick sets the bot's nick on the server identified by key, commands
+// addressed to this nick are recognized.
+func (c *Commander) ServerNick(key, nick string) {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	if len(nick) == 0 {
+		delete(c.nicks, key)
+	} else {
+		c.nicks[key] = nick
+	}
+}
+
+// GetServerNick gets the bot's nick on the server identified by key.
+func (c *Commander) GetServerNick(key string) string {
+	c.protect.RLock()
+	defer c.protect.RUnlock()
+	return c.nicks[key]
+}
+
+// Protocaps sets the channel types used to recognize channel arguments and the
+// casemap used to recognize the bot's nick on the server identified by key.
+// Nil caps revert the server to the defaults.
+func (c *Commander) Protocaps(key string, caps *irc.ProtoCaps) error {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+
+	if caps == nil {
+		delete(c.finders, key)
+		delete(c.casemaps, key)
+		return nil
+	}
+
+	finder, err := irc.CreateChannelFinder(caps.Chantypes())
+	if err != nil {
+		return err
+	}
+	c.finders[key] = finder
+	c.casemaps[key] = caps.Casemap()
+	return nil
+}
+
+// getFinder gets the channel finder for a server. Not thread safe.
+func (c *Commander) getFinder(key string) *irc.ChannelFinder {
+	if finder, ok := c.finders[key]; ok {
+		return finder
+	}
+	return c.finder
+}
+
+// Access sets the AccessChecker used to find logged in users. Without one,
+// commands that require access can never be used.
+func (c *Commander) Access(checker AccessChecker) {
+	c.protect.Lock()
+	c.access = checker
+	c.protect.Unlock()
+}
+
+// Register registers a command. Commands are case insensitive.
+func (c *Commander) Register(cmd *Command) error {
+	if cmd == nil || len(cmd.Name) == 0 || cmd.Handler == nil {
+		return errCmdMissing
+	}
+
+	args, err := parseCommandArgs(cmd.Args)
+	if err != nil {
+		return errors.New(fmt.Sprintf(fmtErrCmdArgSpec, cmd.Name, err))
+	}
+	cmd.args = args
+	if cmd.Scope == 0 {
+		cmd.Scope = CMDSCOPE_ALL
+	}
+
+	name := strings.ToLower(cmd.Name)
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	if _, ok := c.commands[name]; ok {
+		return errors.New(fmt.Sprintf(fmtErrCmdExists, cmd.Name))
+	}
+	c.commands[name] = cmd
+	return nil
+}
+
+// Unregister removes a command by name. Returns false if it did not exist.
+func (c *Commander) Unregister(name string) bool {
+	name = strings.ToLower(name)
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	if _, ok := c.commands[name]; ok {
+		delete(c.commands, name)
+		return true
+	}
+	return false
+}
+
+// Usage returns the usage string of a command as used on the server
+// identified by key. Empty string if the command does not exist.
+func (c *Commander) Usage(name, key string) string {
+	c.protect.RLock()
+	defer c.protect.RUnlock()
+	if cmd, ok := c.commands[strings.ToLower(name)]; ok {
+		return c.usage(cmd, key)
+	}
+	return ""
+}
+
+// usage builds the usage string for a command. Not thread safe.
+func (c *Commander) usage(cmd *Command, key string) string {
+	str := fmt.Sprintf(fmtCmdUsage, c.getPrefix(key), cmd.Name)
+	if len(cmd.Args) > 0 {
+		str += " " + strings.Join(cmd.Args, " ")
+	}
+	if len(cmd.Description) > 0 {
+		str += " - " + cmd.Description
+	}
+	return str
+}
+
+// PrivmsgChannel implements PrivmsgChannelHandler.
+func (c *Commander) PrivmsgChannel(msg *irc.Message, ep irc.Endpoint) {
+	c.run(msg, ep, false)
+}
+
+// PrivmsgUser implements PrivmsgUserHandler.
+func (c *Commander) PrivmsgUser(msg *irc.Message, ep irc.Endpoint) {
+	c.run(msg, ep, true)
+}
+
+// run finds a command in the message and executes it.
+func (c *Commander) run(msg *irc.Message, ep irc.Endpoint, private bool) {
+	if len(msg.Args) < 2 {
+		return
+	}
+
+	c.protect.RLock()
+	line, ok := c.strip(msg.Message(), ep.GetKey(), private)
+	if !ok {
+		c.protect.RUnlock()
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		c.protect.RUnlock()
+		return
+	}
+	cmd, ok := c.commands[strings.ToLower(fields[0])]
+	if !ok || (private && cmd.Scope&CMDSCOPE_PRIVATE == 0) ||
+		(!private && cmd.Scope&CMDSCOPE_CHANNEL == 0) {
+
+		c.protect.RUnlock()
+		return
+	}
+	finder, access := c.getFinder(ep.GetKey()), c.access
+	usage := c.usage(cmd, ep.GetKey())
+	c.protect.RUnlock()
+
+	data := &CommandData{
+		Message: msg,
+		private: private,
+	}
+	if !private {
+		data.channel = msg.Target()
+	}
+
+	nick := data.Nick()
+	err := parseCommandData(cmd, data, fields[1:], finder)
+	if err != nil {
+		ep.Notice(nick, err.Error())
+		ep.Notice(nick, usage)
+		return
+	}
+
+	if access != nil {
+		data.user = access.GetAuthedUser(ep.GetKey(), irc.Mask(msg.Sender))
+	}
+	if cmd.Level > 0 || len(cmd.Flags) > 0 {
+		if data.user == nil {
+			ep.Notice(nick, fmt.Sprintf(fmtErrCmdNotAuthed, cmd.Name))
+			return
+		}
+		if !data.user.GetUserAccess().Has(ep.GetKey(), data.channel, cmd.Level,
+			cmd.Flags) {
+
+			ep.Notice(nick, fmt.Sprintf(fmtErrCmdAccess, cmd.Name))
+			return
+		}
+	}
+
+	if err = cmd.Handler.Command(cmd.Name, data, ep); err != nil {
+		ep.Notice(nick, fmt.Sprintf(fmtErrCmdHandler, cmd.Name, err))
+		ep.Notice(nick, usage)
+	}
+}
+
+// strip removes the prefix or the bot's nick from the start of a line. If
+// neither was present and the line was not private, false is returned.
+// Not thread safe.
+func (c *Commander) strip(line, key string, private bool) (string, bool) {
+	prefix := c.getPrefix(key)
+	if len(prefix) > 0 && strings.HasPrefix(line, prefix) {
+		return line[len(prefix):], true
+	}
+
+	if nick := c.nicks[key]; len(nick) > 0 && len(line) > len(nick)+1 &&
+		c.casemaps[key].Equals(line[:len(nick)], nick) {
+
+		if sep := line[len(nick)]; sep == ':' || sep == ',' {
+			return line[len(nick)+1:], true
+		}
+	}
+
+	return line, private
+}
+
+// parseCommandArgs parses and validates the argument specs of a command.
+func parseCommandArgs(specs []string) ([]commandArg, error) {
+	args := make([]commandArg, 0, len(specs))
+	optional, variadic := false, false
+	for i, spec := range specs {
+		arg := commandArg{kind: CMDARG_REQUIRED}
+		if strings.HasPrefix(spec, "[") && strings.HasSuffix(spec, "]") {
+			arg.kind = CMDARG_OPTIONAL
+			spec = spec[1 : len(spec)-1]
+		}
+		if strings.HasSuffix(spec, "...") {
+			arg.kind |= CMDARG_VARIADIC
+			spec = spec[:len(spec)-3]
+		}
+		if strings.HasPrefix(spec, "#") {
+			if i != 0 {
+				return nil, errors.New("channel must be first")
+			}
+			arg.kind |= CMDARG_CHANNEL
+			spec = spec[1:]
+		} else if strings.HasPrefix(spec, "~") {
+			arg.kind |= CMDARG_NICK
+			spec = spec[1:]
+		}
+		arg.name = spec
+
+		if len(arg.name) == 0 || strings.ContainsAny(arg.name, " []#~.") {
+			return nil, errors.New(specs[i])
+		}
+		if variadic {
+			return nil, errors.New("variadic must be last")
+		}
+		if arg.kind&CMDARG_OPTIONAL != 0 {
+			optional = true
+		} else if optional && arg.kind&CMDARG_CHANNEL == 0 {
+			return nil, errors.New("required after optional")
+		}
+		if arg.kind&CMDARG_VARIADIC != 0 {
+			if arg.kind&(CMDARG_CHANNEL|CMDARG_NICK) != 0 {
+				return nil, errors.New("channel and nick can't be variadic")
+			}
+			variadic = true
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// parseCommandData fills in the arguments of data from the words given after
+// the command name.
+func parseCommandData(cmd *Command, data *CommandData, words []string,
+	finder *irc.ChannelFinder) error {
+
+	data.args = make(map[string]string, len(cmd.args))
+	for _, arg := range cmd.args {
+		if arg.kind&CMDARG_CHANNEL != 0 {
+			if len(words) > 0 && finder.IsChannel(words[0]) {
+				data.channel = words[0]
+				words = words[1:]
+			} else if len(data.channel) == 0 &&
+				arg.kind&CMDARG_OPTIONAL == 0 {
+
+				return errors.New(fmt.Sprintf(fmtErrCmdNoChannel, cmd.Name))
+			}
+			data.args[arg.name] = data.channel
+			continue
+		}
+
+		if len(words) == 0 {
+			if arg.kind&CMDARG_OPTIONAL == 0 {
+				return errors.New(fmt.Sprintf(fmtErrCmdTooFew, cmd.Name))
+			}
+			continue
+		}
+
+		if arg.kind&CMDARG_VARIADIC != 0 {
+			data.variadic = words
+			data.args[arg.name] = strings.Join(words, " ")
+			words = nil
+			continue
+		}
+
+		if arg.kind&CMDARG_NICK != 0 && (finder.IsChannel(words[0]) ||
+			strings.ContainsAny(words[0], "!@*?")) {
+
+			return errors.New(
+				fmt.Sprintf(fmtErrCmdBadNick, cmd.Name, words[0]))
+		}
+		data.args[arg.name] = words[0]
+		words = words[1:]
+	}
+
+	if len(words) > 0 {
+		return errors.New(fmt.Sprintf(fmtErrCmdTooMany, cmd.Name))
+	}
+	return nil
+}
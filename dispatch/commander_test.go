@@ -0,0 +1,291 @@
+package dispatch
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+)
+
+//===========================================================
+// Set up types that can be used to mock commands.
+//===========================================================
+type testCommandHandler struct {
+	command string
+	data    *CommandData
+	err     error
+}
+
+func (t *testCommandHandler) Command(command string, data *CommandData,
+	ep irc.Endpoint) error {
+
+	t.command = command
+	t.data = data
+	return t.err
+}
+
+type testCommandPoint struct {
+	*irc.Helper
+	buf *bytes.Buffer
+	key string
+}
+
+func createTestCommandPoint(key string) *testCommandPoint {
+	buf := &bytes.Buffer{}
+	return &testCommandPoint{&irc.Helper{Writer: buf}, buf, key}
+}
+
+func (t *testCommandPoint) GetKey() string {
+	return t.key
+}
+
+func cmdMsg(target, msg string) *irc.Message {
+	return &irc.Message{IrcMessage: &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "nick!user@host.com",
+		Args:   []string{target, msg},
+	}}
+}
+
+//===========================================================
+// Tests
+//===========================================================
+func (s *s) TestCommander_Register(c *C) {
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+
+	c.Check(cmd.Register(nil), Equals, errCmdMissing)
+	c.Check(cmd.Register(&Command{Name: "x"}), Equals, errCmdMissing)
+	c.Check(cmd.Register(&Command{Name: "kick", Handler: h,
+		Args: []string{"#chan", "~nick", "[reason...]"}}), IsNil)
+	c.Check(cmd.Register(&Command{Name: "KICK", Handler: h}), NotNil)
+	c.Check(cmd.Unregister("Kick"), Equals, true)
+	c.Check(cmd.Unregister("kick"), Equals, false)
+
+	bad := [][]string{
+		{"a", "#chan"},
+		{"rest...", "a"},
+		{"[a]", "b"},
+		{"#chan..."},
+		{"[]"},
+		{"a b"},
+	}
+	for _, args := range bad {
+		err := cmd.Register(&Command{Name: "bad", Handler: h, Args: args})
+		c.Check(err, NotNil, Commentf("%v", args))
+	}
+}
+
+func (s *s) TestCommander_Prefix(c *C) {
+	cmd := CreateCommander(".")
+	cmd.ServerPrefix("srv", "!")
+	c.Check(cmd.GetPrefix("srv"), Equals, "!")
+	c.Check(cmd.GetPrefix("other"), Equals, ".")
+
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "hi", Handler: h})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".hi"), ep)
+	c.Check(h.data, IsNil)
+	cmd.PrivmsgChannel(cmdMsg("#chan", "!hi"), ep)
+	c.Check(h.command, Equals, "hi")
+	c.Check(h.data.Channel(), Equals, "#chan")
+	c.Check(h.data.IsPrivate(), Equals, false)
+	c.Check(h.data.Nick(), Equals, "nick")
+
+	h.data = nil
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".hi"),
+		createTestCommandPoint("other"))
+	c.Check(h.data, NotNil)
+
+	cmd.ServerPrefix("srv", "")
+	h.data = nil
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".hi"), ep)
+	c.Check(h.data, NotNil)
+}
+
+func (s *s) TestCommander_Private(c *C) {
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "hi", Handler: h})
+	cmd.Register(&Command{Name: "chanonly", Handler: h,
+		Scope: CMDSCOPE_CHANNEL})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgUser(cmdMsg("bot", "hi"), ep)
+	c.Check(h.command, Equals, "hi")
+	c.Check(h.data.IsPrivate(), Equals, true)
+	c.Check(h.data.Channel(), Equals, "")
+
+	h.command = ""
+	cmd.PrivmsgUser(cmdMsg("bot", ".hi"), ep)
+	c.Check(h.command, Equals, "hi")
+
+	h.command = ""
+	cmd.PrivmsgUser(cmdMsg("bot", "chanonly"), ep)
+	c.Check(h.command, Equals, "")
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".chanonly"), ep)
+	c.Check(h.command, Equals, "chanonly")
+}
+
+func (s *s) TestCommander_Addressing(c *C) {
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "hi", Handler: h})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgChannel(cmdMsg("#chan", "bot: hi"), ep)
+	c.Check(h.data, IsNil)
+
+	cmd.ServerNick("srv", "Bot")
+	c.Check(cmd.GetServerNick("srv"), Equals, "Bot")
+	cmd.PrivmsgChannel(cmdMsg("#chan", "bot: hi"), ep)
+	c.Check(h.command, Equals, "hi")
+	h.command = ""
+	cmd.PrivmsgChannel(cmdMsg("#chan", "Bot, hi"), ep)
+	c.Check(h.command, Equals, "hi")
+	h.command = ""
+	cmd.PrivmsgChannel(cmdMsg("#chan", "Bothi"), ep)
+	c.Check(h.command, Equals, "")
+
+	cmd.ServerNick("srv", "Bot[1]")
+	cmd.PrivmsgChannel(cmdMsg("#chan", "bot{1}: hi"), ep)
+	c.Check(h.command, Equals, "")
+
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{
+		Args: []string{"nick", "CASEMAPPING=rfc1459", "CHANTYPES=&"},
+	})
+	c.Check(cmd.Protocaps("srv", caps), IsNil)
+	cmd.PrivmsgChannel(cmdMsg("#chan", "bot{1}: hi"), ep)
+	c.Check(h.command, Equals, "hi")
+	c.Check(cmd.getFinder("srv").IsChannel("&chan"), Equals, true)
+	c.Check(cmd.getFinder("srv").IsChannel("#chan"), Equals, false)
+	c.Check(cmd.getFinder("other").IsChannel("#chan"), Equals, true)
+
+	c.Check(cmd.Protocaps("srv", nil), IsNil)
+	h.command = ""
+	cmd.PrivmsgChannel(cmdMsg("#chan", "bot{1}: hi"), ep)
+	c.Check(h.command, Equals, "")
+}
+
+func (s *s) TestCommander_Args(c *C) {
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "kick", Handler: h,
+		Args: []string{"#chan", "~nick", "[reason...]"}})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick fish go  away now"), ep)
+	c.Check(h.data.Arg("chan"), Equals, "#chan")
+	c.Check(h.data.Arg("nick"), Equals, "fish")
+	c.Check(h.data.Arg("reason"), Equals, "go away now")
+	c.Check(h.data.Variadic(), DeepEquals, []string{"go", "away", "now"})
+
+	h.data = nil
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick #other fish"), ep)
+	c.Check(h.data.Channel(), Equals, "#other")
+	c.Check(h.data.Arg("chan"), Equals, "#other")
+	c.Check(h.data.Arg("reason"), Equals, "")
+	c.Check(h.data.Variadic(), IsNil)
+
+	h.data = nil
+	cmd.PrivmsgUser(cmdMsg("bot", "kick #other fish"), ep)
+	c.Check(h.data.Channel(), Equals, "#other")
+
+	h.data = nil
+	cmd.PrivmsgUser(cmdMsg("bot", "kick fish"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Matches, "NOTICE nick :kick: A channel.*"+
+		"NOTICE nick :Usage: .kick #chan ~nick \\[reason...\\]")
+
+	ep.buf.Reset()
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick #a"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Matches, ".*Not enough arguments.*")
+
+	ep.buf.Reset()
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick #a #b"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Matches, ".*Invalid nick.*")
+
+	cmd.Register(&Command{Name: "one", Handler: h, Args: []string{"a"}})
+	ep.buf.Reset()
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".one a b"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Matches, ".*Too many arguments.*")
+}
+
+func (s *s) TestCommander_HandlerError(c *C) {
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{err: errors.New("No way")}
+	cmd.Register(&Command{Name: "hi", Handler: h, Description: "Says hi."})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".hi"), ep)
+	c.Check(ep.buf.String(), Equals,
+		"NOTICE nick :hi: No wayNOTICE nick :Usage: .hi - Says hi.")
+	c.Check(cmd.Usage("HI", "srv"), Equals, "Usage: .hi - Says hi.")
+	c.Check(cmd.Usage("nope", "srv"), Equals, "")
+}
+
+func (s *s) TestCommander_Dispatcher(c *C) {
+	d, err := CreateRichDispatcher(irc.CreateProtoCaps(), nil)
+	c.Check(err, IsNil)
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "hi", Handler: h})
+	d.Register(irc.PRIVMSG, cmd)
+
+	d.Dispatch(cmdMsg("#chan", ".hi").IrcMessage, createTestCommandPoint(""))
+	d.WaitForCompletion()
+	c.Check(h.command, Equals, "hi")
+}
+
+func (s *s) TestCommander_Access(c *C) {
+	users, err := data.CreateUserStore("")
+	c.Assert(err, IsNil)
+	ua, _ := data.CreateUserAccess("user", "pass")
+	ua.GrantChannel("srv", "#chan", 100, "k")
+	users.AddUser(ua)
+
+	cmd := CreateCommander(".")
+	h := &testCommandHandler{}
+	cmd.Register(&Command{Name: "kick", Handler: h, Level: 100, Flags: "k",
+		Args: []string{"#chan", "~nick"}})
+	cmd.Register(&Command{Name: "hi", Handler: h})
+
+	ep := createTestCommandPoint("srv")
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick fish"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Equals, "NOTICE nick :kick: You are not "+
+		"logged in.")
+
+	cmd.Access(users)
+	ep.buf.Reset()
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick fish"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Matches, ".*not logged in.*")
+
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".hi"), ep)
+	c.Check(h.data.GetUser(), IsNil)
+
+	users.Login("srv", "nick!user@host.com", "user", "pass")
+	h.data = nil
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick fish"), ep)
+	c.Check(h.command, Equals, "kick")
+	c.Check(h.data.GetUser().GetUserAccess().Username, Equals, "user")
+
+	h.data = nil
+	ep.buf.Reset()
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick #other fish"), ep)
+	c.Check(h.data, IsNil)
+	c.Check(ep.buf.String(), Equals, "NOTICE nick :kick: Access denied.")
+
+	h.data = nil
+	cmd.PrivmsgChannel(cmdMsg("#chan", ".kick fish"),
+		createTestCommandPoint("other"))
+	c.Check(h.data, IsNil)
+}
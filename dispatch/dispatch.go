@@ -2,11 +2,20 @@
 dispatch package is used to dispatch irc messages to event handlers in an
 asynchronous fashion. It supports various event handling types to easily
 extract information from events, as well as define more succint handlers.
+
+A handler that panics is recovered so it can't bring down the bot, it's logged
+and given to the callback set with OnError. Handlers that keep failing can be
+unregistered automatically with FailureLimit.
+
+Each handler's calls run in goroutines of their own by default. Handlers can
+instead be registered with RegisterMode to run on a bounded worker pool, see
+WorkerPool, or one call at a time in the order they were dispatched.
 */
 package dispatch
 
 import (
 	"errors"
+	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 	"math/rand"
 	"strings"
@@ -38,13 +47,24 @@ type (
 // Dispatcher is made for handling bot-local dispatching of irc
 // events.
 type Dispatcher struct {
-	events eventTableStore
-	finder *irc.ChannelFinder
-	chans  []string
-	waiter sync.WaitGroup
+	events  eventTableStore
+	finder  *irc.ChannelFinder
+	casemap irc.Casemap
+	chans   []string
+	waiter  sync.WaitGroup
+
+	modes   map[handlerKey]int
+	serials map[handlerKey]*serialQueue
+	pool    *workerPool
+
+	onError   func(*HandlerError)
+	failLimit int
+	failures  map[handlerKey]int
 
 	// Protects all state variables.
 	protect sync.RWMutex
+	// Protects the error callback and failure counts.
+	failProtect sync.RWMutex
 }
 
 // CreateDispatcher initializes an empty dispatcher ready to register events.
@@ -87,9 +107,16 @@ func (d *Dispatcher) Protocaps(caps *irc.ProtoCaps) (err error) {
 	return
 }
 
-// protocaps sets the protocaps for this dispatcher. Not thread safe.
+// protocaps sets the protocaps for this dispatcher. The active channels are
+// folded again if the casemapping changed. Not thread safe.
 func (d *Dispatcher) protocaps(caps *irc.ProtoCaps) (err error) {
-	d.finder, err = irc.CreateChannelFinder(caps.Chantypes())
+	if d.finder, err = irc.CreateChannelFinder(caps.Chantypes()); err != nil {
+		return
+	}
+	if casemap := caps.Casemap(); casemap != d.casemap {
+		d.casemap = casemap
+		d.channels(d.chans)
+	}
 	return
 }
 
@@ -126,7 +153,7 @@ func (d *Dispatcher) AddChannels(chans ...string) {
 	}
 
 	for i := 0; i < len(chans); i++ {
-		addchan := strings.ToLower(chans[i])
+		addchan := d.casemap.Fold(chans[i])
 		found := false
 		for j, length := 0, len(d.chans); j < length; j++ {
 			if d.chans[j] == addchan {
@@ -153,7 +180,7 @@ func (d *Dispatcher) RemoveChannels(chans ...string) {
 	}
 
 	for i := 0; i < len(chans); i++ {
-		removechan := strings.ToLower(chans[i])
+		removechan := d.casemap.Fold(chans[i])
 		for j, length := 0, len(d.chans); j < length; j++ {
 			if d.chans[j] == removechan {
 				if length == 1 {
@@ -180,7 +207,7 @@ func (d *Dispatcher) channels(chans []string) {
 	} else {
 		d.chans = make([]string, length)
 		for i := 0; i < length; i++ {
-			d.chans[i] = strings.ToLower(chans[i])
+			d.chans[i] = d.casemap.Fold(chans[i])
 		}
 	}
 }
@@ -218,6 +245,7 @@ func (d *Dispatcher) Unregister(event string, id int) bool {
 	if ev, ok := d.events[event]; ok {
 		if _, ok := ev[id]; ok {
 			delete(ev, id)
+			d.forget(handlerKey{event, id})
 			return true
 		}
 	}
@@ -225,46 +253,148 @@ func (d *Dispatcher) Unregister(event string, id int) bool {
 }
 
 // Dispatch an IrcMessage to event handlers handling event also ensures all raw
-// handlers receive all messages. Returns false if no eventtable was found for
-// the primary sent event.
+// handlers receive all messages. Privmsgs and notices holding ctcp go to the
+// irc.CTCP, irc.ACTION or irc.CTCP_REPLY handlers instead of their own.
+// Returns false if no eventtable was found for the primary sent event.
 func (d *Dispatcher) Dispatch(msg *irc.IrcMessage, ep irc.Endpoint) bool {
 	event := strings.ToUpper(msg.Name)
 
+	if ctcp := ctcpEvent(event, msg); len(ctcp) > 0 {
+		event = ctcp
+	}
+
 	d.protect.RLock()
-	defer d.protect.RUnlock()
+	jobs, handled := d.collect(nil, event, msg, nil, ep)
+	jobs, _ = d.collect(jobs, irc.RAW, msg, nil, ep)
+	d.protect.RUnlock()
 
-	handled := d.dispatchHelper(event, msg, ep)
-	d.dispatchHelper(irc.RAW, msg, ep)
+	d.submit(jobs)
+	return handled
+}
 
+// DispatchState sends a change made by the data store to the handlers
+// registered for its event name and to those registered for data.STATE.
+// Changes to channels that are filtered out are not sent. Returns true if
+// there were handlers for its event name.
+func (d *Dispatcher) DispatchState(ev data.StateEvent, ep irc.Endpoint) bool {
+	d.protect.RLock()
+	if channel := stateChannel(ev); len(channel) > 0 && d.chans != nil &&
+		!d.checkChannel(channel) {
+
+		d.protect.RUnlock()
+		return false
+	}
+
+	jobs, handled := d.collect(nil, ev.StateName(), nil, ev, ep)
+	jobs, _ = d.collect(jobs, data.STATE, nil, ev, ep)
+	d.protect.RUnlock()
+
+	d.submit(jobs)
 	return handled
 }
 
+// ctcpEvent returns the event a privmsg or notice holding ctcp is dispatched
+// as instead of it's own, or empty string if it doesn't hold ctcp.
+func ctcpEvent(event string, msg *irc.IrcMessage) string {
+	if len(msg.Args) < 2 || !irc.IsCTCP(msg.Args[1]) {
+		return ""
+	}
+
+	switch event {
+	case irc.PRIVMSG:
+		if tag, _ := irc.CTCPUnpack(msg.Args[1]); tag == irc.ACTION {
+			return irc.ACTION
+		}
+		return irc.CTCP
+	case irc.NOTICE:
+		return irc.CTCP_REPLY
+	}
+	return ""
+}
+
 // WaitForCompletion waits on all active event handlers to return. Bad event
 // handlers may never return.
 func (d *Dispatcher) WaitForCompletion() {
 	d.waiter.Wait()
 }
 
-// dispatchHelper locates a handler and attempts to resolve it with
-// resolveHandler. It returns true if it was able to find an event table.
-func (d *Dispatcher) dispatchHelper(event string,
-	msg *irc.IrcMessage, ep irc.Endpoint) bool {
+// resolveState calls the method of the handler that takes the type of the
+// state event, falling back to HandleState.
+func (d *Dispatcher) resolveState(id int, handler interface{}, event string,
+	ev data.StateEvent, ep irc.Endpoint) {
 
-	if evtable, ok := d.events[event]; ok {
-		for _, handler := range evtable {
-			d.waiter.Add(1)
-			go d.resolveHandler(handler, event, msg, ep)
+	defer d.handlerDone(event, id, handler, ep)
+
+	switch e := ev.(type) {
+	case *data.UserJoin:
+		if h, ok := handler.(UserJoinHandler); ok {
+			h.UserJoin(e, ep)
+			return
+		}
+	case *data.UserLeave:
+		if h, ok := handler.(UserLeaveHandler); ok {
+			h.UserLeave(e, ep)
+			return
+		}
+	case *data.UserRename:
+		if h, ok := handler.(UserRenameHandler); ok {
+			h.UserRename(e, ep)
+			return
+		}
+	case *data.UserMode:
+		if h, ok := handler.(UserModeHandler); ok {
+			h.UserMode(e, ep)
+			return
+		}
+	case *data.ChannelMode:
+		if h, ok := handler.(ChannelModeHandler); ok {
+			h.ChannelMode(e, ep)
+			return
+		}
+	case *data.Ban:
+		if h, ok := handler.(BanHandler); ok {
+			h.Ban(e, ep)
+			return
+		}
+	case *data.Topic:
+		if h, ok := handler.(TopicStateHandler); ok {
+			h.TopicState(e, ep)
+			return
 		}
-		return true
 	}
-	return false
+
+	if h, ok := handler.(StateHandler); ok {
+		h.HandleState(ev, ep)
+	}
+}
+
+// stateChannel returns the channel a state event happened in, empty if it
+// isn't about a channel.
+func stateChannel(ev data.StateEvent) string {
+	switch e := ev.(type) {
+	case *data.UserJoin:
+		return e.Channel
+	case *data.UserLeave:
+		return e.Channel
+	case *data.UserMode:
+		return e.Channel
+	case *data.ChannelMode:
+		return e.Channel
+	case *data.Ban:
+		return e.Channel
+	case *data.Topic:
+		return e.Channel
+	}
+	return ""
 }
 
 // resolveHandler checks the type of the handler passed in, resolves it to a
 // real type, coerces the IrcMessage in whatever way necessary and then
 // calls that handlers primary dispatch method with the coerced message.
-func (d *Dispatcher) resolveHandler(
-	handler interface{}, event string, msg *irc.IrcMessage, ep irc.Endpoint) {
+func (d *Dispatcher) resolveHandler(id int, handler interface{}, event string,
+	msg *irc.IrcMessage, ep irc.Endpoint) {
+
+	defer d.handlerDone(event, id, handler, ep)
 
 	switch t := handler.(type) {
 	case PrivmsgHandler, PrivmsgUserHandler, PrivmsgChannelHandler:
@@ -272,13 +402,13 @@ func (d *Dispatcher) resolveHandler(
 		if channelHandler, ok := t.(PrivmsgChannelHandler); ok &&
 			d.shouldDispatch(true, msg) {
 
-			channelHandler.PrivmsgChannel(&irc.Message{msg}, ep)
+			channelHandler.PrivmsgChannel(&irc.Message{IrcMessage: msg}, ep)
 		} else if userHandler, ok := t.(PrivmsgUserHandler); ok &&
 			d.shouldDispatch(false, msg) {
 
-			userHandler.PrivmsgUser(&irc.Message{msg}, ep)
+			userHandler.PrivmsgUser(&irc.Message{IrcMessage: msg}, ep)
 		} else if privmsgHandler, ok := t.(PrivmsgHandler); ok {
-			privmsgHandler.Privmsg(&irc.Message{msg}, ep)
+			privmsgHandler.Privmsg(&irc.Message{IrcMessage: msg}, ep)
 		}
 
 	case NoticeHandler, NoticeUserHandler, NoticeChannelHandler:
@@ -286,19 +416,101 @@ func (d *Dispatcher) resolveHandler(
 		if channelHandler, ok := t.(NoticeChannelHandler); ok &&
 			d.shouldDispatch(true, msg) {
 
-			channelHandler.NoticeChannel(&irc.Message{msg}, ep)
+			channelHandler.NoticeChannel(&irc.Message{IrcMessage: msg}, ep)
 		} else if userHandler, ok := t.(NoticeUserHandler); ok &&
 			d.shouldDispatch(false, msg) {
 
-			userHandler.NoticeUser(&irc.Message{msg}, ep)
+			userHandler.NoticeUser(&irc.Message{IrcMessage: msg}, ep)
 		} else if noticeHandler, ok := t.(NoticeHandler); ok {
-			noticeHandler.Notice(&irc.Message{msg}, ep)
+			noticeHandler.Notice(&irc.Message{IrcMessage: msg}, ep)
+		}
+	default:
+		if !resolveTyped(handler, event, msg, ep) {
+			if eventHandler, ok := t.(EventHandler); ok {
+				eventHandler.HandleRaw(msg, ep)
+			}
 		}
-	case EventHandler:
-		t.HandleRaw(msg, ep)
 	}
+}
+
+// resolveTyped calls the method of the handler that takes a view of the
+// event's message. It returns false if the handler has no such method.
+func resolveTyped(handler interface{}, event string,
+	msg *irc.IrcMessage, ep irc.Endpoint) bool {
 
-	d.waiter.Done()
+	switch event {
+	case irc.JOIN:
+		if h, ok := handler.(JoinHandler); ok {
+			h.Join(&irc.JoinMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.PART:
+		if h, ok := handler.(PartHandler); ok {
+			h.Part(&irc.PartMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.QUIT:
+		if h, ok := handler.(QuitHandler); ok {
+			h.Quit(&irc.QuitMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.KICK:
+		if h, ok := handler.(KickHandler); ok {
+			h.Kick(&irc.KickMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.NICK:
+		if h, ok := handler.(NickHandler); ok {
+			h.Nick(&irc.NickMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.MODE:
+		if h, ok := handler.(ModeHandler); ok {
+			h.Mode(&irc.ModeMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.TOPIC:
+		if h, ok := handler.(TopicChangeHandler); ok {
+			h.TopicChange(&irc.TopicMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.INVITE:
+		if h, ok := handler.(InviteHandler); ok {
+			h.Invite(&irc.InviteMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.CTCP:
+		if h, ok := handler.(CTCPHandler); ok {
+			h.CTCP(&irc.CTCPMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.CTCP_REPLY:
+		if h, ok := handler.(CTCPReplyHandler); ok {
+			h.CTCPReply(&irc.CTCPMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.ACTION:
+		if h, ok := handler.(ActionHandler); ok {
+			h.Action(&irc.ActionMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	case irc.CONNECT:
+		if h, ok := handler.(ConnectHandler); ok {
+			h.Connect(ep)
+			return true
+		}
+	case irc.DISCONNECT:
+		if h, ok := handler.(DisconnectHandler); ok {
+			h.Disconnect(ep)
+			return true
+		}
+	default:
+		if h, ok := handler.(NumericHandler); ok && irc.IsNumeric(event) {
+			h.Numeric(&irc.NumericMessage{IrcMessage: msg}, ep)
+			return true
+		}
+	}
+	return false
 }
 
 // shouldDispatch checks if we should dispatch this event. Works for user and
@@ -306,7 +518,8 @@ func (d *Dispatcher) resolveHandler(
 func (d *Dispatcher) shouldDispatch(channel bool, msg *irc.IrcMessage) bool {
 	d.protect.RLock()
 	defer d.protect.RUnlock()
-	return d.finder != nil && channel == d.finder.IsChannel(msg.Args[0]) &&
+	return d.finder != nil && len(msg.Args) > 0 &&
+		channel == d.finder.IsChannel(msg.Args[0]) &&
 		(!channel || d.checkChannels(msg))
 }
 
@@ -318,7 +531,12 @@ func (d *Dispatcher) checkChannels(msg *irc.IrcMessage) bool {
 		return true
 	}
 
-	targ := strings.ToLower(msg.Args[0])
+	return d.checkChannel(msg.Args[0])
+}
+
+// checkChannel checks if a channel is in the list of channels to dispatch to.
+func (d *Dispatcher) checkChannel(channel string) bool {
+	targ := d.casemap.Fold(channel)
 	for i := 0; i < len(d.chans); i++ {
 		if targ == d.chans[i] {
 			return true
@@ -1,8 +1,10 @@
 package dispatch
 
 import (
+	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 	. "launchpad.net/gocheck"
+	"sync"
 	"testing"
 )
 
@@ -467,6 +469,41 @@ func (s *s) TestDispatcher_FilterNoticeChannels(c *C) {
 	c.Check(uc, IsNil)
 }
 
+func (s *s) TestDispatcher_FilterCasemap(c *C) {
+	chanmsg := &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Args:   []string{"#chan{1}", "msg"},
+		Sender: "nick!user@host.com",
+	}
+
+	var pc *irc.Message
+	pch := testPrivmsgChannelHandler{func(m *irc.Message, _ irc.Endpoint) {
+		pc = m
+	}}
+
+	d, err := CreateRichDispatcher(irc.CreateProtoCaps(), []string{"#CHAN[1]"})
+	c.Check(err, IsNil)
+	d.Register(irc.PRIVMSG, pch)
+
+	d.Dispatch(chanmsg, nil)
+	d.WaitForCompletion()
+	c.Check(pc, IsNil)
+
+	caps := irc.CreateProtoCaps()
+	caps.ParseISupport(&irc.IrcMessage{Args: []string{
+		"NICK", "CASEMAPPING=rfc1459",
+	}})
+	c.Check(d.Protocaps(caps), IsNil)
+	c.Check(d.GetChannels(), DeepEquals, []string{"#chan{1}"})
+
+	d.Dispatch(chanmsg, nil)
+	d.WaitForCompletion()
+	c.Check(pc, NotNil)
+
+	d.RemoveChannels("#Chan[1]")
+	c.Check(d.GetChannels(), IsNil)
+}
+
 func (s *s) TestDispatcher_AddRemoveChannels(c *C) {
 	chans := []string{"#chan1", "#chan2", "#chan3"}
 	d, err := CreateRichDispatcher(irc.CreateProtoCaps(), chans)
@@ -588,3 +625,249 @@ func (s *s) TestDispatcher_filterChannelDispatch(c *C) {
 	should = d.checkChannels(&irc.IrcMessage{Args: []string{"#chan2"}})
 	c.Check(should, Equals, false)
 }
+
+type testTypedHandler struct {
+	protect sync.Mutex
+	called  map[string]interface{}
+}
+
+func (t *testTypedHandler) call(event string, view interface{}) {
+	t.protect.Lock()
+	defer t.protect.Unlock()
+	t.called[event] = view
+}
+
+func (t *testTypedHandler) get(event string) interface{} {
+	t.protect.Lock()
+	defer t.protect.Unlock()
+	return t.called[event]
+}
+
+func (t *testTypedHandler) Join(m *irc.JoinMessage, _ irc.Endpoint) {
+	t.call(irc.JOIN, m)
+}
+func (t *testTypedHandler) Part(m *irc.PartMessage, _ irc.Endpoint) {
+	t.call(irc.PART, m)
+}
+func (t *testTypedHandler) Quit(m *irc.QuitMessage, _ irc.Endpoint) {
+	t.call(irc.QUIT, m)
+}
+func (t *testTypedHandler) Kick(m *irc.KickMessage, _ irc.Endpoint) {
+	t.call(irc.KICK, m)
+}
+func (t *testTypedHandler) Nick(m *irc.NickMessage, _ irc.Endpoint) {
+	t.call(irc.NICK, m)
+}
+func (t *testTypedHandler) Mode(m *irc.ModeMessage, _ irc.Endpoint) {
+	t.call(irc.MODE, m)
+}
+func (t *testTypedHandler) TopicChange(m *irc.TopicMessage, _ irc.Endpoint) {
+	t.call(irc.TOPIC, m)
+}
+func (t *testTypedHandler) Invite(m *irc.InviteMessage, _ irc.Endpoint) {
+	t.call(irc.INVITE, m)
+}
+func (t *testTypedHandler) CTCP(m *irc.CTCPMessage, _ irc.Endpoint) {
+	t.call(irc.CTCP, m)
+}
+func (t *testTypedHandler) CTCPReply(m *irc.CTCPMessage, _ irc.Endpoint) {
+	t.call(irc.CTCP_REPLY, m)
+}
+func (t *testTypedHandler) Action(m *irc.ActionMessage, _ irc.Endpoint) {
+	t.call(irc.ACTION, m)
+}
+func (t *testTypedHandler) Numeric(m *irc.NumericMessage, _ irc.Endpoint) {
+	t.call(m.Code(), m)
+}
+func (t *testTypedHandler) Connect(_ irc.Endpoint) {
+	t.call(irc.CONNECT, true)
+}
+func (t *testTypedHandler) Disconnect(_ irc.Endpoint) {
+	t.call(irc.DISCONNECT, true)
+}
+func (t *testTypedHandler) HandleRaw(m *irc.IrcMessage, _ irc.Endpoint) {
+	t.call(irc.RAW, m)
+}
+
+func (s *s) TestDispatcher_TypedHandlers(c *C) {
+	h := &testTypedHandler{called: make(map[string]interface{})}
+	d := CreateDispatcher()
+
+	events := []string{irc.JOIN, irc.PART, irc.QUIT, irc.KICK, irc.NICK,
+		irc.MODE, irc.TOPIC, irc.INVITE, irc.CONNECT, irc.DISCONNECT,
+		irc.RPL_WELCOME}
+	for _, event := range events {
+		d.Register(event, h)
+	}
+
+	for _, event := range events {
+		d.Dispatch(&irc.IrcMessage{Name: event}, nil)
+		d.WaitForCompletion()
+		c.Check(h.get(event), NotNil, Commentf("%v", event))
+	}
+	c.Check(h.get(irc.RAW), IsNil)
+
+	join := &irc.IrcMessage{Name: irc.JOIN, Sender: "nick!user@host",
+		Args: []string{"#chan"}}
+	d.Dispatch(join, nil)
+	d.WaitForCompletion()
+	c.Check(h.get(irc.JOIN).(*irc.JoinMessage).Channel(), Equals, "#chan")
+
+	// Events without a typed method fall back to HandleRaw.
+	d.Register(irc.PING, h)
+	d.Dispatch(&irc.IrcMessage{Name: irc.PING}, nil)
+	d.WaitForCompletion()
+	c.Check(h.get(irc.RAW), NotNil)
+}
+
+func (s *s) TestDispatcher_CTCPAction(c *C) {
+	h := &testTypedHandler{called: make(map[string]interface{})}
+	var p *irc.Message
+	var protect sync.Mutex
+	ph := testPrivmsgHandler{func(m *irc.Message, _ irc.Endpoint) {
+		protect.Lock()
+		p = m
+		protect.Unlock()
+	}}
+
+	d := CreateDispatcher()
+	d.Register(irc.CTCP, h)
+	d.Register(irc.ACTION, h)
+	d.Register(irc.PRIVMSG, ph)
+
+	d.Dispatch(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: "nick!user@host",
+		Args: []string{"me", "\x01VERSION\x01"}}, nil)
+	d.WaitForCompletion()
+	ctcp := h.get(irc.CTCP).(*irc.CTCPMessage)
+	c.Check(ctcp.Tag(), Equals, "VERSION")
+	c.Check(h.get(irc.ACTION), IsNil)
+	c.Check(p, IsNil)
+
+	d.Dispatch(&irc.IrcMessage{Name: irc.PRIVMSG, Sender: "nick!user@host",
+		Args: []string{"#chan", "\x01ACTION waves\x01"}}, nil)
+	d.WaitForCompletion()
+	c.Check(h.get(irc.ACTION).(*irc.ActionMessage).Action(), Equals, "waves")
+
+	// A malformed privmsg must not be mistaken for ctcp.
+	h.called = make(map[string]interface{})
+	d.Dispatch(&irc.IrcMessage{Name: irc.PRIVMSG, Args: []string{"me"}}, nil)
+	d.WaitForCompletion()
+	c.Check(h.get(irc.CTCP), IsNil)
+	c.Check(p, NotNil)
+
+	var n *irc.Message
+	nh := testNoticeHandler{func(m *irc.Message, _ irc.Endpoint) {
+		protect.Lock()
+		n = m
+		protect.Unlock()
+	}}
+	d.Register(irc.CTCP_REPLY, h)
+	d.Register(irc.NOTICE, nh)
+	d.Dispatch(&irc.IrcMessage{Name: irc.NOTICE, Sender: "nick!user@host",
+		Args: []string{"me", "\x01VERSION client 1.0\x01"}}, nil)
+	d.WaitForCompletion()
+	reply := h.get(irc.CTCP_REPLY).(*irc.CTCPMessage)
+	c.Check(reply.Tag(), Equals, "VERSION")
+	c.Check(reply.Data(), Equals, "client 1.0")
+	c.Check(n, IsNil)
+}
+
+type testStateHandler struct {
+	callback func(data.StateEvent, irc.Endpoint)
+}
+
+func (t testStateHandler) HandleState(ev data.StateEvent, ep irc.Endpoint) {
+	t.callback(ev, ep)
+}
+
+type testJoinHandler struct {
+	callback func(*data.UserJoin, irc.Endpoint)
+}
+
+func (t testJoinHandler) UserJoin(ev *data.UserJoin, ep irc.Endpoint) {
+	t.callback(ev, ep)
+}
+
+type testTopicStateHandler struct {
+	testStateHandler
+	callback func(*data.Topic, irc.Endpoint)
+}
+
+func (t testTopicStateHandler) TopicState(ev *data.Topic, ep irc.Endpoint) {
+	t.callback(ev, ep)
+}
+
+func (s *s) TestDispatcher_DispatchState(c *C) {
+	var join *data.UserJoin
+	var topic *data.Topic
+	var all, fallback data.StateEvent
+	var ep irc.Endpoint
+
+	jh := testJoinHandler{func(ev *data.UserJoin, e irc.Endpoint) {
+		join, ep = ev, e
+	}}
+	th := testTopicStateHandler{
+		testStateHandler{func(ev data.StateEvent, _ irc.Endpoint) {
+			fallback = ev
+		}},
+		func(ev *data.Topic, _ irc.Endpoint) {
+			topic = ev
+		},
+	}
+	sh := testStateHandler{func(ev data.StateEvent, _ irc.Endpoint) {
+		all = ev
+	}}
+
+	d := CreateDispatcher()
+	d.Register(data.STATE_USERJOIN, jh)
+	d.Register(data.STATE_TOPIC, th)
+	d.Register(data.STATE, sh)
+
+	point := testPoint{}
+	joinEv := &data.UserJoin{Channel: "#chan", Nick: "nick"}
+	c.Check(d.DispatchState(joinEv, point), Equals, true)
+	d.WaitForCompletion()
+	c.Check(join, Equals, joinEv)
+	c.Check(ep, Equals, point)
+	c.Check(all, Equals, data.StateEvent(joinEv))
+
+	topicEv := &data.Topic{Channel: "#chan", Topic: "topic"}
+	c.Check(d.DispatchState(topicEv, nil), Equals, true)
+	d.WaitForCompletion()
+	c.Check(topic, Equals, topicEv)
+	c.Check(fallback, IsNil)
+	c.Check(all, Equals, data.StateEvent(topicEv))
+
+	// Handlers without a typed method get HandleState.
+	d.Register(data.STATE_USERRENAME, th)
+	renameEv := &data.UserRename{Old: "nick", New: "nick2"}
+	c.Check(d.DispatchState(renameEv, nil), Equals, true)
+	d.WaitForCompletion()
+	c.Check(fallback, Equals, data.StateEvent(renameEv))
+
+	c.Check(d.DispatchState(&data.Ban{Channel: "#chan"}, nil), Equals, false)
+	d.WaitForCompletion()
+}
+
+func (s *s) TestDispatcher_FilterState(c *C) {
+	var got []data.StateEvent
+	var protect sync.Mutex
+	sh := testStateHandler{func(ev data.StateEvent, _ irc.Endpoint) {
+		protect.Lock()
+		got = append(got, ev)
+		protect.Unlock()
+	}}
+
+	d, err := CreateRichDispatcher(irc.CreateProtoCaps(), []string{"#CHAN"})
+	c.Check(err, IsNil)
+	d.Register(data.STATE, sh)
+
+	d.DispatchState(&data.UserJoin{Channel: "#chan2"}, nil)
+	d.WaitForCompletion()
+	c.Check(len(got), Equals, 0)
+
+	d.DispatchState(&data.UserJoin{Channel: "#chan"}, nil)
+	d.DispatchState(&data.UserRename{Old: "a", New: "b"}, nil)
+	d.WaitForCompletion()
+	c.Check(len(got), Equals, 2)
+}
@@ -1,6 +1,9 @@
 package dispatch
 
-import "github.com/aarondl/ultimateq/irc"
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+)
 
 // PrivmsgHandler is for handling privmsgs going to channel or user targets.
 type PrivmsgHandler interface {
@@ -31,3 +34,127 @@ type NoticeUserHandler interface {
 type NoticeChannelHandler interface {
 	NoticeChannel(*irc.Message, irc.Endpoint)
 }
+
+// JoinHandler is for handling users joining channels.
+type JoinHandler interface {
+	Join(*irc.JoinMessage, irc.Endpoint)
+}
+
+// PartHandler is for handling users leaving channels.
+type PartHandler interface {
+	Part(*irc.PartMessage, irc.Endpoint)
+}
+
+// QuitHandler is for handling users quitting.
+type QuitHandler interface {
+	Quit(*irc.QuitMessage, irc.Endpoint)
+}
+
+// KickHandler is for handling users being kicked from channels.
+type KickHandler interface {
+	Kick(*irc.KickMessage, irc.Endpoint)
+}
+
+// NickHandler is for handling users changing their nick.
+type NickHandler interface {
+	Nick(*irc.NickMessage, irc.Endpoint)
+}
+
+// ModeHandler is for handling mode changes on channels or users.
+type ModeHandler interface {
+	Mode(*irc.ModeMessage, irc.Endpoint)
+}
+
+// TopicChangeHandler is for handling raw TOPIC messages, it's registered
+// under irc.TOPIC. See TopicStateHandler for the topic changes the data store
+// makes.
+type TopicChangeHandler interface {
+	TopicChange(*irc.TopicMessage, irc.Endpoint)
+}
+
+// InviteHandler is for handling invites to channels.
+type InviteHandler interface {
+	Invite(*irc.InviteMessage, irc.Endpoint)
+}
+
+// CTCPHandler is for handling ctcp requests, it's registered under irc.CTCP.
+type CTCPHandler interface {
+	CTCP(*irc.CTCPMessage, irc.Endpoint)
+}
+
+// CTCPReplyHandler is for handling replies to ctcp requests, it's registered
+// under irc.CTCP_REPLY.
+type CTCPReplyHandler interface {
+	CTCPReply(*irc.CTCPMessage, irc.Endpoint)
+}
+
+// ActionHandler is for handling ctcp actions, it's registered under
+// irc.ACTION.
+type ActionHandler interface {
+	Action(*irc.ActionMessage, irc.Endpoint)
+}
+
+// NumericHandler is for handling numeric replies, it's registered under the
+// numeric it handles.
+type NumericHandler interface {
+	Numeric(*irc.NumericMessage, irc.Endpoint)
+}
+
+// ConnectHandler is for handling the bot connecting to a server.
+type ConnectHandler interface {
+	Connect(irc.Endpoint)
+}
+
+// DisconnectHandler is for handling the bot disconnecting from a server.
+type DisconnectHandler interface {
+	Disconnect(irc.Endpoint)
+}
+
+// StateHandler is for handling every change the data store makes, it's
+// registered under data.STATE or any of the data.STATE_* events.
+type StateHandler interface {
+	HandleState(data.StateEvent, irc.Endpoint)
+}
+
+// UserJoinHandler is for handling users joining channels, it's registered
+// under data.STATE_USERJOIN.
+type UserJoinHandler interface {
+	UserJoin(*data.UserJoin, irc.Endpoint)
+}
+
+// UserLeaveHandler is for handling users leaving channels by part, kick or
+// quit, it's registered under data.STATE_USERLEAVE.
+type UserLeaveHandler interface {
+	UserLeave(*data.UserLeave, irc.Endpoint)
+}
+
+// UserRenameHandler is for handling users changing their nick, it's
+// registered under data.STATE_USERRENAME.
+type UserRenameHandler interface {
+	UserRename(*data.UserRename, irc.Endpoint)
+}
+
+// UserModeHandler is for handling users being given or losing channel modes,
+// it's registered under data.STATE_USERMODE.
+type UserModeHandler interface {
+	UserMode(*data.UserMode, irc.Endpoint)
+}
+
+// ChannelModeHandler is for handling channel modes being set or unset, it's
+// registered under data.STATE_CHANNELMODE.
+type ChannelModeHandler interface {
+	ChannelMode(*data.ChannelMode, irc.Endpoint)
+}
+
+// BanHandler is for handling bans being added or removed, it's registered
+// under data.STATE_BAN.
+type BanHandler interface {
+	Ban(*data.Ban, irc.Endpoint)
+}
+
+// TopicStateHandler is for handling the topic changes the data store makes,
+// it's registered under data.STATE_TOPIC. See TopicChangeHandler for raw TOPIC
+// messages.
+type TopicStateHandler interface {
+	TopicState(*data.Topic, irc.Endpoint)
+}
@@ -0,0 +1,296 @@
+package dispatch
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+	"sync"
+)
+
+// Dispatch modes, these control how a handler's calls are run. They are
+// chosen when the handler is registered with RegisterMode.
+const (
+	// DISPATCH_UNBOUNDED runs every call in a goroutine of it's own, calls
+	// run at the same time and in no particular order.
+	DISPATCH_UNBOUNDED = iota
+	// DISPATCH_POOL runs calls on the dispatcher's worker pool, dispatching
+	// blocks while the pool's queue is full. Without a worker pool it's the
+	// same as DISPATCH_UNBOUNDED.
+	DISPATCH_POOL
+	// DISPATCH_SERIAL runs the handler's calls one at a time in the order
+	// they were dispatched in.
+	DISPATCH_SERIAL
+)
+
+// Stats is a snapshot of the depth of the dispatcher's queues.
+type Stats struct {
+	// PoolWorkers is the number of workers in the worker pool.
+	PoolWorkers int
+	// PoolCapacity is how many calls the pool can queue before dispatching
+	// blocks.
+	PoolCapacity int
+	// PoolQueued is how many calls are waiting for a worker.
+	PoolQueued int
+	// PoolPeak is the most calls that have waited for a worker at once.
+	PoolPeak int
+	// SerialQueued is how many calls are waiting in the queue of each
+	// serial handler, by event and then by handler id.
+	SerialQueued map[string]map[int]int
+	// SerialPeak is the most calls that have waited in a single serial queue
+	// at once.
+	SerialPeak int
+}
+
+// job is a call of a handler for a message or a state event.
+type job struct {
+	id      int
+	handler interface{}
+	event   string
+	msg     *irc.IrcMessage
+	ev      data.StateEvent
+	ep      irc.Endpoint
+
+	pool   *workerPool
+	serial *serialQueue
+}
+
+// workerPool is a fixed number of goroutines that run jobs from a queue.
+type workerPool struct {
+	workers int
+	jobs    chan job
+	closed  bool
+	peak    int
+
+	protect sync.RWMutex
+	// protects the peak.
+	peakProtect sync.Mutex
+}
+
+// serialQueue runs the jobs of a single handler one after another. It's
+// goroutine only lives while there are jobs to run.
+type serialQueue struct {
+	jobs    []job
+	running bool
+	peak    int
+
+	protect sync.Mutex
+}
+
+// RegisterMode registers an event handler like Register, the mode is one of
+// the DISPATCH_* modes and decides how the handler's calls are run.
+func (d *Dispatcher) RegisterMode(
+	event string, handler interface{}, mode int) int {
+
+	id := d.Register(event, handler)
+	if mode == DISPATCH_UNBOUNDED {
+		return id
+	}
+
+	key := handlerKey{strings.ToUpper(event), id}
+	d.protect.Lock()
+	defer d.protect.Unlock()
+
+	if d.modes == nil {
+		d.modes = make(map[handlerKey]int)
+	}
+	d.modes[key] = mode
+	if mode == DISPATCH_SERIAL {
+		if d.serials == nil {
+			d.serials = make(map[handlerKey]*serialQueue)
+		}
+		d.serials[key] = &serialQueue{}
+	}
+	return id
+}
+
+// WorkerPool starts a pool of workers to run the calls of handlers registered
+// with DISPATCH_POOL. Up to size calls are queued for the workers before
+// dispatching blocks. A previous pool is stopped once it's queue is empty,
+// 0 workers stops the pool.
+func (d *Dispatcher) WorkerPool(workers, size int) {
+	var pool *workerPool
+	if workers > 0 {
+		if size < 0 {
+			size = 0
+		}
+		pool = &workerPool{workers: workers, jobs: make(chan job, size)}
+		for i := 0; i < workers; i++ {
+			go pool.work(d)
+		}
+	}
+
+	d.protect.Lock()
+	old := d.pool
+	d.pool = pool
+	d.protect.Unlock()
+
+	if old != nil {
+		go old.close()
+	}
+}
+
+// Stats returns the depth of the dispatcher's queues.
+func (d *Dispatcher) Stats() Stats {
+	d.protect.RLock()
+	defer d.protect.RUnlock()
+
+	var stats Stats
+	if d.pool != nil {
+		stats.PoolWorkers = d.pool.workers
+		stats.PoolCapacity = cap(d.pool.jobs)
+		stats.PoolQueued = len(d.pool.jobs)
+		d.pool.peakProtect.Lock()
+		stats.PoolPeak = d.pool.peak
+		d.pool.peakProtect.Unlock()
+	}
+
+	for key, queue := range d.serials {
+		queued, peak := queue.depth()
+		if stats.SerialQueued == nil {
+			stats.SerialQueued = make(map[string]map[int]int)
+		}
+		if stats.SerialQueued[key.event] == nil {
+			stats.SerialQueued[key.event] = make(map[int]int)
+		}
+		stats.SerialQueued[key.event][key.id] = queued
+		if peak > stats.SerialPeak {
+			stats.SerialPeak = peak
+		}
+	}
+	return stats
+}
+
+// collect adds a job to jobs for each handler of an event, msg or ev is set
+// depending on the kind of event. It returns true if it was able to find an
+// event table. Not thread safe.
+func (d *Dispatcher) collect(jobs []job, event string, msg *irc.IrcMessage,
+	ev data.StateEvent, ep irc.Endpoint) ([]job, bool) {
+
+	evtable, ok := d.events[event]
+	if !ok {
+		return jobs, false
+	}
+
+	for id, handler := range evtable {
+		j := job{id: id, handler: handler, event: event, msg: msg, ev: ev,
+			ep: ep}
+		key := handlerKey{event, id}
+		switch d.modes[key] {
+		case DISPATCH_POOL:
+			j.pool = d.pool
+		case DISPATCH_SERIAL:
+			j.serial = d.serials[key]
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, true
+}
+
+// submit starts running jobs according to their mode. It must not be called
+// while holding the dispatcher's lock, since it may block on a full pool.
+func (d *Dispatcher) submit(jobs []job) {
+	for _, j := range jobs {
+		d.waiter.Add(1)
+		switch {
+		case j.serial != nil:
+			j.serial.push(d, j)
+		case j.pool != nil && j.pool.push(j):
+		default:
+			go d.run(j)
+		}
+	}
+}
+
+// run calls the handler of a job.
+func (d *Dispatcher) run(j job) {
+	if j.ev != nil {
+		d.resolveState(j.id, j.handler, j.event, j.ev, j.ep)
+	} else {
+		d.resolveHandler(j.id, j.handler, j.event, j.msg, j.ep)
+	}
+}
+
+// forget removes the mode and queue of an unregistered handler, calls that
+// are already queued still run. Not thread safe.
+func (d *Dispatcher) forget(key handlerKey) {
+	delete(d.modes, key)
+	delete(d.serials, key)
+}
+
+// push queues a job for the workers, blocking while the queue is full. It
+// returns false if the pool has been stopped.
+func (p *workerPool) push(j job) bool {
+	p.protect.RLock()
+	defer p.protect.RUnlock()
+	if p.closed {
+		return false
+	}
+
+	p.jobs <- j
+	depth := len(p.jobs)
+	p.peakProtect.Lock()
+	if depth > p.peak {
+		p.peak = depth
+	}
+	p.peakProtect.Unlock()
+	return true
+}
+
+// work runs jobs until the pool is stopped.
+func (p *workerPool) work(d *Dispatcher) {
+	for j := range p.jobs {
+		d.run(j)
+	}
+}
+
+// close stops the pool once it's queue is empty.
+func (p *workerPool) close() {
+	p.protect.Lock()
+	defer p.protect.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+}
+
+// push queues a job and starts the queue's goroutine if it isn't running.
+func (q *serialQueue) push(d *Dispatcher, j job) {
+	q.protect.Lock()
+	defer q.protect.Unlock()
+
+	q.jobs = append(q.jobs, j)
+	if len(q.jobs) > q.peak {
+		q.peak = len(q.jobs)
+	}
+	if !q.running {
+		q.running = true
+		go q.work(d)
+	}
+}
+
+// work runs the queue's jobs in order until it's empty.
+func (q *serialQueue) work(d *Dispatcher) {
+	for {
+		q.protect.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			q.jobs = nil
+			q.protect.Unlock()
+			return
+		}
+		j := q.jobs[0]
+		q.jobs[0] = job{}
+		q.jobs = q.jobs[1:]
+		q.protect.Unlock()
+
+		d.run(j)
+	}
+}
+
+// depth returns the number of jobs waiting in the queue and the most there
+// have been.
+func (q *serialQueue) depth() (queued, peak int) {
+	q.protect.Lock()
+	defer q.protect.Unlock()
+	return len(q.jobs), q.peak
+}
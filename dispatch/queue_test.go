@@ -0,0 +1,159 @@
+package dispatch
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func numbered(i int) *irc.IrcMessage {
+	return &irc.IrcMessage{Name: irc.PRIVMSG,
+		Args: []string{"#chan", strconv.Itoa(i)}}
+}
+
+func (s *s) TestDispatcher_SerialMode(c *C) {
+	var protect sync.Mutex
+	var order []string
+	running, overlapped := 0, false
+
+	d := CreateDispatcher()
+	id := d.RegisterMode(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			protect.Lock()
+			running++
+			overlapped = overlapped || running > 1
+			protect.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			protect.Lock()
+			running--
+			order = append(order, m.Args[1])
+			protect.Unlock()
+		},
+	}, DISPATCH_SERIAL)
+
+	for i := 0; i < 20; i++ {
+		d.Dispatch(numbered(i), nil)
+	}
+	d.WaitForCompletion()
+
+	c.Check(overlapped, Equals, false)
+	c.Assert(len(order), Equals, 20)
+	for i := 0; i < 20; i++ {
+		c.Check(order[i], Equals, strconv.Itoa(i))
+	}
+
+	stats := d.Stats()
+	c.Check(stats.SerialQueued[irc.PRIVMSG][id], Equals, 0)
+	c.Check(stats.SerialPeak > 1, Equals, true)
+
+	c.Check(d.Unregister(irc.PRIVMSG, id), Equals, true)
+	c.Check(d.Stats().SerialQueued, IsNil)
+	c.Check(len(d.modes), Equals, 0)
+}
+
+func (s *s) TestDispatcher_PoolMode(c *C) {
+	release := make(chan int)
+	started := make(chan int, 10)
+
+	d := CreateDispatcher()
+	d.WorkerPool(1, 2)
+	d.RegisterMode(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			started <- 0
+			<-release
+		},
+	}, DISPATCH_POOL)
+
+	stats := d.Stats()
+	c.Check(stats.PoolWorkers, Equals, 1)
+	c.Check(stats.PoolCapacity, Equals, 2)
+
+	d.Dispatch(numbered(0), nil)
+	<-started
+	d.Dispatch(numbered(1), nil)
+	d.Dispatch(numbered(2), nil)
+	c.Check(d.Stats().PoolQueued, Equals, 2)
+	c.Check(d.Stats().PoolPeak, Equals, 2)
+
+	blocked := make(chan int)
+	go func() {
+		d.Dispatch(numbered(3), nil)
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		c.Error("Dispatch should block while the pool's queue is full.")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	for i := 0; i < 4; i++ {
+		release <- 0
+		if i < 3 {
+			<-started
+		}
+	}
+	<-blocked
+	d.WaitForCompletion()
+	c.Check(d.Stats().PoolQueued, Equals, 0)
+}
+
+func (s *s) TestDispatcher_PoolStopped(c *C) {
+	var protect sync.Mutex
+	calls := 0
+	d := CreateDispatcher()
+	d.RegisterMode(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			protect.Lock()
+			calls++
+			protect.Unlock()
+		},
+	}, DISPATCH_POOL)
+
+	d.Dispatch(numbered(0), nil)
+	d.WaitForCompletion()
+
+	d.WorkerPool(2, 5)
+	d.Dispatch(numbered(1), nil)
+	d.WaitForCompletion()
+
+	d.WorkerPool(0, 0)
+	c.Check(d.Stats().PoolWorkers, Equals, 0)
+	d.Dispatch(numbered(2), nil)
+	d.WaitForCompletion()
+
+	c.Check(calls, Equals, 3)
+}
+
+func (s *s) TestDispatcher_ModesMixed(c *C) {
+	var protect sync.Mutex
+	counts := map[string]int{}
+	count := func(name string) testHandler {
+		return testHandler{func(m *irc.IrcMessage, ep irc.Endpoint) {
+			protect.Lock()
+			counts[name]++
+			protect.Unlock()
+		}}
+	}
+
+	d := CreateDispatcher()
+	d.WorkerPool(2, 0)
+	d.RegisterMode(irc.PRIVMSG, count("unbounded"), DISPATCH_UNBOUNDED)
+	d.RegisterMode(irc.PRIVMSG, count("pool"), DISPATCH_POOL)
+	d.RegisterMode(irc.PRIVMSG, count("serial"), DISPATCH_SERIAL)
+	d.RegisterMode(irc.RAW, count("raw"), DISPATCH_SERIAL)
+
+	for i := 0; i < 10; i++ {
+		d.Dispatch(numbered(i), nil)
+	}
+	d.WaitForCompletion()
+
+	c.Check(counts["unbounded"], Equals, 10)
+	c.Check(counts["pool"], Equals, 10)
+	c.Check(counts["serial"], Equals, 10)
+	c.Check(counts["raw"], Equals, 10)
+	c.Check(len(d.Stats().SerialQueued), Equals, 2)
+}
@@ -0,0 +1,155 @@
+package dispatch
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+	"runtime/debug"
+)
+
+var (
+	// Log is the logger used to report handlers that panic, it can be set to
+	// customize logging for this package.
+	Log = func(format string, args ...interface{}) {
+		log.Printf(format, args...)
+	}
+)
+
+// Format strings for errors and logging output.
+const (
+	fmtErrHandlerPanic = "dispatch: Handler %v for %v on (%v) panicked: %v"
+	fmtHandlerPanicLog = "dispatch: Handler %v for %v on (%v) panicked: %v\n%s"
+	fmtHandlerRemoved  = "dispatch: Handler %v for %v unregistered after %v " +
+		"failures in a row"
+)
+
+// HandlerError describes a handler that panicked while handling an event.
+type HandlerError struct {
+	// Event is the name of the event being handled.
+	Event string
+	// Key is the key of the endpoint the event came from, empty if there was
+	// no endpoint.
+	Key string
+	// ID is the identifier the handler was registered with.
+	ID int
+	// Handler is the handler that panicked.
+	Handler interface{}
+	// Panic is the value the handler panicked with.
+	Panic interface{}
+	// Stack is the stack of the handler when it panicked.
+	Stack []byte
+	// Failures is how many times in a row the handler has failed, it's only
+	// counted when there's a failure limit.
+	Failures int
+	// Unregistered is true if the handler was unregistered because it failed
+	// too many times in a row.
+	Unregistered bool
+}
+
+// Error implements the error interface.
+func (h *HandlerError) Error() string {
+	return fmt.Sprintf(fmtErrHandlerPanic, h.ID, h.Event, h.Key, h.Panic)
+}
+
+// handlerKey identifies a registered handler to count it's failures.
+type handlerKey struct {
+	event string
+	id    int
+}
+
+// OnError sets a callback that's given every handler that panics, after it's
+// been logged. Nil stops the callbacks.
+func (d *Dispatcher) OnError(callback func(*HandlerError)) {
+	d.failProtect.Lock()
+	defer d.failProtect.Unlock()
+	d.onError = callback
+}
+
+// FailureLimit sets how many times in a row a handler may panic before it is
+// unregistered. 0, the default, never unregisters handlers.
+func (d *Dispatcher) FailureLimit(limit int) {
+	d.failProtect.Lock()
+	defer d.failProtect.Unlock()
+	d.failLimit = limit
+	if limit <= 0 {
+		d.failures = nil
+	}
+}
+
+// handlerDone must be deferred by every handler goroutine. It recovers a
+// panicking handler so it can't take down the rest of the bot, reports it and
+// keeps count of it's failures.
+func (d *Dispatcher) handlerDone(event string, id int,
+	handler interface{}, ep irc.Endpoint) {
+
+	defer d.waiter.Done()
+
+	r := recover()
+	if r == nil {
+		d.succeeded(handlerKey{event, id})
+		return
+	}
+
+	herr := &HandlerError{
+		Event:   event,
+		ID:      id,
+		Handler: handler,
+		Panic:   r,
+		Stack:   debug.Stack(),
+	}
+	if ep != nil {
+		herr.Key = ep.GetKey()
+	}
+	Log(fmtHandlerPanicLog, id, event, herr.Key, r, herr.Stack)
+
+	callback := d.failed(herr)
+	if herr.Unregistered {
+		Log(fmtHandlerRemoved, id, event, herr.Failures)
+	}
+	if callback != nil {
+		callback(herr)
+	}
+}
+
+// succeeded resets the failures of a handler.
+func (d *Dispatcher) succeeded(key handlerKey) {
+	d.failProtect.RLock()
+	_, ok := d.failures[key]
+	d.failProtect.RUnlock()
+	if !ok {
+		return
+	}
+
+	d.failProtect.Lock()
+	delete(d.failures, key)
+	d.failProtect.Unlock()
+}
+
+// failed counts a failure of a handler and unregisters it if it's over the
+// failure limit. It returns the error callback to call.
+func (d *Dispatcher) failed(herr *HandlerError) func(*HandlerError) {
+	d.failProtect.Lock()
+	callback := d.onError
+	herr.Failures = 1
+	if d.failLimit <= 0 {
+		d.failProtect.Unlock()
+		return callback
+	}
+
+	if d.failures == nil {
+		d.failures = make(map[handlerKey]int)
+	}
+	key := handlerKey{herr.Event, herr.ID}
+	d.failures[key]++
+	herr.Failures = d.failures[key]
+	unregister := herr.Failures >= d.failLimit
+	if unregister {
+		delete(d.failures, key)
+	}
+	d.failProtect.Unlock()
+
+	if unregister {
+		herr.Unregistered = d.Unregister(herr.Event, herr.ID)
+	}
+	return callback
+}
@@ -0,0 +1,192 @@
+package dispatch
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"strings"
+	"sync"
+)
+
+type keyPoint struct {
+	*irc.Helper
+}
+
+func (k keyPoint) GetKey() string {
+	return "server"
+}
+
+// captureLog replaces Log for the duration of a test.
+func captureLog() (logs func() []string, restore func()) {
+	var protect sync.Mutex
+	var lines []string
+	old := Log
+	Log = func(format string, args ...interface{}) {
+		protect.Lock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+		protect.Unlock()
+	}
+
+	logs = func() []string {
+		protect.Lock()
+		defer protect.Unlock()
+		return lines
+	}
+	return logs, func() { Log = old }
+}
+
+func panicHandler() testHandler {
+	return testHandler{func(m *irc.IrcMessage, ep irc.Endpoint) {
+		panic("handler broke")
+	}}
+}
+
+func (s *s) TestDispatcher_RecoverPanic(c *C) {
+	logs, restore := captureLog()
+	defer restore()
+
+	var protect sync.Mutex
+	var errs []*HandlerError
+	var handled bool
+
+	d := CreateDispatcher()
+	d.OnError(func(herr *HandlerError) {
+		protect.Lock()
+		errs = append(errs, herr)
+		protect.Unlock()
+	})
+	id := d.Register(irc.PRIVMSG, panicHandler())
+	d.Register(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			protect.Lock()
+			handled = true
+			protect.Unlock()
+		},
+	})
+
+	d.Dispatch(&irc.IrcMessage{Name: irc.PRIVMSG}, keyPoint{&irc.Helper{}})
+	d.WaitForCompletion()
+
+	c.Check(handled, Equals, true)
+	c.Assert(len(errs), Equals, 1)
+	herr := errs[0]
+	c.Check(herr.Event, Equals, irc.PRIVMSG)
+	c.Check(herr.Key, Equals, "server")
+	c.Check(herr.ID, Equals, id)
+	c.Check(herr.Panic, Equals, "handler broke")
+	c.Check(herr.Failures, Equals, 1)
+	c.Check(herr.Unregistered, Equals, false)
+	c.Check(strings.Contains(string(herr.Stack), "panicHandler"), Equals, true)
+	c.Check(herr.Error(), Equals,
+		fmt.Sprintf(fmtErrHandlerPanic, id, irc.PRIVMSG, "server",
+			"handler broke"))
+
+	c.Assert(len(logs()), Equals, 1)
+	c.Check(strings.Contains(logs()[0], "handler broke"), Equals, true)
+	c.Check(strings.Contains(logs()[0], "panicHandler"), Equals, true)
+}
+
+func (s *s) TestDispatcher_RecoverNoEndpoint(c *C) {
+	_, restore := captureLog()
+	defer restore()
+
+	var herr *HandlerError
+	d := CreateDispatcher()
+	d.OnError(func(h *HandlerError) {
+		herr = h
+	})
+	d.Register(irc.RAW, panicHandler())
+
+	d.Dispatch(&irc.IrcMessage{Name: irc.QUIT}, nil)
+	d.WaitForCompletion()
+	c.Assert(herr, NotNil)
+	c.Check(herr.Event, Equals, irc.RAW)
+	c.Check(herr.Key, Equals, "")
+
+	d.OnError(nil)
+	herr = nil
+	d.Dispatch(&irc.IrcMessage{Name: irc.QUIT}, nil)
+	d.WaitForCompletion()
+	c.Check(herr, IsNil)
+}
+
+func (s *s) TestDispatcher_RecoverState(c *C) {
+	_, restore := captureLog()
+	defer restore()
+
+	var herr *HandlerError
+	d := CreateDispatcher()
+	d.OnError(func(h *HandlerError) {
+		herr = h
+	})
+	d.Register(data.STATE, testStateHandler{
+		func(ev data.StateEvent, ep irc.Endpoint) {
+			panic("state broke")
+		},
+	})
+
+	d.DispatchState(&data.UserJoin{Channel: "#chan"}, keyPoint{})
+	d.WaitForCompletion()
+	c.Assert(herr, NotNil)
+	c.Check(herr.Event, Equals, data.STATE)
+	c.Check(herr.Panic, Equals, "state broke")
+}
+
+func (s *s) TestDispatcher_FailureLimit(c *C) {
+	logs, restore := captureLog()
+	defer restore()
+
+	var fail bool
+	var last *HandlerError
+	calls := 0
+	d := CreateDispatcher()
+	d.FailureLimit(3)
+	d.OnError(func(h *HandlerError) {
+		last = h
+	})
+	id := d.Register(irc.PRIVMSG, testHandler{
+		func(m *irc.IrcMessage, ep irc.Endpoint) {
+			calls++
+			if fail {
+				panic("failing")
+			}
+		},
+	})
+
+	dispatch := func() {
+		d.Dispatch(&irc.IrcMessage{Name: irc.PRIVMSG}, nil)
+		d.WaitForCompletion()
+	}
+
+	fail = true
+	dispatch()
+	dispatch()
+	c.Check(last.Failures, Equals, 2)
+
+	fail = false
+	dispatch()
+
+	fail = true
+	dispatch()
+	c.Check(last.Failures, Equals, 1)
+	dispatch()
+	dispatch()
+	c.Check(last.Failures, Equals, 3)
+	c.Check(last.Unregistered, Equals, true)
+	c.Check(last.ID, Equals, id)
+
+	dispatch()
+	c.Check(calls, Equals, 6)
+	c.Check(d.Unregister(irc.PRIVMSG, id), Equals, false)
+	c.Check(strings.Contains(logs()[len(logs())-1], "unregistered"),
+		Equals, true)
+
+	d.FailureLimit(0)
+	id = d.Register(irc.PRIVMSG, panicHandler())
+	for i := 0; i < 5; i++ {
+		dispatch()
+	}
+	c.Check(last.Failures, Equals, 1)
+	c.Check(d.Unregister(irc.PRIVMSG, id), Equals, true)
+}
@@ -0,0 +1,375 @@
+package extension
+
+import (
+	"github.com/aarondl/ultimateq/dispatch"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReconnTimeout is how long to wait between attempts to reconnect
+	// to a bot that went away.
+	defaultReconnTimeout = 5 * time.Second
+
+	// fmtBotError is logged when a bot reports a failed request.
+	fmtBotError = "extension: (%v) bot error on %v (%v)\n"
+	// fmtBotLost is logged when the connection to a bot is lost.
+	fmtBotLost = "extension: (%v) connection lost (%v)\n"
+	// fmtBotReconnecting is logged before each attempt to reconnect.
+	fmtBotReconnecting = "extension: (%v) reconnecting in %v...\n"
+)
+
+// Extension is the extension process' side of the protocol. Handlers are
+// registered on it exactly like on a dispatch.Dispatcher, and it can be
+// attached to any number of bots at once, either by connecting to them or by
+// accepting their connections. Events from every bot are dispatched to the
+// same handlers, the endpoint passed to a handler writes back to the bot and
+// server the event came from.
+type Extension struct {
+	name       string
+	dispatcher *dispatch.Dispatcher
+
+	// events counts local handlers per event name, refs holds the stable id
+	// each event name is registered on the bots with.
+	events map[string]int
+	refs   map[string]int
+	nextId int
+
+	bots      map[*botConn]bool
+	listeners []net.Listener
+	closed    bool
+
+	reconnTimeout time.Duration
+
+	// Protects all state variables.
+	protect sync.Mutex
+}
+
+// CreateExtension creates an extension that identifies itself to bots with
+// the given name.
+func CreateExtension(name string) *Extension {
+	d, _ := dispatch.CreateRichDispatcher(irc.CreateProtoCaps(), nil)
+	return &Extension{
+		name:          name,
+		dispatcher:    d,
+		events:        make(map[string]int),
+		refs:          make(map[string]int),
+		bots:          make(map[*botConn]bool),
+		reconnTimeout: defaultReconnTimeout,
+	}
+}
+
+// Register registers an event handler to a particular event, handlers may be
+// any of the handler types the dispatch package understands. Every attached
+// bot is asked to send the event. The returned id can be given to Unregister.
+func (e *Extension) Register(event string, handler interface{}) int {
+	event = strings.ToUpper(event)
+	id := e.dispatcher.Register(event, handler)
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	e.events[event]++
+	if _, ok := e.refs[event]; !ok {
+		e.nextId++
+		e.refs[event] = e.nextId
+	}
+	e.syncAll()
+	return id
+}
+
+// Unregister removes a handler added by Register. Bots stop sending an event
+// once no handlers are left for it.
+func (e *Extension) Unregister(event string, id int) bool {
+	event = strings.ToUpper(event)
+	if !e.dispatcher.Unregister(event, id) {
+		return false
+	}
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	if e.events[event]--; e.events[event] <= 0 {
+		delete(e.events, event)
+	}
+	e.syncAll()
+	return true
+}
+
+// Connect connects to a bot listening on the given network ("tcp" or "unix")
+// and address. If the bot goes away, for example because it restarted, the
+// extension keeps trying to reconnect and registers its events again once it
+// succeeds. Only the first attempt reports an error.
+func (e *Extension) Connect(network, address string) error {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	bot, err := e.attach(conn, address)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			log.Printf(fmtBotLost, bot.name, bot.serve())
+			if e.isClosed() {
+				return
+			}
+			for {
+				log.Printf(fmtBotReconnecting, address, e.reconnTimeout)
+				time.Sleep(e.reconnTimeout)
+				if e.isClosed() {
+					return
+				}
+				if conn, err = net.Dial(network, address); err != nil {
+					continue
+				}
+				if bot, err = e.attach(conn, address); err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Listen accepts connections from bots (see bot.Bot.ConnectExtension) on the
+// given network and address. The listener is closed by Close.
+func (e *Extension) Listen(network, address string) (net.Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	e.protect.Lock()
+	e.listeners = append(e.listeners, ln)
+	e.protect.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				bot, err := e.attach(conn, conn.RemoteAddr().String())
+				if err == nil {
+					log.Printf(fmtBotLost, bot.name, bot.serve())
+				}
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// Close stops listening, disconnects from all bots and stops reconnecting.
+func (e *Extension) Close() {
+	e.protect.Lock()
+	e.closed = true
+	for _, ln := range e.listeners {
+		ln.Close()
+	}
+	e.listeners = nil
+	bots := make([]*botConn, 0, len(e.bots))
+	for bot := range e.bots {
+		bots = append(bots, bot)
+	}
+	e.protect.Unlock()
+
+	for _, bot := range bots {
+		bot.codec.Close()
+	}
+}
+
+// WaitForCompletion waits on all active event handlers to return.
+func (e *Extension) WaitForCompletion() {
+	e.dispatcher.WaitForCompletion()
+}
+
+// isClosed checks if Close has been called.
+func (e *Extension) isClosed() bool {
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	return e.closed
+}
+
+// attach performs the handshake with a bot and registers all current events
+// with it. If an error is returned the connection has been closed.
+func (e *Extension) attach(conn net.Conn, name string) (*botConn, error) {
+	bot := &botConn{
+		ext:        e,
+		name:       name,
+		codec:      createCodec(conn),
+		registered: make(map[string]int),
+	}
+
+	_, err := bot.codec.readHello()
+	if err == nil {
+		err = bot.codec.write(&packet{
+			Kind: pktHello, Version: ProtocolVersion, Name: e.name,
+		})
+	}
+	if err != nil {
+		bot.codec.Close()
+		return nil, err
+	}
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	if e.closed {
+		bot.codec.Close()
+		return nil, errClosed
+	}
+	e.bots[bot] = true
+	if err = bot.sync(e.wanted()); err != nil {
+		delete(e.bots, bot)
+		bot.codec.Close()
+		return nil, err
+	}
+	return bot, nil
+}
+
+// wanted returns the events that bots should send, mapped to their ids.
+// When raw is wanted it is the only thing asked for since every message
+// arrives through it and asking for more would dispatch messages twice.
+// Not thread safe.
+func (e *Extension) wanted() map[string]int {
+	if _, ok := e.events[irc.RAW]; ok {
+		return map[string]int{irc.RAW: e.refs[irc.RAW]}
+	}
+	wanted := make(map[string]int, len(e.events))
+	for event := range e.events {
+		wanted[event] = e.refs[event]
+	}
+	return wanted
+}
+
+// syncAll brings the registrations on every bot up to date. Not thread safe.
+func (e *Extension) syncAll() {
+	wanted := e.wanted()
+	for bot := range e.bots {
+		if err := bot.sync(wanted); err != nil {
+			bot.codec.Close()
+		}
+	}
+}
+
+// botConn is a single connection to a bot.
+type botConn struct {
+	ext   *Extension
+	name  string
+	codec *codec
+
+	// registered holds the event -> id registrations the bot knows about.
+	// It is protected by the extension's lock.
+	registered map[string]int
+
+	seq      int
+	protectS sync.Mutex
+}
+
+// sync registers and unregisters events on the bot until its registrations
+// are the ones wanted.
+func (b *botConn) sync(wanted map[string]int) error {
+	for event, id := range b.registered {
+		if _, ok := wanted[event]; ok {
+			continue
+		}
+		err := b.codec.write(&packet{Kind: pktUnregister, Id: id})
+		if err != nil {
+			return err
+		}
+		delete(b.registered, event)
+	}
+	for event, id := range wanted {
+		if _, ok := b.registered[event]; ok {
+			continue
+		}
+		err := b.codec.write(&packet{Kind: pktRegister, Id: id, Event: event})
+		if err != nil {
+			return err
+		}
+		b.registered[event] = id
+	}
+	return nil
+}
+
+// serve reads from the bot and dispatches events until the connection is
+// lost, the reason is returned.
+func (b *botConn) serve() error {
+	defer func() {
+		b.ext.protect.Lock()
+		delete(b.ext.bots, b)
+		b.ext.protect.Unlock()
+		b.codec.Close()
+	}()
+
+	for {
+		p, err := b.codec.read()
+		if err != nil {
+			return err
+		}
+
+		switch p.Kind {
+		case pktEvent:
+			if p.Msg != nil {
+				b.ext.dispatcher.Dispatch(p.Msg, b.endpoint(p.Key))
+			}
+		case pktError:
+			log.Printf(fmtBotError, b.name, p.Key, p.Error)
+		}
+	}
+}
+
+// endpoint creates an endpoint that writes to a server through this bot.
+func (b *botConn) endpoint(key string) *Endpoint {
+	return &Endpoint{&irc.Helper{Writer: &serverWriter{b, key}}, b, key}
+}
+
+// write asks the bot to write to a server.
+func (b *botConn) write(key string, data []byte) error {
+	b.protectS.Lock()
+	b.seq++
+	seq := b.seq
+	b.protectS.Unlock()
+	return b.codec.write(&packet{
+		Kind: pktWrite, Id: seq, Key: key, Data: string(data),
+	})
+}
+
+// serverWriter is an io.Writer that writes to a server through a bot.
+type serverWriter struct {
+	bot *botConn
+	key string
+}
+
+// Write implements io.Writer.
+func (s *serverWriter) Write(buf []byte) (int, error) {
+	if err := s.bot.write(s.key, buf); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Endpoint implements irc.Endpoint for handlers running inside an extension.
+// Writes are sent to the bot the event came from, which writes them to the
+// server the event came from.
+type Endpoint struct {
+	*irc.Helper
+	bot *botConn
+	key string
+}
+
+// GetKey returns the server id of the server the event came from.
+func (e *Endpoint) GetKey() string {
+	return e.key
+}
+
+// GetBot returns the name of the bot the event came from, this is the address
+// of the bot's connection.
+func (e *Endpoint) GetBot() string {
+	return e.bot.name
+}
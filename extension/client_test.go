@@ -0,0 +1,196 @@
+package extension
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"net"
+	"time"
+)
+
+//===========================================================
+// Set up a fake bot that serves Remotes to extensions.
+//===========================================================
+type testBot struct {
+	host     *testHost
+	ln       net.Listener
+	remotes  chan *Remote
+	listenOn string
+}
+
+func createTestBot(c *C, address string) *testBot {
+	ln, err := net.Listen("tcp", address)
+	c.Assert(err, IsNil)
+	b := &testBot{
+		host:     createTestHost(),
+		ln:       ln,
+		remotes:  make(chan *Remote, 10),
+		listenOn: ln.Addr().String(),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := CreateRemote(conn, b.host)
+			if r.Start() == nil {
+				b.remotes <- r
+			}
+		}
+	}()
+	return b
+}
+
+type testPrivmsgChannel struct {
+	called chan *irc.Message
+	reply  string
+}
+
+func (t *testPrivmsgChannel) PrivmsgChannel(m *irc.Message,
+	ep irc.Endpoint) {
+
+	if len(t.reply) > 0 {
+		ep.Privmsg(m.Target(), t.reply)
+	}
+	t.called <- m
+}
+
+func (s *s) TestExtension_Register(c *C) {
+	e := CreateExtension("ext")
+	h := &testPrivmsgChannel{}
+	id := e.Register("privmsg", h)
+	id2 := e.Register(irc.JOIN, h)
+	c.Check(e.events, DeepEquals, map[string]int{irc.PRIVMSG: 1, irc.JOIN: 1})
+	c.Check(e.wanted(), DeepEquals, map[string]int{
+		irc.PRIVMSG: e.refs[irc.PRIVMSG],
+		irc.JOIN:    e.refs[irc.JOIN],
+	})
+
+	idRaw := e.Register(irc.RAW, h)
+	c.Check(e.wanted(), DeepEquals, map[string]int{irc.RAW: e.refs[irc.RAW]})
+
+	c.Check(e.Unregister(irc.RAW, idRaw), Equals, true)
+	c.Check(e.Unregister(irc.RAW, idRaw), Equals, false)
+	c.Check(e.Unregister(irc.PRIVMSG, id), Equals, true)
+	c.Check(e.Unregister(irc.JOIN, id2), Equals, true)
+	c.Check(len(e.events), Equals, 0)
+}
+
+func (s *s) TestExtension_ConnectAndReply(c *C) {
+	bot := createTestBot(c, "127.0.0.1:0")
+	defer bot.ln.Close()
+
+	e := CreateExtension("ext")
+	defer e.Close()
+	h := &testPrivmsgChannel{called: make(chan *irc.Message, 1), reply: "hi"}
+	e.Register(irc.PRIVMSG, h)
+
+	c.Assert(e.Connect("tcp", bot.listenOn), IsNil)
+	remote := <-bot.remotes
+	c.Check(remote.GetName(), Equals, "ext")
+	c.Assert(waitFor(func() bool { return bot.host.nHandlers() == 1 }),
+		Equals, true)
+
+	msg := &irc.IrcMessage{Name: irc.PRIVMSG, Sender: "a!b@c",
+		Args: []string{"#chan", "hello"}}
+	bot.host.handler(irc.PRIVMSG).HandleRaw(msg, testPoint{})
+
+	select {
+	case m := <-h.called:
+		c.Check(m.Message(), Equals, "hello")
+	case <-time.After(5 * time.Second):
+		c.Fatal("Handler was not called.")
+	}
+
+	c.Check(waitFor(func() bool {
+		bot.host.protect.Lock()
+		defer bot.host.protect.Unlock()
+		return len(bot.host.writes) == 1
+	}), Equals, true)
+	bot.host.protect.Lock()
+	c.Check(bot.host.writes, DeepEquals, []string{"PRIVMSG #chan :hi"})
+	bot.host.protect.Unlock()
+
+	// Registering after connecting reaches the bot too.
+	e.Register(irc.JOIN, h)
+	c.Check(waitFor(func() bool { return bot.host.nHandlers() == 2 }),
+		Equals, true)
+}
+
+func (s *s) TestExtension_Reconnect(c *C) {
+	bot := createTestBot(c, "127.0.0.1:0")
+	defer bot.ln.Close()
+
+	e := CreateExtension("ext")
+	e.reconnTimeout = time.Millisecond
+	defer e.Close()
+	e.Register(irc.PRIVMSG, &testPrivmsgChannel{})
+
+	c.Assert(e.Connect("tcp", bot.listenOn), IsNil)
+	remote := <-bot.remotes
+	c.Assert(waitFor(func() bool { return bot.host.nHandlers() == 1 }),
+		Equals, true)
+
+	// Simulate a bot restart, the extension must come back and re-register.
+	remote.Close()
+	c.Check(bot.host.nHandlers(), Equals, 0)
+	select {
+	case <-bot.remotes:
+	case <-time.After(5 * time.Second):
+		c.Fatal("Extension did not reconnect.")
+	}
+	c.Check(waitFor(func() bool { return bot.host.nHandlers() == 1 }),
+		Equals, true)
+}
+
+func (s *s) TestExtension_ManyBots(c *C) {
+	bot1 := createTestBot(c, "127.0.0.1:0")
+	defer bot1.ln.Close()
+	bot2 := createTestBot(c, "127.0.0.1:0")
+	defer bot2.ln.Close()
+
+	e := CreateExtension("ext")
+	defer e.Close()
+	h := &testPrivmsgChannel{called: make(chan *irc.Message, 2), reply: "x"}
+	e.Register(irc.PRIVMSG, h)
+
+	c.Assert(e.Connect("tcp", bot1.listenOn), IsNil)
+	c.Assert(e.Connect("tcp", bot2.listenOn), IsNil)
+	for _, bot := range []*testBot{bot1, bot2} {
+		b := bot
+		c.Assert(waitFor(func() bool { return b.host.nHandlers() == 1 }),
+			Equals, true)
+		msg := &irc.IrcMessage{Name: irc.PRIVMSG, Sender: "a!b@c",
+			Args: []string{"#chan", "hello"}}
+		b.host.handler(irc.PRIVMSG).HandleRaw(msg, testPoint{})
+		select {
+		case <-h.called:
+		case <-time.After(5 * time.Second):
+			c.Fatal("Handler was not called.")
+		}
+		c.Check(waitFor(func() bool {
+			b.host.protect.Lock()
+			defer b.host.protect.Unlock()
+			return len(b.host.writes) == 1
+		}), Equals, true)
+	}
+}
+
+func (s *s) TestExtension_Listen(c *C) {
+	e := CreateExtension("ext")
+	e.Register(irc.PRIVMSG, &testPrivmsgChannel{})
+	ln, err := e.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	host := createTestHost()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	c.Assert(err, IsNil)
+	remote := CreateRemote(conn, host)
+	c.Assert(remote.Start(), IsNil)
+	c.Check(waitFor(func() bool { return host.nHandlers() == 1 }),
+		Equals, true)
+
+	e.Close()
+	c.Check(remote.Wait(), NotNil)
+	c.Check(host.nHandlers(), Equals, 0)
+}
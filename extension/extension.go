@@ -0,0 +1,137 @@
+/*
+extension package implements the wire protocol used between a bot and its
+out-of-process extensions. Extensions are separate processes that either
+connect to a bot or allow a bot to connect to them over unix or tcp sockets.
+Once connected, an extension registers for events by name in the same way
+dispatch.Dispatcher.Register works in-process, receives irc.IrcMessages tagged
+with the server id they came from, and writes back through that server.
+*/
+package extension
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ProtocolVersion is exchanged during the handshake, peers speaking a
+	// different version are refused.
+	ProtocolVersion = 1
+
+	// handshakeTimeout is how long either side waits for the peer's hello.
+	handshakeTimeout = 10 * time.Second
+	// writeTimeout is how long a single packet write may block before the
+	// peer is considered dead.
+	writeTimeout = 10 * time.Second
+)
+
+// Packet kinds, these identify what a packet on the wire is used for.
+const (
+	// pktHello is the first packet sent by both sides. Carries Version and
+	// the Name of the sender.
+	pktHello = iota + 1
+	// pktRegister is sent by an extension to subscribe to Event. Id is chosen
+	// by the extension and is echoed back in every pktEvent for it.
+	pktRegister
+	// pktUnregister is sent by an extension to cancel the registration Id.
+	pktUnregister
+	// pktEvent is sent by the bot when a registered event fires. Id is the
+	// registration, Key the server id and Msg the message itself.
+	pktEvent
+	// pktWrite is sent by an extension to write Data to the server Key.
+	pktWrite
+	// pktError is sent by the bot when a request from the extension failed.
+	// Id is the Id of the failed request.
+	pktError
+)
+
+const (
+	// fmtErrVersion occurs when a peer speaks a different protocol version.
+	fmtErrVersion = "extension: Protocol version mismatch (ours: %v, theirs: %v)"
+	// fmtErrUnexpected occurs when a packet arrives that was not expected.
+	fmtErrUnexpected = "extension: Unexpected packet kind (%v)"
+)
+
+var (
+	// errClosed is returned when writing to a closed connection.
+	errClosed = errors.New("extension: Connection closed")
+)
+
+// packet is the single unit sent across the wire in both directions. Which
+// fields are used depends on the Kind.
+type packet struct {
+	Kind    int
+	Id      int
+	Version int
+	Name    string
+	Event   string
+	Key     string
+	Data    string
+	Error   string
+	Msg     *irc.IrcMessage
+}
+
+// codec reads and writes packets on a connection. Writes are serialized and
+// bounded by writeTimeout so a stuck peer cannot block the writer forever.
+type codec struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+
+	// Protects the encoder.
+	protect sync.Mutex
+}
+
+// createCodec creates a codec around a connection.
+func createCodec(conn net.Conn) *codec {
+	return &codec{
+		conn: conn,
+		enc:  gob.NewEncoder(conn),
+		dec:  gob.NewDecoder(conn),
+	}
+}
+
+// write encodes a packet onto the connection.
+func (c *codec) write(p *packet) error {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.enc.Encode(p)
+}
+
+// read decodes the next packet from the connection.
+func (c *codec) read() (*packet, error) {
+	p := &packet{}
+	if err := c.dec.Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readHello reads a packet and ensures it is a hello of the right version.
+func (c *codec) readHello() (*packet, error) {
+	c.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	p, err := c.read()
+	c.conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if p.Kind != pktHello {
+		return nil, errors.New(fmt.Sprintf(fmtErrUnexpected, p.Kind))
+	}
+	if p.Version != ProtocolVersion {
+		return nil, errors.New(
+			fmt.Sprintf(fmtErrVersion, ProtocolVersion, p.Version))
+	}
+	return p, nil
+}
+
+// Close closes the underlying connection.
+func (c *codec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,54 @@
+package extension
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"net"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) } //Hook into testing package
+type s struct{}
+
+var _ = Suite(&s{})
+
+func (s *s) TestCodec(c *C) {
+	a, b := net.Pipe()
+	ca, cb := createCodec(a), createCodec(b)
+	defer ca.Close()
+	defer cb.Close()
+
+	msg := &irc.IrcMessage{
+		Name:   irc.PRIVMSG,
+		Sender: "nick!user@host",
+		Args:   []string{"#chan", "hello there"},
+	}
+	go ca.write(&packet{Kind: pktEvent, Id: 5, Key: "srv", Msg: msg})
+
+	p, err := cb.read()
+	c.Check(err, IsNil)
+	c.Check(p.Kind, Equals, pktEvent)
+	c.Check(p.Id, Equals, 5)
+	c.Check(p.Key, Equals, "srv")
+	c.Check(p.Msg, DeepEquals, msg)
+}
+
+func (s *s) TestCodec_ReadHello(c *C) {
+	a, b := net.Pipe()
+	ca, cb := createCodec(a), createCodec(b)
+	defer ca.Close()
+	defer cb.Close()
+
+	go ca.write(&packet{Kind: pktHello, Version: ProtocolVersion, Name: "x"})
+	p, err := cb.readHello()
+	c.Check(err, IsNil)
+	c.Check(p.Name, Equals, "x")
+
+	go ca.write(&packet{Kind: pktHello, Version: ProtocolVersion + 1})
+	_, err = cb.readHello()
+	c.Check(err, NotNil)
+
+	go ca.write(&packet{Kind: pktEvent, Version: ProtocolVersion})
+	_, err = cb.readHello()
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,217 @@
+package extension
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+	"net"
+	"sync"
+)
+
+const (
+	// nEventBuffer is how many events can wait to be written to a remote
+	// before new events are dropped.
+	nEventBuffer = 250
+
+	// fmtRemoteDropped is logged when a slow remote misses an event.
+	fmtRemoteDropped = "extension: (%v) dropped event %v, remote too slow\n"
+	// fmtRemoteClosed is logged when a remote goes away.
+	fmtRemoteClosed = "extension: (%v) closed (%v)\n"
+)
+
+// Host is what a Remote uses to subscribe to events and to write back to
+// servers. bot.Bot satisfies this interface.
+type Host interface {
+	// Register subscribes a handler to an event, returning an id.
+	Register(event string, handler interface{}) int
+	// Unregister cancels a registration made with Register.
+	Unregister(event string, id int) bool
+	// Writeln writes a message to the server identified by the key.
+	Writeln(server, message string) error
+}
+
+// registration remembers how to undo a Register call on the host.
+type registration struct {
+	event string
+	id    int
+}
+
+// Remote is the bot's view of a connected extension process. It registers
+// proxy handlers with the Host on the extension's behalf and forwards events
+// to it. Nothing the remote does, including dying, can block the Host: events
+// are buffered and dropped if the remote falls behind, and every registration
+// is removed when the connection goes away.
+type Remote struct {
+	name  string
+	host  Host
+	codec *codec
+
+	events   chan *packet
+	handlers map[int]registration
+	closed   bool
+	done     chan int
+	err      error
+
+	// Protects handlers and closed.
+	protect sync.Mutex
+}
+
+// remoteHandler is registered with the Host for each event a remote asks for.
+type remoteHandler struct {
+	remote *Remote
+	id     int
+}
+
+// HandleRaw implements dispatch.EventHandler and forwards the event.
+func (h *remoteHandler) HandleRaw(msg *irc.IrcMessage, ep irc.Endpoint) {
+	h.remote.deliver(&packet{
+		Kind: pktEvent,
+		Id:   h.id,
+		Key:  ep.GetKey(),
+		Msg:  msg,
+	})
+}
+
+// CreateRemote creates a remote for an extension on the other end of conn.
+// Start must be called to perform the handshake and begin serving it.
+func CreateRemote(conn net.Conn, host Host) *Remote {
+	return &Remote{
+		host:     host,
+		codec:    createCodec(conn),
+		events:   make(chan *packet, nEventBuffer),
+		handlers: make(map[int]registration),
+		done:     make(chan int),
+	}
+}
+
+// Start performs the handshake with the extension and starts the goroutines
+// serving it. If an error is returned the connection has been closed.
+func (r *Remote) Start() error {
+	err := r.codec.write(&packet{Kind: pktHello, Version: ProtocolVersion})
+	if err == nil {
+		var hello *packet
+		if hello, err = r.codec.readHello(); err == nil {
+			r.name = hello.Name
+		}
+	}
+	if err != nil {
+		r.codec.Close()
+		return err
+	}
+
+	go r.pump()
+	go r.siphon()
+	return nil
+}
+
+// GetName returns the name the extension gave during the handshake.
+func (r *Remote) GetName() string {
+	return r.name
+}
+
+// Close unregisters everything the extension registered and closes the
+// connection. It is safe to call more than once.
+func (r *Remote) Close() error {
+	return r.close(nil)
+}
+
+// Wait blocks until the remote has been closed and returns the error that
+// caused it, if any.
+func (r *Remote) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// close does the work of Close, recording the reason for closing.
+func (r *Remote) close(reason error) error {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.err = reason
+
+	for id, reg := range r.handlers {
+		r.host.Unregister(reg.event, reg.id)
+		delete(r.handlers, id)
+	}
+
+	err := r.codec.Close()
+	close(r.done)
+	log.Printf(fmtRemoteClosed, r.name, reason)
+	return err
+}
+
+// deliver queues an event for the remote without ever blocking.
+func (r *Remote) deliver(p *packet) {
+	select {
+	case <-r.done:
+	case r.events <- p:
+	default:
+		log.Printf(fmtRemoteDropped, r.name, p.Msg.Name)
+	}
+}
+
+// pump writes queued events to the remote until it is closed.
+func (r *Remote) pump() {
+	for {
+		select {
+		case p := <-r.events:
+			if err := r.codec.write(p); err != nil {
+				r.close(err)
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// siphon reads requests from the remote until the connection fails.
+func (r *Remote) siphon() {
+	for {
+		p, err := r.codec.read()
+		if err != nil {
+			r.close(err)
+			return
+		}
+
+		switch p.Kind {
+		case pktRegister:
+			r.register(p.Id, p.Event)
+		case pktUnregister:
+			r.unregister(p.Id)
+		case pktWrite:
+			if err = r.host.Writeln(p.Key, p.Data); err != nil {
+				r.codec.write(&packet{
+					Kind: pktError, Id: p.Id, Key: p.Key, Error: err.Error(),
+				})
+			}
+		}
+	}
+}
+
+// register subscribes to an event on the remote's behalf. Registering an id
+// that is already in use replaces the old registration.
+func (r *Remote) register(id int, event string) {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	if r.closed {
+		return
+	}
+	if reg, ok := r.handlers[id]; ok {
+		r.host.Unregister(reg.event, reg.id)
+	}
+	hostId := r.host.Register(event, &remoteHandler{r, id})
+	r.handlers[id] = registration{event, hostId}
+}
+
+// unregister cancels a subscription made by register.
+func (r *Remote) unregister(id int) {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	if reg, ok := r.handlers[id]; ok {
+		r.host.Unregister(reg.event, reg.id)
+		delete(r.handlers, id)
+	}
+}
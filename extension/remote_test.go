@@ -0,0 +1,233 @@
+package extension
+
+import (
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+	"net"
+	"sync"
+	"time"
+)
+
+//===========================================================
+// Set up a type that can be used to mock the Host.
+//===========================================================
+type testHost struct {
+	handlers map[int]interface{}
+	events   map[int]string
+	writes   []string
+	next     int
+
+	protect sync.Mutex
+}
+
+func createTestHost() *testHost {
+	return &testHost{
+		handlers: make(map[int]interface{}),
+		events:   make(map[int]string),
+	}
+}
+
+func (h *testHost) Register(event string, handler interface{}) int {
+	h.protect.Lock()
+	defer h.protect.Unlock()
+	h.next++
+	h.handlers[h.next] = handler
+	h.events[h.next] = event
+	return h.next
+}
+
+func (h *testHost) Unregister(event string, id int) bool {
+	h.protect.Lock()
+	defer h.protect.Unlock()
+	if _, ok := h.handlers[id]; !ok {
+		return false
+	}
+	delete(h.handlers, id)
+	delete(h.events, id)
+	return true
+}
+
+func (h *testHost) Writeln(server, message string) error {
+	h.protect.Lock()
+	defer h.protect.Unlock()
+	if server != "srv" {
+		return errors.New("unknown server")
+	}
+	h.writes = append(h.writes, message)
+	return nil
+}
+
+func (h *testHost) nHandlers() int {
+	h.protect.Lock()
+	defer h.protect.Unlock()
+	return len(h.handlers)
+}
+
+func (h *testHost) handler(event string) *remoteHandler {
+	h.protect.Lock()
+	defer h.protect.Unlock()
+	for id, ev := range h.events {
+		if ev == event {
+			return h.handlers[id].(*remoteHandler)
+		}
+	}
+	return nil
+}
+
+type testPoint struct {
+	*irc.Helper
+}
+
+func (t testPoint) GetKey() string {
+	return "srv"
+}
+
+// waitFor polls a condition so tests don't depend on goroutine scheduling.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+// startRemote creates a remote with the extension side of the handshake
+// completed on the returned codec.
+func startRemote(c *C, host Host) (*Remote, *codec) {
+	botside, extside := net.Pipe()
+	remote := CreateRemote(botside, host)
+	ext := createCodec(extside)
+
+	started := make(chan error)
+	go func() { started <- remote.Start() }()
+
+	_, err := ext.readHello()
+	c.Assert(err, IsNil)
+	err = ext.write(&packet{Kind: pktHello, Version: ProtocolVersion,
+		Name: "test"})
+	c.Assert(err, IsNil)
+	c.Assert(<-started, IsNil)
+	return remote, ext
+}
+
+//===========================================================
+// Tests
+//===========================================================
+func (s *s) TestRemote_Handshake(c *C) {
+	host := createTestHost()
+	remote, ext := startRemote(c, host)
+	c.Check(remote.GetName(), Equals, "test")
+	ext.Close()
+	c.Check(remote.Wait(), NotNil)
+}
+
+func (s *s) TestRemote_HandshakeFail(c *C) {
+	botside, extside := net.Pipe()
+	remote := CreateRemote(botside, createTestHost())
+	ext := createCodec(extside)
+
+	started := make(chan error)
+	go func() { started <- remote.Start() }()
+	ext.readHello()
+	ext.write(&packet{Kind: pktHello, Version: ProtocolVersion + 1})
+	c.Check(<-started, NotNil)
+}
+
+func (s *s) TestRemote_Events(c *C) {
+	host := createTestHost()
+	remote, ext := startRemote(c, host)
+	defer remote.Close()
+
+	err := ext.write(&packet{Kind: pktRegister, Id: 7, Event: irc.PRIVMSG})
+	c.Check(err, IsNil)
+	c.Assert(waitFor(func() bool { return host.nHandlers() == 1 }), Equals,
+		true)
+
+	msg := &irc.IrcMessage{Name: irc.PRIVMSG, Args: []string{"#a", "hi"}}
+	host.handler(irc.PRIVMSG).HandleRaw(msg, testPoint{})
+
+	p, err := ext.read()
+	c.Check(err, IsNil)
+	c.Check(p.Kind, Equals, pktEvent)
+	c.Check(p.Id, Equals, 7)
+	c.Check(p.Key, Equals, "srv")
+	c.Check(p.Msg, DeepEquals, msg)
+
+	err = ext.write(&packet{Kind: pktUnregister, Id: 7})
+	c.Check(err, IsNil)
+	c.Check(waitFor(func() bool { return host.nHandlers() == 0 }), Equals,
+		true)
+}
+
+func (s *s) TestRemote_Writes(c *C) {
+	host := createTestHost()
+	remote, ext := startRemote(c, host)
+	defer remote.Close()
+
+	err := ext.write(&packet{Kind: pktWrite, Id: 1, Key: "srv", Data: "hi"})
+	c.Check(err, IsNil)
+	err = ext.write(&packet{Kind: pktWrite, Id: 2, Key: "nope", Data: "hi"})
+	c.Check(err, IsNil)
+
+	p, err := ext.read()
+	c.Check(err, IsNil)
+	c.Check(p.Kind, Equals, pktError)
+	c.Check(p.Id, Equals, 2)
+	c.Check(p.Error, Not(Equals), "")
+
+	host.protect.Lock()
+	c.Check(host.writes, DeepEquals, []string{"hi"})
+	host.protect.Unlock()
+}
+
+func (s *s) TestRemote_Crash(c *C) {
+	host := createTestHost()
+	remote, ext := startRemote(c, host)
+
+	ext.write(&packet{Kind: pktRegister, Id: 1, Event: irc.PRIVMSG})
+	ext.write(&packet{Kind: pktRegister, Id: 2, Event: irc.JOIN})
+	c.Assert(waitFor(func() bool { return host.nHandlers() == 2 }), Equals,
+		true)
+	handler := host.handler(irc.JOIN)
+
+	ext.Close()
+	c.Check(remote.Wait(), NotNil)
+	c.Check(host.nHandlers(), Equals, 0)
+
+	// Late events for a dead remote must not block.
+	msg := &irc.IrcMessage{Name: irc.JOIN, Args: []string{"#a"}}
+	for i := 0; i < nEventBuffer*2; i++ {
+		handler.HandleRaw(msg, testPoint{})
+	}
+	c.Check(remote.Close(), IsNil)
+}
+
+func (s *s) TestRemote_SlowRemote(c *C) {
+	host := createTestHost()
+	remote, ext := startRemote(c, host)
+	defer ext.Close()
+	defer remote.Close()
+
+	ext.write(&packet{Kind: pktRegister, Id: 1, Event: irc.PRIVMSG})
+	c.Assert(waitFor(func() bool { return host.nHandlers() == 1 }), Equals,
+		true)
+	handler := host.handler(irc.PRIVMSG)
+
+	// Nobody reads on the extension side, the handler must still return.
+	done := make(chan int)
+	go func() {
+		msg := &irc.IrcMessage{Name: irc.PRIVMSG, Args: []string{"#a", "x"}}
+		for i := 0; i < nEventBuffer*2; i++ {
+			handler.HandleRaw(msg, testPoint{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Error("Handler blocked on a slow remote.")
+	}
+}
@@ -6,6 +6,7 @@ package inet
 
 import (
 	"bytes"
+	"github.com/aarondl/ultimateq/irc"
 	"io"
 	"log"
 	"net"
@@ -44,6 +45,12 @@ const (
 	errMsgShutdown        = "Shut Down"
 )
 
+// outMessage is a message on it's way to the pump.
+type outMessage struct {
+	data     []byte
+	priority int
+}
+
 // IrcClient represents a connection to an irc server. It uses a queueing system
 // to throttle writes to the server. And it implements ReadWriteCloser interface
 type IrcClient struct {
@@ -52,11 +59,14 @@ type IrcClient struct {
 
 	conn        net.Conn
 	siphonchan  chan []byte
-	pumpchan    chan []byte
-	pumpservice chan chan []byte
+	pumpchan    chan outMessage
+	pumpservice chan chan outMessage
 	killpump    chan int
 	killsiphon  chan int
-	queue       Queue
+
+	queue        PriorityQueue
+	casemap      irc.Casemap
+	queueProtect sync.Mutex
 
 	// The name of the connection for logging
 	name string
@@ -68,6 +78,7 @@ type IrcClient struct {
 	burst      int
 	timeout    time.Duration
 	step       time.Duration
+	penalty    *Penalty
 
 	// buffering for io.Reader interface
 	readbuf []byte
@@ -80,8 +91,8 @@ func CreateIrcClient(conn net.Conn, name string) *IrcClient {
 		name:        name,
 		conn:        conn,
 		siphonchan:  make(chan []byte),
-		pumpchan:    make(chan []byte),
-		pumpservice: make(chan chan []byte),
+		pumpchan:    make(chan outMessage),
+		pumpservice: make(chan chan outMessage),
 		lastwrite:   time.Time{},
 		scale:       defaultTimeScale,
 	}
@@ -102,6 +113,16 @@ func CreateIrcClientFloodProtect(conn net.Conn, name string, burst, timeout,
 	return c
 }
 
+// CreateIrcClientPenalty creates an irc client that uses the penalty model
+// for flood protection instead of counting messages, see Penalty.
+func CreateIrcClientPenalty(conn net.Conn, name string,
+	penalty *Penalty) *IrcClient {
+
+	c := CreateIrcClient(conn, name)
+	c.penalty = penalty
+	return c
+}
+
 // SpawnWorkers creates two goroutines, one that is constantly reading using
 // Siphon, and one that is constantly working on eliminating the write queue by
 // writing. Also sets up the instances kill channels.
@@ -144,6 +165,15 @@ func (c *IrcClient) calcSleepTime(t time.Time) time.Duration {
 	return 0
 }
 
+// sleepTime calculates the sleep time required by the flood protection before
+// msg can be written at time t.
+func (c *IrcClient) sleepTime(t time.Time, msg []byte) time.Duration {
+	if c.penalty != nil {
+		return c.penalty.wait(t, msg)
+	}
+	return c.calcSleepTime(t)
+}
+
 // pump enqueues the messages given to Write and writes them to the connection.
 // It also sleeps a don't-get-glined amount of time between writes.
 func (c *IrcClient) pump() {
@@ -154,7 +184,8 @@ func (c *IrcClient) pump() {
 	for err == nil {
 		select {
 		case c.pumpservice <- c.pumpchan:
-			message := <-c.pumpchan
+			out := <-c.pumpchan
+			message := out.data
 			if len(message) == 0 {
 				break
 			}
@@ -164,24 +195,31 @@ func (c *IrcClient) pump() {
 					break
 				}
 			} else if sleeper == nil {
-				sleepTime := c.calcSleepTime(time.Now())
+				sleepTime := c.sleepTime(time.Now(), message)
 				if sleepTime == 0 {
 					if err = c.writeMessage(message); err != nil {
 						break
 					}
 				} else {
-					c.queue.Enqueue(message)
+					c.enqueue(out)
 					sleeper = time.After(sleepTime)
 				}
 			} else {
-				c.queue.Enqueue(message)
+				c.enqueue(out)
 			}
 		case <-sleeper:
-			if err = c.writeMessage(c.queue.Dequeue()); err != nil {
+			message, wait := c.next(time.Now())
+			if wait > 0 {
+				sleeper = time.After(wait)
 				break
 			}
-			if c.queue.length > 0 {
-				sleepTime := c.calcSleepTime(time.Now())
+			if message != nil {
+				if err = c.writeMessage(message); err != nil {
+					break
+				}
+			}
+			if c.queued() > 0 {
+				sleepTime := c.sleepTime(time.Now(), c.peek())
 				sleeper = time.After(sleepTime)
 			} else {
 				sleeper = nil
@@ -195,6 +233,72 @@ func (c *IrcClient) pump() {
 	<-c.killpump
 }
 
+// enqueue queues a message that has to wait for the flood protection.
+func (c *IrcClient) enqueue(out outMessage) {
+	c.queueProtect.Lock()
+	c.queue.Enqueue(out.data, out.priority)
+	c.queueProtect.Unlock()
+}
+
+// next takes the next message to write off the queue, nil if it's empty. If
+// the penalty model won't allow the message to be written at time t yet it's
+// left on the queue and the time to wait is returned instead.
+func (c *IrcClient) next(t time.Time) ([]byte, time.Duration) {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	if c.penalty != nil && c.queue.Len() > 0 {
+		if wait := c.penalty.wait(t, c.queue.Peek()); wait > 0 {
+			return nil, wait
+		}
+	}
+	return c.queue.Dequeue(), 0
+}
+
+// peek returns the next message to write without taking it off the queue.
+func (c *IrcClient) peek() []byte {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	return c.queue.Peek()
+}
+
+// queued returns the number of messages waiting on the flood protection.
+func (c *IrcClient) queued() int {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	return c.queue.Len()
+}
+
+// CancelBulk drops the PRIORITY_BULK messages to target that are waiting on
+// the flood protection, and returns how many were dropped.
+func (c *IrcClient) CancelBulk(target string) int {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	return c.queue.Filter(PRIORITY_BULK, func(msg []byte) bool {
+		_, msgTarget := splitCommand(msg)
+		return c.casemap.Equals(string(msgTarget), target)
+	})
+}
+
+// Casemapping sets the casemap of the server, it decides which messages are
+// to the same target when they're queued or cancelled.
+func (c *IrcClient) Casemapping(casemap irc.Casemap) {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	c.casemap = casemap
+	c.queue.Casemapping(casemap)
+}
+
+// Shape caps how many normal and bulk messages may wait on the flood
+// protection for each target, 0 is unlimited. Overflow is one of the
+// OVERFLOW_* policies and decides what happens to messages past the cap,
+// coalesce drops messages that are the same as the last one waiting for their
+// target.
+func (c *IrcClient) Shape(backlog, overflow int, coalesce bool) {
+	c.queueProtect.Lock()
+	defer c.queueProtect.Unlock()
+	c.queue.Shape(backlog, overflow, coalesce)
+}
+
 // writeMessage writes a byte array out to the socket, sets the last write time.
 func (c *IrcClient) writeMessage(msg []byte) error {
 	var n int
@@ -209,6 +313,9 @@ func (c *IrcClient) writeMessage(msg []byte) error {
 		Log(fmtWrite, c.name, wrote)
 		c.lastwrite = time.Now()
 	}
+	if c.penalty != nil {
+		c.penalty.charge(c.lastwrite, msg)
+	}
 	return nil
 }
 
@@ -353,8 +460,24 @@ func (c *IrcClient) Read(buf []byte) (int, error) {
 // is split based on \r\n and each message is queued, then the Pump is signaled
 // through the channel with the number of messages queued. A read lock on a
 // mutex is required to write to the channel to ensure any other thread
-// cannot close the channel while someone is attempting to write to it.
+// cannot close the channel while someone is attempting to write to it. Each
+// message is given a priority class based on it's command, see Priority.
 func (c *IrcClient) Write(buf []byte) (int, error) {
+	return c.write(buf, -1)
+}
+
+// WritePriority writes to the socket like Write, but every message in the
+// buffer is given the priority class passed in, one of the PRIORITY_* classes.
+func (c *IrcClient) WritePriority(buf []byte, priority int) (int, error) {
+	if priority < 0 || priority >= nPriorities {
+		priority = PRIORITY_NORMAL
+	}
+	return c.write(buf, priority)
+}
+
+// write queues each message in buf with a priority, a negative priority means
+// each message is given the priority of it's command.
+func (c *IrcClient) write(buf []byte, priority int) (int, error) {
 	n := len(buf)
 	if n == 0 {
 		return 0, nil
@@ -363,11 +486,15 @@ func (c *IrcClient) Write(buf []byte) (int, error) {
 	write := func(msg []byte) bool {
 		copybuf := make([]byte, len(msg))
 		copy(copybuf, msg)
+		out := outMessage{copybuf, priority}
+		if priority < 0 {
+			out.priority = Priority(copybuf)
+		}
 		service, ok := <-c.pumpservice
 		if !ok {
 			return true
 		}
-		service <- copybuf
+		service <- out
 		return false
 	}
 
@@ -2,6 +2,7 @@ package inet
 
 import (
 	"bytes"
+	"github.com/aarondl/ultimateq/irc"
 	"github.com/aarondl/ultimateq/mocks"
 	"io"
 	. "launchpad.net/gocheck"
@@ -59,7 +60,7 @@ func (s *s) TestIrcClient_Pump(c *C) {
 	fakelast := time.Now().Truncate(5 * time.Hour)
 	client.SpawnWorkers(true, false)
 	ch := <-client.pumpservice
-	ch <- []byte{} //Inconsequential, testcov error handling
+	ch <- outMessage{} //Inconsequential, testcov error handling
 
 	go func() {
 		client.Write(test1)
@@ -108,6 +109,110 @@ func (s *s) TestIrcClient_PumpTimeouts(c *C) {
 	conn.WaitForDeath()
 }
 
+func (s *s) TestIrcClient_PumpPriority(c *C) {
+	bulk := []byte("PRIVMSG #chan :bulk\r\n")
+	other := []byte("PRIVMSG #other :bulk\r\n")
+	kick := []byte("KICK #chan nick :flooding\r\n")
+
+	conn := mocks.CreateConn()
+	client := CreateIrcClientFloodProtect(conn, "", 1, 50, 20,
+		time.Millisecond)
+	client.SpawnWorkers(true, false)
+
+	done := make(chan int)
+	go func() {
+		for i := 0; i < 4; i++ {
+			_, err := client.WritePriority(bulk, PRIORITY_BULK)
+			c.Check(err, IsNil)
+		}
+		client.WritePriority(other, PRIORITY_BULK)
+		client.Write(kick)
+		close(done)
+	}()
+
+	c.Check(bytes.Compare(conn.Receive(len(bulk), nil), bulk), Equals, 0)
+	<-done
+	c.Check(bytes.Compare(conn.Receive(len(kick), nil), kick), Equals, 0)
+	c.Check(client.CancelBulk("#CHAN"), Equals, 3)
+	c.Check(bytes.Compare(conn.Receive(len(other), nil), other), Equals, 0)
+	c.Check(client.queued(), Equals, 0)
+
+	client.Close()
+	conn.WaitForDeath()
+}
+
+func (s *s) TestIrcClient_Shape(c *C) {
+	conn := mocks.CreateConn()
+	client := CreateIrcClientFloodProtect(conn, "", 1, 50, 20,
+		time.Millisecond)
+	client.Shape(2, OVERFLOW_DROP_NEWEST, true)
+	client.SpawnWorkers(true, false)
+
+	first := []byte("PRIVMSG #busy :0\r\n")
+	written := [][]byte{
+		[]byte("PRIVMSG #busy :1\r\n"),
+		[]byte("PRIVMSG #busy :1\r\n"),
+		[]byte("PRIVMSG #busy :2\r\n"),
+		[]byte("PRIVMSG #busy :3\r\n"),
+		[]byte("PRIVMSG #quiet :1\r\n"),
+	}
+	done := make(chan int)
+	go func() {
+		client.Write(first)
+		for _, msg := range written {
+			client.Write(msg)
+		}
+		close(done)
+	}()
+
+	c.Check(bytes.Compare(conn.Receive(len(first), nil), first), Equals, 0)
+	<-done
+	for _, i := range []int{0, 4, 2} {
+		msg := conn.Receive(len(written[i]), nil)
+		c.Check(bytes.Compare(msg, written[i]), Equals, 0)
+	}
+	c.Check(client.queued(), Equals, 0)
+
+	client.Close()
+	conn.WaitForDeath()
+}
+
+func (s *s) TestIrcClient_Penalty(c *C) {
+	conn := mocks.CreateConn()
+	penalty := CreatePenalty(20*time.Millisecond, 10*time.Millisecond, 0)
+	client := CreateIrcClientPenalty(conn, "", penalty)
+	c.Check(client.penalty, Equals, penalty)
+	client.SpawnWorkers(true, false)
+
+	msgs := [][]byte{
+		[]byte("PRIVMSG #chan :1\r\n"),
+		[]byte("WHO #chan\r\n"),
+		[]byte("PRIVMSG #chan :2\r\n"),
+		[]byte("PRIVMSG #chan :3\r\n"),
+		[]byte("NAMES #chan\r\n"),
+		[]byte("PRIVMSG #chan :4\r\n"),
+	}
+	go func() {
+		for _, msg := range msgs {
+			client.Write(msg)
+		}
+	}()
+
+	// Writes are timed when they're received, which is at worst a little
+	// after the client timed them.
+	server := &penaltyServer{limit: penalty.Limit + time.Millisecond,
+		cost: penalty.Cost}
+	start := time.Now()
+	for _, msg := range msgs {
+		c.Check(bytes.Compare(conn.Receive(len(msg), nil), msg), Equals, 0)
+		c.Check(server.receive(time.Now(), msg), Equals, time.Duration(0))
+	}
+	c.Check(time.Since(start) >= 50*time.Millisecond, Equals, true)
+
+	client.Close()
+	conn.WaitForDeath()
+}
+
 func (s *s) TestIrcClient_Siphon(c *C) {
 	test1 := []byte("PRIVMSG :msg\r\n")
 	test2 := []byte("NOTICE :msg\r\n")
@@ -253,7 +358,7 @@ func (s *s) TestIrcClient_Write(c *C) {
 	test2 := []byte("PRIVMSG #chan :msg2")
 
 	client := CreateIrcClient(nil, "")
-	ch := make(chan []byte)
+	ch := make(chan outMessage)
 	go func() {
 		arg := append(test1, test2...)
 		client.Write(nil) //Should be Consequenceless test cov
@@ -262,9 +367,10 @@ func (s *s) TestIrcClient_Write(c *C) {
 		c.Check(n, Equals, len(arg))
 	}()
 	client.pumpservice <- ch
-	c.Check(bytes.Compare(<-ch, test1), Equals, 0)
+	c.Check(bytes.Compare((<-ch).data, test1), Equals, 0)
 	client.pumpservice <- ch
-	c.Check(bytes.Compare(<-ch, append(test2, []byte{13, 10}...)), Equals, 0)
+	c.Check(bytes.Compare((<-ch).data, append(test2, []byte{13, 10}...)),
+		Equals, 0)
 
 	close(client.pumpservice)
 	n, err := client.Write(test1)
@@ -330,3 +436,15 @@ func (s *s) TestfindChunks(c *C) {
 	})
 	c.Check(abort, Equals, true)
 }
+
+func (s *s) TestIrcClient_Casemapping(c *C) {
+	client := CreateIrcClient(mocks.CreateConn(), "")
+	client.queue.Enqueue([]byte("PRIVMSG #[a] :1\r\n"), PRIORITY_BULK)
+	client.queue.Enqueue([]byte("PRIVMSG #{a} :2\r\n"), PRIORITY_BULK)
+	c.Check(client.CancelBulk("#{A}"), Equals, 1)
+
+	client.Casemapping(irc.CASEMAP_RFC1459)
+	client.queue.Enqueue([]byte("PRIVMSG #{a} :3\r\n"), PRIORITY_BULK)
+	c.Check(client.CancelBulk("#{A}"), Equals, 2)
+	c.Check(client.queue.Len(), Equals, 0)
+}
@@ -0,0 +1,91 @@
+package inet
+
+import (
+	"bytes"
+	"time"
+)
+
+// penaltyCommands are the commands that cost extra under the penalty model,
+// by how many messages worth of penalty they add.
+var penaltyCommands = map[string]int{
+	"WHO":    1,
+	"WHOIS":  1,
+	"WHOWAS": 1,
+	"NAMES":  1,
+	"LIST":   2,
+	"MODE":   1,
+	"KICK":   1,
+	"INVITE": 1,
+	"TOPIC":  1,
+}
+
+// Penalty is a flood protection model based on the penalty servers like ircu
+// and hybrid keep for each client. Every message adds to the penalty based on
+// it's length and command, and the penalty wears off in real time. Servers
+// stop reading from a client whose penalty gets too far ahead of the clock,
+// and kill it for Excess Flood if it keeps writing, so messages are held back
+// until they fit under the limit.
+//
+// The fields must not be changed once the Penalty is given to an IrcClient.
+type Penalty struct {
+	// Limit is how far the penalty may get ahead of the clock.
+	Limit time.Duration
+	// Message is the penalty of every message.
+	Message time.Duration
+	// Bytes is how many bytes of a message add another Message to it's
+	// penalty, 0 ignores the length of messages.
+	Bytes int
+	// Commands is the extra penalty of commands that cost more than others,
+	// by upper case command name.
+	Commands map[string]time.Duration
+
+	since time.Time
+}
+
+// CreatePenalty creates a penalty model with the default extra costs for
+// commands like WHO and MODE.
+func CreatePenalty(limit, message time.Duration, bytes int) *Penalty {
+	p := &Penalty{
+		Limit:    limit,
+		Message:  message,
+		Bytes:    bytes,
+		Commands: make(map[string]time.Duration, len(penaltyCommands)),
+	}
+	for command, n := range penaltyCommands {
+		p.Commands[command] = time.Duration(n) * message
+	}
+	return p
+}
+
+// Cost returns the penalty of an irc message.
+func (p *Penalty) Cost(msg []byte) time.Duration {
+	cost := p.Message
+	if p.Bytes > 0 {
+		cost += time.Duration(len(msg)) * p.Message / time.Duration(p.Bytes)
+	}
+	command, _ := splitCommand(msg)
+	return cost + p.Commands[string(bytes.ToUpper(command))]
+}
+
+// wait returns how long to wait before msg can be written at time t without
+// going over the limit. A message is never held back once the penalty has
+// worn off, even if it alone goes over the limit.
+func (p *Penalty) wait(t time.Time, msg []byte) time.Duration {
+	if !p.since.After(t) {
+		return 0
+	}
+
+	wait := p.since.Add(p.Cost(msg) - p.Limit).Sub(t)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// charge adds the penalty of a message written at time t.
+func (p *Penalty) charge(t time.Time, msg []byte) {
+	if p.since.Before(t) {
+		p.since = t
+	}
+	p.since = p.since.Add(p.Cost(msg))
+}
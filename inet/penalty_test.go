@@ -0,0 +1,156 @@
+package inet
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"math/rand"
+	"time"
+)
+
+// penaltyServer keeps the penalty of a client the way a server would, to
+// check the penalty model against.
+type penaltyServer struct {
+	since time.Time
+	limit time.Duration
+	cost  func([]byte) time.Duration
+}
+
+// receive adds the penalty of a message read at time t, and returns how far
+// over the limit the penalty went. A message is allowed over the limit on it's
+// own if the penalty had worn off.
+func (s *penaltyServer) receive(t time.Time, msg []byte) time.Duration {
+	worn := !s.since.After(t)
+	if worn {
+		s.since = t
+	}
+	s.since = s.since.Add(s.cost(msg))
+
+	over := s.since.Sub(t) - s.limit
+	if over < 0 || worn {
+		return 0
+	}
+	return over
+}
+
+// simulatedMessage creates a random irc message of 10-510 bytes.
+func simulatedMessage(r *rand.Rand) []byte {
+	commands := []string{"PRIVMSG #chan :", "NOTICE nick :", "WHO #chan ",
+		"MODE #chan +o ", "LIST ", "PRIVMSG nick :", "KICK #chan nick :"}
+	msg := []byte(commands[r.Intn(len(commands))])
+	size := 10 + r.Intn(501) - len(msg) - 2
+	for i := 0; i < size; i++ {
+		msg = append(msg, byte('a'+r.Intn(26)))
+	}
+	return append(msg, '\r', '\n')
+}
+
+func (s *s) TestCreatePenalty(c *C) {
+	p := CreatePenalty(10*time.Second, 2*time.Second, 120)
+	c.Check(p.Limit, Equals, 10*time.Second)
+	c.Check(p.Message, Equals, 2*time.Second)
+	c.Check(p.Bytes, Equals, 120)
+	c.Check(p.Commands["WHO"], Equals, 2*time.Second)
+	c.Check(p.Commands["LIST"], Equals, 4*time.Second)
+	c.Check(p.Commands["PRIVMSG"], Equals, time.Duration(0))
+}
+
+func (s *s) TestPenalty_Cost(c *C) {
+	p := CreatePenalty(10*time.Second, time.Second, 10)
+
+	msg := []byte("PRIVMSG #chan :hi\r\n")
+	c.Check(p.Cost(msg), Equals, time.Second+19*time.Second/10)
+
+	msg = []byte("who #chan\r\n")
+	c.Check(p.Cost(msg), Equals, 2*time.Second+11*time.Second/10)
+
+	msg = []byte(":me!u@h MODE #chan +o nick\r\n")
+	c.Check(p.Cost(msg), Equals, 2*time.Second+28*time.Second/10)
+
+	p.Bytes = 0
+	c.Check(p.Cost(msg), Equals, 2*time.Second)
+}
+
+func (s *s) TestPenalty_wait(c *C) {
+	now := time.Now()
+	msg := []byte("PRIVMSG #chan :hi\r\n")
+	p := CreatePenalty(3*time.Second, time.Second, 0)
+
+	c.Check(p.wait(now, msg), Equals, time.Duration(0))
+	p.charge(now, msg)
+	p.charge(now, msg)
+	c.Check(p.wait(now, msg), Equals, time.Duration(0))
+	p.charge(now, msg)
+	c.Check(p.since, Equals, now.Add(3*time.Second))
+	c.Check(p.wait(now, msg), Equals, time.Second)
+	c.Check(p.wait(now.Add(time.Second), msg), Equals, time.Duration(0))
+	c.Check(p.wait(now.Add(time.Hour), msg), Equals, time.Duration(0))
+
+	who := []byte("WHO #chan\r\n")
+	c.Check(p.wait(now, who), Equals, 2*time.Second)
+
+	p = CreatePenalty(time.Second, 5*time.Second, 0)
+	c.Check(p.wait(now, msg), Equals, time.Duration(0))
+	p.charge(now, msg)
+	c.Check(p.wait(now, msg), Equals, 9*time.Second)
+	c.Check(p.wait(now.Add(5*time.Second), msg), Equals, time.Duration(0))
+}
+
+func (s *s) TestPenalty_Simulation(c *C) {
+	models := []*Penalty{
+		CreatePenalty(10*time.Second, 2*time.Second, 240),
+		CreatePenalty(10*time.Second, time.Second, 120),
+		CreatePenalty(5*time.Second, time.Second, 0),
+		CreatePenalty(20*time.Second, 3*time.Second, 60),
+		CreatePenalty(time.Second, 2*time.Second, 100),
+	}
+
+	for seed, p := range models {
+		r := rand.New(rand.NewSource(int64(seed)))
+		server := &penaltyServer{limit: p.Limit, cost: p.Cost}
+		now := time.Unix(0, 0)
+		waited := 0
+
+		for i := 0; i < 2000; i++ {
+			if r.Intn(4) == 0 {
+				now = now.Add(time.Duration(r.Int63n(int64(p.Limit))))
+			}
+
+			msg := simulatedMessage(r)
+			if wait := p.wait(now, msg); wait > 0 {
+				waited++
+				now = now.Add(wait)
+				c.Check(p.wait(now, msg), Equals, time.Duration(0))
+				if p.Cost(msg) <= p.Limit {
+					early := p.wait(now.Add(-time.Millisecond), msg)
+					c.Check(early > 0, Equals, true)
+				}
+			}
+
+			p.charge(now, msg)
+			if over := server.receive(now, msg); over > 0 {
+				c.Errorf("Model %v went %v over the limit on message %v.",
+					seed, over, i)
+				break
+			}
+			c.Check(p.since, Equals, server.since)
+		}
+		c.Check(waited > 0, Equals, true)
+	}
+}
+
+func (s *s) TestPenalty_SimulationFlood(c *C) {
+	p := CreatePenalty(10*time.Second, 2*time.Second, 240)
+	server := &penaltyServer{limit: p.Limit, cost: p.Cost}
+	msg := append(bytes.Repeat([]byte("a"), 498), '\r', '\n')
+	now := time.Unix(0, 0)
+	start := now
+
+	for i := 0; i < 100; i++ {
+		now = now.Add(p.wait(now, msg))
+		p.charge(now, msg)
+		c.Check(server.receive(now, msg), Equals, time.Duration(0))
+	}
+
+	// Once the penalty is full, long lines go out no faster than they cost.
+	c.Check(now.Sub(start) >= 99*p.Cost(msg)-p.Limit, Equals, true)
+}
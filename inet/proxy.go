@@ -0,0 +1,288 @@
+package inet
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Proxy Types
+const (
+	PROXY_SOCKS5 = "socks5"
+	PROXY_HTTP   = "http"
+)
+
+const (
+	// proxyTimeout is how long a proxy has to set up the tunnel.
+	proxyTimeout = 30 * time.Second
+
+	// SOCKS5 protocol values, from RFC 1928 and RFC 1929.
+	socksVersion      = 0x05
+	socksAuthVersion  = 0x01
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+	socksSucceeded    = 0x00
+)
+
+// Format strings for proxy errors.
+const (
+	fmtErrProxyType     = "inet: Unknown proxy type (%v)"
+	fmtErrSocksReply    = "inet: Socks5 proxy refused connection (%v)"
+	fmtErrHttpReply     = "inet: Http proxy refused connection (%v)"
+	fmtErrProxyHostLong = "inet: Hostname too long for socks5 proxy (%v)"
+)
+
+var (
+	// errSocksVersion happens when the proxy does not speak socks5.
+	errSocksVersion = errors.New("inet: Proxy is not a socks5 proxy")
+	// errSocksAuth happens when the proxy wants an unsupported authentication
+	// method or none of the offered ones.
+	errSocksAuth = errors.New("inet: Socks5 proxy authentication unsupported")
+	// errSocksLogin happens when the proxy rejects the username and password.
+	errSocksLogin = errors.New("inet: Socks5 proxy authentication failed")
+	// errSocksCredsLong happens when the username or password is too long to
+	// send to a socks5 proxy.
+	errSocksCredsLong = errors.New("inet: Socks5 credentials too long")
+)
+
+// socksReplies are the messages for the socks5 reply codes.
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// Proxy is a proxy server that connections can be tunneled through. Once the
+// tunnel is established the connection is used as if it were made directly.
+type Proxy struct {
+	// Type is one of the PROXY_* types.
+	Type string
+	// Address is the host:port of the proxy.
+	Address string
+	// Username and Password are used to authenticate to the proxy if the
+	// Username is not empty.
+	Username string
+	Password string
+}
+
+// Dial connects to the proxy and asks it to connect to address, a host:port.
+// The hostname is resolved by the proxy.
+func (p Proxy) Dial(address string) (net.Conn, error) {
+	if p.Type != PROXY_SOCKS5 && p.Type != PROXY_HTTP {
+		return nil, errors.New(fmt.Sprintf(fmtErrProxyType, p.Type))
+	}
+
+	conn, err := net.DialTimeout("tcp", p.Address, proxyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel, err := p.Tunnel(conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// Tunnel asks the proxy on the other end of an established connection to
+// connect to address, a host:port. The returned connection should be used
+// in place of the given one.
+func (p Proxy) Tunnel(conn net.Conn, address string) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(proxyTimeout))
+
+	var err error
+	switch p.Type {
+	case PROXY_SOCKS5:
+		err = p.socks5(conn, address)
+	case PROXY_HTTP:
+		conn, err = p.httpConnect(conn, address)
+	default:
+		err = errors.New(fmt.Sprintf(fmtErrProxyType, p.Type))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5 negotiates a tunnel with a socks5 proxy.
+func (p Proxy) socks5(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{socksAuthNone}
+	if len(p.Username) > 0 {
+		methods = []byte{socksAuthNone, socksAuthPassword}
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err = conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return errSocksVersion
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if len(p.Username) == 0 {
+			return errSocksAuth
+		}
+		if err = p.socks5Login(conn); err != nil {
+			return err
+		}
+	default:
+		return errSocksAuth
+	}
+
+	request := []byte{socksVersion, socksConnect, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New(fmt.Sprintf(fmtErrProxyHostLong, host))
+		}
+		request = append(request, socksDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socksIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socksIPv6)
+		request = append(request, ip.To16()...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+
+	reply = make([]byte, 4)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return errSocksVersion
+	}
+	if reply[1] != socksSucceeded {
+		msg, ok := socksReplies[reply[1]]
+		if !ok {
+			msg = "code " + strconv.Itoa(int(reply[1]))
+		}
+		return errors.New(fmt.Sprintf(fmtErrSocksReply, msg))
+	}
+
+	// Discard the address the proxy bound to.
+	var skip int
+	switch reply[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return errSocksVersion
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// socks5Login authenticates to a socks5 proxy with a username and password.
+func (p Proxy) socks5Login(conn net.Conn) error {
+	if len(p.Username) > 255 || len(p.Password) > 255 {
+		return errSocksCredsLong
+	}
+
+	login := []byte{socksAuthVersion, byte(len(p.Username))}
+	login = append(login, p.Username...)
+	login = append(login, byte(len(p.Password)))
+	login = append(login, p.Password...)
+	if _, err := conn.Write(login); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != socksSucceeded {
+		return errSocksLogin
+	}
+	return nil
+}
+
+// httpConnect negotiates a tunnel with an http proxy using CONNECT.
+func (p Proxy) httpConnect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if len(p.Username) > 0 {
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString(
+				[]byte(p.Username+":"+p.Password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf(fmtErrHttpReply, resp.Status))
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{conn, reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection that has had some of it's data read into a
+// buffer, which must be read before the rest of the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffer, and then the connection.
+func (b *bufferedConn) Read(buf []byte) (int, error) {
+	return b.reader.Read(buf)
+}
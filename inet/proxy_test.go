@@ -0,0 +1,270 @@
+package inet
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// testTarget is a server that greets whoever connects to it.
+func testTarget(c *C) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello\r\n"))
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+// testProxy is a stand-in proxy server, it records the address it was asked to
+// connect to and then connects to target regardless.
+type testProxy struct {
+	ln       net.Listener
+	target   string
+	username string
+	password string
+	reply    byte
+	status   int
+	asked    chan string
+}
+
+// createTestProxy starts a stand-in proxy of the given type.
+func createTestProxy(c *C, kind, target string) *testProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	p := &testProxy{ln: ln, target: target, status: http.StatusOK,
+		asked: make(chan string, 4)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if kind == PROXY_SOCKS5 {
+				go p.socks5(conn)
+			} else {
+				go p.http(conn)
+			}
+		}
+	}()
+	return p
+}
+
+// splice connects the client to the target.
+func (p *testProxy) splice(conn net.Conn) {
+	defer conn.Close()
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	io.Copy(conn, target)
+}
+
+func (p *testProxy) socks5(conn net.Conn) {
+	buf := make([]byte, 2)
+	io.ReadFull(conn, buf)
+	methods := make([]byte, buf[1])
+	io.ReadFull(conn, methods)
+
+	if len(p.username) > 0 {
+		conn.Write([]byte{socksVersion, socksAuthPassword})
+		io.ReadFull(conn, buf)
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != p.username || string(pass) != p.password {
+			conn.Write([]byte{socksAuthVersion, 0x01})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{socksAuthVersion, socksSucceeded})
+	} else {
+		conn.Write([]byte{socksVersion, socksAuthNone})
+	}
+
+	header := make([]byte, 4)
+	io.ReadFull(conn, header)
+	var host string
+	switch header[3] {
+	case socksDomain:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	case socksIPv4:
+		ip := make(net.IP, net.IPv4len)
+		io.ReadFull(conn, ip)
+		host = ip.String()
+	case socksIPv6:
+		ip := make(net.IP, net.IPv6len)
+		io.ReadFull(conn, ip)
+		host = ip.String()
+	}
+	io.ReadFull(conn, buf)
+	port := int(buf[0])<<8 | int(buf[1])
+	p.asked <- net.JoinHostPort(host, strconv.Itoa(port))
+
+	conn.Write([]byte{socksVersion, p.reply, 0x00, socksIPv4,
+		127, 0, 0, 1, 0, 0})
+	if p.reply != socksSucceeded {
+		conn.Close()
+		return
+	}
+	p.splice(conn)
+}
+
+func (p *testProxy) http(conn net.Conn) {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	p.asked <- req.Method + " " + req.Host
+
+	status := p.status
+	if len(p.username) > 0 {
+		auth := "Basic " + base64.StdEncoding.EncodeToString(
+			[]byte(p.username+":"+p.password))
+		if req.Header.Get("Proxy-Authorization") != auth {
+			status = http.StatusProxyAuthRequired
+		}
+	}
+
+	conn.Write([]byte("HTTP/1.1 " + strconv.Itoa(status) + " " +
+		http.StatusText(status) + "\r\n\r\n"))
+	if status != http.StatusOK {
+		conn.Close()
+		return
+	}
+	p.splice(conn)
+}
+
+// readHello reads the greeting from the target through a tunnel.
+func readHello(c *C, conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	c.Check(err, IsNil)
+	c.Check(line, Equals, "hello\r\n")
+}
+
+func (s *s) TestProxy_Socks5(c *C) {
+	target := testTarget(c)
+	defer target.Close()
+	proxy := createTestProxy(c, PROXY_SOCKS5, target.Addr().String())
+	defer proxy.ln.Close()
+
+	p := Proxy{Type: PROXY_SOCKS5, Address: proxy.ln.Addr().String()}
+	conn, err := p.Dial("irc.test.net:6667")
+	c.Assert(err, IsNil)
+	c.Check(<-proxy.asked, Equals, "irc.test.net:6667")
+	readHello(c, conn)
+
+	conn, err = p.Dial("10.0.0.1:6697")
+	c.Assert(err, IsNil)
+	c.Check(<-proxy.asked, Equals, "10.0.0.1:6697")
+	readHello(c, conn)
+
+	conn, err = p.Dial("[::1]:6667")
+	c.Assert(err, IsNil)
+	c.Check(<-proxy.asked, Equals, "[::1]:6667")
+	readHello(c, conn)
+}
+
+func (s *s) TestProxy_Socks5Auth(c *C) {
+	target := testTarget(c)
+	defer target.Close()
+	proxy := createTestProxy(c, PROXY_SOCKS5, target.Addr().String())
+	defer proxy.ln.Close()
+	proxy.username, proxy.password = "user", "pass"
+
+	p := Proxy{Type: PROXY_SOCKS5, Address: proxy.ln.Addr().String(),
+		Username: "user", Password: "pass"}
+	conn, err := p.Dial("irc.test.net:6667")
+	c.Assert(err, IsNil)
+	readHello(c, conn)
+
+	p.Password = "wrong"
+	_, err = p.Dial("irc.test.net:6667")
+	c.Check(err, Equals, errSocksLogin)
+
+	p.Username = ""
+	_, err = p.Dial("irc.test.net:6667")
+	c.Check(err, Equals, errSocksAuth)
+}
+
+func (s *s) TestProxy_Socks5Refused(c *C) {
+	proxy := createTestProxy(c, PROXY_SOCKS5, "")
+	defer proxy.ln.Close()
+	proxy.reply = 0x05
+
+	p := Proxy{Type: PROXY_SOCKS5, Address: proxy.ln.Addr().String()}
+	_, err := p.Dial("irc.test.net:6667")
+	c.Check(err, ErrorMatches, ".*connection refused.*")
+
+	_, err = p.Dial("irc.test.net")
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestProxy_Http(c *C) {
+	target := testTarget(c)
+	defer target.Close()
+	proxy := createTestProxy(c, PROXY_HTTP, target.Addr().String())
+	defer proxy.ln.Close()
+
+	p := Proxy{Type: PROXY_HTTP, Address: proxy.ln.Addr().String()}
+	conn, err := p.Dial("irc.test.net:6667")
+	c.Assert(err, IsNil)
+	c.Check(<-proxy.asked, Equals, "CONNECT irc.test.net:6667")
+	readHello(c, conn)
+
+	proxy.username, proxy.password = "user", "pass"
+	_, err = p.Dial("irc.test.net:6667")
+	c.Check(err, ErrorMatches, ".*407.*")
+	<-proxy.asked
+
+	p.Username, p.Password = "user", "pass"
+	conn, err = p.Dial("irc.test.net:6667")
+	c.Assert(err, IsNil)
+	readHello(c, conn)
+}
+
+func (s *s) TestProxy_HttpBuffered(c *C) {
+	// A proxy that sends data right behind it's response.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		http.ReadRequest(bufio.NewReader(conn))
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\nhello\r\n"))
+		conn.Close()
+	}()
+
+	p := Proxy{Type: PROXY_HTTP, Address: ln.Addr().String()}
+	conn, err := p.Dial("irc.test.net:6667")
+	c.Assert(err, IsNil)
+	readHello(c, conn)
+}
+
+func (s *s) TestProxy_BadType(c *C) {
+	p := Proxy{Type: "socks4", Address: "127.0.0.1:1080"}
+	_, err := p.Dial("irc.test.net:6667")
+	c.Check(err, ErrorMatches, "inet: Unknown proxy type.*")
+}
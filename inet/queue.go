@@ -1,5 +1,10 @@
 package inet
 
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/irc"
+)
+
 // queueNode is the node structure underneath the Queue type.
 type queueNode struct {
 	next *queueNode
@@ -46,3 +51,198 @@ func (q *Queue) Dequeue() []byte {
 
 	return *data
 }
+
+// Priority classes of outgoing messages, lower is more urgent.
+const (
+	// PRIORITY_CRITICAL is for messages the connection depends on, like
+	// registration and nick changes. They're always sent first.
+	PRIORITY_CRITICAL = iota
+	// PRIORITY_MODERATION is for channel moderation and services, like kicks,
+	// modes and identifying to NickServ.
+	PRIORITY_MODERATION
+	// PRIORITY_NORMAL is for everything else.
+	PRIORITY_NORMAL
+	// PRIORITY_BULK is for long replies and anything else that can wait, or
+	// be cancelled with CancelBulk.
+	PRIORITY_BULK
+	// nPriorities is the number of priority classes.
+	nPriorities
+)
+
+// prioritySchedule is the order the non-critical classes take turns in when
+// messages are waiting in more than one, it gives each class a share of the
+// writes so bulk messages can't be starved.
+var prioritySchedule = []int{
+	PRIORITY_MODERATION, PRIORITY_MODERATION, PRIORITY_NORMAL,
+	PRIORITY_MODERATION, PRIORITY_MODERATION, PRIORITY_NORMAL,
+	PRIORITY_BULK,
+}
+
+// Filter removes the byte slices the callback returns true for, and returns
+// how many were removed.
+func (q *Queue) Filter(remove func([]byte) bool) int {
+	removed := 0
+	var prev *queueNode
+	for node := q.front; node != nil; node = node.next {
+		if !remove(*node.data) {
+			prev = node
+			continue
+		}
+
+		if prev == nil {
+			q.front = node.next
+		} else {
+			prev.next = node.next
+		}
+		if node == q.back {
+			q.back = prev
+		}
+		q.length--
+		removed++
+	}
+	return removed
+}
+
+// PriorityQueue is a queue of byte slices for each priority class. Critical
+// messages are dequeued first, the other classes take turns. Within a class
+// the targets of the messages take turns.
+type PriorityQueue struct {
+	queues [nPriorities]TargetQueue
+	turn   int
+}
+
+// Enqueue adds the byte slice to the queue of a priority class, priorities
+// out of range are treated as PRIORITY_NORMAL.
+func (p *PriorityQueue) Enqueue(bytes []byte, priority int) {
+	if priority < 0 || priority >= nPriorities {
+		priority = PRIORITY_NORMAL
+	}
+	p.queues[priority].Enqueue(bytes)
+}
+
+// Dequeue dequeues the next byte slice to send.
+func (p *PriorityQueue) Dequeue() []byte {
+	if p.queues[PRIORITY_CRITICAL].Len() > 0 {
+		return p.queues[PRIORITY_CRITICAL].Dequeue()
+	}
+
+	for i := 0; i < len(prioritySchedule); i++ {
+		priority := prioritySchedule[p.turn]
+		p.turn = (p.turn + 1) % len(prioritySchedule)
+		if p.queues[priority].Len() > 0 {
+			return p.queues[priority].Dequeue()
+		}
+	}
+	return nil
+}
+
+// Peek returns the byte slice Dequeue would return without removing it.
+func (p *PriorityQueue) Peek() []byte {
+	if p.queues[PRIORITY_CRITICAL].Len() > 0 {
+		return p.queues[PRIORITY_CRITICAL].Peek()
+	}
+
+	for i := 0; i < len(prioritySchedule); i++ {
+		priority := prioritySchedule[(p.turn+i)%len(prioritySchedule)]
+		if p.queues[priority].Len() > 0 {
+			return p.queues[priority].Peek()
+		}
+	}
+	return nil
+}
+
+// Len returns the number of byte slices queued in every class.
+func (p *PriorityQueue) Len() int {
+	length := 0
+	for i := range p.queues {
+		length += p.queues[i].Len()
+	}
+	return length
+}
+
+// Shape caps the backlog of each target and sets if identical lines in a row
+// are coalesced, see TargetQueue. It only applies to the PRIORITY_NORMAL and
+// PRIORITY_BULK classes, the others are never dropped.
+func (p *PriorityQueue) Shape(backlog, overflow int, coalesce bool) {
+	for _, priority := range []int{PRIORITY_NORMAL, PRIORITY_BULK} {
+		p.queues[priority].Backlog = backlog
+		p.queues[priority].Overflow = overflow
+		p.queues[priority].Coalesce = coalesce
+	}
+}
+
+// Casemapping sets the casemap the targets of every class are compared with,
+// see TargetQueue.
+func (p *PriorityQueue) Casemapping(casemap irc.Casemap) {
+	for i := range p.queues {
+		p.queues[i].Casemapping(casemap)
+	}
+}
+
+// Filter removes the byte slices of a priority class the callback returns
+// true for, and returns how many were removed.
+func (p *PriorityQueue) Filter(priority int, remove func([]byte) bool) int {
+	if priority < 0 || priority >= nPriorities {
+		return 0
+	}
+	return p.queues[priority].Filter(remove)
+}
+
+var (
+	// criticalCommands are the commands sent with PRIORITY_CRITICAL.
+	criticalCommands = []string{
+		"PASS", "NICK", "USER", "CAP", "AUTHENTICATE", "PING", "PONG", "QUIT",
+	}
+	// moderationCommands are the commands sent with PRIORITY_MODERATION.
+	moderationCommands = []string{
+		"KICK", "MODE", "INVITE", "TOPIC", "REMOVE",
+	}
+	// services are the targets of privmsgs sent with PRIORITY_MODERATION.
+	services = []string{
+		"NICKSERV", "CHANSERV",
+	}
+)
+
+// Priority returns the priority class of an irc message based on it's command.
+// Messages to services are PRIORITY_MODERATION, and nothing is PRIORITY_BULK
+// unless it was written with it.
+func Priority(msg []byte) int {
+	command, target := splitCommand(msg)
+	for _, cmd := range criticalCommands {
+		if bytes.EqualFold(command, []byte(cmd)) {
+			return PRIORITY_CRITICAL
+		}
+	}
+	for _, cmd := range moderationCommands {
+		if bytes.EqualFold(command, []byte(cmd)) {
+			return PRIORITY_MODERATION
+		}
+	}
+	if bytes.EqualFold(command, []byte("PRIVMSG")) {
+		for _, service := range services {
+			if bytes.EqualFold(target, []byte(service)) {
+				return PRIORITY_MODERATION
+			}
+		}
+	}
+	return PRIORITY_NORMAL
+}
+
+// splitCommand returns the command of an irc message and the argument after
+// it, skipping any tags or prefix. There's no target if the argument after it
+// is the trailing one.
+func splitCommand(msg []byte) (command, target []byte) {
+	msg = bytes.TrimRight(msg, "\r\n")
+	fields := bytes.Fields(msg)
+	for len(fields) > 0 && (fields[0][0] == '@' || fields[0][0] == ':') {
+		fields = fields[1:]
+	}
+
+	if len(fields) > 0 {
+		command = fields[0]
+	}
+	if len(fields) > 1 && fields[1][0] != ':' {
+		target = fields[1]
+	}
+	return
+}
@@ -63,3 +63,104 @@ func (s *s) TestQueue_dequeue(c *C) {
 	c.Check(q.front, IsNil)
 	c.Check(q.back, IsNil)
 }
+
+func (s *s) TestQueue_Filter(c *C) {
+	q := Queue{}
+	for _, b := range []byte{1, 2, 3, 4, 5} {
+		q.Enqueue([]byte{b})
+	}
+
+	removed := q.Filter(func(b []byte) bool {
+		return b[0]%2 == 1
+	})
+	c.Check(removed, Equals, 3)
+	c.Check(q.length, Equals, 2)
+	c.Check(q.Dequeue(), DeepEquals, []byte{2})
+	c.Check(q.Dequeue(), DeepEquals, []byte{4})
+	c.Check(q.front, IsNil)
+	c.Check(q.back, IsNil)
+
+	q.Enqueue([]byte{1})
+	q.Enqueue([]byte{2})
+	c.Check(q.Filter(func(b []byte) bool { return b[0] == 2 }), Equals, 1)
+	c.Check(q.back, Equals, q.front)
+	q.Enqueue([]byte{3})
+	c.Check(q.Dequeue(), DeepEquals, []byte{1})
+	c.Check(q.Dequeue(), DeepEquals, []byte{3})
+}
+
+func (s *s) TestPriorityQueue(c *C) {
+	p := PriorityQueue{}
+	c.Check(p.Dequeue(), IsNil)
+	c.Check(p.Peek(), IsNil)
+
+	for i := 0; i < 10; i++ {
+		p.Enqueue([]byte{'b'}, PRIORITY_BULK)
+		p.Enqueue([]byte{'n'}, PRIORITY_NORMAL)
+		p.Enqueue([]byte{'m'}, PRIORITY_MODERATION)
+	}
+	p.Enqueue([]byte{'c'}, PRIORITY_CRITICAL)
+	p.Enqueue([]byte{'x'}, 50)
+	c.Check(p.Len(), Equals, 32)
+
+	c.Check(p.Peek(), DeepEquals, []byte{'c'})
+	c.Check(p.Dequeue(), DeepEquals, []byte{'c'})
+	c.Check(p.Peek(), DeepEquals, []byte{'m'})
+
+	var order []byte
+	for i := 0; i < len(prioritySchedule); i++ {
+		order = append(order, p.Dequeue()[0])
+	}
+	c.Check(string(order), Equals, "mmnmmnb")
+
+	for p.Len() > 0 {
+		order = append(order, p.Dequeue()[0])
+	}
+	c.Check(len(order), Equals, 31)
+	c.Check(bytes.Count(order, []byte{'m'}), Equals, 10)
+	c.Check(bytes.Count(order, []byte{'n'}), Equals, 10)
+	c.Check(bytes.Count(order, []byte{'x'}), Equals, 1)
+	c.Check(bytes.Count(order, []byte{'b'}), Equals, 10)
+}
+
+func (s *s) TestPriorityQueue_Filter(c *C) {
+	p := PriorityQueue{}
+	p.Enqueue([]byte("PRIVMSG #chan :1\r\n"), PRIORITY_BULK)
+	p.Enqueue([]byte("PRIVMSG #other :2\r\n"), PRIORITY_BULK)
+	p.Enqueue([]byte("PRIVMSG #chan :3\r\n"), PRIORITY_NORMAL)
+
+	c.Check(p.Filter(-1, nil), Equals, 0)
+	removed := p.Filter(PRIORITY_BULK, func(b []byte) bool {
+		return bytes.Contains(b, []byte("#chan"))
+	})
+	c.Check(removed, Equals, 1)
+	c.Check(p.Len(), Equals, 2)
+}
+
+func (s *s) TestPriority(c *C) {
+	c.Check(Priority([]byte("PONG :123\r\n")), Equals, PRIORITY_CRITICAL)
+	c.Check(Priority([]byte("nick newnick")), Equals, PRIORITY_CRITICAL)
+	c.Check(Priority([]byte("KICK #chan nick :bye")), Equals,
+		PRIORITY_MODERATION)
+	c.Check(Priority([]byte("@a=b :me!u@h MODE #chan +o nick")), Equals,
+		PRIORITY_MODERATION)
+	c.Check(Priority([]byte("PRIVMSG NickServ :IDENTIFY pass")), Equals,
+		PRIORITY_MODERATION)
+	c.Check(Priority([]byte("PRIVMSG #chan :hello")), Equals,
+		PRIORITY_NORMAL)
+	c.Check(Priority([]byte("")), Equals, PRIORITY_NORMAL)
+}
+
+func (s *s) TestSplitCommand(c *C) {
+	command, target := splitCommand([]byte("@t :p PRIVMSG #chan :hi\r\n"))
+	c.Check(string(command), Equals, "PRIVMSG")
+	c.Check(string(target), Equals, "#chan")
+
+	command, target = splitCommand([]byte("QUIT"))
+	c.Check(string(command), Equals, "QUIT")
+	c.Check(target, IsNil)
+
+	command, target = splitCommand([]byte("AWAY :gone\r\n"))
+	c.Check(string(command), Equals, "AWAY")
+	c.Check(target, IsNil)
+}
@@ -0,0 +1,232 @@
+package inet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aarondl/ultimateq/irc"
+)
+
+// Overflow policies, these decide what happens to a message written to a
+// target whose backlog is full.
+const (
+	// OVERFLOW_DROP_OLDEST drops the oldest message waiting for the target to
+	// make room for the new one.
+	OVERFLOW_DROP_OLDEST = iota
+	// OVERFLOW_DROP_NEWEST drops the new message.
+	OVERFLOW_DROP_NEWEST
+	// OVERFLOW_TRUNCATE drops the new message and every one after it until
+	// the backlog has been sent, it's followed by a line saying how many
+	// lines were left out.
+	OVERFLOW_TRUNCATE
+)
+
+// fmtTruncated is the line sent in place of the lines a truncated backlog
+// left out.
+const fmtTruncated = "%s %s :…and %d more lines\r\n"
+
+// targetBacklog is the messages waiting to be sent to a single target.
+type targetBacklog struct {
+	// target is the target as it was first written, to fold it again if the
+	// casemap changes.
+	target string
+	queue  Queue
+	// summary is the truncation line at the back of the queue, nil if the
+	// backlog hasn't been truncated.
+	summary *[]byte
+	dropped int
+}
+
+// TargetQueue is a queue of irc messages that takes turns between the targets
+// (channel or nick) of the messages, so one busy target can't hold up the
+// rest. Each target's backlog can be capped and identical lines in a row to a
+// target can be coalesced. Targets are the same if they're the same under the
+// casemap set with Casemapping, ascii if it's not set.
+type TargetQueue struct {
+	// Backlog is the most messages that may wait for a single target, 0 is
+	// unlimited.
+	Backlog int
+	// Overflow is one of the OVERFLOW_* policies, used when a target's
+	// backlog is full.
+	Overflow int
+	// Coalesce drops a message if it's the same as the last message waiting
+	// for it's target.
+	Coalesce bool
+
+	casemap irc.Casemap
+	targets map[string]*targetBacklog
+	order   []string
+	turn    int
+	length  int
+}
+
+// Casemapping sets the casemap targets are compared with. Backlogs that are
+// now for the same target are merged.
+func (t *TargetQueue) Casemapping(casemap irc.Casemap) {
+	if casemap == t.casemap {
+		return
+	}
+	t.casemap = casemap
+	if len(t.order) == 0 {
+		return
+	}
+
+	turn := casemap.Fold(t.targets[t.order[t.turn%len(t.order)]].target)
+	targets := make(map[string]*targetBacklog, len(t.targets))
+	order := make([]string, 0, len(t.order))
+	for _, key := range t.order {
+		backlog := t.targets[key]
+		key = casemap.Fold(backlog.target)
+		if merged, ok := targets[key]; ok {
+			merged.merge(backlog)
+			continue
+		}
+		targets[key] = backlog
+		order = append(order, key)
+	}
+
+	t.targets = targets
+	t.order = order
+	for i, key := range order {
+		if key == turn {
+			t.turn = i
+		}
+	}
+}
+
+// Enqueue adds the byte slice to the backlog of it's target.
+func (t *TargetQueue) Enqueue(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+
+	command, target := splitCommand(msg)
+	key := t.casemap.Fold(string(target))
+	if t.targets == nil {
+		t.targets = make(map[string]*targetBacklog)
+	}
+	backlog, ok := t.targets[key]
+	if !ok {
+		backlog = &targetBacklog{target: string(target)}
+		t.targets[key] = backlog
+		t.order = append(t.order, key)
+	}
+
+	q := &backlog.queue
+	if t.Coalesce && q.length > 0 && bytes.Equal(*q.back.data, msg) {
+		return
+	}
+
+	if backlog.summary != nil {
+		backlog.dropped++
+		*backlog.summary = truncated(command, target, backlog.dropped)
+		return
+	}
+
+	if t.Backlog > 0 && q.length >= t.Backlog {
+		switch {
+		case t.Overflow == OVERFLOW_DROP_OLDEST:
+			q.Dequeue()
+			t.length--
+		case t.Overflow == OVERFLOW_TRUNCATE && len(target) > 0:
+			backlog.dropped = 1
+			q.Enqueue(truncated(command, target, backlog.dropped))
+			backlog.summary = q.back.data
+			t.length++
+			return
+		default:
+			return
+		}
+	}
+
+	q.Enqueue(msg)
+	t.length++
+}
+
+// Dequeue dequeues the next byte slice to send, from the next target in turn.
+func (t *TargetQueue) Dequeue() []byte {
+	if t.length == 0 {
+		return nil
+	}
+
+	t.turn %= len(t.order)
+	msg := t.targets[t.order[t.turn]].queue.Dequeue()
+	t.length--
+	if !t.tidy(t.turn) {
+		t.turn++
+	}
+	return msg
+}
+
+// Peek returns the byte slice Dequeue would return without removing it.
+func (t *TargetQueue) Peek() []byte {
+	if t.length == 0 {
+		return nil
+	}
+	return *t.targets[t.order[t.turn%len(t.order)]].queue.front.data
+}
+
+// Len returns the number of byte slices waiting for every target.
+func (t *TargetQueue) Len() int {
+	return t.length
+}
+
+// Filter removes the byte slices the callback returns true for, and returns
+// how many were removed.
+func (t *TargetQueue) Filter(remove func([]byte) bool) int {
+	removed := 0
+	for i := 0; i < len(t.order); {
+		backlog := t.targets[t.order[i]]
+		n := backlog.queue.Filter(remove)
+		removed += n
+		t.length -= n
+		if backlog.summary != nil && (backlog.queue.length == 0 ||
+			backlog.queue.back.data != backlog.summary) {
+			backlog.summary = nil
+		}
+		if !t.tidy(i) {
+			i++
+		}
+	}
+	return removed
+}
+
+// tidy forgets the target at index i of the turn order if nothing is waiting
+// for it, and returns true if it was forgotten.
+func (t *TargetQueue) tidy(i int) bool {
+	key := t.order[i]
+	if t.targets[key].queue.length > 0 {
+		return false
+	}
+
+	delete(t.targets, key)
+	t.order = append(t.order[:i], t.order[i+1:]...)
+	if t.turn > i {
+		t.turn--
+	}
+	return true
+}
+
+// merge moves the messages waiting in other to the back of the backlog. A
+// truncation line that's no longer at the back is left as it is.
+func (b *targetBacklog) merge(other *targetBacklog) {
+	if other.queue.length == 0 {
+		return
+	}
+	if b.queue.length == 0 {
+		b.queue.front = other.queue.front
+	} else {
+		b.queue.back.next = other.queue.front
+	}
+	b.queue.back = other.queue.back
+	b.queue.length += other.queue.length
+	b.summary, b.dropped = other.summary, other.dropped
+}
+
+// truncated creates the line sent in place of the lines left out of a
+// truncated backlog, it's a notice if they were notices.
+func truncated(command, target []byte, dropped int) []byte {
+	if !bytes.EqualFold(command, []byte("NOTICE")) {
+		command = []byte("PRIVMSG")
+	}
+	return []byte(fmt.Sprintf(fmtTruncated, command, target, dropped))
+}
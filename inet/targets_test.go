@@ -0,0 +1,178 @@
+package inet
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "launchpad.net/gocheck"
+)
+
+func privmsg(target, text string) []byte {
+	return []byte("PRIVMSG " + target + " :" + text + "\r\n")
+}
+
+// drain dequeues everything in a TargetQueue.
+func drain(t *TargetQueue) []string {
+	var msgs []string
+	for t.Len() > 0 {
+		msgs = append(msgs, string(t.Dequeue()))
+	}
+	return msgs
+}
+
+func (s *s) TestTargetQueue(c *C) {
+	t := TargetQueue{}
+	c.Check(t.Dequeue(), IsNil)
+	c.Check(t.Peek(), IsNil)
+	t.Enqueue(nil)
+	c.Check(t.Len(), Equals, 0)
+
+	t.Enqueue(privmsg("#busy", "1"))
+	t.Enqueue(privmsg("#busy", "2"))
+	t.Enqueue(privmsg("#busy", "3"))
+	t.Enqueue(privmsg("#quiet", "a"))
+	t.Enqueue(privmsg("#BUSY", "4"))
+	t.Enqueue(privmsg("nick", "b"))
+	t.Enqueue([]byte("AWAY :gone\r\n"))
+	c.Check(t.Len(), Equals, 7)
+
+	c.Check(drain(&t), DeepEquals, []string{
+		string(privmsg("#busy", "1")),
+		string(privmsg("#quiet", "a")),
+		string(privmsg("nick", "b")),
+		"AWAY :gone\r\n",
+		string(privmsg("#busy", "2")),
+		string(privmsg("#busy", "3")),
+		string(privmsg("#BUSY", "4")),
+	})
+	c.Check(len(t.targets), Equals, 0)
+	c.Check(len(t.order), Equals, 0)
+
+	t.Enqueue(privmsg("#a", "1"))
+	t.Enqueue(privmsg("#a", "2"))
+	c.Check(string(t.Peek()), Equals, string(privmsg("#a", "1")))
+	c.Check(string(t.Dequeue()), Equals, string(privmsg("#a", "1")))
+	t.Enqueue(privmsg("#b", "1"))
+	c.Check(string(t.Peek()), Equals, string(privmsg("#b", "1")))
+	c.Check(string(t.Dequeue()), Equals, string(privmsg("#b", "1")))
+	c.Check(string(t.Dequeue()), Equals, string(privmsg("#a", "2")))
+	c.Check(t.Dequeue(), IsNil)
+}
+
+func (s *s) TestTargetQueue_Coalesce(c *C) {
+	t := TargetQueue{}
+	t.Enqueue(privmsg("#chan", "same"))
+	t.Enqueue(privmsg("#chan", "same"))
+	c.Check(t.Len(), Equals, 2)
+	drain(&t)
+
+	t.Coalesce = true
+	t.Enqueue(privmsg("#chan", "same"))
+	t.Enqueue(privmsg("#chan", "same"))
+	t.Enqueue(privmsg("#other", "same"))
+	t.Enqueue(privmsg("#chan", "different"))
+	t.Enqueue(privmsg("#chan", "same"))
+	c.Check(t.Len(), Equals, 4)
+}
+
+func (s *s) TestTargetQueue_DropOldest(c *C) {
+	t := TargetQueue{Backlog: 2, Overflow: OVERFLOW_DROP_OLDEST}
+	for _, text := range []string{"1", "2", "3", "4"} {
+		t.Enqueue(privmsg("#chan", text))
+	}
+	t.Enqueue(privmsg("#other", "1"))
+	c.Check(drain(&t), DeepEquals, []string{
+		string(privmsg("#chan", "3")),
+		string(privmsg("#other", "1")),
+		string(privmsg("#chan", "4")),
+	})
+}
+
+func (s *s) TestTargetQueue_DropNewest(c *C) {
+	t := TargetQueue{Backlog: 2, Overflow: OVERFLOW_DROP_NEWEST}
+	for _, text := range []string{"1", "2", "3", "4"} {
+		t.Enqueue(privmsg("#chan", text))
+	}
+	c.Check(drain(&t), DeepEquals, []string{
+		string(privmsg("#chan", "1")),
+		string(privmsg("#chan", "2")),
+	})
+}
+
+func (s *s) TestTargetQueue_Truncate(c *C) {
+	t := TargetQueue{Backlog: 2, Overflow: OVERFLOW_TRUNCATE}
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		t.Enqueue(privmsg("#chan", text))
+	}
+	t.Enqueue([]byte("NOTICE nick :1\r\n"))
+	t.Enqueue([]byte("NOTICE nick :2\r\n"))
+	t.Enqueue([]byte("NOTICE nick :3\r\n"))
+	c.Check(t.Len(), Equals, 6)
+
+	c.Check(string(t.Dequeue()), Equals, string(privmsg("#chan", "1")))
+	c.Check(string(t.Dequeue()), Equals, "NOTICE nick :1\r\n")
+	t.Enqueue(privmsg("#chan", "6"))
+	c.Check(drain(&t), DeepEquals, []string{
+		string(privmsg("#chan", "2")),
+		"NOTICE nick :2\r\n",
+		"PRIVMSG #chan :…and 4 more lines\r\n",
+		"NOTICE nick :…and 1 more lines\r\n",
+	})
+
+	t.Enqueue(privmsg("#chan", "7"))
+	c.Check(drain(&t), DeepEquals, []string{string(privmsg("#chan", "7"))})
+
+	t.Enqueue([]byte("AWAY :1\r\n"))
+	t.Enqueue([]byte("AWAY :2\r\n"))
+	t.Enqueue([]byte("AWAY :3\r\n"))
+	c.Check(t.Len(), Equals, 2)
+}
+
+func (s *s) TestTargetQueue_Filter(c *C) {
+	t := TargetQueue{Backlog: 1, Overflow: OVERFLOW_TRUNCATE}
+	t.Enqueue(privmsg("#chan", "1"))
+	t.Enqueue(privmsg("#chan", "2"))
+	t.Enqueue(privmsg("#other", "1"))
+	c.Check(t.Len(), Equals, 3)
+
+	c.Check(t.Filter(func(msg []byte) bool {
+		return string(msg) == "PRIVMSG #chan :…and 1 more lines\r\n"
+	}), Equals, 1)
+	c.Check(t.Len(), Equals, 2)
+	c.Check(t.targets["#chan"].summary, IsNil)
+
+	c.Check(t.Filter(func(msg []byte) bool { return true }), Equals, 2)
+	c.Check(t.Len(), Equals, 0)
+	c.Check(len(t.order), Equals, 0)
+	c.Check(t.Dequeue(), IsNil)
+}
+
+func (s *s) TestTargetQueue_Casemapping(c *C) {
+	t := TargetQueue{}
+	t.Enqueue(privmsg("#[a]", "1"))
+	t.Enqueue(privmsg("#b", "1"))
+	t.Enqueue(privmsg("#{A}", "3"))
+	t.Enqueue(privmsg("#c", "1"))
+	t.Enqueue(privmsg("#[a]", "2"))
+	c.Check(len(t.order), Equals, 4)
+	c.Check(string(t.Dequeue()), Equals, string(privmsg("#[a]", "1")))
+
+	t.Casemapping(irc.CASEMAP_RFC1459)
+	c.Check(t.order, DeepEquals, []string{"#{a}", "#b", "#c"})
+	c.Check(t.Len(), Equals, 4)
+	t.Enqueue(privmsg("#[A]", "4"))
+	c.Check(len(t.order), Equals, 3)
+
+	c.Check(drain(&t), DeepEquals, []string{
+		string(privmsg("#b", "1")),
+		string(privmsg("#c", "1")),
+		string(privmsg("#[a]", "2")),
+		string(privmsg("#{A}", "3")),
+		string(privmsg("#[A]", "4")),
+	})
+
+	t.Enqueue(privmsg("#{a}", "1"))
+	t.Enqueue(privmsg("#[a]", "2"))
+	c.Check(len(t.order), Equals, 1)
+	t.Casemapping(irc.CASEMAP_ASCII)
+	t.Enqueue(privmsg("#[a]", "3"))
+	c.Check(len(t.order), Equals, 2)
+}
@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"strings"
+)
+
+const (
+	// CASEMAP_ASCII folds only the letters A-Z.
+	CASEMAP_ASCII Casemap = iota
+	// CASEMAP_RFC1459 folds A-Z and treats []\^ as the uppercase of {}|~.
+	CASEMAP_RFC1459
+	// CASEMAP_STRICT_RFC1459 folds A-Z and treats []\ as the uppercase of {}|.
+	CASEMAP_STRICT_RFC1459
+	// CASEMAP_RFC7613 folds unicode letters. Width and composition are not
+	// normalized, so it's only an approximation of the PRECIS profile.
+	CASEMAP_RFC7613
+)
+
+// Casemap is the way a server decides that two nicks or channels are the same.
+// The zero value is CASEMAP_ASCII.
+type Casemap int
+
+// casemapNames are the CASEMAPPING values in RPL_ISUPPORT.
+var casemapNames = map[string]Casemap{
+	"ascii":          CASEMAP_ASCII,
+	"rfc1459":        CASEMAP_RFC1459,
+	"strict-rfc1459": CASEMAP_STRICT_RFC1459,
+	"rfc7613":        CASEMAP_RFC7613,
+}
+
+// CreateCasemap looks up a casemap by it's CASEMAPPING name. Unknown names
+// are treated as ascii.
+func CreateCasemap(name string) Casemap {
+	return casemapNames[strings.ToLower(name)]
+}
+
+// Fold returns the string in the form used to compare it, this should be used
+// when keying maps on nicks or channels.
+func (c Casemap) Fold(str string) string {
+	if c == CASEMAP_RFC7613 {
+		return strings.ToLower(str)
+	}
+
+	i := 0
+	for ; i < len(str); i++ {
+		if c.foldByte(str[i]) != str[i] {
+			break
+		}
+	}
+	if i == len(str) {
+		return str
+	}
+
+	folded := []byte(str)
+	for ; i < len(folded); i++ {
+		folded[i] = c.foldByte(folded[i])
+	}
+	return string(folded)
+}
+
+// Equals checks if two strings are the same under the casemap.
+func (c Casemap) Equals(a, b string) bool {
+	return c.Fold(a) == c.Fold(b)
+}
+
+// String returns the CASEMAPPING name of the casemap.
+func (c Casemap) String() string {
+	for name, casemap := range casemapNames {
+		if casemap == c {
+			return name
+		}
+	}
+	return CAPS_DEFAULT_CASEMAPPING
+}
+
+// foldByte folds a single byte.
+func (c Casemap) foldByte(b byte) byte {
+	switch {
+	case b >= 'A' && b <= 'Z':
+		return b + 'a' - 'A'
+	case c == CASEMAP_ASCII:
+		return b
+	case b == '[', b == ']', b == '\\', b == '^' && c == CASEMAP_RFC1459:
+		return b + '{' - '['
+	}
+	return b
+}
@@ -0,0 +1,32 @@
+package irc
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestCasemap_Fold(c *C) {
+	c.Check(CASEMAP_ASCII.Fold("Nick[]\\~^"), Equals, "nick[]\\~^")
+	c.Check(CASEMAP_RFC1459.Fold("Nick[]\\~^"), Equals, "nick{}|~~")
+	c.Check(CASEMAP_STRICT_RFC1459.Fold("Nick[]\\~^"), Equals, "nick{}|~^")
+	c.Check(CASEMAP_RFC7613.Fold("NICK[ÄÖ]"), Equals, "nick[äö]")
+	c.Check(CASEMAP_ASCII.Fold("ÄÖ"), Equals, "ÄÖ")
+
+	c.Check(CASEMAP_RFC1459.Equals("Foo[away]", "foo{AWAY}"), Equals, true)
+	c.Check(CASEMAP_ASCII.Equals("Foo[away]", "foo{AWAY}"), Equals, false)
+	c.Check(CASEMAP_STRICT_RFC1459.Equals("a^", "A~"), Equals, false)
+}
+
+func (s *s) TestCasemap_Create(c *C) {
+	c.Check(CreateCasemap("ascii"), Equals, CASEMAP_ASCII)
+	c.Check(CreateCasemap("RFC1459"), Equals, CASEMAP_RFC1459)
+	c.Check(CreateCasemap("strict-rfc1459"), Equals, CASEMAP_STRICT_RFC1459)
+	c.Check(CreateCasemap("rfc7613"), Equals, CASEMAP_RFC7613)
+	c.Check(CreateCasemap("unknown"), Equals, CASEMAP_ASCII)
+
+	c.Check(CASEMAP_STRICT_RFC1459.String(), Equals, "strict-rfc1459")
+
+	p := CreateProtoCaps()
+	c.Check(p.Casemap(), Equals, CASEMAP_ASCII)
+	p.ParseISupport(&IrcMessage{Args: []string{"nick", "CASEMAPPING=rfc1459"}})
+	c.Check(p.Casemap(), Equals, CASEMAP_RFC1459)
+}
@@ -6,6 +6,7 @@ and constants.
 package irc
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
@@ -29,17 +30,62 @@ const (
 // IRC Messages, these messages are 1-1 constant to string lookups for ease of
 // use when registering handlers etc.
 const (
-	JOIN    = "JOIN"
-	KICK    = "KICK"
-	MODE    = "MODE"
-	NICK    = "NICK"
-	NOTICE  = "NOTICE"
-	PART    = "PART"
-	PING    = "PING"
-	PONG    = "PONG"
-	PRIVMSG = "PRIVMSG"
-	QUIT    = "QUIT"
-	TOPIC   = "TOPIC"
+	ACCOUNT      = "ACCOUNT"
+	AUTHENTICATE = "AUTHENTICATE"
+	AWAY         = "AWAY"
+	CAP          = "CAP"
+	CHGHOST      = "CHGHOST"
+	INVITE       = "INVITE"
+	JOIN         = "JOIN"
+	KICK         = "KICK"
+	MODE         = "MODE"
+	NICK         = "NICK"
+	NOTICE       = "NOTICE"
+	PART         = "PART"
+	PING         = "PING"
+	PONG         = "PONG"
+	PRIVMSG      = "PRIVMSG"
+	QUIT         = "QUIT"
+	TOPIC        = "TOPIC"
+	WHOIS        = "WHOIS"
+	WHOWAS       = "WHOWAS"
+)
+
+// IRCv3 CAP subcommands, these are the second argument of a CAP message.
+const (
+	CAP_LS   = "LS"
+	CAP_LIST = "LIST"
+	CAP_REQ  = "REQ"
+	CAP_ACK  = "ACK"
+	CAP_NAK  = "NAK"
+	CAP_NEW  = "NEW"
+	CAP_DEL  = "DEL"
+	CAP_END  = "END"
+
+	// CAP_VERSION is the version of capability negotiation requested.
+	CAP_VERSION = "302"
+	// CAP_NOTIFY is the capability that enables CAP NEW and CAP DEL.
+	CAP_NOTIFY = "cap-notify"
+	// CAP_SASL is the capability that enables AUTHENTICATE.
+	CAP_SASL = "sasl"
+	// CAP_ACCOUNT_NOTIFY is the capability that enables ACCOUNT.
+	CAP_ACCOUNT_NOTIFY = "account-notify"
+	// CAP_AWAY_NOTIFY is the capability that enables AWAY for other users.
+	CAP_AWAY_NOTIFY = "away-notify"
+	// CAP_EXTENDED_JOIN is the capability that adds the account and realname
+	// to JOIN.
+	CAP_EXTENDED_JOIN = "extended-join"
+	// CAP_CHGHOST is the capability that enables CHGHOST.
+	CAP_CHGHOST = "chghost"
+)
+
+// IRCv3 SASL mechanisms and the AUTHENTICATE argument used for empty and
+// aborted responses.
+const (
+	SASL_PLAIN    = "PLAIN"
+	SASL_EXTERNAL = "EXTERNAL"
+	SASL_EMPTY    = "+"
+	SASL_ABORT    = "*"
 )
 
 // IRC Reply and Error Messages. These are sent in reply to a previous message.
@@ -185,6 +231,27 @@ const (
 	ERR_USERSDONTMATCH    = "502"
 )
 
+// Common Reply Messages that are not part of the RFC but are sent by most
+// servers.
+const (
+	RPL_WHOISACCOUNT = "330"
+	RPL_WHOISSECURE  = "671"
+)
+
+// IRCv3 SASL Reply and Error Messages. These are sent during SASL
+// authentication.
+const (
+	RPL_LOGGEDIN    = "900"
+	RPL_LOGGEDOUT   = "901"
+	ERR_NICKLOCKED  = "902"
+	RPL_SASLSUCCESS = "903"
+	ERR_SASLFAIL    = "904"
+	ERR_SASLTOOLONG = "905"
+	ERR_SASLABORTED = "906"
+	ERR_SASLALREADY = "907"
+	RPL_SASLMECHS   = "908"
+)
+
 // Pseudo Messages, these messages are not real messages defined by the irc
 // protocol but the bot provides them to allow for additional messages to be
 // handled such as connect or disconnects which the irc protocol has no protocol
@@ -193,6 +260,20 @@ const (
 	RAW        = "RAW"
 	CONNECT    = "CONNECT"
 	DISCONNECT = "DISCONNECT"
+	CTCP       = "CTCP"
+	CTCP_REPLY = "CTCP_REPLY"
+	ACTION     = "ACTION"
+)
+
+// Pseudo Messages sent while the bot reconnects to a server. The arguments of
+// RECONNECT are the host:port about to be tried, the attempt number, and how
+// long the bot waited before trying. RECONNECT_FAILED adds the error the
+// attempt failed with. RECONNECT_ABORTED is sent with the number of attempts
+// made when the bot gives up on the server.
+const (
+	RECONNECT         = "RECONNECT"
+	RECONNECT_FAILED  = "RECONNECT_FAILED"
+	RECONNECT_ABORTED = "RECONNECT_ABORTED"
 )
 
 // Endpoint represents the source of an event, and should allow replies on a
@@ -232,6 +313,18 @@ type Endpoint interface {
 	Quit(string) error
 }
 
+// MessageSender is implemented by endpoints that can send a whole irc message,
+// including it's tags, like those built on Helper. It's kept out of Endpoint so
+// endpoints outside this package don't have to implement it, check for it with
+// a type assertion:
+//
+//	if sender, ok := endpoint.(irc.MessageSender); ok {
+//		sender.SendMessage(msg)
+//	}
+type MessageSender interface {
+	SendMessage(*IrcMessage) error
+}
+
 // IrcMessage contains all the information broken out of an irc message.
 type IrcMessage struct {
 	// Name of the message. Uppercase constant name or numeric.
@@ -240,6 +333,37 @@ type IrcMessage struct {
 	Sender string
 	// The args split by space delimiting.
 	Args []string
+	// The IRCv3 message tags, values are unescaped. Tags without a value
+	// have an empty value. Nil if the message had no tags.
+	Tags map[string]string
+}
+
+// String serializes the message into irc protocol, without the \r\n. The
+// last argument is sent as a trailing argument when it has to be.
+func (m *IrcMessage) String() string {
+	var buf bytes.Buffer
+	if len(m.Tags) > 0 {
+		buf.WriteByte('@')
+		writeTags(&buf, m.Tags)
+		buf.WriteByte(' ')
+	}
+	if len(m.Sender) > 0 {
+		buf.WriteByte(':')
+		buf.WriteString(m.Sender)
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(m.Name)
+
+	for i, arg := range m.Args {
+		buf.WriteByte(' ')
+		if i == len(m.Args)-1 && (len(arg) == 0 || arg[0] == ':' ||
+			strings.IndexByte(arg, ' ') >= 0) {
+
+			buf.WriteByte(':')
+		}
+		buf.WriteString(arg)
+	}
+	return buf.String()
 }
 
 // Split splits string arguments. A convenience method to avoid having to call
@@ -248,6 +372,15 @@ func (m *IrcMessage) Split(index int) []string {
 	return strings.Split(m.Args[index], ",")
 }
 
+// arg returns the argument at index, or an empty string if the message doesn't
+// have that many arguments.
+func (m *IrcMessage) arg(index int) string {
+	if index < 0 || index >= len(m.Args) {
+		return ""
+	}
+	return m.Args[index]
+}
+
 // Message type provides a view around an IrcMessage to access it's parts in a
 // more convenient way.
 type Message struct {
@@ -257,12 +390,12 @@ type Message struct {
 
 // Target retrieves the channel or user this message was sent to.
 func (p *Message) Target() string {
-	return p.Args[0]
+	return p.arg(0)
 }
 
 // Message retrieves the message sent to the user or channel.
 func (p *Message) Message() string {
-	return p.Args[1]
+	return p.arg(1)
 }
 
 // Helper fullfills the Endpoint's many interface requirements.
@@ -359,6 +492,12 @@ func (h *Helper) Quit(msg string) error {
 	return err
 }
 
+// SendMessage serializes an irc message, including it's tags, and sends it.
+func (h *Helper) SendMessage(msg *IrcMessage) error {
+	_, err := io.WriteString(h, msg.String())
+	return err
+}
+
 // splitSend breaks a message down into irc-digestable chunks based on
 // IRC_MAX_LENGTH, and appends the header to each message.
 func (h *Helper) splitSend(header, msg []byte) error {
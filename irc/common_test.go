@@ -48,6 +48,29 @@ func (s *s) TestMsgTypes_Notice(c *C) {
 	c.Check(notice.Message(), Equals, args[1])
 }
 
+func (s *s) TestIrcMessage_String(c *C) {
+	msg := &IrcMessage{Name: PING, Args: []string{"123"}}
+	c.Check(msg.String(), Equals, "PING 123")
+
+	msg = &IrcMessage{
+		Name: PRIVMSG,
+		Args: []string{"#chan", "hello there"},
+	}
+	c.Check(msg.String(), Equals, "PRIVMSG #chan :hello there")
+	msg.Sender = "nick!user@host"
+	c.Check(msg.String(), Equals, ":nick!user@host PRIVMSG #chan :hello there")
+
+	msg = &IrcMessage{Name: TOPIC, Args: []string{"#chan", ""}}
+	c.Check(msg.String(), Equals, "TOPIC #chan :")
+	msg.Args[1] = ":)"
+	c.Check(msg.String(), Equals, "TOPIC #chan ::)")
+
+	msg = &IrcMessage{Name: PRIVMSG, Args: []string{"#chan", "hi"}}
+	msg.SetTag("+draft/reply", "a b")
+	msg.SetTag("flag", "")
+	c.Check(msg.String(), Equals, `@+draft/reply=a\sb;flag PRIVMSG #chan hi`)
+}
+
 type fakeHelper struct {
 	*Helper
 }
@@ -189,6 +212,19 @@ func (s *s) TestHelper_Quit(c *C) {
 	c.Check(string(buf.Bytes()), Equals, fmt.Sprintf("%v :%v", QUIT, msg))
 }
 
+func (s *s) TestHelper_SendMessage(c *C) {
+	buf := bytes.Buffer{}
+	h := &Helper{&buf}
+	msg := &IrcMessage{Name: PRIVMSG, Args: []string{"#chan", "hi there"},
+		Tags: map[string]string{"+typing": "active"}}
+	h.SendMessage(msg)
+	c.Check(string(buf.Bytes()), Equals, "@+typing=active PRIVMSG #chan :hi there")
+
+	var ep interface{} = h
+	_, ok := ep.(MessageSender)
+	c.Check(ok, Equals, true)
+}
+
 func (s *s) TestHelper_splitSend(c *C) {
 	buf := bytes.Buffer{}
 	h := &Helper{&buf}
@@ -65,6 +65,12 @@ func (w WildMask) Match(m Mask) bool {
 	return true
 }
 
+// MatchFold matches the mask after folding both with the casemap, this is
+// how servers match bans.
+func (w WildMask) MatchFold(m Mask, casemap Casemap) bool {
+	return WildMask(casemap.Fold(string(w))).Match(Mask(casemap.Fold(string(m))))
+}
+
 // GetNick returns the nick of this mask.
 func (m Mask) GetNick() string {
 	nick := string(m)
@@ -25,6 +25,18 @@ func (s *s) TestMask(c *C) {
 	c.Check(mask.GetFullhost(), Equals, string(mask))
 }
 
+func (s *s) TestWildMask_MatchFold(c *C) {
+	var wild WildMask = "Foo[*]!*@*.Host.com"
+
+	c.Check(wild.Match("foo{away}!user@my.host.com"), Equals, false)
+	c.Check(wild.MatchFold("foo{away}!user@my.host.com", CASEMAP_ASCII),
+		Equals, false)
+	c.Check(wild.MatchFold("FOO[away]!user@my.host.com", CASEMAP_ASCII),
+		Equals, true)
+	c.Check(wild.MatchFold("foo{away}!user@my.host.com", CASEMAP_RFC1459),
+		Equals, true)
+}
+
 func (s *s) TestMask_SplitHost(c *C) {
 	var nick, user, host string
 
@@ -0,0 +1,300 @@
+package irc
+
+import (
+	"strings"
+)
+
+const (
+	// ctcpDelim starts and ends every ctcp message.
+	ctcpDelim = '\x01'
+)
+
+// IsCTCP checks if the text of a privmsg or notice is a ctcp message.
+func IsCTCP(msg string) bool {
+	return len(msg) >= 2 && msg[0] == ctcpDelim
+}
+
+// CTCPUnpack splits a ctcp message into it's tag and data. The closing
+// delimiter is optional since some clients leave it off.
+func CTCPUnpack(msg string) (tag, data string) {
+	if !IsCTCP(msg) {
+		return "", ""
+	}
+	msg = msg[1:]
+	if msg[len(msg)-1] == ctcpDelim {
+		msg = msg[:len(msg)-1]
+	}
+
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		return strings.ToUpper(msg[:i]), msg[i+1:]
+	}
+	return strings.ToUpper(msg), ""
+}
+
+// CTCPPack wraps a tag and it's data into a ctcp message that can be sent as
+// the text of a privmsg, or of a notice when it's a reply.
+func CTCPPack(tag, data string) string {
+	if len(data) == 0 {
+		return string(ctcpDelim) + tag + string(ctcpDelim)
+	}
+	return string(ctcpDelim) + tag + " " + data + string(ctcpDelim)
+}
+
+// IsNumeric checks if an event name is a three digit numeric.
+func IsNumeric(name string) bool {
+	if len(name) != 3 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < '0' || name[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// JoinMessage is a view around a JOIN message. With extended-join the account
+// and realname of the user are also given.
+type JoinMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that joined.
+func (m *JoinMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Channel retrieves the channel that was joined.
+func (m *JoinMessage) Channel() string {
+	return m.arg(0)
+}
+
+// Account retrieves the account of the user that joined, empty if it wasn't
+// given or the user is not logged in.
+func (m *JoinMessage) Account() string {
+	if account := m.arg(1); account != "*" {
+		return account
+	}
+	return ""
+}
+
+// Realname retrieves the realname of the user that joined, empty if it wasn't
+// given.
+func (m *JoinMessage) Realname() string {
+	return m.arg(2)
+}
+
+// PartMessage is a view around a PART message.
+type PartMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that left.
+func (m *PartMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Channel retrieves the channel that was left.
+func (m *PartMessage) Channel() string {
+	return m.arg(0)
+}
+
+// Reason retrieves the part message, empty if none was given.
+func (m *PartMessage) Reason() string {
+	return m.arg(1)
+}
+
+// QuitMessage is a view around a QUIT message.
+type QuitMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that quit.
+func (m *QuitMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Reason retrieves the quit message, empty if none was given.
+func (m *QuitMessage) Reason() string {
+	return m.arg(0)
+}
+
+// KickMessage is a view around a KICK message.
+type KickMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that did the kicking.
+func (m *KickMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Channel retrieves the channel the user was kicked from.
+func (m *KickMessage) Channel() string {
+	return m.arg(0)
+}
+
+// Kicked retrieves the nick of the user that was kicked.
+func (m *KickMessage) Kicked() string {
+	return m.arg(1)
+}
+
+// Reason retrieves the kick message, empty if none was given.
+func (m *KickMessage) Reason() string {
+	return m.arg(2)
+}
+
+// NickMessage is a view around a NICK message.
+type NickMessage struct {
+	*IrcMessage
+}
+
+// OldNick retrieves the nick the user had.
+func (m *NickMessage) OldNick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// NewNick retrieves the nick the user changed to.
+func (m *NickMessage) NewNick() string {
+	return m.arg(0)
+}
+
+// ModeMessage is a view around a MODE message.
+type ModeMessage struct {
+	*IrcMessage
+}
+
+// Target retrieves the channel or user the modes were set on.
+func (m *ModeMessage) Target() string {
+	return m.arg(0)
+}
+
+// Modes retrieves the modestring along with it's arguments, in the form it
+// can be applied to a mode set.
+func (m *ModeMessage) Modes() string {
+	if len(m.Args) < 2 {
+		return ""
+	}
+	return strings.Join(m.Args[1:], " ")
+}
+
+// TopicMessage is a view around a TOPIC message.
+type TopicMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that changed the topic.
+func (m *TopicMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Channel retrieves the channel the topic was changed in.
+func (m *TopicMessage) Channel() string {
+	return m.arg(0)
+}
+
+// Topic retrieves the new topic, empty if it was cleared.
+func (m *TopicMessage) Topic() string {
+	return m.arg(1)
+}
+
+// InviteMessage is a view around an INVITE message.
+type InviteMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that sent the invite.
+func (m *InviteMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Target retrieves the nick of the user that was invited.
+func (m *InviteMessage) Target() string {
+	return m.arg(0)
+}
+
+// Channel retrieves the channel the user was invited to.
+func (m *InviteMessage) Channel() string {
+	return m.arg(1)
+}
+
+// CTCPMessage is a view around a privmsg that holds a ctcp request, or a
+// notice that holds a ctcp reply.
+type CTCPMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that sent the request or reply.
+func (m *CTCPMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Target retrieves the channel or user the request or reply was sent to.
+func (m *CTCPMessage) Target() string {
+	return m.arg(0)
+}
+
+// Tag retrieves the upper cased ctcp command, e.g. VERSION.
+func (m *CTCPMessage) Tag() string {
+	tag, _ := CTCPUnpack(m.arg(1))
+	return tag
+}
+
+// Data retrieves everything after the ctcp command.
+func (m *CTCPMessage) Data() string {
+	_, data := CTCPUnpack(m.arg(1))
+	return data
+}
+
+// ActionMessage is a view around a privmsg that holds a ctcp ACTION.
+type ActionMessage struct {
+	*IrcMessage
+}
+
+// Nick retrieves the nick of the user that did the action.
+func (m *ActionMessage) Nick() string {
+	return Mask(m.Sender).GetNick()
+}
+
+// Target retrieves the channel or user the action was sent to.
+func (m *ActionMessage) Target() string {
+	return m.arg(0)
+}
+
+// Action retrieves the text of the action.
+func (m *ActionMessage) Action() string {
+	_, data := CTCPUnpack(m.arg(1))
+	return data
+}
+
+// NumericMessage is a view around a numeric reply from the server.
+type NumericMessage struct {
+	*IrcMessage
+}
+
+// Code retrieves the numeric, e.g. 001.
+func (m *NumericMessage) Code() string {
+	return m.Name
+}
+
+// Target retrieves the nick the reply was sent to.
+func (m *NumericMessage) Target() string {
+	return m.arg(0)
+}
+
+// Params retrieves the arguments of the reply after the target.
+func (m *NumericMessage) Params() []string {
+	if len(m.Args) < 2 {
+		return nil
+	}
+	return m.Args[1:]
+}
+
+// Text retrieves the last argument of the reply, which is usually the human
+// readable part. Empty if there's nothing after the target.
+func (m *NumericMessage) Text() string {
+	if len(m.Args) < 2 {
+		return ""
+	}
+	return m.Args[len(m.Args)-1]
+}
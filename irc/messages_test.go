@@ -0,0 +1,137 @@
+package irc
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+var viewSender = "nick!user@host"
+
+func (s *s) TestCTCPUnpack(c *C) {
+	c.Check(IsCTCP("\x01VERSION\x01"), Equals, true)
+	c.Check(IsCTCP("\x01"), Equals, false)
+	c.Check(IsCTCP("VERSION"), Equals, false)
+
+	tag, data := CTCPUnpack("\x01version\x01")
+	c.Check(tag, Equals, "VERSION")
+	c.Check(data, Equals, "")
+	tag, data = CTCPUnpack("\x01PING 12345\x01")
+	c.Check(tag, Equals, "PING")
+	c.Check(data, Equals, "12345")
+	tag, data = CTCPUnpack("\x01ACTION waves hello")
+	c.Check(tag, Equals, "ACTION")
+	c.Check(data, Equals, "waves hello")
+	tag, data = CTCPUnpack("not ctcp")
+	c.Check(tag, Equals, "")
+	c.Check(data, Equals, "")
+}
+
+func (s *s) TestCTCPPack(c *C) {
+	c.Check(CTCPPack("VERSION", ""), Equals, "\x01VERSION\x01")
+	c.Check(CTCPPack("PING", "123"), Equals, "\x01PING 123\x01")
+
+	tag, data := CTCPUnpack(CTCPPack("TIME", "now and then"))
+	c.Check(tag, Equals, "TIME")
+	c.Check(data, Equals, "now and then")
+}
+
+func (s *s) TestIsNumeric(c *C) {
+	c.Check(IsNumeric(RPL_WELCOME), Equals, true)
+	c.Check(IsNumeric("01"), Equals, false)
+	c.Check(IsNumeric("0a1"), Equals, false)
+	c.Check(IsNumeric(PRIVMSG), Equals, false)
+}
+
+func (s *s) TestMessageViews(c *C) {
+	join := &JoinMessage{&IrcMessage{Name: JOIN, Sender: viewSender,
+		Args: []string{"#chan", "account", "real name"}}}
+	c.Check(join.Nick(), Equals, "nick")
+	c.Check(join.Channel(), Equals, "#chan")
+	c.Check(join.Account(), Equals, "account")
+	c.Check(join.Realname(), Equals, "real name")
+	join.Args[1] = "*"
+	c.Check(join.Account(), Equals, "")
+
+	part := &PartMessage{&IrcMessage{Name: PART, Sender: viewSender,
+		Args: []string{"#chan", "bye"}}}
+	c.Check(part.Nick(), Equals, "nick")
+	c.Check(part.Channel(), Equals, "#chan")
+	c.Check(part.Reason(), Equals, "bye")
+
+	quit := &QuitMessage{&IrcMessage{Name: QUIT, Sender: viewSender,
+		Args: []string{"gone"}}}
+	c.Check(quit.Nick(), Equals, "nick")
+	c.Check(quit.Reason(), Equals, "gone")
+
+	kick := &KickMessage{&IrcMessage{Name: KICK, Sender: viewSender,
+		Args: []string{"#chan", "other", "out"}}}
+	c.Check(kick.Nick(), Equals, "nick")
+	c.Check(kick.Channel(), Equals, "#chan")
+	c.Check(kick.Kicked(), Equals, "other")
+	c.Check(kick.Reason(), Equals, "out")
+
+	nick := &NickMessage{&IrcMessage{Name: NICK, Sender: viewSender,
+		Args: []string{"newnick"}}}
+	c.Check(nick.OldNick(), Equals, "nick")
+	c.Check(nick.NewNick(), Equals, "newnick")
+
+	mode := &ModeMessage{&IrcMessage{Name: MODE, Sender: viewSender,
+		Args: []string{"#chan", "+ov", "a", "b"}}}
+	c.Check(mode.Target(), Equals, "#chan")
+	c.Check(mode.Modes(), Equals, "+ov a b")
+
+	topic := &TopicMessage{&IrcMessage{Name: TOPIC, Sender: viewSender,
+		Args: []string{"#chan", "new topic"}}}
+	c.Check(topic.Nick(), Equals, "nick")
+	c.Check(topic.Channel(), Equals, "#chan")
+	c.Check(topic.Topic(), Equals, "new topic")
+
+	invite := &InviteMessage{&IrcMessage{Name: INVITE, Sender: viewSender,
+		Args: []string{"me", "#chan"}}}
+	c.Check(invite.Nick(), Equals, "nick")
+	c.Check(invite.Target(), Equals, "me")
+	c.Check(invite.Channel(), Equals, "#chan")
+
+	ctcp := &CTCPMessage{&IrcMessage{Name: PRIVMSG, Sender: viewSender,
+		Args: []string{"me", "\x01PING 123\x01"}}}
+	c.Check(ctcp.Nick(), Equals, "nick")
+	c.Check(ctcp.Target(), Equals, "me")
+	c.Check(ctcp.Tag(), Equals, "PING")
+	c.Check(ctcp.Data(), Equals, "123")
+
+	action := &ActionMessage{&IrcMessage{Name: PRIVMSG, Sender: viewSender,
+		Args: []string{"#chan", "\x01ACTION waves\x01"}}}
+	c.Check(action.Nick(), Equals, "nick")
+	c.Check(action.Target(), Equals, "#chan")
+	c.Check(action.Action(), Equals, "waves")
+
+	numeric := &NumericMessage{&IrcMessage{Name: RPL_WELCOME,
+		Sender: "irc.server.net", Args: []string{"me", "Welcome me"}}}
+	c.Check(numeric.Code(), Equals, RPL_WELCOME)
+	c.Check(numeric.Target(), Equals, "me")
+	c.Check(numeric.Params(), DeepEquals, []string{"Welcome me"})
+	c.Check(numeric.Text(), Equals, "Welcome me")
+}
+
+func (s *s) TestMessageViews_Malformed(c *C) {
+	empty := &IrcMessage{}
+
+	c.Check((&Message{empty}).Target(), Equals, "")
+	c.Check((&Message{empty}).Message(), Equals, "")
+	c.Check((&JoinMessage{empty}).Channel(), Equals, "")
+	c.Check((&JoinMessage{empty}).Account(), Equals, "")
+	c.Check((&JoinMessage{empty}).Realname(), Equals, "")
+	c.Check((&PartMessage{empty}).Reason(), Equals, "")
+	c.Check((&QuitMessage{empty}).Reason(), Equals, "")
+	c.Check((&KickMessage{empty}).Kicked(), Equals, "")
+	c.Check((&KickMessage{empty}).Reason(), Equals, "")
+	c.Check((&NickMessage{empty}).NewNick(), Equals, "")
+	c.Check((&ModeMessage{empty}).Modes(), Equals, "")
+	c.Check((&TopicMessage{empty}).Topic(), Equals, "")
+	c.Check((&InviteMessage{empty}).Channel(), Equals, "")
+	c.Check((&CTCPMessage{empty}).Tag(), Equals, "")
+	c.Check((&CTCPMessage{empty}).Data(), Equals, "")
+	c.Check((&ActionMessage{empty}).Action(), Equals, "")
+	c.Check((&NumericMessage{empty}).Target(), Equals, "")
+	c.Check((&NumericMessage{empty}).Params(), IsNil)
+	c.Check((&NumericMessage{empty}).Text(), Equals, "")
+}
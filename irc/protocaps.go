@@ -164,6 +164,13 @@ func (p *ProtoCaps) Casemapping() string {
 	return p.casemapping
 }
 
+// Casemap gets the casemap named by the casemapping from the ProtoCaps.
+func (p *ProtoCaps) Casemap() Casemap {
+	p.protect.RLock()
+	defer p.protect.RUnlock()
+	return CreateCasemap(p.casemapping)
+}
+
 // Prefix gets the prefix from the ProtoCaps.
 func (p *ProtoCaps) Prefix() string {
 	p.protect.RLock()
@@ -0,0 +1,124 @@
+package irc
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+const (
+	// TAG_CLIENT_PREFIX marks a tag as a client-only tag.
+	TAG_CLIENT_PREFIX = '+'
+	// TAG_VENDOR_SEPARATOR separates a vendor from the name of a tag.
+	TAG_VENDOR_SEPARATOR = '/'
+)
+
+// EscapeTagValue escapes a tag value so it can be sent in the tags section
+// of an irc message.
+func EscapeTagValue(value string) string {
+	if !strings.ContainsAny(value, "; \\\r\n") {
+		return value
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case ';':
+			buf.WriteString(`\:`)
+		case ' ':
+			buf.WriteString(`\s`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
+// UnescapeTagValue reverses EscapeTagValue. Unknown escapes are replaced by
+// the escaped character and a trailing backslash is dropped.
+func UnescapeTagValue(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i >= len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf.WriteByte(';')
+		case 's':
+			buf.WriteByte(' ')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
+// IsClientTag checks if a tag name is a client-only tag.
+func IsClientTag(name string) bool {
+	return len(name) > 0 && name[0] == TAG_CLIENT_PREFIX
+}
+
+// GetTagVendor returns the vendor of a tag name, empty string if it has none.
+func GetTagVendor(name string) string {
+	name = strings.TrimLeft(name, string(TAG_CLIENT_PREFIX))
+	if i := strings.IndexRune(name, TAG_VENDOR_SEPARATOR); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// GetTag returns the value of a tag, and if the tag was present at all. Tags
+// without a value are present with an empty value.
+func (m *IrcMessage) GetTag(name string) (string, bool) {
+	value, ok := m.Tags[name]
+	return value, ok
+}
+
+// SetTag sets a tag on the message, creating the tag map if necessary.
+func (m *IrcMessage) SetTag(name, value string) {
+	if m.Tags == nil {
+		m.Tags = make(map[string]string)
+	}
+	m.Tags[name] = value
+}
+
+// writeTags writes the tags section of an irc message, without the leading @
+// or the trailing space. Tags are sorted by name so output is stable.
+func writeTags(buf *bytes.Buffer, tags map[string]string) {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(';')
+		}
+		buf.WriteString(name)
+		if value := tags[name]; len(value) > 0 {
+			buf.WriteByte('=')
+			buf.WriteString(EscapeTagValue(value))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package irc
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *s) TestTags_Escape(c *C) {
+	c.Check(EscapeTagValue("plain"), Equals, "plain")
+	c.Check(EscapeTagValue("a; b\\c\r\n"), Equals, `a\:\sb\\c\r\n`)
+	c.Check(UnescapeTagValue(`a\:\sb\\c\r\n`), Equals, "a; b\\c\r\n")
+	c.Check(UnescapeTagValue("plain"), Equals, "plain")
+	c.Check(UnescapeTagValue(`\b\`), Equals, "b")
+
+	values := []string{"", ";", " ", "\\", "\\s", "x\r\ny", "\\\\:;"}
+	for _, v := range values {
+		c.Check(UnescapeTagValue(EscapeTagValue(v)), Equals, v)
+	}
+}
+
+func (s *s) TestTags_Names(c *C) {
+	c.Check(IsClientTag("+typing"), Equals, true)
+	c.Check(IsClientTag("time"), Equals, false)
+	c.Check(IsClientTag(""), Equals, false)
+	c.Check(GetTagVendor("+example.com/x"), Equals, "example.com")
+	c.Check(GetTagVendor("example.com/x"), Equals, "example.com")
+	c.Check(GetTagVendor("time"), Equals, "")
+}
+
+func (s *s) TestTags_GetSet(c *C) {
+	msg := &IrcMessage{}
+	_, ok := msg.GetTag("a")
+	c.Check(ok, Equals, false)
+	msg.SetTag("a", "b")
+	v, ok := msg.GetTag("a")
+	c.Check(ok, Equals, true)
+	c.Check(v, Equals, "b")
+}
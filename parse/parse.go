@@ -5,23 +5,24 @@ package parse
 
 import (
 	"github.com/aarondl/ultimateq/irc"
-	"regexp"
 	"strings"
 )
 
 const (
-	// errMsgParseFailure is given when the ircRegex fails to parse the protocol
+	// errMsgParseFailure is given when the irc protocol could not be parsed.
 	errMsgParseFailure = "parse: Unable to parse received irc protocol"
-)
 
-var (
-	// ircRegex is used to parse the parts of irc protocol.
-	ircRegex = regexp.MustCompile(
-		`^(?::(\S+) )?([A-Z0-9]+)((?: (?:[^:\s][^\s]*))*)(?: :(.*))?\s*$`)
+	// maxMiddleParams is how many parameters RFC 2812 allows before the
+	// remainder of the line is taken as the trailing parameter, regardless
+	// of a colon.
+	maxMiddleParams = 14
+	// nAssumedArgs is the typical number of arguments in a message.
+	nAssumedArgs = 4
 )
 
-// ParseError is generated when something does not match the regex, irc.Parse
-// will return one of these containing the invalid seeming irc protocol string.
+// ParseError is generated when something does not conform to the irc
+// protocol, irc.Parse will return one of these containing the invalid seeming
+// irc protocol string.
 type ParseError struct {
 	// The message
 	Msg string
@@ -36,27 +37,137 @@ func (p ParseError) Error() string {
 
 // Parse produces an IrcMessage from a byte slice. The string is an irc
 // protocol message, split by \r\n, and \r\n should not be
-// present at the end of the string.
+// present at the end of the string. IRCv3 message tags are unescaped into the
+// message's tag map.
+//
+// The grammar is that of RFC 1459 and 2812: parameters are separated by one or
+// more spaces, a parameter beginning with a colon is the trailing parameter
+// and may contain spaces or be empty, and after 14 middle parameters the
+// remainder of the line is the trailing parameter even without a colon.
+// Command names are uppercased.
 func Parse(str []byte) (*irc.IrcMessage, error) {
-	parts := ircRegex.FindSubmatch(str)
-	if parts == nil {
-		return nil, ParseError{Msg: errMsgParseFailure, Irc: string(str)}
+	line := string(str)
+	end := len(line)
+	for end > 0 && (line[end-1] == '\r' || line[end-1] == '\n') {
+		end--
 	}
 
 	msg := &irc.IrcMessage{}
-	msg.Sender = string(parts[1])
-	msg.Name = string(parts[2])
-	if len(parts[3]) != 0 {
-		msg.Args = strings.Fields(string(parts[3]))
+	i := 0
+
+	if i < end && line[i] == '@' {
+		start := i + 1
+		i = scanWord(line, start, end)
+		if i == end {
+			return nil, ParseError{Msg: errMsgParseFailure, Irc: line}
+		}
+		msg.Tags = parseTags(line[start:i])
+		i = skipSpaces(line, i, end)
 	}
 
-	if len(parts[4]) != 0 {
-		if msg.Args != nil {
-			msg.Args = append(msg.Args, string(parts[4]))
-		} else {
-			msg.Args = []string{string(parts[4])}
+	if i < end && line[i] == ':' {
+		start := i + 1
+		i = scanWord(line, start, end)
+		if i == start || i == end {
+			return nil, ParseError{Msg: errMsgParseFailure, Irc: line}
 		}
+		msg.Sender = line[start:i]
+		i = skipSpaces(line, i, end)
+	}
+
+	start := i
+	i = scanWord(line, start, end)
+	if i == start {
+		return nil, ParseError{Msg: errMsgParseFailure, Irc: line}
+	}
+	name, ok := commandName(line[start:i])
+	if !ok {
+		return nil, ParseError{Msg: errMsgParseFailure, Irc: line}
+	}
+	msg.Name = name
+
+	for i < end {
+		i = skipSpaces(line, i, end)
+		if i == end {
+			break
+		}
+		if msg.Args == nil {
+			msg.Args = make([]string, 0, nAssumedArgs)
+		}
+
+		if line[i] == ':' {
+			msg.Args = append(msg.Args, line[i+1:end])
+			break
+		}
+		if len(msg.Args) == maxMiddleParams {
+			msg.Args = append(msg.Args, line[i:end])
+			break
+		}
+
+		start = i
+		i = scanWord(line, start, end)
+		msg.Args = append(msg.Args, line[start:i])
 	}
 
 	return msg, nil
 }
+
+// scanWord returns the index of the first space at or after i, or end.
+func scanWord(line string, i, end int) int {
+	for i < end && line[i] != ' ' {
+		i++
+	}
+	return i
+}
+
+// skipSpaces returns the index of the first non-space at or after i, or end.
+func skipSpaces(line string, i, end int) int {
+	for i < end && line[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// commandName validates a command name, which is either letters or a numeric,
+// and uppercases it. The original string is returned when it is already
+// uppercase to avoid allocating.
+func commandName(name string) (string, bool) {
+	lower := false
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z':
+			lower = true
+		default:
+			return "", false
+		}
+	}
+	if lower {
+		return strings.ToUpper(name), true
+	}
+	return name, true
+}
+
+// parseTags splits the tags section of a message into a map. Later tags
+// replace earlier ones with the same name.
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for len(raw) > 0 {
+		tag := raw
+		if i := strings.IndexByte(raw, ';'); i >= 0 {
+			tag, raw = raw[:i], raw[i+1:]
+		} else {
+			raw = ""
+		}
+
+		name, value := tag, ""
+		if i := strings.IndexByte(tag, '='); i >= 0 {
+			name, value = tag[:i], tag[i+1:]
+		}
+		if len(name) == 0 {
+			continue
+		}
+		tags[name] = irc.UnescapeTagValue(value)
+	}
+	return tags
+}
@@ -0,0 +1,77 @@
+package parse
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	benchPrivmsg = []byte(":nick!user@host.com PRIVMSG #chan :hello there")
+	benchNumeric = []byte(":irc.test.net 005 me CHANTYPES=# " +
+		"PREFIX=(ov)@+ CHANMODES=b,k,l,imnpst NICKLEN=30 :are supported")
+	benchTagged = []byte("@time=2013-06-01T12:00:00.000Z;account=bob;" +
+		"msgid=abc :nick!user@host.com PRIVMSG #chan :hello there")
+
+	// regexIrc is the regex the parser used before it was hand-written, it
+	// is kept to compare against.
+	regexIrc = regexp.MustCompile(
+		`^(?::(\S+) )?([A-Z0-9]+)((?: (?:[^:\s][^\s]*))*)(?: :(.*))?\s*$`)
+)
+
+// regexParse is the old regex based parser.
+func regexParse(str []byte) *irc.IrcMessage {
+	parts := regexIrc.FindSubmatch(str)
+	if parts == nil {
+		return nil
+	}
+	msg := &irc.IrcMessage{Sender: string(parts[1]), Name: string(parts[2])}
+	if len(parts[3]) != 0 {
+		msg.Args = strings.Fields(string(parts[3]))
+	}
+	if len(parts[4]) != 0 {
+		msg.Args = append(msg.Args, string(parts[4]))
+	}
+	return msg
+}
+
+func benchmarkParse(b *testing.B, line []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkRegexParse(b *testing.B, line []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	for i := 0; i < b.N; i++ {
+		if regexParse(line) == nil {
+			b.Fatal("Failed to parse.")
+		}
+	}
+}
+
+func BenchmarkParse_Privmsg(b *testing.B) {
+	benchmarkParse(b, benchPrivmsg)
+}
+
+func BenchmarkParse_Numeric(b *testing.B) {
+	benchmarkParse(b, benchNumeric)
+}
+
+func BenchmarkParse_Tagged(b *testing.B) {
+	benchmarkParse(b, benchTagged)
+}
+
+func BenchmarkRegexParse_Privmsg(b *testing.B) {
+	benchmarkRegexParse(b, benchPrivmsg)
+}
+
+func BenchmarkRegexParse_Numeric(b *testing.B) {
+	benchmarkRegexParse(b, benchNumeric)
+}
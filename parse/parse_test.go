@@ -1,7 +1,10 @@
 package parse
 
 import (
+	"bytes"
 	. "launchpad.net/gocheck"
+	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -79,9 +82,227 @@ func (s *s) TestParse_Error(c *C) {
 	_, err := Parse([]byte{})
 	c.Check(err.Error(), Equals, errMsgParseFailure)
 
-	irc := "invalid irc message"
+	// Lowercase command names are accepted, punctuation is not.
+	irc := "invalid! irc message"
 	_, err = Parse([]byte(irc))
 	e, ok := err.(ParseError)
 	c.Check(ok, Equals, true)
 	c.Check(e.Irc, Equals, irc)
+
+	invalid := []string{
+		" ", ":", ":sender", ":sender ", ": PRIVMSG #chan", "@tags",
+		"@tags :sender", "PRIV_MSG #chan", "@a=b  ",
+	}
+	for _, line := range invalid {
+		_, err = Parse([]byte(line))
+		c.Check(err, NotNil, Commentf("%q", line))
+	}
+}
+
+func (s *s) TestParse_Grammar(c *C) {
+	tests := []struct {
+		line   string
+		sender string
+		name   string
+		args   []string
+	}{
+		{"privmsg #chan :hi", "", "PRIVMSG", []string{"#chan", "hi"}},
+		{"PING", "", "PING", nil},
+		{"PING   ", "", "PING", nil},
+		{":n!u@h  QUIT", "n!u@h", "QUIT", nil},
+		{"TOPIC #chan :", "", "TOPIC", []string{"#chan", ""}},
+		{"TOPIC #chan   :  a  b ", "", "TOPIC", []string{"#chan", "  a  b "}},
+		{"MODE #chan  +o   nick", "", "MODE", []string{"#chan", "+o", "nick"}},
+		{"USER a\tb 0 * ::)", "", "USER", []string{"a\tb", "0", "*", ":)"}},
+		{"PRIVMSG #chan a:b", "", "PRIVMSG", []string{"#chan", "a:b"}},
+		{"PING :123\r\n", "", "PING", []string{"123"}},
+		{":srv 001 me :Welcome", "srv", "001", []string{"me", "Welcome"}},
+		{"005 a b c d e f g h i j k l m n o p q", "", "005", []string{
+			"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m",
+			"n", "o p q",
+		}},
+		{"005 a b c d e f g h i j k l m n :o p", "", "005", []string{
+			"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m",
+			"n", "o p",
+		}},
+	}
+
+	for _, test := range tests {
+		msg, err := Parse([]byte(test.line))
+		comment := Commentf("%q", test.line)
+		c.Assert(err, IsNil, comment)
+		c.Check(msg.Sender, Equals, test.sender, comment)
+		c.Check(msg.Name, Equals, test.name, comment)
+		c.Check(msg.Args, DeepEquals, test.args, comment)
+	}
+}
+
+func (s *s) TestParse_Tags(c *C) {
+	raw := `@time=2013-06-01T12:00:00.000Z;account=bob;+example.com/x=a\sb\:c` +
+		`\\d\r\n;flag;empty=;bad\ :nick!user@host.com PRIVMSG #chan :hi there`
+	msg, err := Parse([]byte(raw))
+	c.Assert(err, IsNil)
+	c.Check(msg.Sender, Equals, "nick!user@host.com")
+	c.Check(msg.Name, Equals, "PRIVMSG")
+	c.Check(msg.Args, DeepEquals, []string{"#chan", "hi there"})
+	c.Check(msg.Tags, DeepEquals, map[string]string{
+		"time":           "2013-06-01T12:00:00.000Z",
+		"account":        "bob",
+		"+example.com/x": "a b;c\\d\r\n",
+		"flag":           "",
+		"empty":          "",
+		`bad\`:           "",
+	})
+
+	value, ok := msg.GetTag("flag")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "")
+	_, ok = msg.GetTag("nope")
+	c.Check(ok, Equals, false)
+
+	msg, err = Parse([]byte("@a=1;a=2;=x  PING :123"))
+	c.Assert(err, IsNil)
+	c.Check(msg.Tags, DeepEquals, map[string]string{"a": "2"})
+	c.Check(msg.Name, Equals, "PING")
+	c.Check(msg.Args, DeepEquals, []string{"123"})
+
+	msg, err = Parse([]byte("PING :123"))
+	c.Assert(err, IsNil)
+	c.Check(msg.Tags, IsNil)
+
+	_, err = Parse([]byte("@a=1"))
+	c.Check(err, NotNil)
+	_, err = Parse([]byte("@a=1 "))
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestParse_RoundTrip(c *C) {
+	lines := []string{
+		`@+draft/reply=abc;msgid=x\sy :nick!u@h PRIVMSG #chan :hello world`,
+		`:irc.test.net 005 nick CHANTYPES=# :are supported`,
+		`PING 12345`,
+		`@a :n!u@h PRIVMSG #chan ::)`,
+		`PRIVMSG #chan :`,
+	}
+	for _, line := range lines {
+		msg, err := Parse([]byte(line))
+		c.Assert(err, IsNil)
+		c.Check(msg.String(), Equals, line)
+		again, err := Parse([]byte(msg.String()))
+		c.Check(err, IsNil)
+		c.Check(again, DeepEquals, msg)
+	}
+}
+
+// mutateCorpus seeds TestParse_Mutations and FuzzParse with real world lines
+// to mutate.
+var mutateCorpus = []string{
+	":nick!user@host.com PRIVMSG #chan :hello there",
+	"@time=2013-06-01T12:00:00.000Z;account=bob :n!u@h JOIN #chan * :Bob",
+	":irc.test.net 005 me CHANTYPES=# PREFIX=(ov)@+ :are supported",
+	"PING :irc.test.net",
+	`@+draft/reply=a\sb\:c\\ :n!u@h TAGMSG #chan`,
+	"MODE #chan +ov a b",
+}
+
+// mutateBytes are bytes that mean something to the parser.
+var mutateBytes = []byte(" :@;=\\\r\n\x00\xffaZ09!#")
+
+// TestParse_Mutations mutates valid lines randomly and checks that Parse never
+// panics, and that anything it accepts without CR or LF survives a round trip
+// through IrcMessage.String. It's not a coverage guided fuzzer, the random
+// source is seeded so every run tries the same lines and a failure can be
+// reproduced.
+func (s *s) TestParse_Mutations(c *C) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50000; i++ {
+		line := []byte(mutateCorpus[r.Intn(len(mutateCorpus))])
+		for n := r.Intn(8); n >= 0; n-- {
+			line = mutate(r, line)
+		}
+
+		msg, err := Parse(line)
+		if err != nil {
+			continue
+		}
+		comment := Commentf("%q", line)
+		c.Assert(len(msg.Name), Not(Equals), 0, comment)
+
+		// CR and LF can't be sent inside a line so they can't round trip.
+		if strings.ContainsAny(string(line), "\r\n") {
+			continue
+		}
+
+		again, err := Parse([]byte(msg.String()))
+		c.Assert(err, IsNil, comment)
+		if len(msg.Tags) == 0 {
+			msg.Tags = nil
+		}
+		c.Assert(again, DeepEquals, msg, comment)
+	}
+}
+
+// FuzzParse checks that Parse never panics, and that anything it accepts
+// without CR or LF survives a round trip through IrcMessage.String. Run it
+// with go test -fuzz FuzzParse to explore new lines, without -fuzz only the
+// corpus is checked.
+func FuzzParse(f *testing.F) {
+	for _, line := range mutateCorpus {
+		f.Add([]byte(line))
+	}
+
+	f.Fuzz(func(t *testing.T, line []byte) {
+		msg, err := Parse(line)
+		if err != nil {
+			return
+		}
+		if len(msg.Name) == 0 {
+			t.Fatalf("Parse(%q) accepted a message without a name.", line)
+		}
+
+		// CR and LF can't be sent inside a line so they can't round trip.
+		if bytes.ContainsAny(line, "\r\n") {
+			return
+		}
+
+		again, err := Parse([]byte(msg.String()))
+		if err != nil {
+			t.Fatalf("Parse(%q) can't parse it's own String() %q: %v",
+				line, msg.String(), err)
+		}
+		if len(msg.Tags) == 0 {
+			msg.Tags = nil
+		}
+		if !reflect.DeepEqual(again, msg) {
+			t.Fatalf("Parse(%q) = %#v, after a round trip %#v",
+				line, msg, again)
+		}
+	})
+}
+
+// mutate randomly inserts, replaces, deletes or duplicates bytes in a line.
+func mutate(r *rand.Rand, line []byte) []byte {
+	if len(line) == 0 {
+		return []byte{mutateBytes[r.Intn(len(mutateBytes))]}
+	}
+
+	i := r.Intn(len(line))
+	b := mutateBytes[r.Intn(len(mutateBytes))]
+	if r.Intn(4) == 0 {
+		b = byte(r.Intn(256))
+	}
+
+	switch r.Intn(4) {
+	case 0:
+		return append(line[:i], append([]byte{b}, line[i:]...)...)
+	case 1:
+		line[i] = b
+		return line
+	case 2:
+		return append(line[:i], line[i+1:]...)
+	default:
+		j := i + r.Intn(len(line)-i)
+		return append(line[:j], append(append([]byte{}, line[i:j]...),
+			line[j:]...)...)
+	}
 }
@@ -103,5 +103,8 @@ func main() {
 	b.WaitForHalt()
 	b.Stop()
 	b.Disconnect()
+	if err := b.Close(); err != nil {
+		log.Println(err)
+	}
 	<-time.After(10 * time.Second)
 }